@@ -21,16 +21,16 @@ SET default_tablespace = '';
 SET default_table_access_method = heap;
 
 --
--- Name: collated_metrics; Type: TABLE; Schema: public; Owner: caliper_user
+-- Name: caliper_metrics; Type: TABLE; Schema: public; Owner: caliper_user
 --
 
-CREATE TABLE public.collated_metrics (
+CREATE TABLE public.caliper_metrics (
     version text NOT NULL,
     metric text NOT NULL,
     node text NOT NULL,
     pod text NOT NULL,
     namespace text NOT NULL,
-    label_app text,
+    owner_name text,
     avg_value numeric,
     q95_value numeric,
     max_value numeric,
@@ -41,7 +41,7 @@ CREATE TABLE public.collated_metrics (
 );
 
 
-ALTER TABLE public.collated_metrics OWNER TO caliper_user;
+ALTER TABLE public.caliper_metrics OWNER TO caliper_user;
 
 --
 -- PostgreSQL database dump complete
@@ -0,0 +1,131 @@
+import numpy
+import pandas as pd
+from scipy import stats
+
+# significance level below which a delta is reported as a real change rather than run-to-run noise
+ALPHA = 0.05
+
+compare_columns = ['base_runs', 'head_runs', 'base', 'head', 'delta', 'delta_pct', 'p_welch', 'p_mann_whitney',
+                   'ci_low', 'ci_high', 'significant']
+
+
+def run_totals(df=pd.DataFrame(), op='', by='group') -> pd.DataFrame:
+    # A run is identified by its query_time, which prom-top stamps identically on every row of an invocation.
+    return df.groupby(by=['version', 'query_time', by], as_index=False)[op].sum()
+
+
+def welch_ttest(a, b):
+    if len(a) < 2 or len(b) < 2:
+        return numpy.nan
+    return stats.ttest_ind(a, b, equal_var=False).pvalue
+
+
+def mann_whitney(a, b):
+    if len(a) < 1 or len(b) < 1:
+        return numpy.nan
+    try:
+        return stats.mannwhitneyu(a, b, alternative='two-sided').pvalue
+    except ValueError:
+        # raised when every observation is identical
+        return numpy.nan
+
+
+def mean_diff_ci(a, b, confidence=0.95):
+    # Welch confidence interval for mean(b) - mean(a)
+    if len(a) < 2 or len(b) < 2:
+        return numpy.nan, numpy.nan
+    va = numpy.var(a, ddof=1) / len(a)
+    vb = numpy.var(b, ddof=1) / len(b)
+    d = numpy.mean(b) - numpy.mean(a)
+    if va + vb == 0:
+        return d, d
+    dof = (va + vb) ** 2 / (va ** 2 / (len(a) - 1) + vb ** 2 / (len(b) - 1))
+    t = stats.t.ppf((1 + confidence) / 2, dof)
+    se = numpy.sqrt(va + vb)
+    return d - t * se, d + t * se
+
+
+def compare_builds(df=pd.DataFrame(), base='', head='', op='', by='group', alpha=ALPHA) -> pd.DataFrame:
+    totals = run_totals(df, op=op, by=by)
+    rows = []
+    for key, frame in totals.groupby(by=by, sort=True):
+        a = frame.loc[frame['version'] == base, op].to_numpy(dtype=float)
+        b = frame.loc[frame['version'] == head, op].to_numpy(dtype=float)
+        if len(a) == 0 or len(b) == 0:
+            continue
+        base_mean = a.mean()
+        head_mean = b.mean()
+        delta = head_mean - base_mean
+        p_welch = welch_ttest(a, b)
+        ci_low, ci_high = mean_diff_ci(a, b)
+        rows.append({
+            by: key,
+            'base_runs': len(a),
+            'head_runs': len(b),
+            'base': base_mean,
+            'head': head_mean,
+            'delta': delta,
+            'delta_pct': delta / base_mean * 100 if base_mean != 0 else numpy.nan,
+            'p_welch': p_welch,
+            'p_mann_whitney': mann_whitney(a, b),
+            'ci_low': ci_low,
+            'ci_high': ci_high,
+            'significant': bool(p_welch < alpha) if not numpy.isnan(p_welch) else False,
+        })
+    return pd.DataFrame(rows, columns=[by] + compare_columns)
+
+
+def format_value(v, precision=4):
+    if v is None or (isinstance(v, float) and numpy.isnan(v)):
+        return 'n/a'
+    return f'{v:.{precision}g}'
+
+
+def format_pct(v):
+    if v is None or numpy.isnan(v):
+        return 'n/a'
+    return f'{v:+.1f}%'
+
+
+def to_markdown(results, by='group', base='', head='', alpha=ALPHA) -> str:
+    # results maps a metric name to the output of compare_builds
+    lines = [
+        f'### Caliper comparison: `{base}` → `{head}`',
+        '',
+        f'| metric | {by} | old | new | delta% | p |',
+        '|---|---|---:|---:|---:|---:|',
+    ]
+    for metric, df in results.items():
+        for _, row in df.iterrows():
+            delta = format_pct(row['delta_pct'])
+            if row['significant']:
+                delta = f'**{delta}**'
+            lines.append(f"| {metric} | {row[by]} | {format_value(row['base'])} | {format_value(row['head'])} "
+                         f"| {delta} | {format_value(row['p_welch'], 2)} |")
+    lines.append('')
+    lines.append(f'Bold deltas are significant at p < {alpha} (Welch\'s t-test over repeated runs).')
+    return '\n'.join(lines) + '\n'
+
+
+def to_text(results) -> str:
+    frames = []
+    for metric, df in results.items():
+        df = df.copy()
+        df.insert(0, 'metric', metric)
+        frames.append(df)
+    if len(frames) == 0:
+        return 'no comparable rows\n'
+    return pd.concat(frames).to_string(index=False) + '\n'
+
+
+def regressions(results, threshold=0.0, alpha=ALPHA) -> pd.DataFrame:
+    # Rows whose increase exceeds threshold percent.  Deltas a significance test has shown to be noise are excluded;
+    # untested deltas (a single run per build) are kept, since they can't be shown to be noise.
+    frames = []
+    for metric, df in results.items():
+        df = df[(df['delta_pct'] > threshold) & ~(df['p_welch'] >= alpha)].copy()
+        df.insert(0, 'metric', metric)
+        frames.append(df)
+    if len(frames) == 0:
+        return pd.DataFrame(columns=['metric'] + compare_columns)
+    return pd.concat(frames)
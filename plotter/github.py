@@ -0,0 +1,42 @@
+import json
+from urllib.error import HTTPError
+from urllib.request import Request, urlopen
+
+API_URL = 'https://api.github.com'
+
+# GitHub caps commit status descriptions at 140 characters
+MAX_DESCRIPTION = 140
+
+
+def _post(path, token, payload):
+    req = Request(
+        url=f'{API_URL}{path}',
+        data=json.dumps(payload).encode('utf-8'),
+        method='POST',
+        headers={
+            'Authorization': f'token {token}',
+            'Accept': 'application/vnd.github.v3+json',
+            'Content-Type': 'application/json',
+        },
+    )
+    try:
+        with urlopen(req) as resp:
+            return json.load(resp)
+    except HTTPError as e:
+        raise Exception(f'github POST {path} failed: {e.code} {e.read().decode("utf-8", "replace")}')
+
+
+def post_comment(repo='', pr=0, body='', token=''):
+    # pull request comments are issue comments in the GitHub API
+    return _post(f'/repos/{repo}/issues/{pr}/comments', token, {'body': body})
+
+
+def post_status(repo='', sha='', state='success', description='', token='', context='caliper', target_url=None):
+    payload = {
+        'state': state,
+        'description': description[:MAX_DESCRIPTION],
+        'context': context,
+    }
+    if target_url:
+        payload['target_url'] = target_url
+    return _post(f'/repos/{repo}/statuses/{sha}', token, payload)
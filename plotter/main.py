@@ -1,8 +1,12 @@
+import argparse
 import os
+import re
+import sys
 
 import dash
 import dash_core_components as dcc
 import dash_html_components as html
+import dash_table
 import numpy
 import pandas as pd
 import psycopg2
@@ -13,6 +17,9 @@ from dotenv import load_dotenv
 from plotly import express as px
 from plotly import graph_objects as go
 
+import compare
+import github
+
 # for debugging dataframes printed to console
 pd.set_option('min_rows', 10)
 pd.set_option('max_rows', 500)
@@ -25,6 +32,9 @@ pg_port = os.getenv('PGPORT')
 pg_database = os.getenv('PGDATABASE')
 pg_user = os.getenv('PGUSER')
 pg_password = os.getenv('PGPASSWORD')
+# PGSCHEMA and CALIPER_TABLE_PREFIX locate the tables as they do for prom-top
+pg_schema = os.getenv('PGSCHEMA', '')
+table_prefix = os.getenv('CALIPER_TABLE_PREFIX', '')
 conn = psycopg2.connect(
     host=pg_host,
     port=pg_port,
@@ -39,6 +49,43 @@ with open('component-mappings.yaml', 'r') as file:
 
 value_columns = ['q95_value', 'avg_value', 'min_value', 'max_value']
 
+# Columns every metrics query must return.  A custom --query-file may return a superset of these.
+required_columns = ['version', 'metric', 'pod', 'namespace', 'owner_name', 'query_time'] + value_columns
+
+# custom_query, when set from --query-file, replaces the metrics table as the source of all charts and reports
+custom_query = None
+
+
+def table_name(base):
+    for name, value in [('PGSCHEMA', pg_schema), ('CALIPER_TABLE_PREFIX', table_prefix)]:
+        # prom-top rejects any other names, and they are interpolated into queries
+        if value and not re.fullmatch(r'[a-z_][a-z0-9_]*', value):
+            raise ValueError(f'invalid {name} {value!r}: use lower case letters, digits, and underscores')
+    table = table_prefix + base
+    if pg_schema:
+        return f'{pg_schema}.{table}'
+    return table
+
+
+def load_query_file(file_path):
+    with open(file_path, 'r') as file:
+        query = file.read()
+    # the query is embedded as a subquery, so a trailing statement terminator must go
+    return query.strip().rstrip(';').strip()
+
+
+def metrics_query(metric=''):
+    source = table_name('caliper_metrics')
+    if custom_query:
+        source = f'({custom_query}) AS custom_query'
+    return f"SELECT * FROM {source} WHERE metric = '{metric}';"
+
+
+def check_columns(columns):
+    missing = [c for c in required_columns if c not in columns]
+    if len(missing) > 0:
+        raise KeyError(f'query result is missing required columns {missing}, expected at least {required_columns}')
+
 
 def db_numeric_to_float(df):
     for v in value_columns:
@@ -90,6 +137,7 @@ def executeQuery(query):
     cur.execute(query)
     desc = cur.description
     columns = [col[0] for col in desc]
+    check_columns(columns)
     rows = [row for row in cur.fetchall()]
     df = pd.DataFrame([[c for c in r] for r in rows])
     df.rename(inplace=True, columns=dict(enumerate(columns)))
@@ -105,19 +153,13 @@ def sort_by_version(df=pd.DataFrame()) -> pd.DataFrame:
 
 
 def get_mem_metrics():
-    query_mem = """
-    SELECT * FROM caliper_metrics WHERE metric = 'container_memory_bytes';
-    """
-    df = executeQuery(query_mem)
+    df = executeQuery(metrics_query('container_memory_bytes'))
     df = df_mem_bytes_to_gigabytes(df)
     return df
 
 
 def get_cpu_metrics():
-    query_cpu = """
-    SELECT * FROM caliper_metrics WHERE metric = 'cpu_usage_ratio';
-    """
-    df = executeQuery(query_cpu)
+    df = executeQuery(metrics_query('cpu_usage_ratio'))
     for v in value_columns:
         df[v] = df[v] * 100
     return df
@@ -292,6 +334,56 @@ def bar_group_fig(df=pd.DataFrame(), op='', y_max=0.0, title='', y_title='', x_t
     return fig
 
 
+def namespace_rollup(df=pd.DataFrame(), op='') -> pd.DataFrame:
+    # sum pods within each run, then average the runs of each build
+    per_run = df.groupby(by=['version', 'query_time', 'namespace'], as_index=False)[op].sum()
+    return per_run.groupby(by=['version', 'namespace'], as_index=False)[op].mean()
+
+
+def namespace_rollup_fig(df=pd.DataFrame(), op='', top_n=10, title='', y_title='', tick_suffix=''):
+    df = namespace_rollup(df, op)
+    if top_n:
+        heaviest = df.groupby(by='namespace')[op].max().nlargest(top_n).index
+        df = df[df['namespace'].isin(heaviest)]
+    df = sort_by_version(df)
+    fig = px.bar(data_frame=df, x='version', y=op, color='namespace', title=title)
+    fig.update_yaxes({'title': y_title, 'ticksuffix': tick_suffix})
+    fig.update_xaxes({'title': 'OCP Version'})
+    fig.update_layout({'legend': {'traceorder': 'reversed'}})
+    return fig
+
+
+def distribution_fig(df=pd.DataFrame(), op='', kind='violin', title='', y_title=''):
+    df = sort_by_version(df)
+    if kind == 'histogram':
+        fig = px.histogram(data_frame=df, x=op, color='version', barmode='overlay', opacity=0.6, title=title)
+        fig.update_xaxes({'title': y_title})
+        fig.update_yaxes({'title': 'Pods'})
+    else:
+        fig = px.violin(data_frame=df, x='version', y=op, color='version', box=True, points='outliers',
+                        hover_data=['namespace', 'pod'], title=title)
+        fig.update_yaxes({'title': y_title})
+        fig.update_xaxes({'title': 'OCP Version'})
+    return fig
+
+
+def app_history_fig(df=pd.DataFrame(), op='', order='version', title='', y_title='', tick_suffix=''):
+    # one point per stored run: the app's pods are summed within each run
+    runs = df.groupby(by=['version', 'query_time'], as_index=False)[op].sum()
+    if order == 'date':
+        runs = runs.sort_values(by='query_time')
+        x, x_title = runs['query_time'], 'Run Date'
+    else:
+        runs = sort_by_version(runs)
+        x, x_title = runs['version'], 'OCP Version'
+    fig = go.Figure()
+    fig.update_layout({'title': title})
+    fig.update_yaxes({'title': y_title, 'ticksuffix': tick_suffix, 'rangemode': 'tozero'})
+    fig.update_xaxes({'title': x_title})
+    fig.add_trace(go.Scatter(x=x, y=runs[op], mode='lines+markers', text=runs['version']))
+    return fig
+
+
 radio_options = [
     {'label': '95th-%', 'value': 'q95_value'},
     {'label': 'Average', 'value': 'avg_value'},
@@ -299,6 +391,32 @@ radio_options = [
     {'label': 'Max', 'value': 'max_value'},
 ]
 
+metric_options = [
+    {'label': 'Memory', 'value': 'memory'},
+    {'label': 'CPU', 'value': 'cpu'},
+]
+
+distribution_kind_options = [
+    {'label': 'Violin', 'value': 'violin'},
+    {'label': 'Histogram', 'value': 'histogram'},
+]
+
+namespace_top_options = [
+    {'label': 'Top 10', 'value': 10},
+    {'label': 'Top 25', 'value': 25},
+    {'label': 'All', 'value': 0},
+]
+
+history_order_options = [
+    {'label': 'By Version', 'value': 'version'},
+    {'label': 'By Date', 'value': 'date'},
+]
+
+metric_getters = {
+    'memory': get_mem_metrics,
+    'cpu': get_cpu_metrics,
+}
+
 app = dash.Dash(__name__, external_stylesheets=['./style.css'])
 app.layout = html.Div(children=[
     html.H1(children='Caliper - Basic Dashboard'),
@@ -322,6 +440,39 @@ app.layout = html.Div(children=[
     html.Div(children=[
         dcc.Graph(id='cpu-line'),
         dcc.RadioItems(id='cpu-line-input', value='q95_value', options=radio_options)
+    ]),
+    html.Div(children=[
+        html.H2(children='Namespace Totals'),
+        dcc.Graph(id='namespace-graph'),
+        dcc.RadioItems(id='namespace-metric', value='memory', options=metric_options),
+        dcc.RadioItems(id='namespace-op', value='q95_value', options=radio_options),
+        dcc.RadioItems(id='namespace-top', value=10, options=namespace_top_options),
+    ]),
+    html.Div(children=[
+        html.H2(children='App History'),
+        dcc.Graph(id='history-graph'),
+        dcc.Dropdown(id='history-app', placeholder='Select an app'),
+        dcc.RadioItems(id='history-metric', value='memory', options=metric_options),
+        dcc.RadioItems(id='history-op', value='q95_value', options=radio_options),
+        dcc.RadioItems(id='history-order', value='version', options=history_order_options),
+    ]),
+    html.Div(children=[
+        html.H2(children='Per-Pod Distribution'),
+        dcc.Graph(id='distribution-graph'),
+        dcc.Dropdown(id='distribution-group', placeholder='All groups'),
+        dcc.RadioItems(id='distribution-metric', value='memory', options=metric_options),
+        dcc.RadioItems(id='distribution-op', value='q95_value', options=radio_options),
+        dcc.RadioItems(id='distribution-kind', value='violin', options=distribution_kind_options),
+    ]),
+    html.Div(children=[
+        html.H2(children='Build Comparison'),
+        html.P(children=f'Per-group totals of repeated runs, compared with Welch\'s t-test and Mann-Whitney U. '
+                        f'Deltas with p < {compare.ALPHA} are marked significant.'),
+        dcc.Dropdown(id='compare-base', placeholder='Baseline build'),
+        dcc.Dropdown(id='compare-head', placeholder='Candidate build'),
+        dcc.RadioItems(id='compare-metric', value='memory', options=metric_options),
+        dcc.RadioItems(id='compare-op', value='q95_value', options=radio_options),
+        dash_table.DataTable(id='compare-table'),
     ])
 ])
 
@@ -402,5 +553,206 @@ def cpu_line_response(op):
         print(f'cpu_line_response: got exception type {type(e)}:\n{e}')
 
 
+@app.callback(
+    Output(component_id='namespace-graph', component_property='figure'),
+    Input(component_id='namespace-metric', component_property='value'),
+    Input(component_id='namespace-op', component_property='value'),
+    Input(component_id='namespace-top', component_property='value')
+)
+def namespace_response(metric, op, top_n):
+    try:
+        df = metric_getters[metric]()
+        if metric == 'memory':
+            y_title, suffix = 'Memory (Gb)', 'Gb'
+        else:
+            y_title, suffix = 'CPU %', '%'
+        return namespace_rollup_fig(df, op=op, top_n=top_n, title=f'Namespace {y_title} Totals by Version',
+                                    y_title=y_title, tick_suffix=suffix)
+    except Exception as e:
+        print(f'namespace_response: got exception type {type(e)}:\n{e}')
+
+
+@app.callback(
+    Output(component_id='history-app', component_property='options'),
+    Input(component_id='history-metric', component_property='value')
+)
+def history_apps(metric):
+    try:
+        df = metric_getters[metric]()
+        return [{'label': a, 'value': a} for a in sorted(df['owner_name'].dropna().unique())]
+    except Exception as e:
+        print(f'history_apps: got exception type {type(e)}:\n{e}')
+        return []
+
+
+@app.callback(
+    Output(component_id='history-graph', component_property='figure'),
+    Input(component_id='history-app', component_property='value'),
+    Input(component_id='history-metric', component_property='value'),
+    Input(component_id='history-op', component_property='value'),
+    Input(component_id='history-order', component_property='value')
+)
+def history_response(app_name, metric, op, order):
+    if not app_name:
+        return go.Figure()
+    try:
+        df = metric_getters[metric]()
+        df = df[df['owner_name'] == app_name]
+        if metric == 'memory':
+            y_title, suffix = 'Memory (Gb)', 'Gb'
+        else:
+            y_title, suffix = 'CPU %', '%'
+        return app_history_fig(df, op=op, order=order, title=f'{app_name} {y_title} Across Runs', y_title=y_title,
+                               tick_suffix=suffix)
+    except Exception as e:
+        print(f'history_response: got exception type {type(e)}:\n{e}')
+
+
+@app.callback(
+    Output(component_id='distribution-group', component_property='options'),
+    Input(component_id='distribution-metric', component_property='value')
+)
+def distribution_groups(metric):
+    try:
+        df = metric_getters[metric]()
+        return [{'label': g, 'value': g} for g in sorted(df['group'].dropna().unique())]
+    except Exception as e:
+        print(f'distribution_groups: got exception type {type(e)}:\n{e}')
+        return []
+
+
+@app.callback(
+    Output(component_id='distribution-graph', component_property='figure'),
+    Input(component_id='distribution-metric', component_property='value'),
+    Input(component_id='distribution-op', component_property='value'),
+    Input(component_id='distribution-kind', component_property='value'),
+    Input(component_id='distribution-group', component_property='value')
+)
+def distribution_response(metric, op, kind, group):
+    try:
+        df = metric_getters[metric]()
+        if group:
+            df = df[df['group'] == group]
+        y_title = 'Memory (Gb)' if metric == 'memory' else 'CPU %'
+        return distribution_fig(df, op=op, kind=kind, title=f'Per-Pod {y_title} Distribution by Version',
+                                y_title=y_title)
+    except Exception as e:
+        print(f'distribution_response: got exception type {type(e)}:\n{e}')
+
+
+@app.callback(
+    Output(component_id='compare-base', component_property='options'),
+    Output(component_id='compare-head', component_property='options'),
+    Input(component_id='compare-metric', component_property='value')
+)
+def compare_versions(metric):
+    try:
+        df = metric_getters[metric]()
+        versions = sort_by_version(df[['version']].drop_duplicates())['version']
+        options = [{'label': v, 'value': v} for v in versions]
+        return options, options
+    except Exception as e:
+        print(f'compare_versions: got exception type {type(e)}:\n{e}')
+        return [], []
+
+
+@app.callback(
+    Output(component_id='compare-table', component_property='data'),
+    Output(component_id='compare-table', component_property='columns'),
+    Input(component_id='compare-base', component_property='value'),
+    Input(component_id='compare-head', component_property='value'),
+    Input(component_id='compare-metric', component_property='value'),
+    Input(component_id='compare-op', component_property='value')
+)
+def compare_response(base, head, metric, op):
+    if not base or not head:
+        return [], []
+    try:
+        df = metric_getters[metric]()
+        result = compare.compare_builds(df, base=base, head=head, op=op, by='group').round(4)
+        columns = [{'name': c, 'id': c} for c in result.columns]
+        return result.to_dict('records'), columns
+    except Exception as e:
+        print(f'compare_response: got exception type {type(e)}:\n{e}')
+        return [], []
+
+
+def set_args():
+    query_file_help = 'file containing a SELECT whose rows replace the caliper_metrics table, ' \
+                      f'must return at least the columns {", ".join(required_columns)}'
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--query-file', type=str, dest='query_file', default=None, help=query_file_help)
+    # accept --query-file after the subcommand, too, without clobbering a value given before it
+    common = argparse.ArgumentParser(add_help=False)
+    common.add_argument('--query-file', type=str, dest='query_file', default=argparse.SUPPRESS, help=query_file_help)
+    sub = parser.add_subparsers(dest='command')
+    sub.add_parser('serve', parents=[common], help='serve the dashboard (default)')
+    cmp = sub.add_parser('compare', parents=[common], help='print a regression table comparing two builds')
+    cmp.add_argument('--base', type=str, dest='base', required=True, help='baseline build version')
+    cmp.add_argument('--head', type=str, dest='head', required=True, help='candidate build version')
+    cmp.add_argument('--metric', type=str, dest='metric', choices=list(metric_getters.keys()),
+                     help='limit the comparison to one metric')
+    cmp.add_argument('--op', type=str, dest='op', default='q95_value', choices=value_columns,
+                     help='aggregation column to compare')
+    cmp.add_argument('--by', type=str, dest='by', default='owner_name', choices=['owner_name', 'group', 'namespace'],
+                     help='grouping to compare on, owner_name is the app')
+    cmp.add_argument('--format', type=str, dest='format', default='text', choices=['text', 'markdown'],
+                     help='output format, markdown is suitable for pull request comments')
+    cmp.add_argument('--fail-threshold', type=float, dest='fail_threshold', default=None,
+                     help='fail the check when any increase exceeds this percentage and is not shown to be noise')
+    cmp.add_argument('--github-repo', type=str, dest='github_repo', help='owner/name of the repository to report to')
+    cmp.add_argument('--github-pr', type=int, dest='github_pr', help='pull request number to comment on')
+    cmp.add_argument('--github-sha', type=str, dest='github_sha', help='commit to set the caliper status on')
+    cmp.add_argument('--github-token', type=str, dest='github_token', default=os.getenv('GITHUB_TOKEN'),
+                     help='GitHub API token, defaults to $GITHUB_TOKEN')
+    return parser.parse_args()
+
+
+def compare_results(args=argparse.Namespace()):
+    metrics = [args.metric] if args.metric else list(metric_getters.keys())
+    results = {}
+    for m in metrics:
+        results[m] = compare.compare_builds(metric_getters[m](), base=args.base, head=args.head, op=args.op, by=args.by)
+    return results
+
+
+def report_to_github(args=argparse.Namespace(), results=None, failed=False):
+    if not args.github_repo:
+        return
+    if not args.github_token:
+        raise Exception('--github-repo requires --github-token or $GITHUB_TOKEN')
+    if args.github_pr:
+        body = compare.to_markdown(results, by=args.by, base=args.base, head=args.head)
+        github.post_comment(repo=args.github_repo, pr=args.github_pr, body=body, token=args.github_token)
+        print(f'posted comparison to {args.github_repo}#{args.github_pr}')
+    if args.github_sha:
+        if failed:
+            state, description = 'failure', f'regressions above {args.fail_threshold}% vs {args.base}'
+        else:
+            state, description = 'success', f'no regressions vs {args.base}'
+        github.post_status(repo=args.github_repo, sha=args.github_sha, state=state, description=description,
+                           token=args.github_token)
+        print(f'set caliper status {state} on {args.github_sha}')
+
+
+def compare_command(args=argparse.Namespace()):
+    results = compare_results(args)
+    if args.format == 'markdown':
+        print(compare.to_markdown(results, by=args.by, base=args.base, head=args.head), end='')
+    else:
+        print(compare.to_text(results), end='')
+    failed = False
+    if args.fail_threshold is not None:
+        failed = len(compare.regressions(results, threshold=args.fail_threshold)) > 0
+    report_to_github(args, results, failed)
+    return 1 if failed else 0
+
+
 if __name__ == '__main__':
-    app.run_server(debug=True, port=8050, host='0.0.0.0')
+    args = set_args()
+    if args.query_file:
+        custom_query = load_query_file(args.query_file)
+    if args.command == 'compare':
+        sys.exit(compare_command(args))
+    else:
+        app.run_server(debug=True, port=8050, host='0.0.0.0')
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// reauthTransport authenticates requests with the credentials of the kubeconfig, or of --prometheus-token-file or
+// --prometheus-token-vault, reloading them when a request is rejected as unauthorized.  Collections that sample for
+// hours outlive the short-lived tokens issued by OAuth servers and exec plugins.  client-go re-reads token files and
+// refreshes exec credentials on its own, but only for later requests, and never re-reads the kubeconfig, which is
+// where `oc login` writes a renewed token.  The rejected request is retried once with the reloaded credentials.
+type reauthTransport struct {
+	mu sync.Mutex
+	rt http.RoundTripper
+	// generation counts reloads, so that concurrent requests rejected with the same credentials reload them once.
+	generation int
+}
+
+// newReauthTransport returns a reauthTransport, initially using the credentials of cfg.
+func newReauthTransport(cfg *rest.Config) (*reauthTransport, error) {
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &reauthTransport{rt: rt}, nil
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	rt, generation := t.rt, t.generation
+	t.mu.Unlock()
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	retry, ok := rewind(req)
+	if !ok {
+		return resp, nil
+	}
+	rt, err = t.reload(generation)
+	if err != nil {
+		klog.Warningf("request rejected as unauthorized, reloading credentials failed: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+	return rt.RoundTrip(retry)
+}
+
+// reload replaces the transport unless another request already did since generation, and returns the current one.
+func (t *reauthTransport) reload(generation int) (http.RoundTripper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.generation != generation {
+		return t.rt, nil
+	}
+	klog.Info("request rejected as unauthorized, reloading credentials")
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err = overrideToken(cfg); err != nil {
+		return nil, err
+	}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.rt = rt
+	t.generation++
+	return rt, nil
+}
+
+// rewind returns a copy of req that can be sent again, false if its body cannot be replayed.
+func rewind(req *http.Request) (*http.Request, bool) {
+	retry := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return retry, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	retry.Body = body
+	return retry, true
+}
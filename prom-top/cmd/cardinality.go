@@ -0,0 +1,51 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/report"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const cardinalityUsage = `usage: prom-top cardinality [--cardinality-limit <n>]`
+
+// cardinalityCommand writes the series counts per metric and the top label cardinality contributors of the
+// cluster's prometheus, which drive the footprint of the monitoring stack as much as the usage of its pods.
+func cardinalityCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(cardinalityUsage)
+	}
+	if cardinalityLimit < 0 {
+		return fmt.Errorf("--cardinality-limit must not be negative")
+	}
+	cfg, err := optionalClusterConfig()
+	if err != nil {
+		return err
+	}
+	ctx := signalContext()
+	conn, err := prometheusClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	status, err := top.Cardinality(ctx, conn, cardinalityLimit)
+	if err != nil {
+		return err
+	}
+	return report.WriteCardinality(os.Stdout, status)
+}
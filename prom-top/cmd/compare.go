@@ -0,0 +1,86 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/compare"
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// compareCommand collects fresh metrics and prints their change against the rows stored in postgres for
+// --baseline-build, without writing the fresh metrics anywhere.
+func compareCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(compareUsage)
+	}
+	if baselineBuild == "" {
+		return fmt.Errorf("compare requires a baseline: add --baseline-build\n%s", compareUsage)
+	}
+	agg := top.Aggregation(compareAggregate)
+	if !validAggregation(agg) {
+		return fmt.Errorf("unknown --compare-aggregation %q: use one of avg, max, min, q95, inst", compareAggregate)
+	}
+	if err := dbhandler.ValidateConfig(); err != nil {
+		return fmt.Errorf("compare requires a database: %v. Set them in the environment or the .env file next to the binary", err)
+	}
+	if err := validateFlags(); err != nil {
+		return err
+	}
+
+	db, err := dbhandler.NewPostgresClient()
+	if err != nil {
+		return fmt.Errorf("connecting to db: %v", err)
+	}
+	defer db.Close()
+	rows, err := dbhandler.SelectRows(db, baselineBuild)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no rows stored for baseline build %q", baselineBuild)
+	}
+	baseline := make(top.PodMetricTable, 0, len(rows))
+	for i := range rows {
+		m := top.PodMetric(rows[i])
+		baseline = append(baseline, &m)
+	}
+
+	cache, err := newCache()
+	if err != nil {
+		return err
+	}
+	current, err := collect(signalContext(), cache)
+	if err != nil {
+		return err
+	}
+	klog.Infof("comparing %d fresh results against %d rows of %s", len(current), len(baseline), baselineBuild)
+	return compare.WriteText(os.Stdout, compare.Compare(baseline, current, agg))
+}
+
+func validAggregation(agg top.Aggregation) bool {
+	for _, a := range top.Aggregations {
+		if a == agg {
+			return true
+		}
+	}
+	return false
+}
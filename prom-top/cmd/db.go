@@ -0,0 +1,216 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const dbUsage = `usage: prom-top db <command>
+Commands:
+	migrate  bring the database schema up to date
+	export   write the rows stored for --build to --output-file, or stdout, as --format csv (the default) or parquet
+	import   store the rows of csv files written by --format csv under --build, in --cpu-unit and --memory-unit
+	ping     verify the database is reachable, migrated, and writable by the configured user
+	summary  list the builds stored in the database with their run and row counts, time span, and metrics`
+
+const compareUsage = `usage: prom-top compare --baseline-build <version>`
+
+// runSubcommand executes the subcommand named by the first positional argument.  Subcommands share the global flags.
+func runSubcommand(args []string) error {
+	switch args[0] {
+	case "db":
+		return dbCommand(args[1:])
+	case "compare":
+		return compareCommand(args[1:])
+	case "diff":
+		return diffCommand(args[1:])
+	case "merge":
+		return mergeCommand(args[1:])
+	case "cardinality":
+		return cardinalityCommand(args[1:])
+	case "metrics":
+		return metricsCommand(args[1:])
+	case "nodes":
+		return nodesCommand(args[1:])
+	case "schema":
+		return schemaCommand(args[1:])
+	case "validate":
+		return validateCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(dbUsage)
+	}
+	if args[0] == "import" {
+		return importDatabase(args[1:])
+	}
+	if len(args) != 1 {
+		return fmt.Errorf(dbUsage)
+	}
+	switch args[0] {
+	case "migrate":
+		return migrateDatabase()
+	case "export":
+		return exportDatabase()
+	case "ping":
+		return pingDatabase()
+	case "summary":
+		return summarizeDatabase()
+	default:
+		return fmt.Errorf("unknown db command %q\n%s", args[0], dbUsage)
+	}
+}
+
+// openDatabase connects to the database configured in the environment for the db subcommand named command.
+func openDatabase(command string) (*sqlx.DB, error) {
+	if err := dbhandler.ValidateConfig(); err != nil {
+		return nil, fmt.Errorf("db %s requires a database: %v. Set them in the environment or the .env file next to the binary", command, err)
+	}
+	db, err := dbhandler.NewPostgresClient()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to db: %v", err)
+	}
+	return db, nil
+}
+
+func migrateDatabase() error {
+	db, err := openDatabase("migrate")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	n, err := dbhandler.Migrate(db)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		klog.Infof("schema is up to date at version %d", dbhandler.LatestSchemaVersion())
+		return nil
+	}
+	klog.Infof("applied %d migrations, schema is at version %d", n, dbhandler.LatestSchemaVersion())
+	return nil
+}
+
+func exportDatabase() error {
+	if build == "" {
+		return fmt.Errorf("db export requires the cluster version to export: add --build")
+	}
+	var write func(w io.Writer, rows []dbhandler.Row) error
+	switch format {
+	case "stdout", "csv":
+		write = func(w io.Writer, rows []dbhandler.Row) error {
+			table := make(top.PodMetricTable, 0, len(rows))
+			for i := range rows {
+				m := top.PodMetric(rows[i])
+				table = append(table, &m)
+			}
+			return table.WriteCSV(w)
+		}
+	case "parquet":
+		write = dbhandler.WriteParquet
+	default:
+		return fmt.Errorf("db export writes csv or parquet, not %q: use --format csv | --format parquet", format)
+	}
+	db, err := openDatabase("export")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := dbhandler.CheckSchema(db); err != nil {
+		return err
+	}
+	rows, err := dbhandler.SelectRows(db, build)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no rows stored for build %q: see prom-top db summary", build)
+	}
+	if outputFile == "" || outputFile == "-" {
+		return write(os.Stdout, rows)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("creating export: %v", err)
+	}
+	if err = write(f, rows); err != nil {
+		f.Close()
+		return fmt.Errorf("writing export: %v", err)
+	}
+	klog.Infof("exported %d rows of build %s to %s", len(rows), build, outputFile)
+	return f.Close()
+}
+
+func pingDatabase() error {
+	db, err := openDatabase("ping")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	h, err := dbhandler.Ping(db)
+	if err != nil {
+		return err
+	}
+	schema := h.Schema
+	if schema == "" {
+		schema = "(not yet created)"
+	}
+	klog.Infof("connected to postgres %s as %s, schema %s", h.Server, h.User, schema)
+	if len(h.Missing) > 0 {
+		return fmt.Errorf("user %s lacks privileges: %s", h.User, strings.Join(h.Missing, ", "))
+	}
+	if err := dbhandler.CheckSchema(db); err != nil {
+		return err
+	}
+	klog.Infof("schema is at version %d, ready to write", h.SchemaVersion)
+	return nil
+}
+
+func summarizeDatabase() error {
+	db, err := openDatabase("summary")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := dbhandler.CheckSchema(db); err != nil {
+		return err
+	}
+	summaries, err := dbhandler.Summarize(db)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "version\truns\trows\tfirst\tlast\tmetrics\t")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t\n", s.Version, s.Runs, s.Rows, s.FirstTime, s.LastTime, s.Metrics)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/diff"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const diffUsage = `usage: prom-top diff <a.csv> <b.csv>`
+
+// diffCommand prints the change of every row between two results saved with --format csv.  No cluster or database
+// is involved.
+func diffCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(diffUsage)
+	}
+	agg := top.Aggregation(compareAggregate)
+	if !validAggregation(agg) {
+		return fmt.Errorf("unknown --compare-aggregation %q: use one of avg, max, min, q95, inst", compareAggregate)
+	}
+	a, err := readCSV(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readCSV(args[1])
+	if err != nil {
+		return err
+	}
+	rows, summaries, err := diff.Diff(a, b, agg)
+	if err != nil {
+		return err
+	}
+	return diff.WriteText(os.Stdout, rows, summaries)
+}
+
+func readCSV(path string) (top.PodMetricTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	table, err := top.ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return table, nil
+}
@@ -16,21 +16,151 @@ limitations under the License.
 package main
 
 import (
-	"os"
-	"path/filepath"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
-	"k8s.io/klog"
-)
+	"k8s.io/apimachinery/pkg/api/resource"
 
-const kubeconfigEnv = "KUBECONFIG"
+	"github.com/redhat-et/caliper/prom-top/pkg/budget"
+	"github.com/redhat-et/caliper/prom-top/pkg/cloudwatch"
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/sink"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/webhook"
+)
 
 var (
-	kubeconfig string
-	queryType  string
-	queryRange string
-	toDb       bool
-	version    string
+	kubeconfig  string
+	kubeContext string
+	queryType   string
+	contexts    []string
+	queryRange  string
+	toDb        bool
+	format      string
+	schema      string
+	dbBatchSize int
+	dbDriver    string
+	dbDeltas    bool
+	dbTLS       dbhandler.TLSConfig
+	outputFile  string
+	build       string
+	clusterName string
+	cacheTTL    time.Duration
+	cacheDir    string
+	maxQueries  int
+	shardSize   int
+
+	queryRetries      int
+	queryRetryBackoff time.Duration
+	queryRetryJitter  float64
+	queryTimeout      time.Duration
+	deadline          time.Duration
+
+	recordDir             string
+	replayDir             string
+	metricsServerFallback bool
+	ignoreOptOut          bool
+	strict                bool
+	continueOnError       bool
+	preflight             bool
+	preflightStaleAfter   time.Duration
+
+	samples        int
+	sampleInterval time.Duration
+	federate       bool
+
+	interval   time.Duration
+	iterations int
+
+	efficiencySummary int
+
+	cpuUnit    string
+	memoryUnit string
+	precision  int
+	notation   string
+	noColor    bool
+
+	burstyThreshold float64
+	deltaThreshold  float64
+
+	minCPU          float64
+	minMemory       string
+	topPerNamespace int
+
+	reportModes    []string
+	showTotals     bool
+	showQueryStats bool
+	summary        string
+	idleCPU        float64
+	idleMemory     string
+	noisyShare     float64
+
+	prometheusStats bool
+
+	budgetFile      string
+	failOnViolation bool
+
+	baselineBuild    string
+	compareAggregate string
+	reaggregate      bool
+
+	distributionAggregate string
+	cardinalityLimit      int
+
+	downsampleThreshold time.Duration
+	downsampleStep      string
+
+	promRouteNamespace string
+	promRouteName      string
+	prometheusURL      string
+	promTokenFile      string
+	promTokenVault     string
+	promUser           string
+	promPassword       string
+	promHeaderPairs    []string
+	promTenant         string
+	promCACert         string
+	promClientCert     string
+	promClientKey      string
+	promInsecure       bool
+
+	thanos                bool
+	thanosDedup           bool
+	thanosReplicaLabels   []string
+	thanosPartialResponse bool
+
+	matchers     []string
+	histograms   []string
+	metricRegex  string
+	customLabels []string
+
+	splitContainers bool
+	sidecars        []string
+
+	podLabels []string
+
+	bigqueryProject     string
+	bigqueryDataset     string
+	bigqueryTable       string
+	bigqueryCredentials string
+
+	cloudwatchRegion    string
+	cloudwatchNamespace string
+
+	datadogSite string
+	datadogTags []string
+
+	webhookURL     string
+	webhookToken   string
+	webhookRetries int
+
+	remoteWriteURL    string
+	remoteWriteToken  string
+	remoteWriteLabels []string
 )
 
 const rangeHelp = `The range of time over which metrics are collected, starting from now() - range until now()
@@ -55,28 +185,491 @@ One of:
 Example:
 	$ prom-top -t i # query instant vectors`
 
-func init() {
-	home, _ := os.UserHomeDir()
-	kubeconfigDefault := filepath.Join(home, `.kube/config`)
+const formatHelp = `Output format. One of:
+	"stdout"      print results as a table
+	"csv"         write results as CSV to --output-file
+	"postgres"    push results to the postgres database configured in the .env file
+	"bigquery"    stream results into the table given by the --bigquery-* flags
+	"cloudwatch"  publish results as CloudWatch custom metrics, using the AWS_* credentials in the environment
+	"datadog"     post results to the Datadog metrics API, using the DD_API_KEY in the environment
+	"json"        write results with run metadata as a JSON document to --output-file, one line per --interval
+	              iteration. The document is described by 'prom-top schema'
+	"proto"       write results as a binary protobuf PodMetricTable, see pkg/resultpb/result.proto, to --output-file.
+	              --interval iterations are appended and decode as a single table
+	"arrow"       write results as an Arrow IPC stream with the columns of the caliper_metrics table to --output-file.
+	              --interval iterations are appended as further record batches
+	"webhook"     POST results with run metadata as a JSON document to --webhook-url
+	"remote-write"
+	              send results as time series to the prometheus remote_write endpoint --remote-write-url, e.g. of
+	              Mimir, Thanos Receive, or Grafana Cloud
+Builds of prom-top that import additional sinks accept their names as well.`
 
-	pflag.StringVar(&kubeconfig, "kubeconfig", kubeconfigDefault, "Path to kubeconfig file")
+const schemaHelp = `Shape of csv and postgres output. One of:
+	"wide"  one row per pod and metric, with a column per aggregation
+	"long"  one row per pod, metric, and aggregation, named in an aggregation column. Postgres rows are written to
+	        the caliper_metrics_long table`
+
+func init() {
+	pflag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file. Defaults to $KUBECONFIG, which may list several colon-separated files, then ~/.kube/config")
+	pflag.StringVar(&kubeContext, "context", "", "kubeconfig context to use. Defaults to the current context")
+	pflag.StringSliceVar(&contexts, "contexts", nil, "kubeconfig contexts of several clusters to collect in turn, e.g. --contexts ctx1,ctx2. Each cluster's results are written as a run of their own, labeled with its cluster ID, or the context name if the cluster has none")
 	pflag.StringVarP(&queryType, "agg", "a", "", aggregationHelp)
 	pflag.StringVar(&queryRange, "range", "", rangeHelp)
-	pflag.BoolVar(&toDb, "postgres", false, "when set, pushes output to postgres database configured in the .env file. --version flag required")
-	pflag.StringVarP(&version, "ocp-version", "v", "", "the version of ocp executed against")
+	pflag.StringArrayVar(&matchers, "match", nil, "label matcher added to every query, e.g. --match namespace=~'openshift-.*'. Repeatable. Values are taken literally and must not be quoted. kube-state-metrics series are only matched on the namespace, pod, and, for requests, container labels")
+	pflag.StringArrayVar(&histograms, "histogram", nil, "also collect the q95, max, and average of this prometheus histogram, named without its _bucket suffix, e.g. --histogram storage_operation_duration_seconds. Repeatable")
+	pflag.BoolVar(&splitContainers, "split-containers", false, "report the cpu and memory of each pod's init containers, each of its --sidecars, and its remaining, main containers as separate rows, named in the component column")
+	pflag.StringSliceVar(&sidecars, "sidecars", top.DefaultSidecars, "sidecar containers split from the main container by --split-containers, comma separated or repeated")
+	pflag.StringSliceVar(&podLabels, "pod-labels", nil, "pod labels joined from kube-state-metrics' kube_pod_labels onto every result, e.g. app.kubernetes.io/name,app.kubernetes.io/component, recorded in the pod_labels column and shown as a column each on stdout. kube-state-metrics must export them, see its --metric-labels-allowlist")
+	pflag.StringVar(&metricRegex, "metric-regex", "", "also collect every metric of the cluster whose name matches this regular expression, e.g. --metric-regex 'container_(network|fs)_.*'. Counters are collected as per-second rates, histograms like --histogram, and other metrics as gauges. Summaries are skipped")
+	pflag.StringArrayVar(&customLabels, "label", nil, "custom label attached to every result and stored by every sink, e.g. --label scenario=density-1000, so runs can be sliced by it later. Repeatable")
+	pflag.StringVar(&promTokenFile, "prometheus-token-file", "", "file holding the bearer token used for the cluster and prometheus, e.g. a mounted secret, instead of the kubeconfig's. It is re-read as it changes")
+	pflag.StringVar(&promTokenVault, "prometheus-token-vault", "", "vault reference, path#field, to the bearer token used for the cluster and prometheus instead of the kubeconfig's. See VAULT_ADDR in the README")
+	pflag.BoolVar(&metricsServerFallback, "metrics-server-fallback", false, "when prometheus is unreachable or its queries fail, read an instant snapshot of pod usage from the metrics.k8s.io API instead. Snapshot rows only have instant values and their range is \"metrics-server\"")
+	pflag.StringVar(&recordDir, "record", "", "save the raw prometheus responses of the collection, and what was read from the cluster, to this directory for --replay")
+	pflag.StringVar(&replayDir, "replay", "", "instead of querying the cluster, collate the responses saved to this directory by --record and write them to the sink. The query flags must match the recorded run's")
+	pflag.BoolVar(&continueOnError, "continue-on-error", false, "write the results of the queries that succeed when others fail, e.g. by timing out, instead of failing the run. The failed queries are logged and listed by --query-stats, and the rows of their metrics are marked partial")
+	pflag.BoolVar(&strict, "strict", false, "fail the run if prometheus returns warnings with any query, e.g. of dropped samples or a failing replica, instead of writing possibly partial results. Without it the warnings are logged")
+	pflag.BoolVar(&preflight, "preflight", false, "before collecting, check that prometheus is ready and that the kube-state-metrics and cAdvisor targets behind the queries are up and freshly scraped, warning of any problem")
+	pflag.DurationVar(&preflightStaleAfter, "preflight-stale-after", top.DefaultStaleAfter, "age of a target's last scrape beyond which --preflight warns that it is stale")
+	pflag.BoolVar(&ignoreOptOut, "ignore-opt-out", false, "also collect the namespaces annotated caliper.redhat-et.io/exclude=true, which are skipped by default")
+	pflag.StringVar(&promRouteNamespace, "prom-route-namespace", promNamespace, "namespace of the prometheus route")
+	pflag.StringVar(&promRouteName, "prom-route-name", promRoute, "name of the prometheus route")
+	pflag.StringVar(&prometheusURL, "prometheus-url", "", "base URL of the prometheus query API, e.g. http://localhost:9090 for a port-forward, used instead of discovering the route. Only the token of --prometheus-token-file or --prometheus-token-vault is sent to it. Access to the cluster becomes optional, set --cluster-name and --build without it")
+	pflag.StringVar(&promUser, "prom-user", "", "user name sent to --prometheus-url with basic auth, e.g. to an nginx proxy in front of prometheus")
+	pflag.StringVar(&promPassword, "prom-password", "", "password of --prom-user. Defaults to $CALIPER_PROM_PASSWORD")
+	pflag.StringArrayVar(&promHeaderPairs, "prom-header", nil, "header sent with every request to prometheus, e.g. --prom-header X-Forwarded-User=ci for an oauth2-proxy. Repeatable")
+	pflag.StringVar(&promTenant, "tenant-id", "", "tenant queried in a Cortex or Mimir cluster storing the metrics, sent as the X-Scope-OrgID header, e.g. --tenant-id prod. Join several with | to query them together where federation is enabled")
+	pflag.StringVar(&promCACert, "ca-cert", "", "CA certificate file verifying --prometheus-url, for endpoints signed by a private CA. Defaults to the system's CAs")
+	pflag.StringVar(&promClientCert, "client-cert", "", "client certificate file authenticating to --prometheus-url with mutual TLS. Requires --client-key")
+	pflag.StringVar(&promClientKey, "client-key", "", "private key file of --client-cert")
+	pflag.BoolVar(&promInsecure, "insecure-skip-tls-verify", false, "do not verify the certificate of --prometheus-url. Insecure, for testing only")
+	pflag.BoolVar(&thanos, "thanos", false, "query prometheus as a Thanos Query endpoint, e.g. the thanos-querier route or --prometheus-url, sending the --thanos-* options with every query")
+	pflag.BoolVar(&thanosDedup, "thanos-dedup", true, "with --thanos, merge the series of the replicas of each prometheus, told apart by --thanos-replica-label")
+	pflag.StringSliceVar(&thanosReplicaLabels, "thanos-replica-label", nil, "with --thanos, external label distinguishing the replicas of a prometheus, e.g. prometheus_replica. Repeatable, defaults to the querier's --query.replica-label")
+	pflag.BoolVar(&thanosPartialResponse, "thanos-partial-response", false, "with --thanos, accept the answers of the querier when some of its stores fail. Their warnings are logged and the rows of the affected metrics are marked partial; --strict fails the run instead")
+	pflag.BoolVar(&toDb, "postgres", false, "when set, pushes output to postgres database configured in the .env file. Equivalent to --format postgres")
+	pflag.StringVar(&format, "format", "stdout", formatHelp)
+	pflag.StringVar(&bigqueryProject, "bigquery-project", "", "GCP project of the BigQuery destination table")
+	pflag.StringVar(&bigqueryDataset, "bigquery-dataset", "", "dataset of the BigQuery destination table")
+	pflag.StringVar(&bigqueryTable, "bigquery-table", "", "BigQuery destination table")
+	pflag.StringVar(&bigqueryCredentials, "bigquery-credentials", "", "service account key file used to write to BigQuery. Defaults to $GOOGLE_APPLICATION_CREDENTIALS")
+	pflag.StringVar(&cloudwatchRegion, "cloudwatch-region", "", "AWS region to publish CloudWatch metrics to. Defaults to $AWS_REGION")
+	pflag.StringVar(&cloudwatchNamespace, "cloudwatch-namespace", cloudwatch.DefaultNamespace, "CloudWatch namespace to publish metrics under")
+	pflag.StringVar(&datadogSite, "datadog-site", "", "Datadog site to post metrics to, e.g. datadoghq.eu. Defaults to $DD_SITE, then datadoghq.com")
+	pflag.StringArrayVar(&datadogTags, "datadog-tag", nil, "tag added to every Datadog series, e.g. --datadog-tag env:ci. Repeatable")
+	pflag.StringVar(&webhookURL, "webhook-url", "", "endpoint results are POSTed to by --format webhook")
+	pflag.StringVar(&webhookToken, "webhook-token", "", "bearer token sent to --webhook-url. Defaults to $CALIPER_WEBHOOK_TOKEN")
+	pflag.IntVar(&webhookRetries, "webhook-retries", webhook.DefaultRetries, "number of times a failed webhook delivery is retried")
+	pflag.StringVar(&remoteWriteURL, "remote-write-url", "", "prometheus remote_write endpoint results are sent to by --format remote-write, e.g. https://mimir.example.com/api/v1/push")
+	pflag.StringVar(&remoteWriteToken, "remote-write-token", "", "bearer token sent to --remote-write-url. Defaults to $CALIPER_REMOTE_WRITE_TOKEN")
+	pflag.StringArrayVar(&remoteWriteLabels, "remote-write-label", nil, "label added to every remote_write series, e.g. --remote-write-label env=ci. Repeatable")
+	pflag.StringVar(&schema, "schema", "wide", schemaHelp)
+	pflag.StringVar(&dbTLS.Mode, "db-sslmode", "", "postgres TLS mode: disable, allow, prefer, require, verify-ca, or verify-full. Defaults to $PGSSLMODE, then prefer")
+	pflag.StringVar(&dbTLS.RootCert, "db-sslrootcert", "", "CA certificate file verifying the postgres server, for --db-sslmode verify-ca and verify-full. Defaults to $PGSSLROOTCERT")
+	pflag.StringVar(&dbTLS.Cert, "db-sslcert", "", "client certificate file authenticating to postgres. Defaults to $PGSSLCERT")
+	pflag.StringVar(&dbTLS.Key, "db-sslkey", "", "private key file of --db-sslcert. Defaults to $PGSSLKEY")
+	pflag.StringVar(&dbDriver, "db-driver", "sql", "how rows are written to postgres: sql, multi-row INSERT statements through database/sql, or pgx, a prepared INSERT pipelined over a native connection, faster for large writes")
+	pflag.IntVar(&dbBatchSize, "db-batch-size", 500, "maximum number of rows per postgres INSERT statement")
+	pflag.BoolVar(&dbDeltas, "db-deltas", false, "after inserting the results into postgres, store the change of each row's q95 since the most recent earlier run of another build in its baseline_version, q95_delta, and q95_delta_pct columns")
+	pflag.StringVarP(&outputFile, "output-file", "o", "", "file to write csv output, or the output of prom-top merge and db export, to. Defaults to stdout")
+	pflag.StringVarP(&build, "ocp-version", "v", "", "the version of ocp executed against")
+	_ = pflag.CommandLine.MarkDeprecated("ocp-version", "use --build")
+	pflag.Float64Var(&minCPU, "min-cpu", 0, "drop cpu results whose q95 usage, in cores, is below this floor before output")
+	pflag.StringVar(&minMemory, "min-memory", "", "drop memory results whose q95 usage is below this floor, e.g. 50Mi, before output")
+	pflag.StringVar(&cpuUnit, "cpu-unit", "cores", "unit of cpu values written to stdout, csv, and webhook output. One of: cores, millicores")
+	pflag.StringVar(&memoryUnit, "memory-unit", "bytes", "unit of memory values written to stdout, csv, and webhook output. One of: bytes, KiB, MiB, GiB")
+	pflag.IntVar(&precision, "precision", -1, "digits after the decimal point of values in csv, log, and report output. Defaults to full precision in csv and 4 significant digits in reports")
+	pflag.StringVar(&notation, "notation", "", "notation of values in csv, log, and report output, one of fixed, scientific. Defaults to scientific in csv")
+	pflag.BoolVar(&noColor, "no-color", false, "disable the colors of --format stdout output, e.g. when it is captured in logs. Colors are only used on terminals")
+	pflag.Float64Var(&burstyThreshold, "bursty-threshold", 3, "--format stdout highlights rows whose max usage is at least this many times their average, and --report spikes lists them. 0 disables the highlight")
+	pflag.Float64Var(&deltaThreshold, "delta-threshold", 0.2, "with --interval, --format stdout shows the change of each row's q95 since the previous iteration, and highlights rows whose q95 changed by at least this fraction of it, growth in magenta and shrinkage in green. 0 disables the highlight")
+	pflag.IntVar(&topPerNamespace, "top-per-namespace", 0, "when non-zero, keep only this many pods of each namespace and metric, those with the highest q95 usage")
+	pflag.IntVar(&efficiencySummary, "efficiency-summary", 10, "log this many least efficient apps, by average usage over requests, at the end of the run. 0 disables the summary")
+	pflag.BoolVar(&showTotals, "totals", false, "write the total usage of the collected pods, per metric for the whole cluster and per namespace, to stdout after the results. --format webhook adds them to the document")
+	pflag.StringVar(&summary, "summary", "log", "summary of the pods, namespaces, and nodes counted, the queries executed, the series they returned, and the wall-clock duration of every collection: log logs it, footer writes it to stdout after the results, none omits it")
+	pflag.BoolVar(&showQueryStats, "query-stats", false, "write the wall time, series count, and warnings of every prometheus query to stdout after the results, slowest first. --format postgres stores them in the caliper_query_stats table")
+	pflag.BoolVar(&prometheusStats, "prometheus-stats", false, "ask prometheus for the execution stats of every query (stats=all) and log the total evaluation time and samples loaded. --query-stats adds them per query. Samples are reported by prometheus 2.35 and later")
+	pflag.StringSliceVar(&reportModes, "report", nil, "analyses written to stdout after the results, comma separated or repeated. One of: distribution, idle, noisy-neighbors, per-replica, quota, spikes")
+	pflag.Float64Var(&idleCPU, "idle-cpu", 0.001, "idle report: apps whose pods' q95 cpu usage, in cores, stays below this floor are idle")
+	pflag.StringVar(&idleMemory, "idle-memory", "32Mi", "idle report: apps whose pods' q95 memory usage stays below this floor are idle")
+	pflag.Float64Var(&noisyShare, "noisy-share", 0.6, "noisy-neighbors report: pods using more than this fraction of their node's combined pod usage are flagged")
+	pflag.StringVar(&budgetFile, "budget-file", "", "YAML file of per-namespace and per-app q95 cpu and memory budgets. Rows exceeding their budget are flagged in the violation column")
+	pflag.BoolVar(&failOnViolation, "fail-on-violation", false, "exit non-zero, after writing the results, if any row exceeds its budget. requires --budget-file")
+	pflag.StringVar(&baselineBuild, "baseline-build", "", "prom-top compare: cluster version whose rows in postgres are the baseline")
+	pflag.StringVar(&build, "build", "", "the build under test, e.g. the OpenShift release, recorded in the version column of every result. Defaults to the version of the ClusterVersion resource. prom-top db export: build whose rows in postgres are exported. prom-top db import: build the imported rows are stored under")
+	pflag.StringVar(&distributionAggregate, "distribution-aggregation", string(top.Average), "distribution report: per-pod aggregation whose spread across the pods of each app is reported, one of avg, max, min, q95, inst")
+	pflag.IntVar(&cardinalityLimit, "cardinality-limit", 10, "prom-top cardinality: number of metrics, labels, and label pairs listed in each table")
+	pflag.StringVar(&compareAggregate, "compare-aggregation", string(top.Quantile95), "prom-top compare and diff: aggregation compared, one of avg, max, min, q95, inst")
+	pflag.BoolVar(&reaggregate, "reaggregate", false, "prom-top merge: combine the rows of the same cluster, namespace, pod, and metric into one")
+	pflag.StringVar(&clusterName, "cluster-name", "", "name recorded in the cluster column of every result. Defaults to the cluster ID of the ClusterVersion resource")
+	pflag.DurationVar(&cacheTTL, "cache-ttl", 0, "when non-zero, reuse query results younger than this duration instead of re-querying prometheus")
+	pflag.IntVar(&maxQueries, "max-concurrency", 4, "maximum number of prometheus queries executed in parallel")
+	pflag.IntVar(&queryRetries, "query-retries", 2, "number of times a query failing transiently, with a network error, a timeout, or a 5xx response such as a route's 502, is retried. 0 disables retries")
+	pflag.DurationVar(&queryRetryBackoff, "query-retry-backoff", time.Second, "delay before the first retry of a query, doubled for each subsequent one")
+	pflag.Float64Var(&queryRetryJitter, "query-retry-jitter", 0.2, "fraction by which each retry delay is randomized, so that queries failing together do not retry in lockstep")
+	pflag.DurationVar(&queryTimeout, "query-timeout", 0, "time after which an attempt of a query is abandoned, and retried per --query-retries. 0 waits for prometheus to answer")
+	pflag.DurationVar(&deadline, "deadline", 0, "time after which the collection stops and the results collected so far are written, marked partial. 0 disables the deadline")
+	pflag.StringVar(&cacheDir, "cache-dir", "", "persist the query cache in this directory so it is shared between invocations. requires --cache-ttl")
+	pflag.DurationVar(&downsampleThreshold, "downsample-threshold", 24*time.Hour, "ranges longer than this are evaluated as subqueries at --downsample-step resolution to bound query cost. 0 disables downsampling")
+	pflag.StringVar(&downsampleStep, "downsample-step", "5m", "subquery resolution used when the range exceeds --downsample-threshold")
+	pflag.IntVar(&samples, "samples", 0, "when non-zero, instead of aggregating over --range in prometheus, execute the instant queries this many times, --sample-interval apart, and aggregate the samples client-side")
+	pflag.DurationVar(&sampleInterval, "sample-interval", time.Minute, "wall-clock time between the samples of --samples")
+	pflag.BoolVar(&federate, "federate", false, "take the samples of --samples from prometheus' /federate endpoint and aggregate them client-side, for clusters where the query API is blocked but federation is allowed. Requires --samples")
+	pflag.DurationVar(&interval, "interval", 0, "when non-zero, repeat the collection on this schedule, writing each iteration's results, numbered in the iteration column, to the sink as it completes")
+	pflag.IntVar(&iterations, "iterations", 0, "number of collections of --interval, 0 repeats until interrupted")
+	pflag.IntVar(&shardSize, "shard-size", 0, "when non-zero, split each query by groups of this many namespaces to avoid prometheus timeouts on large clusters")
 	pflag.Parse()
 
-	// If kubeconfig env var was set and no kubeconfig was provided via flag, use
-	// env var value.  Else leave it as default kubeconfig path ($HOME/.kube/config)
-	f := pflag.Lookup("kubeconfig")
-	if !f.Changed {
-		if kc, ok := os.LookupEnv(kubeconfigEnv); ok {
-			kubeconfig = kc
+	if toDb {
+		format = "postgres"
+	}
+}
+
+// numberFormat returns the rendering of values selected by --precision and --notation.
+func numberFormat() (top.NumberFormat, error) {
+	f := top.NumberFormat{Precision: precision}
+	switch notation {
+	case "":
+	case "fixed":
+		f.Notation = 'f'
+	case "scientific":
+		f.Notation = 'e'
+	default:
+		return f, fmt.Errorf("unknown notation %q", notation)
+	}
+	return f, nil
+}
+
+// writesOutputFile reports whether --format writes to --output-file.
+func writesOutputFile() bool {
+	switch format {
+	case "csv", "json", "proto", "arrow":
+		return true
+	}
+	return false
+}
+
+// validateFlags checks flag values and combinations up front, before any connection is made, so that mistakes are
+// reported with a fix instead of failing deep into a collection.  All problems are reported at once.
+func validateFlags() error {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if err := sink.Validate(format); err != nil {
+		problem("--format %s: %v", format, err)
+	}
+	switch schema {
+	case "wide":
+	case "long":
+		if format != "csv" && format != "postgres" {
+			problem("--schema long is only supported by --format csv and postgres: drop it or change --format")
+		}
+	default:
+		problem("unknown --schema %q: use one of wide, long", schema)
+	}
+	for _, r := range reportModes {
+		if _, ok := reports[r]; !ok {
+			problem("unknown --report %q: use one of %s", r, reportNames())
+		}
+		if r == "spikes" && burstyThreshold == 0 {
+			problem("--report spikes lists the rows above --bursty-threshold, which must not be 0")
+		}
+	}
+	if !validAggregation(top.Aggregation(distributionAggregate)) {
+		problem("unknown --distribution-aggregation %q: use one of avg, max, min, q95, inst", distributionAggregate)
+	}
+	// file formats go to stdout unless written to a file, where they would be interleaved with the tables
+	resultsOnStdout := writesOutputFile() && (outputFile == "" || outputFile == "-")
+	if len(reportModes) > 0 && resultsOnStdout {
+		problem("--report and --format %s both write to stdout: add -o | --output-file", format)
+	}
+	if showTotals && resultsOnStdout {
+		problem("--totals and --format %s both write to stdout: add -o | --output-file", format)
+	}
+	if showQueryStats && resultsOnStdout {
+		problem("--query-stats and --format %s both write to stdout: add -o | --output-file", format)
+	}
+	switch summary {
+	case "log", "none":
+	case "footer":
+		if resultsOnStdout {
+			problem("--summary footer and --format %s both write to stdout: add -o | --output-file or use --summary log", format)
+		}
+	default:
+		problem("unknown --summary %q: use one of log, footer, none", summary)
+	}
+	if _, err := resource.ParseQuantity(idleMemory); err != nil {
+		problem("invalid --idle-memory %q: use a quantity such as 32Mi", idleMemory)
+	}
+	if err := outputUnits().Validate(); err != nil {
+		problem("--cpu-unit, --memory-unit: %v", err)
+	}
+	if _, err := numberFormat(); err != nil {
+		problem("unknown --notation %q: use one of fixed, scientific", notation)
+	}
+	if burstyThreshold < 0 {
+		problem("--bursty-threshold must not be negative, 0 disables the highlight")
+	}
+	if deltaThreshold < 0 {
+		problem("--delta-threshold must not be negative, 0 disables the highlight")
+	}
+	if minCPU < 0 {
+		problem("--min-cpu must not be negative")
+	}
+	if minMemory != "" {
+		if _, err := resource.ParseQuantity(minMemory); err != nil {
+			problem("invalid --min-memory %q: use a quantity such as 50Mi", minMemory)
+		}
+	}
+	if preflightStaleAfter <= 0 {
+		problem("--preflight-stale-after must be positive")
+	}
+	if continueOnError && samples > 0 {
+		problem("--continue-on-error applies to the queries over --range, --samples fails on the first failed sample: drop one")
+	}
+	if prometheusStats && replayDir != "" {
+		problem("--prometheus-stats reports the load of queries on prometheus, which --replay does not query: drop one")
+	}
+	if preflight && replayDir != "" {
+		problem("--preflight checks the cluster's prometheus, which --replay does not query: drop one")
+	}
+	if interval < 0 {
+		problem("--interval must not be negative")
+	}
+	if iterations < 0 {
+		problem("--iterations must not be negative, 0 repeats until interrupted")
+	}
+	if iterations > 0 && interval == 0 {
+		problem("--iterations requires --interval, e.g. --interval 15m")
+	}
+	if recordDir != "" || replayDir != "" {
+		switch {
+		case recordDir != "" && replayDir != "":
+			problem("--record and --replay are exclusive")
+		case samples > 0:
+			problem("--record and --replay do not support --samples, whose queries repeat")
+		case interval > 0:
+			problem("--record and --replay do not support --interval, a recording holds a single collection")
+		case recordDir != "" && cacheTTL > 0:
+			problem("--record requires every query to reach prometheus: drop --cache-ttl")
+		}
+	}
+	if nodeMode {
+		switch {
+		case len(histograms) > 0 || metricRegex != "":
+			problem("prom-top nodes only collects the node metrics: drop --histogram and --metric-regex")
+		case splitContainers:
+			problem("prom-top nodes sums the pods of each node: drop --split-containers")
+		case len(podLabels) > 0:
+			problem("prom-top nodes sums the pods of each node: drop --pod-labels")
+		case samples > 0:
+			problem("prom-top nodes does not support --samples, prometheus computes its statistics over --range")
+		case shardSize > 0:
+			problem("prom-top nodes sums the pods of every namespace on each node: drop --shard-size")
+		case metricsServerFallback:
+			problem("--metrics-server-fallback snapshots pods rather than nodes: drop it")
+		case len(reportModes) > 0:
+			problem("--report analyzes pods rather than nodes: drop it")
+		}
+	}
+	if topPerNamespace < 0 {
+		problem("--top-per-namespace must not be negative, 0 keeps every pod")
+	}
+	if noisyShare <= 0 || noisyShare >= 1 {
+		problem("--noisy-share must be between 0 and 1, e.g. 0.6")
+	}
+	if outputFile != "" && !writesOutputFile() {
+		problem("--output-file is only used by --format csv, json, proto, and arrow: drop it or add --format csv")
+	}
+
+	switch queryType {
+	case "", "q", "a":
+	case "i":
+		if queryRange != "" {
+			problem("--range has no effect on instant queries (-a i): drop --range or use -a q | -a a")
+		}
+	default:
+		problem("unknown aggregation -a %q: use one of i, q, a", queryType)
+	}
+	if queryRange != "" {
+		if err := top.ValidateDuration(queryRange); err != nil {
+			problem("invalid --range %q: use a prometheus duration such as 10m or 2h", queryRange)
+		}
+	}
+	if err := top.ValidateDuration(downsampleStep); err != nil {
+		problem("invalid --downsample-step %q: use a prometheus duration such as 5m", downsampleStep)
+	}
+
+	for _, m := range matchers {
+		if _, err := top.ParseMatcher(m); err != nil {
+			problem("--match: %v", err)
 		}
 	}
 
-	if toDb && version == "" {
-		klog.Info("version flag (-v | --ocp-version) required")
-		os.Exit(1)
+	for _, h := range histograms {
+		if err := top.ValidateMetricName(h); err != nil {
+			problem("--histogram: %v", err)
+		}
+	}
+	if splitContainers {
+		if _, err := top.SplitTemplates(sidecars); err != nil {
+			problem("--sidecars: %v", err)
+		}
+	}
+	for _, l := range podLabelNames() {
+		if err := top.ValidateLabelName(l); err != nil {
+			problem("--pod-labels: %v", err)
+		}
+	}
+	if metricRegex != "" {
+		if _, err := regexp.Compile(metricRegex); err != nil {
+			problem("invalid --metric-regex: %v", err)
+		}
+	}
+	if _, err := parseLabels(); err != nil {
+		problem("--label: %v", err)
+	}
+
+	if prometheusURL != "" {
+		if u, err := url.Parse(prometheusURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("invalid --prometheus-url %q: use an http or https URL such as http://localhost:9090", prometheusURL)
+		}
+		if replayDir != "" {
+			problem("--replay does not query prometheus: drop --prometheus-url")
+		}
+	}
+	if len(contexts) > 0 {
+		switch {
+		case kubeContext != "":
+			problem("--contexts lists the contexts to collect: drop --context")
+		case clusterName != "":
+			problem("--cluster-name would label the results of every context alike: drop it")
+		case prometheusURL != "":
+			problem("--prometheus-url is a single cluster's prometheus: drop it or --contexts")
+		case recordDir != "" || replayDir != "":
+			problem("--record and --replay save and read a single cluster's collection: drop them or --contexts")
+		}
+	}
+	if thanos {
+		if replayDir != "" {
+			problem("--replay does not query prometheus: drop --thanos")
+		}
+		if federate {
+			problem("--federate reads prometheus' /federate endpoint, which Thanos Query does not serve: drop --thanos")
+		}
+	} else if !thanosDedup || len(thanosReplicaLabels) > 0 || thanosPartialResponse {
+		problem("--thanos-dedup, --thanos-replica-label, and --thanos-partial-response apply to Thanos Query: add --thanos")
+	}
+	if promUser != "" {
+		switch {
+		case prometheusURL == "":
+			problem("--prom-user authenticates to --prometheus-url, the cluster's prometheus takes the kubeconfig's credentials: add --prometheus-url")
+		case promTokenFile != "" || promTokenVault != "":
+			problem("--prom-user and --prometheus-token-file or --prometheus-token-vault are exclusive: drop one")
+		}
+	} else if promPassword != "" {
+		problem("--prom-password is the password of --prom-user: add --prom-user")
+	}
+	if promCACert != "" || promClientCert != "" || promClientKey != "" || promInsecure {
+		switch {
+		case prometheusURL == "":
+			problem("--ca-cert, --client-cert, --client-key, and --insecure-skip-tls-verify configure the connection to --prometheus-url, the kubeconfig configures the cluster's: add --prometheus-url")
+		case (promClientCert == "") != (promClientKey == ""):
+			problem("--client-cert and --client-key are a pair: set both")
+		case promInsecure && promCACert != "":
+			problem("--insecure-skip-tls-verify skips the verification --ca-cert is for: drop one")
+		}
+	}
+	if _, err := promHeaders(); err != nil {
+		problem("--prom-header: %v", err)
+	}
+	if promTokenFile != "" && promTokenVault != "" {
+		problem("--prometheus-token-file and --prometheus-token-vault are exclusive: drop one")
+	}
+
+	if failOnViolation && budgetFile == "" {
+		problem("--fail-on-violation requires budgets: add --budget-file")
+	}
+	if budgetFile != "" {
+		if _, err := budget.Load(budgetFile); err != nil {
+			problem("--budget-file: %v", err)
+		}
+	}
+	if cacheDir != "" && cacheTTL <= 0 {
+		problem("--cache-dir requires --cache-ttl: add e.g. --cache-ttl 5m")
+	}
+	if interval > 0 && cacheTTL >= interval {
+		// the cache is keyed on the query, not its time, so every iteration would repeat the results of the first
+		problem("--cache-ttl must be shorter than --interval, or iterations reuse the results of earlier ones")
+	}
+	if samples != 0 {
+		if samples < 2 {
+			problem("--samples must be at least 2, cpu usage is the rate between consecutive samples")
+		}
+		if queryRange != "" {
+			problem("--samples spans (--samples - 1) * --sample-interval and ignores --range: drop --range")
+		}
+		if sampleInterval <= 0 {
+			problem("--sample-interval must be positive")
+		}
+	}
+	if federate {
+		switch {
+		case samples == 0:
+			problem("--federate samples pods client-side: add --samples, e.g. --samples 10")
+		case len(histograms) > 0 || metricRegex != "":
+			problem("--federate only collects cpu and memory: drop --histogram and --metric-regex")
+		case splitContainers:
+			problem("--federate sums the containers of each pod: drop --split-containers")
+		case len(podLabels) > 0:
+			problem("--federate does not join kube_pod_labels: drop --pod-labels")
+		case shardSize > 0:
+			problem("--federate reads every namespace in a single request: drop --shard-size")
+		case prometheusStats:
+			problem("--prometheus-stats reports the load of queries, which --federate does not execute: drop one")
+		}
+	}
+	if maxQueries < 1 {
+		problem("--max-concurrency must be at least 1")
+	}
+	if queryRetries < 0 {
+		problem("--query-retries must not be negative, 0 disables retries")
+	}
+	if queryRetryBackoff <= 0 {
+		problem("--query-retry-backoff must be positive")
+	}
+	if queryRetryJitter < 0 || queryRetryJitter > 1 {
+		problem("--query-retry-jitter must be between 0 and 1")
+	}
+	if queryTimeout < 0 {
+		problem("--query-timeout must not be negative, 0 disables it")
+	}
+	if deadline < 0 {
+		problem("--deadline must not be negative, 0 disables it")
+	}
+	if deadline > 0 && samples > 0 {
+		problem("--samples takes as long as --samples and --sample-interval make it, --deadline bounds the queries over --range: drop one")
+	}
+	if shardSize < 0 {
+		problem("--shard-size must not be negative, 0 disables sharding")
+	}
+	if webhookRetries < 0 {
+		problem("--webhook-retries must not be negative")
+	}
+	if dbDriver != "sql" && dbDriver != "pgx" {
+		problem("unknown --db-driver %q: use one of sql, pgx", dbDriver)
+	}
+	if dbBatchSize < 1 {
+		problem("--db-batch-size must be at least 1")
+	}
+	if dbDeltas && (format != "postgres" || schema != "wide") {
+		problem("--db-deltas sets columns of the postgres wide table: add --format postgres, drop --schema long")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid arguments:\n  %s", strings.Join(problems, "\n  "))
 	}
+	return nil
 }
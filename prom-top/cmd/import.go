@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const importUsage = `usage: prom-top db import <results.csv>... --build <version> [--cpu-unit <unit>] [--memory-unit <unit>]`
+
+// importedToolVersion is recorded as the tool_version of imported runs, whose prom-top version is not known.
+const importedToolVersion = "imported"
+
+// importDatabase stores results saved with --format csv under the build --build, e.g. to backfill runs collected
+// offline or by older versions of prom-top.  Each run-id of the files becomes a run, and the rows of a file without
+// one form a run per cluster and query time, identified by importedRunID.  Each run is stored with its rows in a
+// single transaction, so a failed import can be retried.  --cpu-unit and --memory-unit give the units the files were
+// written in.
+func importDatabase(paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf(importUsage)
+	}
+	if build == "" {
+		return fmt.Errorf("db import requires the cluster version to store the rows under: add --build")
+	}
+	// subcommands skip validateFlags
+	if err := outputUnits().Validate(); err != nil {
+		return fmt.Errorf("--cpu-unit, --memory-unit: %v", err)
+	}
+	for _, path := range paths {
+		header, err := csvHeader(path)
+		if err != nil {
+			return err
+		}
+		for _, column := range header {
+			if column == "aggregation" {
+				return fmt.Errorf("%s has the long schema: only wide csv can be imported", path)
+			}
+		}
+	}
+
+	var runs []top.PodMetricTable
+	for _, path := range paths {
+		table, err := readCSV(path)
+		if err != nil {
+			return err
+		}
+		byRun := make(map[string]top.PodMetricTable)
+		var ids []string
+		for _, m := range table.FromUnits(outputUnits()) {
+			if m.RunID == "" {
+				m.RunID = importedRunID(path, m)
+			}
+			if _, ok := byRun[m.RunID]; !ok {
+				ids = append(ids, m.RunID)
+			}
+			byRun[m.RunID] = append(byRun[m.RunID], m)
+		}
+		for _, id := range ids {
+			runs = append(runs, byRun[id])
+		}
+		klog.V(2).Infof("read %d rows of %d runs from %s", len(table), len(ids), filepath.Base(path))
+	}
+
+	db, err := openDatabase("import")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := dbhandler.CheckSchema(db); err != nil {
+		return err
+	}
+	var nrows int64
+	for _, result := range runs {
+		r, err := importedRun(result)
+		if err != nil {
+			return err
+		}
+		exists, err := dbhandler.RunExists(db, r.RunID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("run %s is already stored, was it imported before?", r.RunID)
+		}
+		rows := make([]dbhandler.Row, 0, len(result))
+		for _, m := range result {
+			row := dbhandler.Row(*m)
+			row.Version = build
+			row.RunID = r.RunID
+			rows = append(rows, row)
+		}
+		n, err := dbhandler.InsertRunRows(db, r, rows, dbBatchSize)
+		if err != nil {
+			return fmt.Errorf("importing run %s: %v", r.RunID, err)
+		}
+		nrows += n
+	}
+	klog.Infof("imported %d rows of %d runs as build %s", nrows, len(runs), build)
+	return nil
+}
+
+// importedRunNamespace is the namespace of the name-based UUIDs of importedRunID.
+var importedRunNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/redhat-et/caliper/prom-top/import")
+
+// importedRunID identifies the run of m, a row read from path without a run-id, by the file's name and the row's
+// cluster and query time, so that importing the file again is refused rather than duplicating its rows.
+func importedRunID(path string, m *top.PodMetric) string {
+	name := strings.Join([]string{filepath.Base(path), m.Cluster, m.QueryTime}, "\x00")
+	return uuid.NewV5(importedRunNamespace, name).String()
+}
+
+// importedRun describes the run of result, rows read from a file and identified by their run-id, which starts at
+// their query time.
+func importedRun(result top.PodMetricTable) (dbhandler.Run, error) {
+	r, err := newRun(result, build, result[0].Cluster, time.Now())
+	if err != nil {
+		return r, err
+	}
+	r.RunID = result[0].RunID
+	r.ToolVersion = importedToolVersion
+	r.Duration = 0
+	if t := result[0].QueryTime; t != "" {
+		r.StartTime = t
+	}
+	return r, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"strings"
+
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+)
+
+const (
+	nodeRolePrefix = "node-role.kubernetes.io/"
+	// instanceTypeLabel is set by cloud providers, betaInstanceTypeLabel by those of older clusters.
+	instanceTypeLabel     = "node.kubernetes.io/instance-type"
+	betaInstanceTypeLabel = "beta.kubernetes.io/instance-type"
+)
+
+// describeInfrastructure records the shape of the cluster in r: its platform and version, read from the
+// Infrastructure and ClusterVersion resources, and its nodes by role and instance type.  Like clusterIdentifier, it
+// is best effort: what cannot be read, e.g. the OpenShift resources of other clusters, is left empty.
+func describeInfrastructure(cfg *rest.Config, r *dbhandler.Run) {
+	if cfg == nil {
+		return
+	}
+	ctx := context.Background()
+	if cc, err := configv1client.NewForConfig(cfg); err == nil {
+		if infra, err := cc.Infrastructures().Get(ctx, "cluster", metav1.GetOptions{}); err == nil {
+			r.Platform = string(infra.Status.Platform)
+			if infra.Status.PlatformStatus != nil {
+				r.Platform = string(infra.Status.PlatformStatus.Type)
+			}
+		} else {
+			klog.V(2).Infof("reading the infrastructure resource: %v", err)
+		}
+		if cv, err := cc.ClusterVersions().Get(ctx, "version", metav1.GetOptions{}); err == nil {
+			r.ClusterVersion = cv.Status.Desired.Version
+		} else {
+			klog.V(2).Infof("reading the cluster version resource: %v", err)
+		}
+	}
+
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		klog.Warningf("unable to describe the cluster's nodes: %v", err)
+		return
+	}
+	nodes, err := kc.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("unable to describe the cluster's nodes: %v", err)
+		return
+	}
+	r.NodeRoles = make(map[string]int)
+	r.InstanceTypes = make(map[string]int)
+	for _, n := range nodes.Items {
+		roles := 0
+		for label := range n.Labels {
+			if strings.HasPrefix(label, nodeRolePrefix) {
+				r.NodeRoles[strings.TrimPrefix(label, nodeRolePrefix)]++
+				roles++
+			}
+		}
+		if roles == 0 {
+			r.NodeRoles["none"]++
+		}
+		if t := n.Labels[instanceTypeLabel]; t != "" {
+			r.InstanceTypes[t]++
+		} else if t := n.Labels[betaInstanceTypeLabel]; t != "" {
+			r.InstanceTypes[t]++
+		}
+		if r.Platform == "" {
+			// outside of OpenShift, the cloud provider prefixes the provider ID, e.g. aws:///us-east-1a/i-0123
+			if i := strings.Index(n.Spec.ProviderID, "://"); i > 0 {
+				r.Platform = n.Spec.ProviderID[:i]
+			}
+		}
+	}
+	klog.Infof("cluster platform %q, version %q, nodes by role %v, by instance type %v", r.Platform, r.ClusterVersion, r.NodeRoles, r.InstanceTypes)
+}
@@ -18,21 +18,37 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
 	routev1 "github.com/openshift/api/route/v1"
-	routeClient "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
 	promapi "github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
+	"github.com/redhat-et/caliper/prom-top/pkg/budget"
 	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/federation"
+	"github.com/redhat-et/caliper/prom-top/pkg/metricsserver"
+	"github.com/redhat-et/caliper/prom-top/pkg/report"
+	"github.com/redhat-et/caliper/prom-top/pkg/secret"
+	"github.com/redhat-et/caliper/prom-top/pkg/sink"
 	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/record"
 )
 
 func hasBearerToken(cfg *rest.Config) bool {
@@ -46,12 +62,115 @@ func prometheusHost(r *routev1.Route) string {
 	return fmt.Sprintf("https://%s", r.Spec.Host)
 }
 
+// restConfig loads the client config the way kubectl does: --kubeconfig if given, else the (possibly
+// colon-separated) $KUBECONFIG list merged in order, else ~/.kube/config, with --context overriding the current
+// context.
+func restConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	if kubeconfig != "" {
+		klog.Infof("initializing openshift client from %s", kubeconfig)
+	} else {
+		klog.Infof("initializing openshift client from %s", strings.Join(rules.Precedence, string(os.PathListSeparator)))
+	}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %v", err)
+	}
+	return cfg, nil
+}
+
+// overrideToken replaces the kubeconfig's credentials with the token of --prometheus-token-file or
+// --prometheus-token-vault, if set.
+func overrideToken(cfg *rest.Config) error {
+	switch {
+	case promTokenFile != "":
+		// client-go re-reads the file periodically, so rotated tokens are picked up
+		cfg.BearerToken, cfg.BearerTokenFile = "", promTokenFile
+	case promTokenVault != "":
+		token, err := secret.Resolve("", "", promTokenVault)
+		if err != nil {
+			return fmt.Errorf("reading --prometheus-token-vault: %v", err)
+		}
+		cfg.BearerToken, cfg.BearerTokenFile = token, ""
+	default:
+		return nil
+	}
+	cfg.Username, cfg.Password = "", ""
+	cfg.ExecProvider, cfg.AuthProvider = nil, nil
+	return nil
+}
+
+// handleSignals cancels the collection on the first SIGINT or SIGTERM, allowing partial results to be written and
+// connections closed.  A second signal exits immediately.
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		klog.Warningf("received %s, stopping collection. signal again to exit immediately", sig)
+		cancel()
+		sig = <-sigs
+		klog.Errorf("received %s, exiting", sig)
+		klog.Flush()
+		os.Exit(1)
+	}()
+}
+
+// signalContext returns a context canceled on the first SIGINT or SIGTERM, see handleSignals.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	handleSignals(cancel)
+	return ctx
+}
+
 func handleError(e error) {
 	if e != nil {
 		klog.ExitDepth(1, e)
 	}
 }
 
+// toolVersion identifies the prom-top build, set at link time with -ldflags "-X main.toolVersion=..."
+var toolVersion = "dev"
+
+// run describes the current invocation.  It is recorded by sinks that keep run metadata.
+var run dbhandler.Run
+
+// queryStats records the execution of the queries of the current collection.
+var queryStats *top.RunStats
+
+// newRun describes an invocation of build that started at start and produced result.
+func newRun(result top.PodMetricTable, build, cluster string, start time.Time) (dbhandler.Run, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return dbhandler.Run{}, fmt.Errorf("generating run id: %v", err)
+	}
+	r := dbhandler.Run{
+		RunID:       id.String(),
+		Version:     build,
+		Cluster:     cluster,
+		ToolVersion: toolVersion,
+		Range:       queryRange,
+		StartTime:   start.Format(dbhandler.TimestampFormat),
+		Duration:    time.Since(start).Seconds(),
+	}
+	nodes := make(map[string]bool)
+	pods := make(map[string]bool)
+	for _, m := range result {
+		nodes[m.Node] = true
+		pods[m.Namespace+"/"+m.Pod] = true
+		r.Partial = r.Partial || m.Partial
+		// the range actually queried, which may be a default
+		r.Range = m.Range
+	}
+	delete(nodes, "")
+	r.NodeCount = len(nodes)
+	r.PodCount = len(pods)
+	return r, nil
+}
+
+// Default location of the prometheus route, overridden by --prom-route-namespace and --prom-route-name
 const (
 	promNamespace = `openshift-monitoring`
 	promRoute     = `prometheus-k8s`
@@ -60,94 +179,840 @@ const (
 func main() {
 	pflag.Parse()
 	defer klog.Flush()
+	defer closePortForwards()
 
-	klog.Infof("initializing openshift client from KUBECONFIG=%s", kubeconfig)
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	nf, err := numberFormat()
 	handleError(err)
+	handleError(top.SetNumberFormat(nf))
+	dbhandler.SetTLS(dbTLS)
+
+	if pflag.NArg() > 0 {
+		handleError(runSubcommand(pflag.Args()))
+		return
+	}
+
+	handleError(validateFlags())
+	handleError(collectLoop(signalContext()))
+}
+
+// collectLoop collects the results and writes them to the --format sink, once or every --interval.  The query cache
+// is shared by every iteration and context, its entries expiring before the next iteration.
+func collectLoop(ctx context.Context) error {
+	out, err := sink.Open(format)
+	if err != nil {
+		return err
+	}
+	cache, err := newCache()
+	if err != nil {
+		return err
+	}
+
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+	violations := 0
+	for i := 1; ; i++ {
+		iteration := 0
+		if ticker != nil {
+			iteration = i
+			klog.Infof("starting iteration %d", i)
+		}
+		n, err := collectContexts(ctx, out, cache, iteration)
+		if err != nil {
+			return err
+		}
+		violations += n
+		if ticker == nil || i == iterations || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			klog.Infof("stopped after %d iterations", i)
+			break
+		}
+	}
+
+	if violations > 0 && failOnViolation {
+		return fmt.Errorf("%d results exceed their budget", violations)
+	}
+	return nil
+}
+
+// collectContexts runs collectAndWrite against the cluster of each of --contexts in turn, or once against the current
+// context, and returns the total number of budget violations.  An interrupted collection skips the remaining contexts.
+func collectContexts(ctx context.Context, out sink.Sink, cache top.Cache, iteration int) (int, error) {
+	if len(contexts) == 0 {
+		return collectAndWrite(ctx, out, cache, iteration)
+	}
+	violations := 0
+	for _, c := range contexts {
+		if ctx.Err() != nil {
+			klog.Warningf("collection interrupted, skipping context %s", c)
+			continue
+		}
+		klog.Infof("collecting context %s", c)
+		kubeContext = c
+		n, err := collectAndWrite(ctx, out, cache, iteration)
+		if err != nil {
+			return 0, fmt.Errorf("context %s: %v", c, err)
+		}
+		violations += n
+	}
+	return violations, nil
+}
+
+// collectAndWrite collects the results, tagged with iteration, writes them to out, and returns the number of
+// budget violations.
+func collectAndWrite(ctx context.Context, out sink.Sink, cache top.Cache, iteration int) (int, error) {
+	result, err := collect(ctx, cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err = filterResults(result)
+	if err != nil {
+		return 0, err
+	}
+	if nodeMode {
+		result = result.Busiest()
+	}
+	labels, err := parseLabels()
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range result {
+		m.Iteration = iteration
+		m.Labels = labels
+	}
+
+	violations, err := checkBudgets(result)
+	if err != nil {
+		return 0, err
+	}
+
+	// not ctx: the results of an interrupted collection are still written
+	if err = out.Write(context.Background(), result); err != nil {
+		return 0, err
+	}
+	if err = writeReports(result); err != nil {
+		return 0, err
+	}
+	if showTotals {
+		if err = report.WriteTotals(os.Stdout, result.Convert(outputUnits()).Totals()); err != nil {
+			return 0, err
+		}
+	}
+	if showQueryStats {
+		if err = report.WriteQueryStats(os.Stdout, queryStats.Queries()); err != nil {
+			return 0, err
+		}
+	}
+	if err = writeSummary(result); err != nil {
+		return 0, err
+	}
+
+	if !nodeMode {
+		logEfficiencySummary(result)
+	}
+	return violations, nil
+}
+
+// writeSummary logs or writes the --summary of the collection of result.
+func writeSummary(result top.PodMetricTable) error {
+	if summary == "none" {
+		return nil
+	}
+	s := report.NewSummary(result, queryStats.Queries(), time.Duration(run.Duration*float64(time.Second)))
+	if summary == "footer" {
+		return report.WriteSummary(os.Stdout, s)
+	}
+	klog.Infof("summary: %s", s)
+	return nil
+}
+
+// logEfficiencySummary logs the --efficiency-summary least efficient, i.e. most over-requested, apps.
+func logEfficiencySummary(result top.PodMetricTable) {
+	if efficiencySummary <= 0 {
+		return
+	}
+	apps := result.Efficiencies()
+	if len(apps) > efficiencySummary {
+		apps = apps[:efficiencySummary]
+	}
+	if len(apps) == 0 {
+		return
+	}
+	klog.Infof("least efficient apps (average usage / request):")
+	for _, a := range apps {
+		klog.Infof("  %-22s %s/%s: %.1f%% (%s of %s)", a.Metric, a.Namespace, a.App, a.Efficiency*100, top.FormatValue(a.Usage), top.FormatValue(a.Request))
+	}
+}
+
+// filterResults drops the results below --min-cpu and --min-memory, then all but the --top-per-namespace heaviest
+// of each namespace, so that output focuses on meaningful consumers.
+func filterResults(result top.PodMetricTable) (top.PodMetricTable, error) {
+	floors := make(map[string]float64)
+	if minCPU > 0 {
+		floors[top.CPUMetric] = minCPU
+	}
+	if minMemory != "" {
+		mem, err := resource.ParseQuantity(minMemory)
+		if err != nil {
+			return nil, fmt.Errorf("parsing --min-memory: %v", err)
+		}
+		floors[top.MemoryMetric] = float64(mem.Value())
+	}
+	if len(floors) > 0 {
+		kept := result.AtLeast(floors)
+		klog.Infof("dropped %d of %d results below --min-cpu or --min-memory", len(result)-len(kept), len(result))
+		result = kept
+	}
+	if topPerNamespace > 0 {
+		kept := result.TopPerNamespace(topPerNamespace)
+		klog.Infof("kept the top %d pods of each namespace, %d of %d results", topPerNamespace, len(kept), len(result))
+		result = kept
+	}
+	return result, nil
+}
+
+// checkBudgets flags the results exceeding the budgets of --budget-file and returns the number of violations.
+func checkBudgets(result top.PodMetricTable) (int, error) {
+	if budgetFile == "" {
+		return 0, nil
+	}
+	budgets, err := budget.Load(budgetFile)
+	if err != nil {
+		return 0, err
+	}
+	n := budgets.Apply(result)
+	if n > 0 {
+		klog.Warningf("%d results exceed their budget", n)
+	}
+	return n, nil
+}
+
+// clusterConfig loads the client config of the cluster, with the credentials of --prometheus-token-file or
+// --prometheus-token-vault if set.
+func clusterConfig() (*rest.Config, error) {
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := overrideToken(cfg); err != nil {
+		return nil, err
+	}
 
 	if !hasBearerToken(cfg) {
-		klog.Exit("error: bearer token not found, required access to prometheus oauth access.  login to cluster with 'oc'")
+		return nil, fmt.Errorf("bearer token not found, required access to prometheus oauth access.  login to cluster with 'oc'")
 	}
+	return cfg, nil
+}
 
-	rc := routeClient.NewForConfigOrDie(cfg)
-	klog.Infof("fetching prometheus route")
-	route, err := rc.Routes(promNamespace).Get(context.Background(), promRoute, metav1.GetOptions{})
-	handleError(err)
+// optionalClusterConfig returns clusterConfig, or nil if it fails and --prometheus-url makes the cluster optional.
+// Without it, the namespace opt-outs, cluster ID, build, and infrastructure are not read: set --cluster-name and
+// --build.
+func optionalClusterConfig() (*rest.Config, error) {
+	cfg, err := clusterConfig()
+	if err != nil && prometheusURL != "" {
+		klog.Warningf("no access to the cluster, only querying --prometheus-url: %v", err)
+		return nil, nil
+	}
+	return cfg, err
+}
 
-	transport, err := rest.TransportFor(cfg)
-	handleError(err)
+// prometheusClient discovers the cluster's prometheus within ctx and connects to it with the credentials of cfg and
+// the headers of --prom-header, or connects to --prometheus-url if set.
+func prometheusClient(ctx context.Context, cfg *rest.Config) (promapi.Client, error) {
+	if prometheusURL != "" {
+		return prometheusURLClient()
+	}
+	host, err := discoverPrometheus(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newReauthTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	header, err := promHeaders()
+	if err != nil {
+		return nil, err
+	}
 
-	host := prometheusHost(route)
 	klog.Infof("initializing connection for host: %s", host)
-	conn, err := promapi.NewClient(promapi.Config{
+	return promapi.NewClient(promapi.Config{
 		Address:      host,
-		RoundTripper: transport,
+		RoundTripper: withHeaders(transport, header),
 	})
+}
+
+// collect discovers prometheus, executes the queries through cache, which may be nil, and returns the labeled results.
+// An interrupted collection returns the partial results collected so far.  run is set to describe the collection.
+func collect(ctx context.Context, cache top.Cache) (top.PodMetricTable, error) {
+	queryStats = new(top.RunStats)
+	if replayDir != "" {
+		return replay(ctx)
+	}
+	cfg, err := optionalClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := prometheusClient(ctx, cfg)
+	if err != nil {
+		return fallBack(cfg, err)
+	}
+
+	if preflight {
+		checkPrometheus(ctx, conn)
+	}
+	if err = expandMetricRegex(ctx, conn); err != nil {
+		return fallBack(cfg, err)
+	}
 
 	klog.Info("creating prometheus api client")
-	pc := promv1.NewAPI(conn)
-	handleError(err)
+	var pc top.Querier = promv1.NewAPI(conn)
+	switch {
+	case thanos:
+		pc = top.NewThanosQuerier(conn, top.ThanosOptions{
+			Dedup:           thanosDedup,
+			ReplicaLabels:   thanosReplicaLabels,
+			PartialResponse: thanosPartialResponse,
+			Stats:           prometheusStats,
+		})
+	case prometheusStats:
+		pc = top.NewStatsQuerier(conn)
+	}
+	var recorder *record.Recorder
+	if recordDir != "" {
+		recorder = record.NewRecorder(pc)
+		pc = recorder
+	}
+
+	optedOut := optedOutNamespaces(cfg)
+	var namespaces []string
+	if shardSize > 0 {
+		namespaces, err = listNamespaces(cfg)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = without(namespaces, optedOut)
+		klog.Infof("sharding queries across %d namespaces, %d per shard", len(namespaces), shardSize)
+	}
+
+	cluster := clusterIdentifier(cfg)
 
-	result, err := top.Top(top.Config{
+	start := time.Now()
+	topCfg, err := topConfig(ctx, pc, cache, optedOut, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	topCfg.Time = start
+	topCfg.CacheScope = cacheScope(cluster)
+	if deadline > 0 {
+		topCfg.Deadline = start.Add(deadline)
+	}
+	topCfg.Build = clusterBuild(cfg)
+	var result top.PodMetricTable
+	if samples > 0 {
+		result, err = sample(ctx, topCfg, conn)
+	} else {
+		result, err = top.Top(topCfg)
+	}
+	var failed top.QueryErrors
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// flush what was collected before the interruption or --deadline, the rows are marked partial
+		klog.Warningf("%v, writing %d partial results", err, len(result))
+	} else if errors.As(err, &failed) {
+		for _, f := range failed {
+			klog.Warningf("%v", f)
+		}
+		klog.Warningf("%d queries failed, writing the %d results of the others, the rows of their metrics are marked partial", len(failed), len(result))
+	} else if errors.Is(err, top.ErrWarnings) {
+		// prometheus answered, the metrics-server snapshot is no substitute for its complete results
+		return nil, fmt.Errorf("%v: the results may be partial, failing as --strict is set", err)
+	} else if err != nil {
+		return fallBack(cfg, err)
+	}
+	finishCollection(result, cluster, topCfg)
+
+	run, err = newRun(result, topCfg.Build, cluster, start)
+	describeInfrastructure(cfg, &run)
+	if err == nil && recorder != nil {
+		err = saveRecording(recordDir, recorder, recording{
+			Cluster:    cluster,
+			OptedOut:   optedOut,
+			Namespaces: namespaces,
+			Metrics:    matchedMetrics,
+			Time:       start,
+			Run:        run,
+		})
+	}
+	return result, err
+}
+
+// checkPrometheus logs the problems found by top.Preflight with the data sources behind the queries.  They are not
+// fatal: the results are collected regardless, and may be incomplete.
+func checkPrometheus(ctx context.Context, conn promapi.Client) {
+	problems, err := top.Preflight(ctx, conn, preflightStaleAfter)
+	if err != nil {
+		klog.Warningf("unable to run the preflight checks: %v", err)
+		return
+	}
+	for _, p := range problems {
+		klog.Warningf("preflight: %s, the results may be incomplete", p)
+	}
+	if len(problems) == 0 {
+		klog.Info("preflight: prometheus is ready, the kube-state-metrics and cAdvisor targets are up")
+	}
+}
+
+// topConfig returns the configuration of a collection from q, excluding the optedOut namespaces and sharded across
+// namespaces if --shard-size is set.
+func topConfig(ctx context.Context, q top.Querier, cache top.Cache, optedOut, namespaces []string) (top.Config, error) {
+	labelMatchers, err := parseMatchers()
+	if err != nil {
+		return top.Config{}, err
+	}
+	if len(optedOut) > 0 {
+		klog.Infof("excluding %d namespaces annotated %s=true: %s", len(optedOut), excludeAnnotation, strings.Join(optedOut, ", "))
+		labelMatchers = append(labelMatchers, top.NoneOf("namespace", optedOut))
+	}
+
+	builder, err := queryBuilder()
+	if err != nil {
+		return top.Config{}, err
+	}
+
+	return top.Config{
 		Range:            queryRange,
-		Context:          context.Background(),
-		PrometheusClient: pc,
-	})
-	handleError(err)
+		Context:          ctx,
+		PrometheusClient: q,
+		Cache:            cache,
+		QueryBuilder:     builder,
+		MaxConcurrency:   maxQueries,
+		Retries:          queryRetries,
+		RetryBackoff:     queryRetryBackoff,
+		RetryJitter:      queryRetryJitter,
+		Timeout:          queryTimeout,
+		Matchers:         labelMatchers,
+		PodLabels:        podLabelNames(),
+		Namespaces:       namespaces,
+		ShardSize:        shardSize,
+
+		DownsampleThreshold: downsampleThresholdOrDisabled(),
+		DownsampleStep:      downsampleStep,
+		Stats:               queryStats,
+		Strict:              strict,
+		ContinueOnError:     continueOnError,
+		PartialOnWarnings:   thanosPartialResponse,
+	}, nil
+}
 
-	if toDb {
-		if err = streamToDatabase(result); err != nil {
-			handleError(err)
+// finishCollection logs the warnings prometheus returned for the queries of topCfg and, with --prometheus-stats, the
+// load they placed on it, tags result with cluster and the replicas of its autoscaled workloads, and reads the replica
+// counts and namespace limits if the per-replica and quota reports are selected.
+func finishCollection(result top.PodMetricTable, cluster string, topCfg top.Config) {
+	for _, w := range queryStats.Warnings() {
+		klog.Warningf("prometheus warning, the results may be partial: %s", w)
+	}
+	if prometheusStats {
+		ps := queryStats.Prometheus()
+		klog.Infof("prometheus spent %.3fs evaluating the queries and %.3fs queueing them, loading %d samples, at most %d at once",
+			ps.EvalTime.Seconds(), ps.QueueTime.Seconds(), ps.SamplesLoaded, ps.PeakSamples)
+	}
+
+	for _, m := range result {
+		m.Cluster = cluster
+	}
+	if !nodeMode {
+		if hpa, err := top.HPAReplicas(topCfg); err != nil {
+			klog.Warningf("unable to read the replicas of the autoscalers, the hpa columns will be 0: %v", err)
+		} else {
+			result.AttachHPAReplicas(hpa)
 		}
-	} else {
-		printToStdout(result)
 	}
-	handleError(err)
+	if reportSelected("per-replica") {
+		var err error
+		if replicaCounts, err = top.Replicas(topCfg); err != nil {
+			klog.Warningf("unable to read replica counts, the per-replica report will be empty: %v", err)
+		}
+	}
+	if reportSelected("quota") {
+		var err error
+		if namespaceLimits, err = top.ReadLimits(topCfg); err != nil {
+			klog.Warningf("unable to read quotas and limit ranges, the quota report will be empty: %v", err)
+		}
+	}
+}
+
+// fallBack returns an instant snapshot from metrics-server if --metrics-server-fallback is set, and otherwise
+// promErr, the error that made prometheus unusable.  run is set to describe the snapshot.
+func fallBack(cfg *rest.Config, promErr error) (top.PodMetricTable, error) {
+	if !metricsServerFallback || cfg == nil {
+		return nil, promErr
+	}
+	klog.Warningf("prometheus is unavailable: %v", promErr)
+	klog.Warningf("falling back to metrics-server: results are an instant snapshot, recorded with range %q", metricsserver.Range)
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err := metricsserver.Snapshot(context.Background(), kc)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus is unavailable (%v) and so is metrics-server: %v", promErr, err)
+	}
+	if optedOut := optedOutNamespaces(cfg); len(optedOut) > 0 {
+		excluded := make(map[string]bool, len(optedOut))
+		for _, ns := range optedOut {
+			excluded[ns] = true
+		}
+		kept := result[:0]
+		for _, m := range result {
+			if !excluded[m.Namespace] {
+				kept = append(kept, m)
+			}
+		}
+		result = kept
+	}
+	cluster := clusterIdentifier(cfg)
+	b := clusterBuild(cfg)
+	for _, m := range result {
+		m.Cluster = cluster
+		m.Version = b
+	}
+	run, err = newRun(result, b, cluster, start)
+	describeInfrastructure(cfg, &run)
+	return result, err
+}
+
+// sample executes the instant queries --samples times, --sample-interval apart, and returns the samples aggregated
+// client-side.  With --federate the samples are instead read from conn's /federate endpoint.  An interrupted sampling
+// returns the aggregation of the samples taken so far, marked partial.
+func sample(ctx context.Context, cfg top.Config, conn promapi.Client) (top.PodMetricTable, error) {
+	c, err := top.NewCollector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	interrupted := func() (top.PodMetricTable, error) {
+		result := c.Table()
+		for _, m := range result {
+			m.Partial = true
+		}
+		return result, fmt.Errorf("sampling interrupted after %d samples: %w", c.Samples(), ctx.Err())
+	}
+
+	takeSample := c.Collect
+	if federate {
+		takeSample = func(ctx context.Context) error {
+			now := time.Now()
+			vectors, err := federation.Scrape(ctx, conn, cfg.Matchers)
+			if err != nil {
+				return err
+			}
+			c.Observe(vectors, now)
+			return nil
+		}
+	}
+
+	klog.Infof("taking %d samples, %s apart", samples, sampleInterval)
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return interrupted()
+			}
+		}
+		if err := takeSample(ctx); err != nil {
+			if ctx.Err() != nil {
+				return interrupted()
+			}
+			return nil, err
+		}
+		klog.V(2).Infof("took sample %d of %d", i+1, samples)
+	}
+	return c.Table(), nil
 }
 
-//Postgres compatible time format, required for converting query timestamps
-func streamToDatabase(metrics top.PodMetricTable) error {
-	klog.Infoln("init postgres db client")
-	db, err := dbhandler.NewPostgresClient()
+func listNamespaces(cfg *rest.Config) ([]string, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("listing namespaces requires access to the cluster")
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	nsList, err := kc.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to send to db: %v", err)
+		return nil, fmt.Errorf("listing namespaces: %v", err)
+	}
+	namespaces := make([]string, 0, len(nsList.Items))
+	for _, ns := range nsList.Items {
+		namespaces = append(namespaces, ns.Name)
 	}
-	sqIns := squirrel.
-		Insert(dbhandler.Table).
-		Columns(dbhandler.ColumnsHeaders()...).
-		PlaceholderFormat(squirrel.Dollar).
-		RunWith(db)
+	return namespaces, nil
+}
 
-	for _, m := range metrics {
-		sqIns = sqIns.Values(
-			version,
-			m.Metric,
-			m.Node,
-			m.Pod,
-			m.Namespace,
-			m.OwnerName,
-			m.AvgValue,
-			m.Q95Value,
-			m.MaxValue,
-			m.MinValue,
-			m.InstValue,
-			m.QueryTime,
-			m.Range,
-		)
+// excludeAnnotation opts a namespace out of collection when set to "true".
+const excludeAnnotation = "caliper.redhat-et.io/exclude"
 
+// optedOutNamespaces returns the namespaces annotated with excludeAnnotation, sorted, unless --ignore-opt-out is
+// set.  Failing to list namespaces is not fatal, but is logged as the opt-outs cannot be honored.
+func optedOutNamespaces(cfg *rest.Config) []string {
+	if ignoreOptOut {
+		return nil
+	}
+	if cfg == nil {
+		klog.Warningf("unable to read namespace opt-outs without access to the cluster, %s is ignored", excludeAnnotation)
+		return nil
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		klog.Warningf("unable to read namespace opt-outs, %s is ignored: %v", excludeAnnotation, err)
+		return nil
 	}
-	resp, err := sqIns.Exec()
+	nsList, err := kc.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("unable to generate sql insert script: %v", err)
+		klog.Warningf("unable to read namespace opt-outs, %s is ignored: %v", excludeAnnotation, err)
+		return nil
 	}
-	nrows, _ := resp.RowsAffected()
-	klog.Infof("insert success, updated %d rows", nrows)
+	var optedOut []string
+	for _, ns := range nsList.Items {
+		if ns.Annotations[excludeAnnotation] == "true" {
+			optedOut = append(optedOut, ns.Name)
+		}
+	}
+	sort.Strings(optedOut)
+	return optedOut
+}
+
+// without returns the elements of all not in excluded.
+func without(all, excluded []string) []string {
+	skip := make(map[string]bool, len(excluded))
+	for _, e := range excluded {
+		skip[e] = true
+	}
+	kept := make([]string, 0, len(all))
+	for _, a := range all {
+		if !skip[a] {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// clusterIdentifier returns --cluster-name if set, else the cluster ID of the ClusterVersion resource.  Failing to
+// read the ClusterVersion, e.g. on clusters other than OpenShift, is not fatal: results are left unlabeled, or with
+// --contexts labeled with the name of the context.
+func clusterIdentifier(cfg *rest.Config) string {
+	if clusterName != "" {
+		return clusterName
+	}
+	if cfg == nil {
+		klog.Warningf("unable to identify cluster without access to it, set --cluster-name")
+		return ""
+	}
+	cc, err := configv1client.NewForConfig(cfg)
+	if err != nil {
+		return unidentifiedCluster(err)
+	}
+	cv, err := cc.ClusterVersions().Get(context.Background(), "version", metav1.GetOptions{})
+	if err != nil {
+		return unidentifiedCluster(err)
+	}
+	return string(cv.Spec.ClusterID)
+}
+
+// unidentifiedCluster logs that the cluster's ID could not be read for err and returns the label of its results in
+// its stead: the context name with --contexts, else none.
+func unidentifiedCluster(err error) string {
+	if len(contexts) > 0 {
+		klog.Infof("unable to identify cluster, labeling its results with context %s: %v", kubeContext, err)
+		return kubeContext
+	}
+	klog.Warningf("unable to identify cluster, set --cluster-name: %v", err)
+	return ""
+}
+
+// clusterBuild returns --build if set, else the version the ClusterVersion resource reports.  Like
+// clusterIdentifier, failing to read it is not fatal: the results are left without a build, which the sinks that
+// require one reject.
+func clusterBuild(cfg *rest.Config) string {
+	if build != "" {
+		return build
+	}
+	if cfg == nil {
+		klog.Warningf("unable to detect the build under test without access to the cluster, set --build")
+		return ""
+	}
+	cc, err := configv1client.NewForConfig(cfg)
+	if err != nil {
+		klog.Warningf("unable to detect the build under test, set --build: %v", err)
+		return ""
+	}
+	cv, err := cc.ClusterVersions().Get(context.Background(), "version", metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("unable to detect the build under test, set --build: %v", err)
+		return ""
+	}
+	klog.Infof("recording results as build %s, the version of the cluster. Set --build to override", cv.Status.Desired.Version)
+	return cv.Status.Desired.Version
+}
+
+// downsampleThresholdOrDisabled maps the CLI convention of 0 disabling downsampling onto top.Config's, where 0
+// selects the default.
+func downsampleThresholdOrDisabled() time.Duration {
+	if downsampleThreshold == 0 {
+		return -1
+	}
+	return downsampleThreshold
+}
+
+func parseMatchers() ([]top.Matcher, error) {
+	parsed := make([]top.Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		pm, err := top.ParseMatcher(m)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, pm)
+	}
+	return parsed, nil
+}
+
+// podLabelNames returns the kube_pod_labels names of the --pod-labels, nil if there are none.
+func podLabelNames() []string {
+	if len(podLabels) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(podLabels))
+	for _, l := range podLabels {
+		names = append(names, top.PodLabelName(l))
+	}
+	return names
+}
+
+// reservedLabels are the names of the columns and labels every result already has, which --label must not shadow.
+var reservedLabels = map[string]bool{
+	"version": true, "cluster": true, "metric": true, "node": true, "pod": true, "namespace": true,
+	"owner_name": true, "range": true, "aggregation": true, "run_id": true, "iteration": true, "component": true,
+}
+
+// parseLabels returns the --label flags, nil if there are none.
+func parseLabels() (dbhandler.Labels, error) {
+	if len(customLabels) == 0 {
+		return nil, nil
+	}
+	labels := make(dbhandler.Labels, len(customLabels))
+	for _, l := range customLabels {
+		i := strings.Index(l, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not of the form name=value", l)
+		}
+		name, value := l[:i], l[i+1:]
+		if err := top.ValidateLabelName(name); err != nil {
+			return nil, err
+		}
+		switch {
+		case reservedLabels[name] || strings.HasPrefix(name, "__"):
+			return nil, fmt.Errorf("%s is reserved, choose another name", name)
+		case value == "":
+			return nil, fmt.Errorf("%s has no value", name)
+		case strings.Contains(value, ","):
+			return nil, fmt.Errorf("value of %s must not contain commas", name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("%s is given more than once", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// matchedMetrics are the metrics matched by --metric-regex, each mapped to its prometheus type.
+var matchedMetrics map[string]string
+
+// expandMetricRegex sets matchedMetrics to the metrics of the cluster matched by --metric-regex, if set.
+func expandMetricRegex(ctx context.Context, conn promapi.Client) error {
+	if metricRegex == "" {
+		return nil
+	}
+	var err error
+	if matchedMetrics, err = top.ExpandMetrics(ctx, promv1.NewAPI(conn), metricRegex); err != nil {
+		return fmt.Errorf("expanding --metric-regex: %v", err)
+	}
+	if len(matchedMetrics) == 0 {
+		return fmt.Errorf("--metric-regex %q matches no metric of the cluster", metricRegex)
+	}
+	names := make([]string, 0, len(matchedMetrics))
+	for name := range matchedMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	klog.Infof("--metric-regex matches %d metrics: %s", len(names), strings.Join(names, ", "))
 	return nil
 }
 
-func printToStdout(podMetrics []*top.PodMetric) {
-	klog.Infof("got %d results", len(podMetrics))
-	for _, pm := range podMetrics {
-		klog.Info(pm)
+// queryBuilder returns the builtin queries extended with those of every --histogram and of the metrics matched by
+// --metric-regex, or the queries of the node view for prom-top nodes.
+func queryBuilder() (*top.QueryBuilder, error) {
+	if nodeMode {
+		return top.NodeQueryBuilder(), nil
+	}
+	if len(histograms) == 0 && len(matchedMetrics) == 0 && !splitContainers {
+		return top.DefaultQueryBuilder(), nil
+	}
+	templates := top.DefaultTemplates()
+	if splitContainers {
+		split, err := top.SplitTemplates(sidecars)
+		if err != nil {
+			return nil, err
+		}
+		for metric, t := range split {
+			templates[metric] = t
+		}
+	}
+	for name, metricType := range matchedMetrics {
+		t, err := top.MetricTemplates(name, metricType)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	for _, h := range histograms {
+		t, err := top.HistogramTemplates(h)
+		if err != nil {
+			return nil, err
+		}
+		templates[h] = t
+	}
+	return top.NewQueryBuilder(templates)
+}
+
+// cacheScope identifies the prometheus of cluster in the keys of the cache: a --cache-dir may be shared by the
+// invocations, and a cache by the --contexts, of several clusters.
+func cacheScope(cluster string) string {
+	return strings.Join([]string{cluster, prometheusURL, promTenant}, "\x00")
+}
+
+func newCache() (top.Cache, error) {
+	switch {
+	case cacheTTL <= 0:
+		return nil, nil
+	case cacheDir != "":
+		klog.Infof("caching query results in %s for %s", cacheDir, cacheTTL)
+		return top.NewFileCache(cacheDir, cacheTTL)
+	default:
+		return top.NewMemoryCache(cacheTTL), nil
 	}
 }
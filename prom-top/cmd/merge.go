@@ -0,0 +1,135 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const mergeUsage = `usage: prom-top merge <run.csv>... [-o all.csv] [--reaggregate]`
+
+// mergeCommand concatenates results saved with --format csv into one, e.g. to combine the shards of a chunked or
+// multi-cluster collection.  Rows without a run-id are tagged with the name of their file.
+func mergeCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("merge requires at least two files\n%s", mergeUsage)
+	}
+	if err := compatibleHeaders(args); err != nil {
+		return err
+	}
+
+	var merged top.PodMetricTable
+	for _, path := range args {
+		table, err := readCSV(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range table {
+			if m.RunID == "" {
+				m.RunID = filepath.Base(path)
+			}
+		}
+		merged = append(merged, table...)
+	}
+	if reaggregate {
+		n := len(merged)
+		merged = merged.Reaggregate()
+		klog.Infof("reaggregated %d rows into %d", n, len(merged))
+	}
+
+	if outputFile == "" || outputFile == "-" {
+		return merged.WriteCSV(os.Stdout)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("creating merge output: %v", err)
+	}
+	if err = merged.WriteCSV(f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing merge output: %v", err)
+	}
+	return f.Close()
+}
+
+// compatibleHeaders returns an error unless every file has the columns of the first, e.g. because they were written
+// by different versions of prom-top.
+func compatibleHeaders(paths []string) error {
+	var first []string
+	for _, path := range paths {
+		header, err := csvHeader(path)
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			first = header
+			continue
+		}
+		missing, extra := columnDiff(first, header)
+		var diffs []string
+		if len(missing) > 0 {
+			diffs = append(diffs, "lacks "+strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			diffs = append(diffs, "adds "+strings.Join(extra, ", "))
+		}
+		if len(diffs) > 0 {
+			return fmt.Errorf("%s is incompatible with %s: it %s", path, paths[0], strings.Join(diffs, " and "))
+		}
+	}
+	return nil
+}
+
+func csvHeader(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header of %s: %v", path, err)
+	}
+	return header, nil
+}
+
+// columnDiff returns the columns of want missing from got, and those of got not in want.
+func columnDiff(want, got []string) (missing, extra []string) {
+	inGot := make(map[string]bool, len(got))
+	for _, c := range got {
+		inGot[c] = true
+	}
+	inWant := make(map[string]bool, len(want))
+	for _, c := range want {
+		inWant[c] = true
+		if !inGot[c] {
+			missing = append(missing, c)
+		}
+	}
+	for _, c := range got {
+		if !inWant[c] {
+			extra = append(extra, c)
+		}
+	}
+	return missing, extra
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/report"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const metricsUsage = `usage: prom-top metrics list [pattern] [--histogram <name>]... [--metric-regex <regex>]`
+
+// metricsCommand lists the prometheus series read by the queries, including those of --histogram and --metric-regex,
+// and whether the cluster has them.  Series renamed between versions otherwise go unnoticed, their queries silently
+// return nothing.  A pattern, a regular expression, narrows the list to the series it matches and adds the cluster's
+// other matching series.  It fails if any series read by the queries is missing.
+func metricsCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 || args[0] != "list" {
+		return fmt.Errorf(metricsUsage)
+	}
+	var pattern *regexp.Regexp
+	if len(args) == 2 {
+		var err error
+		if pattern, err = regexp.Compile(args[1]); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	cfg, err := optionalClusterConfig()
+	if err != nil {
+		return err
+	}
+	ctx := signalContext()
+	conn, err := prometheusClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err = expandMetricRegex(ctx, conn); err != nil {
+		return err
+	}
+	builder, err := queryBuilder()
+	if err != nil {
+		return err
+	}
+	series, err := top.DiscoverSeries(ctx, promv1.NewAPI(conn), builder, pattern)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteSeries(os.Stdout, series); err != nil {
+		return err
+	}
+	missing := 0
+	for _, s := range series {
+		if len(s.Targets) > 0 && !s.Found {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d series read by the queries are missing from prometheus, their results will be empty", missing)
+	}
+	return nil
+}
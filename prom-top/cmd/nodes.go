@@ -0,0 +1,37 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import "fmt"
+
+const nodesUsage = `usage: prom-top nodes [--range <range>] [--format <format>]`
+
+// nodeMode is set by prom-top nodes: the queries are those of the node view, and the rows describe nodes rather than
+// pods.
+var nodeMode bool
+
+// nodesCommand collects the usage of every node, that of its pods summed and its utilization reported by
+// node-exporter, and writes it to the sink ranked from the busiest node, like a collection of pods.
+func nodesCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(nodesUsage)
+	}
+	nodeMode = true
+	if err := validateFlags(); err != nil {
+		return err
+	}
+	return collectLoop(signalContext())
+}
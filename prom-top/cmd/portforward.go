@@ -0,0 +1,222 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/klog/v2"
+)
+
+// prometheusServices select the services of the common prometheus deployments of vanilla kubernetes, in order of
+// preference: kube-prometheus' prometheus-k8s, kube-prometheus-stack's, and the prometheus-operator's governing
+// service of any Prometheus.
+var prometheusServices = []metav1.ListOptions{
+	{FieldSelector: "metadata.name=" + promRoute},
+	{LabelSelector: "app=kube-prometheus-stack-prometheus"},
+	{LabelSelector: "operated-prometheus=true"},
+}
+
+// portForward is an open port-forward to a pod of a prometheus service.
+type portForward struct {
+	address string
+	stop    chan struct{}
+	// done is closed once the port-forward ends, e.g. because the pod went away.
+	done chan struct{}
+}
+
+// portForwards are the open port-forwards by kubeconfig context, reused by the collections of every --interval until
+// closePortForwards.
+var portForwards = make(map[string]*portForward)
+
+// closePortForwards stops the open port-forwards.
+func closePortForwards() {
+	for name, fw := range portForwards {
+		close(fw.stop)
+		delete(portForwards, name)
+	}
+}
+
+// portForwardDiscoverer opens a port-forward to a pod of the cluster's prometheus service, for clusters without the
+// route API, e.g. kubeadm, EKS, or GKE, and returns its local address.  The port-forward of the context is reused
+// while it is open.
+func portForwardDiscoverer(ctx context.Context, cfg *rest.Config) (string, error) {
+	if fw, ok := portForwards[kubeContext]; ok {
+		select {
+		case <-fw.done:
+			klog.Warningf("port-forward to %s ended, reopening it", fw.address)
+			delete(portForwards, kubeContext)
+		default:
+			return fw.address, nil
+		}
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	svc, err := findPrometheusService(ctx, kc)
+	if err != nil {
+		return "", err
+	}
+	pod, port, err := servicePod(ctx, kc, svc)
+	if err != nil {
+		return "", err
+	}
+	fw, err := forwardPort(ctx, cfg, kc, pod, port)
+	if err != nil {
+		return "", fmt.Errorf("port-forwarding to pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	klog.Infof("port-forwarding %s to pod %s/%s of service %s/%s", fw.address, pod.Namespace, pod.Name, svc.Namespace, svc.Name)
+	portForwards[kubeContext] = fw
+	return fw.address, nil
+}
+
+// findPrometheusService returns the first service matched by prometheusServices in any namespace.
+func findPrometheusService(ctx context.Context, kc kubernetes.Interface) (*corev1.Service, error) {
+	var selectors []string
+	for _, opts := range prometheusServices {
+		list, err := kc.CoreV1().Services("").List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Items) > 0 {
+			return &list.Items[0], nil
+		}
+		selectors = append(selectors, opts.FieldSelector+opts.LabelSelector)
+	}
+	return nil, fmt.Errorf("no prometheus service found, looked for %s", strings.Join(selectors, ", "))
+}
+
+// servicePod returns a ready pod backing svc and the container port its preferred port targets.
+func servicePod(ctx context.Context, kc kubernetes.Interface, svc *corev1.Service) (*corev1.Pod, int, error) {
+	if len(svc.Spec.Selector) == 0 {
+		return nil, 0, fmt.Errorf("service %s/%s has no selector", svc.Namespace, svc.Name)
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return nil, 0, fmt.Errorf("service %s/%s exposes no ports", svc.Namespace, svc.Name)
+	}
+	sp := svc.Spec.Ports[0]
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "web" || p.Name == "http-web" {
+			sp = p
+			break
+		}
+	}
+	pods, err := kc.CoreV1().Pods(svc.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !podReady(pod) {
+			continue
+		}
+		port, err := targetPort(pod, sp)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pod, port, nil
+	}
+	return nil, 0, fmt.Errorf("service %s/%s has no ready pods", svc.Namespace, svc.Name)
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// targetPort resolves the container port of pod that sp forwards to, looking named target ports up in its
+// containers.
+func targetPort(pod *corev1.Pod, sp corev1.ServicePort) (int, error) {
+	if sp.TargetPort.StrVal == "" {
+		if sp.TargetPort.IntVal != 0 {
+			return int(sp.TargetPort.IntVal), nil
+		}
+		return int(sp.Port), nil
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == sp.TargetPort.StrVal {
+				return int(p.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("pod %s/%s has no port named %s", pod.Namespace, pod.Name, sp.TargetPort.StrVal)
+}
+
+// forwardPort forwards a free local port to port of pod and returns the port-forward once it is listening.  It serves
+// every query until stopped.
+func forwardPort(ctx context.Context, cfg *rest.Config, kc kubernetes.Interface, pod *corev1.Pod, port int) (*portForward, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	req := kc.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+	stop, ready, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", port)}, stop, ready,
+		ioutil.Discard, klogWriter{})
+	if err != nil {
+		return nil, err
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- fw.ForwardPorts()
+		close(done)
+	}()
+	select {
+	case <-ready:
+	case err = <-failed:
+		return nil, err
+	case <-ctx.Done():
+		close(stop)
+		return nil, ctx.Err()
+	}
+	ports, err := fw.GetPorts()
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+	return &portForward{address: fmt.Sprintf("http://localhost:%d", ports[0].Local), stop: stop, done: done}, nil
+}
+
+// klogWriter logs the errors of the port-forward of individual connections, which do not end it.
+type klogWriter struct{}
+
+func (klogWriter) Write(p []byte) (int, error) {
+	klog.Warningf("port-forward: %s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
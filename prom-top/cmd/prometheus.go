@@ -0,0 +1,223 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	routeClient "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	promapi "github.com/prometheus/client_golang/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// thanosRoute is the Thanos Querier route deployed alongside prometheus-k8s in OpenShift 4.x.  It serves the same
+// query API and is tried when the prometheus route is missing.
+const thanosRoute = `thanos-querier`
+
+// discoverer attempts to locate the prometheus query API, returning its base URL.
+type discoverer struct {
+	description string
+	discover    func(ctx context.Context, cfg *rest.Config) (string, error)
+}
+
+// discoveryChain lists the ways the query endpoint is located, in order of preference.
+func discoveryChain() []discoverer {
+	return []discoverer{
+		{
+			description: fmt.Sprintf("route %s/%s", promRouteNamespace, promRouteName),
+			discover:    routeDiscoverer(promRouteNamespace, promRouteName),
+		},
+		{
+			description: fmt.Sprintf("route %s/%s", promRouteNamespace, thanosRoute),
+			discover:    routeDiscoverer(promRouteNamespace, thanosRoute),
+		},
+		{
+			description: fmt.Sprintf("service %s/%s via apiserver proxy", promRouteNamespace, promRouteName),
+			discover:    serviceProxyDiscoverer(promRouteNamespace, promRouteName),
+		},
+		{
+			description: "port-forward to a prometheus service",
+			discover:    portForwardDiscoverer,
+		},
+	}
+}
+
+func routeDiscoverer(namespace, name string) func(context.Context, *rest.Config) (string, error) {
+	return func(ctx context.Context, cfg *rest.Config) (string, error) {
+		rc, err := routeClient.NewForConfig(cfg)
+		if err != nil {
+			return "", err
+		}
+		route, err := rc.Routes(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return prometheusHost(route), nil
+	}
+}
+
+// serviceProxyDiscoverer reaches the service through the apiserver's service proxy, which only requires that the
+// apiserver itself is reachable.
+func serviceProxyDiscoverer(namespace, name string) func(context.Context, *rest.Config) (string, error) {
+	return func(ctx context.Context, cfg *rest.Config) (string, error) {
+		kc, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return "", err
+		}
+		svc, err := kc.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		port, err := servicePort(svc)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/api/v1/namespaces/%s/services/https:%s:%s/proxy",
+			strings.TrimSuffix(cfg.Host, "/"), namespace, name, port), nil
+	}
+}
+
+// servicePort prefers the conventional "web" port of the prometheus service, falling back to its first port.
+func servicePort(svc *corev1.Service) (string, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return "", fmt.Errorf("service %s/%s exposes no ports", svc.Namespace, svc.Name)
+	}
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "web" {
+			return p.Name, nil
+		}
+	}
+	p := svc.Spec.Ports[0]
+	if p.Name != "" {
+		return p.Name, nil
+	}
+	return fmt.Sprintf("%d", p.Port), nil
+}
+
+// discoverPrometheus walks the discovery chain, returning the first endpoint found.  If none is found, the error
+// lists every attempt and why it failed.
+func discoverPrometheus(ctx context.Context, cfg *rest.Config) (string, error) {
+	var attempts []string
+	for _, d := range discoveryChain() {
+		klog.Infof("looking up prometheus: %s", d.description)
+		host, err := d.discover(ctx, cfg)
+		if err == nil {
+			return host, nil
+		}
+		klog.V(2).Infof("%s: %v", d.description, err)
+		attempts = append(attempts, fmt.Sprintf("  %s: %v", d.description, err))
+	}
+	return "", fmt.Errorf("unable to locate the prometheus query endpoint, attempted:\n%s\n"+
+		"use --prom-route-namespace and --prom-route-name if prometheus is exposed elsewhere, or --prometheus-url",
+		strings.Join(attempts, "\n"))
+}
+
+// prometheusURLClient connects to --prometheus-url, authenticating with the token of --prometheus-token-file or
+// --prometheus-token-vault, or as --prom-user, if set, and with the TLS settings of --ca-cert, --client-cert, and
+// --insecure-skip-tls-verify.  The kubeconfig's credentials are never sent to it: the URL may be outside the cluster.
+func prometheusURLClient() (promapi.Client, error) {
+	cfg := &rest.Config{
+		Username: promUser,
+		Password: promPassword,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:   promCACert,
+			CertFile: promClientCert,
+			KeyFile:  promClientKey,
+			Insecure: promInsecure,
+		},
+	}
+	if promUser != "" && cfg.Password == "" {
+		cfg.Password = os.Getenv("CALIPER_PROM_PASSWORD")
+	}
+	if err := overrideToken(cfg); err != nil {
+		return nil, err
+	}
+	transport, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	header, err := promHeaders()
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("initializing connection for --prometheus-url: %s", prometheusURL)
+	return promapi.NewClient(promapi.Config{
+		Address:      prometheusURL,
+		RoundTripper: withHeaders(transport, header),
+	})
+}
+
+// tenantHeader names the tenant of a request to Cortex and Mimir.
+const tenantHeader = "X-Scope-OrgID"
+
+// promHeaders parses the name=value pairs of --prom-header, and adds the tenant of --tenant-id.  Repeated names send
+// several values.
+func promHeaders() (http.Header, error) {
+	if len(promHeaderPairs) == 0 && promTenant == "" {
+		return nil, nil
+	}
+	header := make(http.Header, len(promHeaderPairs)+1)
+	for _, h := range promHeaderPairs {
+		i := strings.Index(h, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%q is not of the form name=value", h)
+		}
+		name := h[:i]
+		if strings.ContainsAny(name, " \t:") {
+			return nil, fmt.Errorf("%q is not a valid header name", name)
+		}
+		header.Add(name, h[i+1:])
+	}
+	if promTenant != "" {
+		if header.Get(tenantHeader) != "" {
+			return nil, fmt.Errorf("%s is also set by --tenant-id: drop one", tenantHeader)
+		}
+		header.Set(tenantHeader, promTenant)
+	}
+	return header, nil
+}
+
+// headerTransport adds header to every request, e.g. the headers an auth proxy in front of prometheus expects.
+type headerTransport struct {
+	header http.Header
+	rt     http.RoundTripper
+}
+
+// withHeaders returns rt, adding header to every request if it is not empty.
+func withHeaders(rt http.RoundTripper, header http.Header) http.RoundTripper {
+	if len(header) == 0 {
+		return rt
+	}
+	return &headerTransport{header: header, rt: rt}
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.rt.RoundTrip(req)
+}
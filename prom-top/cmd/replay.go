@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/record"
+)
+
+// Files of a --record directory.
+const (
+	responsesFile = "responses.json"
+	recordingFile = "recording.json"
+)
+
+// recording is what a --replay needs besides the prometheus responses: what the queries were built from, including
+// the metrics matched by --metric-regex and their types, and the description of the run, which were read from the
+// cluster.
+type recording struct {
+	Cluster    string            `json:"cluster"`
+	OptedOut   []string          `json:"optedOut,omitempty"`
+	Namespaces []string          `json:"namespaces,omitempty"`
+	Metrics    map[string]string `json:"metrics,omitempty"`
+	Time       time.Time         `json:"time"`
+	Run        dbhandler.Run     `json:"run"`
+}
+
+// saveRecording writes the responses recorded by recorder, and rec, to dir.
+func saveRecording(dir string, recorder *record.Recorder, rec recording) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating --record directory: %v", err)
+	}
+	if err := recorder.Save(filepath.Join(dir, responsesFile)); err != nil {
+		return fmt.Errorf("recording responses: %v", err)
+	}
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding recording: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, recordingFile), b, 0644); err != nil {
+		return fmt.Errorf("recording run: %v", err)
+	}
+	klog.Infof("recorded %d prometheus responses to %s", len(recorder.Responses()), dir)
+	return nil
+}
+
+// replay collates the prometheus responses saved to --replay-dir by a --record run, without cluster access.  The
+// query flags, e.g. --range and --match, must be those of the recorded run, as queries are answered by their text.
+func replay(ctx context.Context) (top.PodMetricTable, error) {
+	b, err := ioutil.ReadFile(filepath.Join(replayDir, recordingFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %v", err)
+	}
+	var rec recording
+	if err = json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", recordingFile, err)
+	}
+	q, err := record.LoadQuerier(filepath.Join(replayDir, responsesFile))
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("replaying the collection of %s recorded at %s", rec.Cluster, rec.Time.Format(time.RFC3339))
+
+	if metricRegex != "" {
+		matchedMetrics = rec.Metrics
+	}
+	start := time.Now()
+	topCfg, err := topConfig(ctx, q, nil, rec.OptedOut, rec.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+	topCfg.Time = rec.Time
+	topCfg.Build = build
+	if topCfg.Build == "" {
+		topCfg.Build = rec.Run.Version
+	}
+	result, err := top.Top(topCfg)
+	if err != nil {
+		return nil, fmt.Errorf("replaying %s, were the query flags those of the recorded run?: %v", replayDir, err)
+	}
+	finishCollection(result, rec.Cluster, topCfg)
+
+	run, err = newRun(result, topCfg.Build, rec.Cluster, start)
+	run.Platform, run.ClusterVersion = rec.Run.Platform, rec.Run.ClusterVersion
+	run.NodeRoles, run.InstanceTypes = rec.Run.NodeRoles, rec.Run.InstanceTypes
+	return result, err
+}
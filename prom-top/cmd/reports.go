@@ -0,0 +1,169 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/report"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// reports are the analyses selectable with --report, keyed by name.  Each writes a section to stdout after the
+// results have been written to the sink.
+var reports = map[string]func(w io.Writer, result top.PodMetricTable) error{
+	"idle": func(w io.Writer, result top.PodMetricTable) error {
+		mem, err := resource.ParseQuantity(idleMemory)
+		if err != nil {
+			return fmt.Errorf("--idle-memory: %v", err)
+		}
+		return report.WriteIdle(w, report.Idle(result, idleCPU, float64(mem.Value())))
+	},
+	"spikes": func(w io.Writer, result top.PodMetricTable) error {
+		spikes := report.Spikes(result, burstyThreshold)
+		if err := attachEvents(spikes); err != nil {
+			return err
+		}
+		return report.WriteSpikes(w, spikes)
+	},
+	"distribution": func(w io.Writer, result top.PodMetricTable) error {
+		agg := top.Aggregation(distributionAggregate)
+		return report.WriteDistributions(w, agg, result.Distributions(agg))
+	},
+	"per-replica": func(w io.Writer, result top.PodMetricTable) error {
+		return report.WriteReplicaUsage(w, top.Average, result.PerReplica(top.Average, replicaCounts))
+	},
+	"noisy-neighbors": func(w io.Writer, result top.PodMetricTable) error {
+		return report.WriteNoisyNeighbors(w, report.NoisyNeighbors(result, noisyShare))
+	},
+	"quota": func(w io.Writer, result top.PodMetricTable) error {
+		quotas, pods := result.QuotaUsage(namespaceLimits)
+		return report.WriteQuotas(w, quotas, pods)
+	},
+}
+
+// spikeReasons are the reasons of the events attached to spikes.  The kubelet reports OOM kills as OOMKilling.
+var spikeReasons = map[string]bool{"OOMKilled": true, "OOMKilling": true, "Evicted": true, "BackOff": true}
+
+// attachEvents fetches the OOM kill, eviction, and back-off events of the spiking pods that occurred during the
+// range of the run and attaches them to their spikes.  Events are only retained by the cluster for a while, an hour
+// by default, so those of long ranges may be incomplete.
+func attachEvents(spikes []report.Spike) error {
+	if len(spikes) == 0 {
+		return nil
+	}
+	cfg, err := restConfig()
+	if err != nil {
+		return err
+	}
+	if err = overrideToken(cfg); err != nil {
+		return err
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if d, err := model.ParseDuration(run.Range); err == nil {
+		since = time.Now().Add(-time.Duration(d))
+	}
+	byPod := make(map[string][]*report.Spike)
+	for i := range spikes {
+		s := &spikes[i]
+		byPod[s.Namespace+"/"+s.Pod] = append(byPod[s.Namespace+"/"+s.Pod], s)
+	}
+	listed := make(map[string]bool)
+	for _, s := range spikes {
+		if listed[s.Namespace] {
+			continue
+		}
+		listed[s.Namespace] = true
+		events, err := kc.CoreV1().Events(s.Namespace).List(context.Background(), metav1.ListOptions{
+			FieldSelector: "involvedObject.kind=Pod",
+		})
+		if err != nil {
+			return fmt.Errorf("listing events of namespace %s: %v", s.Namespace, err)
+		}
+		for _, e := range events.Items {
+			t := e.LastTimestamp.Time
+			if t.IsZero() {
+				t = e.EventTime.Time
+			}
+			if !spikeReasons[e.Reason] || t.Before(since) {
+				continue
+			}
+			for _, spike := range byPod[s.Namespace+"/"+e.InvolvedObject.Name] {
+				spike.Events = append(spike.Events, report.Event{Time: t, Reason: e.Reason, Message: e.Message, Count: e.Count})
+			}
+		}
+	}
+	for i := range spikes {
+		events := spikes[i].Events
+		sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	}
+	return nil
+}
+
+// replicaCounts are the replica counts of the pods' controllers, keyed by namespace/owner, read by collect for the
+// per-replica report.
+var replicaCounts map[string]float64
+
+// namespaceLimits are the ResourceQuota and LimitRange constraints of the namespaces, read by collect for the quota
+// report.
+var namespaceLimits top.Limits
+
+// reportSelected reports whether --report selects name.
+func reportSelected(name string) bool {
+	for _, r := range reportModes {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func reportNames() string {
+	names := make([]string, 0, len(reports))
+	for n := range reports {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// writeReports writes the sections selected by --report, in the order given.
+func writeReports(result top.PodMetricTable) error {
+	for i, name := range reportModes {
+		if i > 0 {
+			fmt.Fprintln(os.Stdout)
+		}
+		if err := reports[name](os.Stdout, result); err != nil {
+			return fmt.Errorf("%s report: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/jsonschema"
+	"github.com/redhat-et/caliper/prom-top/pkg/webhook"
+)
+
+const (
+	schemaUsage   = `usage: prom-top schema`
+	validateUsage = `usage: prom-top validate <file.json>...`
+)
+
+// schemaCommand prints the JSON Schema of the documents written by --format json and webhook.
+func schemaCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf(schemaUsage)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(webhook.Schema())
+}
+
+// validateCommand checks files written by --format json, or webhook bodies captured to files, against the schema
+// of this build of prom-top.  Each file holds one document, or one per line.
+func validateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(validateUsage)
+	}
+	schema := webhook.Schema()
+	invalid := 0
+	for _, path := range args {
+		problems, err := validateFile(schema, path)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", path, p)
+		}
+		if len(problems) > 0 {
+			invalid++
+			continue
+		}
+		klog.Infof("%s is valid", path)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files do not match the schema", invalid, len(args))
+	}
+	return nil
+}
+
+func validateFile(schema jsonschema.Schema, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	problems, err := schema.Validate(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return problems, nil
+}
@@ -0,0 +1,443 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/arrow"
+	"github.com/redhat-et/caliper/prom-top/pkg/bigquery"
+	"github.com/redhat-et/caliper/prom-top/pkg/cloudwatch"
+	"github.com/redhat-et/caliper/prom-top/pkg/datadog"
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/remotewrite"
+	"github.com/redhat-et/caliper/prom-top/pkg/report"
+	"github.com/redhat-et/caliper/prom-top/pkg/resultpb"
+	"github.com/redhat-et/caliper/prom-top/pkg/sink"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/webhook"
+)
+
+// The builtin sinks, selected by --format.  Their configuration is read from the flags when they are validated or
+// opened, after flag parsing.
+func init() {
+	sink.Register("stdout", sink.Driver{
+		Open: func() (sink.Sink, error) { return converted(sink.Func(printToStdout)), nil },
+	})
+	sink.Register("csv", sink.Driver{
+		Open: func() (sink.Sink, error) { return converted(sink.Func(writeCSV)), nil },
+	})
+	sink.Register("json", sink.Driver{
+		Open: func() (sink.Sink, error) { return converted(sink.Func(writeJSON)), nil },
+	})
+	sink.Register("arrow", sink.Driver{
+		Open: func() (sink.Sink, error) { return sink.Func(writeArrow), nil },
+	})
+	sink.Register("proto", sink.Driver{
+		Open: func() (sink.Sink, error) { return sink.Func(writeProto), nil },
+	})
+	sink.Register("postgres", sink.Driver{
+		Validate: validatePostgres,
+		Open:     func() (sink.Sink, error) { return sink.Func(streamToDatabase), nil },
+	})
+	sink.Register("bigquery", sink.Driver{
+		Validate: func() error {
+			if err := bigqueryConfig().Validate(); err != nil {
+				return fmt.Errorf("%v. Set --bigquery-project, --bigquery-dataset, --bigquery-table and --bigquery-credentials", err)
+			}
+			return nil
+		},
+		Open: func() (sink.Sink, error) {
+			return bigquery.NewClient(bigqueryConfig())
+		},
+	})
+	sink.Register("cloudwatch", sink.Driver{
+		Validate: func() error {
+			if err := cloudwatchConfig().Validate(); err != nil {
+				return fmt.Errorf("%v. Set --cloudwatch-region and export the AWS credentials", err)
+			}
+			return nil
+		},
+		Open: func() (sink.Sink, error) {
+			return cloudwatch.NewClient(cloudwatchConfig())
+		},
+	})
+	sink.Register("datadog", sink.Driver{
+		Validate: func() error { return datadogConfig().Validate() },
+		Open: func() (sink.Sink, error) {
+			return datadog.NewClient(datadogConfig())
+		},
+	})
+	sink.Register("webhook", sink.Driver{
+		Validate: func() error {
+			if err := webhookConfig().Validate(); err != nil {
+				return fmt.Errorf("%v. Set --webhook-url and a --webhook-retries of at least 0", err)
+			}
+			return nil
+		},
+		Open: func() (sink.Sink, error) {
+			c, err := webhook.NewClient(webhookConfig())
+			if err != nil {
+				return nil, err
+			}
+			return converted(sink.Func(func(ctx context.Context, metrics top.PodMetricTable) error {
+				for _, m := range metrics {
+					m.RunID = run.RunID
+				}
+				return c.Write(ctx, metrics)
+			})), nil
+		},
+	})
+	sink.Register("remote-write", sink.Driver{
+		Validate: func() error {
+			if remoteWriteURL == "" {
+				return fmt.Errorf("requires an endpoint: add --remote-write-url")
+			}
+			return remoteWriteConfig().Validate()
+		},
+		Open: func() (sink.Sink, error) {
+			return remotewrite.NewClient(remoteWriteConfig())
+		},
+	})
+}
+
+// outputUnits are the units selected by --cpu-unit and --memory-unit.
+func outputUnits() top.Units {
+	return top.Units{CPU: cpuUnit, Memory: memoryUnit}
+}
+
+// converted wraps s to express results in the --cpu-unit and --memory-unit before writing them.  Only the sinks
+// meant to be read by people or ad-hoc consumers convert, databases and metric backends always receive cores and
+// bytes so that results stay comparable.
+func converted(s sink.Sink) sink.Sink {
+	return sink.Func(func(ctx context.Context, metrics top.PodMetricTable) error {
+		return s.Write(ctx, metrics.Convert(outputUnits()))
+	})
+}
+
+// previousTables hold the results of the previous --interval iteration written to stdout, by cluster, to show what
+// changed since.
+var previousTables = make(map[string]top.PodMetricTable)
+
+func printToStdout(_ context.Context, podMetrics top.PodMetricTable) error {
+	klog.Infof("got %d results", len(podMetrics))
+	cluster := ""
+	if len(podMetrics) > 0 {
+		cluster = podMetrics[0].Cluster
+	}
+	err := report.WriteTable(os.Stdout, podMetrics, report.TableOptions{
+		Color:           colorOutput(),
+		BurstyThreshold: burstyThreshold,
+		Previous:        previousTables[cluster],
+		DeltaThreshold:  deltaThreshold,
+		PodLabels:       podLabelNames(),
+		Cluster:         len(contexts) > 0,
+	})
+	if interval > 0 {
+		previousTables[cluster] = podMetrics
+	}
+	return err
+}
+
+// colorOutput reports whether stdout output is colored: unless --no-color is set, when stdout is a terminal.
+func colorOutput() bool {
+	if noColor {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// csvWriter is satisfied by both the wide and long tables.
+type csvWriter interface {
+	WriteCSV(w io.Writer) error
+	AppendCSV(w io.Writer) error
+}
+
+// outputStarted is set once the results of the first collection have been written to --output-file: the later
+// iterations of an --interval loop append theirs.
+var outputStarted bool
+
+// writeOutput writes the results of a collection, n rows, to --output-file, or stdout, as kind.  write is told
+// whether it appends to the output of an earlier iteration.
+func writeOutput(kind string, n int, write func(w io.Writer, appending bool) error) error {
+	appending, flags := outputStarted, os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	outputStarted = true
+	if outputFile == "" || outputFile == "-" {
+		return write(os.Stdout, appending)
+	}
+	f, err := os.OpenFile(outputFile, flags, 0666)
+	if err != nil {
+		return fmt.Errorf("creating %s output: %v", kind, err)
+	}
+	if err = write(f, appending); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s output: %v", kind, err)
+	}
+	klog.Infof("wrote %d results to %s", n, outputFile)
+	return f.Close()
+}
+
+func writeCSV(_ context.Context, metrics top.PodMetricTable) error {
+	for _, m := range metrics {
+		m.RunID = run.RunID
+	}
+	var table csvWriter = metrics
+	if schema == "long" {
+		table = metrics.Long()
+	}
+	return writeOutput("csv", len(metrics), func(w io.Writer, appending bool) error {
+		if appending {
+			return table.AppendCSV(w)
+		}
+		return table.WriteCSV(w)
+	})
+}
+
+// writeJSON writes the document --format webhook would post, on a single line.
+func writeJSON(_ context.Context, metrics top.PodMetricTable) error {
+	for _, m := range metrics {
+		m.RunID = run.RunID
+	}
+	doc := webhookConfig().Document(metrics)
+	return writeOutput("json", len(metrics), func(w io.Writer, _ bool) error {
+		return json.NewEncoder(w).Encode(doc)
+	})
+}
+
+// writeProto writes metrics as a resultpb.PodMetricTable.  Like databases, it receives cores and bytes.
+func writeProto(_ context.Context, metrics top.PodMetricTable) error {
+	for _, m := range metrics {
+		m.RunID = run.RunID
+	}
+	b, err := proto.Marshal(resultpb.FromTable(metrics))
+	if err != nil {
+		return fmt.Errorf("encoding protobuf output: %v", err)
+	}
+	return writeOutput("protobuf", len(metrics), func(w io.Writer, _ bool) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// writeArrow writes metrics as a record batch of an Arrow IPC stream with the columns of the database table,
+// preceded by the stream's schema unless appending.  Like databases, it receives cores and bytes.
+func writeArrow(_ context.Context, metrics top.PodMetricTable) error {
+	rows := make([]dbhandler.Row, 0, len(metrics))
+	for _, m := range metrics {
+		r := dbhandler.Row(*m)
+		r.RunID = run.RunID
+		rows = append(rows, r)
+	}
+	return writeOutput("arrow", len(metrics), func(w io.Writer, appending bool) error {
+		if !appending {
+			columns, err := dbhandler.ArrowColumns()
+			if err != nil {
+				return err
+			}
+			if err = arrow.WriteSchema(w, columns); err != nil {
+				return err
+			}
+		}
+		return dbhandler.WriteArrowBatch(w, rows)
+	})
+}
+
+func validatePostgres() error {
+	if err := dbhandler.ValidateConfig(); err != nil {
+		return fmt.Errorf("requires a database: %v. Set them in the environment or the .env file next to the binary", err)
+	}
+	return nil
+}
+
+// rowInserter writes metric rows with one of the --db-driver drivers.
+type rowInserter interface {
+	InsertRows(rows []dbhandler.Row, batchSize int) (int64, error)
+	InsertLongRows(rows []dbhandler.LongRow, batchSize int) (int64, error)
+}
+
+// sqlInserter is the database/sql driver.
+type sqlInserter struct {
+	db *sqlx.DB
+}
+
+func (s sqlInserter) InsertRows(rows []dbhandler.Row, batchSize int) (int64, error) {
+	return dbhandler.InsertRows(s.db, rows, batchSize)
+}
+
+func (s sqlInserter) InsertLongRows(rows []dbhandler.LongRow, batchSize int) (int64, error) {
+	return dbhandler.InsertLongRows(s.db, rows, batchSize)
+}
+
+// streamToDatabase inserts metrics into the postgres database configured in the environment or .env file.
+func streamToDatabase(_ context.Context, metrics top.PodMetricTable) error {
+	if run.Version == "" {
+		return fmt.Errorf("results lack the build they were collected from: add --build")
+	}
+	klog.Infoln("init postgres db client")
+	db, err := dbhandler.NewPostgresClient()
+	if err != nil {
+		return fmt.Errorf("failed to send to db: %v", err)
+	}
+	defer db.Close()
+
+	if err = dbhandler.CheckSchema(db); err != nil {
+		return err
+	}
+
+	if err = dbhandler.InsertRun(db, run); err != nil {
+		return err
+	}
+
+	var ins rowInserter = sqlInserter{db}
+	if dbDriver == "pgx" {
+		nc, err := dbhandler.NewNativeClient()
+		if err != nil {
+			return fmt.Errorf("failed to send to db: %v", err)
+		}
+		defer nc.Close()
+		ins = nc
+	}
+
+	var nrows int64
+	if schema == "long" {
+		long := metrics.Long()
+		rows := make([]dbhandler.LongRow, 0, len(long))
+		for _, m := range long {
+			r := dbhandler.LongRow(*m)
+			r.RunID = run.RunID
+			rows = append(rows, r)
+		}
+		nrows, err = ins.InsertLongRows(rows, dbBatchSize)
+	} else {
+		rows := make([]dbhandler.Row, 0, len(metrics))
+		for _, m := range metrics {
+			r := dbhandler.Row(*m)
+			r.RunID = run.RunID
+			rows = append(rows, r)
+		}
+		nrows, err = ins.InsertRows(rows, dbBatchSize)
+	}
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+	klog.Infof("insert success, updated %d rows", nrows)
+
+	if dbDeltas {
+		compared, err := dbhandler.UpdateDeltas(db, run.RunID, run.Version)
+		if err != nil {
+			return err
+		}
+		klog.Infof("compared %d of %d rows with an earlier build", compared, nrows)
+	}
+
+	if showQueryStats {
+		iteration := 0
+		if len(metrics) > 0 {
+			iteration = metrics[0].Iteration
+		}
+		if _, err = dbhandler.InsertQueryStats(db, queryStatRows(iteration), dbBatchSize); err != nil {
+			return fmt.Errorf("inserting query stats: %v", err)
+		}
+	}
+	return nil
+}
+
+// queryStatRows returns the stats of the queries of the current collection as rows of run.
+func queryStatRows(iteration int) []dbhandler.QueryStat {
+	stats := queryStats.Queries()
+	rows := make([]dbhandler.QueryStat, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, dbhandler.QueryStat{
+			RunID:       run.RunID,
+			Iteration:   iteration,
+			Metric:      s.Metric,
+			Aggregation: string(s.Aggregation),
+			Query:       s.Expr,
+			Duration:    s.Duration.Seconds(),
+			Samples:     s.Samples,
+			Cached:      s.Cached,
+			Warnings:    strings.Join(s.Warnings, "\n"),
+
+			EvalSeconds:   s.Prometheus.EvalTime.Seconds(),
+			SamplesLoaded: s.Prometheus.SamplesLoaded,
+			Error:         s.Error,
+		})
+	}
+	return rows
+}
+
+func bigqueryConfig() bigquery.Config {
+	return bigquery.Config{
+		Project:         bigqueryProject,
+		Dataset:         bigqueryDataset,
+		Table:           bigqueryTable,
+		CredentialsFile: bigqueryCredentials,
+	}
+}
+
+func cloudwatchConfig() cloudwatch.Config {
+	return cloudwatch.Config{
+		Region:    cloudwatchRegion,
+		Namespace: cloudwatchNamespace,
+	}
+}
+
+func datadogConfig() datadog.Config {
+	return datadog.Config{
+		Site: datadogSite,
+		Tags: datadogTags,
+	}
+}
+
+func webhookConfig() webhook.Config {
+	token := webhookToken
+	if token == "" {
+		token = os.Getenv("CALIPER_WEBHOOK_TOKEN")
+	}
+	return webhook.Config{
+		URL:         webhookURL,
+		Token:       token,
+		Retries:     webhookRetries,
+		Units:       outputUnits(),
+		Totals:      showTotals,
+		ToolVersion: toolVersion,
+	}
+}
+
+func remoteWriteConfig() remotewrite.Config {
+	token := remoteWriteToken
+	if token == "" {
+		token = os.Getenv("CALIPER_REMOTE_WRITE_TOKEN")
+	}
+	return remotewrite.Config{
+		URL:    remoteWriteURL,
+		Token:  token,
+		Labels: remoteWriteLabels,
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package arrow writes flat tables as Apache Arrow IPC streams: a schema message followed by record batches of
+// non-nullable, uncompressed columns, which analysts can read or memory-map without parsing, e.g. with
+// pyarrow.ipc.open_stream.  It implements just what writing caliper results needs.
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is the type of a column.
+type Type int
+
+// Column types.
+const (
+	Boolean Type = iota
+	Int64
+	Double
+	// String is UTF-8.
+	String
+)
+
+// Column describes a column of the stream.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Arrow enum values, from Schema.fbs and Message.fbs
+const (
+	metadataV5          = 4
+	headerSchema        = 1
+	headerRecordBatch   = 3
+	typeInt             = 2
+	typeFloatingPoint   = 3
+	typeUtf8            = 5
+	typeBool            = 6
+	precisionDouble     = 2
+	continuationMarker  = 0xFFFFFFFF
+	bufferAlignment     = 8
+	messagePrefixLength = 8
+)
+
+// WriteSchema writes the schema message that starts a stream of columns.
+func WriteSchema(w io.Writer, columns []Column) error {
+	fields := make(fbTables, len(columns))
+	for i, col := range columns {
+		f := new(fbTable).ref(0, fbString(col.Name)).bool(1, false)
+		switch col.Type {
+		case Boolean:
+			f.uint8(2, typeBool).ref(3, new(fbTable))
+		case Int64:
+			f.uint8(2, typeInt).ref(3, new(fbTable).int32(0, 64).bool(1, true))
+		case Double:
+			f.uint8(2, typeFloatingPoint).ref(3, new(fbTable).int16(0, precisionDouble))
+		case String:
+			f.uint8(2, typeUtf8).ref(3, new(fbTable))
+		default:
+			return fmt.Errorf("column %s: unknown type %d", col.Name, col.Type)
+		}
+		fields[i] = f.ref(5, fbTables{})
+	}
+	schema := new(fbTable).int16(0, 0).ref(1, fields)
+	return writeMessage(w, headerSchema, schema, nil)
+}
+
+// WriteRecordBatch writes a record batch of nrows rows of columns, which must match the schema of the stream.  value
+// returns the value of the row'th row in the col'th column, a bool, int64, float64, or string as given by the
+// column's Type.
+//
+// A stream may hold any number of record batches, and ends where its writer stops: readers accept the end of the
+// input as the end of the stream.
+func WriteRecordBatch(w io.Writer, columns []Column, nrows int, value func(row, col int) interface{}) error {
+	var body []byte
+	var nodes, buffers []int64
+	// addBuffer appends data to the body, padded to the buffer alignment, and describes it in buffers
+	addBuffer := func(data []byte) {
+		buffers = append(buffers, int64(len(body)), int64(len(data)))
+		body = append(body, data...)
+		for len(body)%bufferAlignment != 0 {
+			body = append(body, 0)
+		}
+	}
+	for c, col := range columns {
+		nodes = append(nodes, int64(nrows), 0)
+		// no validity bitmap, every column is non-nullable
+		addBuffer(nil)
+		switch col.Type {
+		case Boolean:
+			bits := make([]byte, (nrows+7)/8)
+			for i := 0; i < nrows; i++ {
+				v, ok := value(i, c).(bool)
+				if !ok {
+					return typeError(col, i, value(i, c))
+				}
+				if v {
+					bits[i/8] |= 1 << uint(i%8)
+				}
+			}
+			addBuffer(bits)
+		case Int64, Double:
+			data := make([]byte, 8*nrows)
+			for i := 0; i < nrows; i++ {
+				var bits uint64
+				switch v := value(i, c).(type) {
+				case int64:
+					if col.Type != Int64 {
+						return typeError(col, i, v)
+					}
+					bits = uint64(v)
+				case float64:
+					if col.Type != Double {
+						return typeError(col, i, v)
+					}
+					bits = math.Float64bits(v)
+				default:
+					return typeError(col, i, v)
+				}
+				binary.LittleEndian.PutUint64(data[8*i:], bits)
+			}
+			addBuffer(data)
+		case String:
+			offsets := make([]byte, 4*(nrows+1))
+			var data []byte
+			for i := 0; i < nrows; i++ {
+				v, ok := value(i, c).(string)
+				if !ok {
+					return typeError(col, i, value(i, c))
+				}
+				data = append(data, v...)
+				binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+			}
+			addBuffer(offsets)
+			addBuffer(data)
+		default:
+			return fmt.Errorf("column %s: unknown type %d", col.Name, col.Type)
+		}
+	}
+	batch := new(fbTable).
+		int64(0, int64(nrows)).
+		ref(1, fbStructs{n: len(nodes) / 2, data: int64s(nodes...)}).
+		ref(2, fbStructs{n: len(buffers) / 2, data: int64s(buffers...)})
+	return writeMessage(w, headerRecordBatch, batch, body)
+}
+
+func typeError(col Column, row int, v interface{}) error {
+	return fmt.Errorf("column %s, row %d: unexpected %T", col.Name, row, v)
+}
+
+// writeMessage writes an encapsulated message: the continuation marker, the length of the metadata, the Message
+// flatbuffer, padded so the body is aligned, and the body.
+func writeMessage(w io.Writer, headerType uint8, header *fbTable, body []byte) error {
+	message := new(fbTable).
+		int16(0, metadataV5).
+		uint8(1, headerType).
+		ref(2, header).
+		int64(3, int64(len(body)))
+	metadata := finish(message)
+	for (messagePrefixLength+len(metadata))%bufferAlignment != 0 {
+		metadata = append(metadata, 0)
+	}
+	prefix := make([]byte, messagePrefixLength)
+	binary.LittleEndian.PutUint32(prefix, continuationMarker)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(metadata)))
+	for _, b := range [][]byte{prefix, metadata, body} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// columns and batches are a fixture of every column type, the first batch with more rows than a byte of booleans.
+var (
+	columns = []Column{
+		{Name: "pod", Type: String},
+		{Name: "iteration", Type: Int64},
+		{Name: "avg_value", Type: Double},
+		{Name: "partial", Type: Boolean},
+	}
+	batches = [][][]interface{}{
+		{
+			{"etcd-0", int64(0), .5, false},
+			{"", int64(-1), 0., true},
+			{"dns-default-x7k2p", int64(math.MaxInt64), math.Inf(1), true},
+			{"ünïcödé", int64(math.MinInt64), -2.5e-300, false},
+			{"a", int64(4), 4., false},
+			{"b", int64(5), 5., false},
+			{"c", int64(6), 6., false},
+			{"d", int64(7), 7., true},
+			{"e", int64(8), 8., false},
+			{"f", int64(9), 9., true},
+		},
+		{},
+		{
+			{"prometheus-k8s-0", int64(1), 1., true},
+		},
+	}
+)
+
+func write(t *testing.T, columns []Column, batches [][][]interface{}) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := WriteSchema(buf, columns); err != nil {
+		t.Fatal(err)
+	}
+	for _, rows := range batches {
+		err := WriteRecordBatch(buf, columns, len(rows), func(row, col int) interface{} { return rows[row][col] })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	gotColumns, gotBatches := read(t, write(t, columns, batches))
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Errorf("got columns %v, want %v", gotColumns, columns)
+	}
+	if len(gotBatches) != len(batches) {
+		t.Fatalf("got %d batches, want %d", len(gotBatches), len(batches))
+	}
+	for i, rows := range batches {
+		if len(rows) == 0 && len(gotBatches[i]) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(gotBatches[i], rows) {
+			t.Errorf("batch %d: got rows %v, want %v", i, gotBatches[i], rows)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	if err := WriteSchema(ioutil.Discard, []Column{{Name: "decimal", Type: 9}}); err == nil {
+		t.Error("expected an error writing the schema of an unknown type")
+	}
+	for _, tt := range []struct {
+		name  string
+		col   Column
+		value interface{}
+	}{
+		{"int", Column{Name: "iteration", Type: Int64}, 1},
+		{"double of an int64 column", Column{Name: "iteration", Type: Int64}, 1.},
+		{"int64 of a double column", Column{Name: "avg_value", Type: Double}, int64(1)},
+		{"string", Column{Name: "partial", Type: Boolean}, "true"},
+		{"bool", Column{Name: "pod", Type: String}, true},
+		{"unknown type", Column{Name: "decimal", Type: 9}, int64(1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WriteRecordBatch(ioutil.Discard, []Column{tt.col}, 1, func(int, int) interface{} { return tt.value })
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestGolden compares the stream written of the fixture to testdata/golden.arrows, so that any change of the
+// encoding is noticed.  Run the tests with -update to rewrite it after an intended change, and check that a reference
+// reader still reads the fixture from it, e.g. pyarrow:
+//
+//	python3 -c 'import pyarrow as pa; print(pa.ipc.open_stream(open("testdata/golden.arrows", "rb")).read_all())'
+func TestGolden(t *testing.T) {
+	got := write(t, columns, batches)
+	path := filepath.Join("testdata", "golden.arrows")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the stream written differs from %s, run the tests with -update if the change is intended", path)
+	}
+}
+
+// read decodes a stream written by WriteSchema and WriteRecordBatch following the Arrow IPC format specification,
+// independently of the encoder, and returns its columns and the rows of each record batch.
+func read(t *testing.T, stream []byte) ([]Column, [][][]interface{}) {
+	t.Helper()
+	var columns []Column
+	var batches [][][]interface{}
+	for pos := 0; pos < len(stream); {
+		if pos%8 != 0 {
+			t.Fatalf("message at %d is not 8-byte aligned", pos)
+		}
+		if binary.LittleEndian.Uint32(stream[pos:]) != 0xFFFFFFFF {
+			t.Fatalf("message at %d lacks the continuation marker", pos)
+		}
+		length := int(binary.LittleEndian.Uint32(stream[pos+4:]))
+		metadata := stream[pos+8 : pos+8+length]
+		// Message: 0 version, 1 header_type, 2 header, 3 bodyLength
+		message := fbRoot(metadata)
+		if v := message.int16(0); v != 4 {
+			t.Errorf("message at %d: got metadata version %d, want V5", pos, v)
+		}
+		bodyStart := pos + 8 + length
+		body := stream[bodyStart : bodyStart+int(message.int64(3))]
+		pos = bodyStart + len(body)
+
+		header := message.table(2)
+		switch message.uint8(1) {
+		case 1:
+			if columns != nil {
+				t.Fatalf("second schema at %d", pos)
+			}
+			columns = readSchema(t, header)
+		case 3:
+			if columns == nil {
+				t.Fatalf("record batch before the schema")
+			}
+			batches = append(batches, readRecordBatch(t, columns, header, body))
+		default:
+			t.Fatalf("message at %d: unexpected header type %d", pos, message.uint8(1))
+		}
+	}
+	return columns, batches
+}
+
+func readSchema(t *testing.T, schema fbReadTable) []Column {
+	t.Helper()
+	// Schema: 0 endianness, 1 fields
+	if schema.int16(0) != 0 {
+		t.Errorf("schema is not little-endian")
+	}
+	var columns []Column
+	fields := schema.vector(1)
+	for i := 0; i < fields.len(); i++ {
+		// Field: 0 name, 1 nullable, 2 type_type, 3 type, 5 children
+		field := fields.table(i)
+		col := Column{Name: field.string(0)}
+		if field.uint8(1) != 0 || field.vector(5).len() != 0 {
+			t.Errorf("column %s is nullable or nested", col.Name)
+		}
+		typ := field.table(3)
+		switch field.uint8(2) {
+		case 6:
+			col.Type = Boolean
+		case 2:
+			// Int: 0 bitWidth, 1 is_signed
+			if typ.int32(0) != 64 || typ.uint8(1) != 1 {
+				t.Errorf("column %s: got an int of %d bits, signed %d", col.Name, typ.int32(0), typ.uint8(1))
+			}
+			col.Type = Int64
+		case 3:
+			// FloatingPoint: 0 precision
+			if typ.int16(0) != 2 {
+				t.Errorf("column %s: got floating point precision %d", col.Name, typ.int16(0))
+			}
+			col.Type = Double
+		case 5:
+			col.Type = String
+		default:
+			t.Fatalf("column %s: unexpected type %d", col.Name, field.uint8(2))
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+func readRecordBatch(t *testing.T, columns []Column, batch fbReadTable, body []byte) [][]interface{} {
+	t.Helper()
+	// RecordBatch: 0 length, 1 nodes, 2 buffers
+	nrows := int(batch.int64(0))
+	nodes, buffers := batch.vector(1), batch.vector(2)
+	if nodes.len() != len(columns) {
+		t.Fatalf("got %d field nodes of %d columns", nodes.len(), len(columns))
+	}
+	for _, v := range []fbReadVector{nodes, buffers} {
+		if (v.pos+4)%8 != 0 {
+			t.Errorf("the structs of the vector at %d are not 8-byte aligned", v.pos)
+		}
+	}
+	// buffer returns the i'th buffer of the body, each struct Buffer { offset: long; length: long }
+	next := 0
+	buffer := func() []byte {
+		offset, length := buffers.structInt64(next, 0), buffers.structInt64(next, 1)
+		next++
+		if offset%8 != 0 {
+			t.Errorf("buffer %d at %d is not 8-byte aligned", next-1, offset)
+		}
+		return body[offset : offset+length]
+	}
+	rows := make([][]interface{}, nrows)
+	for i := range rows {
+		rows[i] = make([]interface{}, len(columns))
+	}
+	for c, col := range columns {
+		// struct FieldNode { length: long; null_count: long }
+		length, nulls := nodes.structInt64(c, 0), nodes.structInt64(c, 1)
+		if length != int64(nrows) || nulls != 0 {
+			t.Errorf("column %s: got %d values, %d null, of %d rows", col.Name, length, nulls, nrows)
+		}
+		if validity := buffer(); len(validity) != 0 {
+			t.Errorf("column %s: got a validity bitmap", col.Name)
+		}
+		switch col.Type {
+		case Boolean:
+			bits := buffer()
+			for row := range rows {
+				rows[row][c] = bits[row/8]&(1<<uint(row%8)) != 0
+			}
+		case Int64:
+			data := buffer()
+			for row := range rows {
+				rows[row][c] = int64(binary.LittleEndian.Uint64(data[8*row:]))
+			}
+		case Double:
+			data := buffer()
+			for row := range rows {
+				rows[row][c] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*row:]))
+			}
+		case String:
+			offsets, data := buffer(), buffer()
+			for row := range rows {
+				start, end := binary.LittleEndian.Uint32(offsets[4*row:]), binary.LittleEndian.Uint32(offsets[4*row+4:])
+				rows[row][c] = string(data[start:end])
+			}
+		}
+	}
+	if next != buffers.len() {
+		t.Errorf("got %d buffers, the columns have %d", buffers.len(), next)
+	}
+	return rows
+}
+
+// fbReadTable reads a FlatBuffers table at pos of buf.
+type fbReadTable struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbReadTable {
+	return fbReadTable{buf: buf, pos: int(binary.LittleEndian.Uint32(buf))}
+}
+
+// offset returns the position of the field in slot, or 0 if it is absent.
+func (t fbReadTable) offset(slot int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*slot >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if o := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*slot:])); o != 0 {
+		return t.pos + o
+	}
+	return 0
+}
+
+func (t fbReadTable) uint8(slot int) uint8 {
+	if o := t.offset(slot); o != 0 {
+		return t.buf[o]
+	}
+	return 0
+}
+
+func (t fbReadTable) int16(slot int) int16 {
+	if o := t.offset(slot); o != 0 {
+		return int16(binary.LittleEndian.Uint16(t.buf[o:]))
+	}
+	return 0
+}
+
+func (t fbReadTable) int32(slot int) int32 {
+	if o := t.offset(slot); o != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[o:]))
+	}
+	return 0
+}
+
+func (t fbReadTable) int64(slot int) int64 {
+	if o := t.offset(slot); o != 0 {
+		return int64(binary.LittleEndian.Uint64(t.buf[o:]))
+	}
+	return 0
+}
+
+// deref returns the position of the object referenced by the field in slot, or 0 if it is absent.
+func (t fbReadTable) deref(slot int) int {
+	o := t.offset(slot)
+	if o == 0 {
+		return 0
+	}
+	return o + int(binary.LittleEndian.Uint32(t.buf[o:]))
+}
+
+func (t fbReadTable) table(slot int) fbReadTable {
+	return fbReadTable{buf: t.buf, pos: t.deref(slot)}
+}
+
+func (t fbReadTable) string(slot int) string {
+	pos := t.deref(slot)
+	n := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	return string(t.buf[pos+4 : pos+4+n])
+}
+
+// fbReadVector is a FlatBuffers vector at pos of buf, or an absent one if pos is 0.
+type fbReadVector struct {
+	buf []byte
+	pos int
+}
+
+func (t fbReadTable) vector(slot int) fbReadVector {
+	return fbReadVector{buf: t.buf, pos: t.deref(slot)}
+}
+
+func (v fbReadVector) len() int {
+	if v.pos == 0 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(v.buf[v.pos:]))
+}
+
+func (v fbReadVector) table(i int) fbReadTable {
+	elem := v.pos + 4 + 4*i
+	return fbReadTable{buf: v.buf, pos: elem + int(binary.LittleEndian.Uint32(v.buf[elem:]))}
+}
+
+// structInt64 returns the field'th int64 of the i'th element of a vector of structs of two int64s, e.g. FieldNode.
+func (v fbReadVector) structInt64(i, field int) int64 {
+	return int64(binary.LittleEndian.Uint64(v.buf[v.pos+4+16*i+8*field:]))
+}
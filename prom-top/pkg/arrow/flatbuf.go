@@ -0,0 +1,205 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package arrow
+
+import (
+	"encoding/binary"
+)
+
+// A minimal FlatBuffers encoder for the Arrow IPC metadata.  Unlike the reference builder, which writes back to
+// front, objects are laid out front to back: a table is followed by the objects it references, so every offset
+// points forward as FlatBuffers requires.
+
+// fbObject is a table, vector, or string.  writeTo appends it to b and returns its position.
+type fbObject interface {
+	writeTo(b *fbBuilder) int
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// pad aligns the end of the buffer to align bytes.
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) uint16At(pos int, v uint16) {
+	binary.LittleEndian.PutUint16(b.buf[pos:], v)
+}
+
+func (b *fbBuilder) uint32(v uint32) int {
+	pos := len(b.buf)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b.buf[pos:], v)
+	return pos
+}
+
+// link sets the offset at pos to reference the object at target.
+func (b *fbBuilder) link(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// finish returns the encoding of a buffer whose root is root.
+func finish(root fbObject) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.link(0, root.writeTo(b))
+	return b.buf
+}
+
+// fbField is a field of a table: either a little-endian scalar or a reference to an object.
+type fbField struct {
+	slot   int
+	scalar []byte
+	ref    fbObject
+}
+
+// fbTable is a table, whose fields are identified by their slot, their position in the schema.
+type fbTable struct {
+	fields []fbField
+}
+
+func (t *fbTable) uint8(slot int, v uint8) *fbTable {
+	t.fields = append(t.fields, fbField{slot: slot, scalar: []byte{v}})
+	return t
+}
+
+func (t *fbTable) bool(slot int, v bool) *fbTable {
+	if v {
+		return t.uint8(slot, 1)
+	}
+	return t.uint8(slot, 0)
+}
+
+func (t *fbTable) int16(slot int, v int16) *fbTable {
+	s := make([]byte, 2)
+	binary.LittleEndian.PutUint16(s, uint16(v))
+	t.fields = append(t.fields, fbField{slot: slot, scalar: s})
+	return t
+}
+
+func (t *fbTable) int32(slot int, v int32) *fbTable {
+	s := make([]byte, 4)
+	binary.LittleEndian.PutUint32(s, uint32(v))
+	t.fields = append(t.fields, fbField{slot: slot, scalar: s})
+	return t
+}
+
+func (t *fbTable) int64(slot int, v int64) *fbTable {
+	s := make([]byte, 8)
+	binary.LittleEndian.PutUint64(s, uint64(v))
+	t.fields = append(t.fields, fbField{slot: slot, scalar: s})
+	return t
+}
+
+func (t *fbTable) ref(slot int, o fbObject) *fbTable {
+	t.fields = append(t.fields, fbField{slot: slot, ref: o})
+	return t
+}
+
+func (t *fbTable) writeTo(b *fbBuilder) int {
+	slots := 0
+	for _, f := range t.fields {
+		if f.slot+1 > slots {
+			slots = f.slot + 1
+		}
+	}
+	// the vtable: its size, the table's size, and the offset of each slot's field in the table, 0 if absent
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*slots)...)
+	b.uint16At(vtable, uint16(4+2*slots))
+
+	b.pad(4)
+	table := len(b.buf)
+	b.uint32(uint32(int32(table - vtable)))
+	// the referenced objects follow the table, their offsets are set once they are written
+	var refs []int
+	for _, f := range t.fields {
+		size := len(f.scalar)
+		if f.ref != nil {
+			size = 4
+		}
+		b.pad(size)
+		b.uint16At(vtable+4+2*f.slot, uint16(len(b.buf)-table))
+		if f.ref != nil {
+			refs = append(refs, b.uint32(0))
+			continue
+		}
+		b.buf = append(b.buf, f.scalar...)
+	}
+	b.uint16At(vtable+2, uint16(len(b.buf)-table))
+	for _, f := range t.fields {
+		if f.ref != nil {
+			b.link(refs[0], f.ref.writeTo(b))
+			refs = refs[1:]
+		}
+	}
+	return table
+}
+
+// fbString is a string.
+type fbString string
+
+func (s fbString) writeTo(b *fbBuilder) int {
+	b.pad(4)
+	pos := b.uint32(uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+// fbTables is a vector of tables.
+type fbTables []*fbTable
+
+func (v fbTables) writeTo(b *fbBuilder) int {
+	b.pad(4)
+	pos := b.uint32(uint32(len(v)))
+	elems := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, t := range v {
+		b.link(elems+4*i, t.writeTo(b))
+	}
+	return pos
+}
+
+// fbStructs is a vector of n structs of 8-byte aligned fields, encoded in data.
+type fbStructs struct {
+	n    int
+	data []byte
+}
+
+func (v fbStructs) writeTo(b *fbBuilder) int {
+	// the elements, which follow the length, must be 8-byte aligned
+	for (len(b.buf)+4)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := b.uint32(uint32(v.n))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
+
+// int64s returns the little-endian encoding of values, e.g. the fields of a vector of structs.
+func int64s(values ...int64) []byte {
+	s := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(s[8*i:], uint64(v))
+	}
+	return s
+}
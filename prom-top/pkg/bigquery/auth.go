@@ -0,0 +1,44 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// scope limits the access tokens to streaming rows into tables.
+const scope = "https://www.googleapis.com/auth/bigquery.insertdata"
+
+// authorizedClient returns an http.Client authorizing its requests with the credentials in the file at path, e.g. a
+// service account key.  Its access tokens are obtained, cached, and refreshed by golang.org/x/oauth2.
+func authorizedClient(path string) (*http.Client, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials: %v", err)
+	}
+	creds, err := google.CredentialsFromJSON(context.Background(), b, scope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing credentials %s: %v", path, err)
+	}
+	return oauth2.NewClient(context.Background(), creds.TokenSource), nil
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bigquery streams PodMetric rows into a BigQuery table through the tabledata.insertAll REST API.  The
+// destination table must exist and have a column for each of dbhandler.ColumnsHeaders.
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const insertAllURL = "https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll"
+
+// MaxBatchSize is the number of rows sent per insertAll request.  BigQuery recommends at most 500.
+const MaxBatchSize = 500
+
+// Config identifies the destination table and the credentials used to write to it.
+type Config struct {
+	Project string
+	Dataset string
+	Table   string
+	// CredentialsFile is a credentials file, e.g. a service account key.  Defaults to $GOOGLE_APPLICATION_CREDENTIALS.
+	CredentialsFile string
+}
+
+// Validate reports missing settings.
+func (c Config) Validate() error {
+	var missing []string
+	if c.Project == "" {
+		missing = append(missing, "project")
+	}
+	if c.Dataset == "" {
+		missing = append(missing, "dataset")
+	}
+	if c.Table == "" {
+		missing = append(missing, "table")
+	}
+	if c.CredentialsFile == "" && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+		missing = append(missing, "credentials")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Client writes rows to a single BigQuery table.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewClient loads the credentials of cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	path := cfg.CredentialsFile
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	client, err := authorizedClient(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cfg: cfg, http: client}, nil
+}
+
+type insertAllRequest struct {
+	SkipInvalidRows bool        `json:"skipInvalidRows"`
+	Rows            []insertRow `json:"rows"`
+}
+
+type insertRow struct {
+	// InsertID lets BigQuery de-duplicate rows re-sent after a failed request.  It is at most 128 characters long.
+	InsertID string                 `json:"insertId"`
+	JSON     map[string]interface{} `json:"json"`
+}
+
+type insertAllResponse struct {
+	InsertErrors []struct {
+		Index  int `json:"index"`
+		Errors []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// Write streams metrics into the table in batches of MaxBatchSize rows.  Rows rejected by BigQuery are reported in
+// the returned error, along with the number of rows written before the failure.  Every row must identify its build
+// in Version.
+func (c *Client) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	for _, m := range metrics {
+		if m.Version == "" {
+			return fmt.Errorf("results lack the build they were collected from")
+		}
+	}
+	endpoint := fmt.Sprintf(insertAllURL,
+		url.PathEscape(c.cfg.Project), url.PathEscape(c.cfg.Dataset), url.PathEscape(c.cfg.Table))
+	for start := 0; start < len(metrics); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		req := insertAllRequest{Rows: make([]insertRow, 0, end-start)}
+		for _, m := range metrics[start:end] {
+			req.Rows = append(req.Rows, insertRow{
+				InsertID: insertID(m),
+				JSON:     c.row(m),
+			})
+		}
+		if err := c.insert(ctx, endpoint, req); err != nil {
+			return fmt.Errorf("rows %d-%d failed, %d rows previously written: %v", start, end-1, start, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) row(m *top.PodMetric) map[string]interface{} {
+	return map[string]interface{}{
+		"version":          m.Version,
+		"cluster":          m.Cluster,
+		"metric":           m.Metric,
+		"node":             m.Node,
+		"pod":              m.Pod,
+		"namespace":        m.Namespace,
+		"owner_name":       m.OwnerName,
+		"workload":         m.Workload,
+		"avg_value":        float(m.AvgValue),
+		"q95_value":        float(m.Q95Value),
+		"max_value":        float(m.MaxValue),
+		"min_value":        float(m.MinValue),
+		"inst_value":       float(m.InstValue),
+		"request":          float(m.Request),
+		"efficiency":       float(m.Efficiency),
+		"burstiness":       float(m.Burstiness),
+		"q95_burstiness":   float(m.Q95Burstiness),
+		"query_time":       m.QueryTime,
+		"range":            m.Range,
+		"partial":          m.Partial,
+		"violation":        m.Violation,
+		"iteration":        m.Iteration,
+		"labels":           m.Labels.JSON(),
+		"hpa_min_replicas": m.HPAMinReplicas,
+		"hpa_max_replicas": m.HPAMaxReplicas,
+		"component":        m.Component,
+		"pod_labels":       m.PodLabels.JSON(),
+	}
+}
+
+// float returns v, or nil, a null, if v is NaN or infinite, which JSON cannot represent.  Prometheus returns NaN
+// e.g. for the quantiles of series without samples.
+func float(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// insertID identifies the row of m by a hash of its key, which may exceed the 128 characters BigQuery allows.
+func insertID(m *top.PodMetric) string {
+	key := strings.Join([]string{
+		m.Version, m.Cluster, m.QueryTime, m.Range, m.Namespace, m.Pod, m.Metric, m.Component,
+	}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Client) insert(ctx context.Context, endpoint string, body insertAllRequest) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encoding rows: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("insertAll returned %s: %s", resp.Status, respBody)
+	}
+	var result insertAllResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	if len(result.InsertErrors) > 0 {
+		first := result.InsertErrors[0]
+		msg := "unknown error"
+		if len(first.Errors) > 0 {
+			msg = fmt.Sprintf("%s: %s", first.Errors[0].Reason, first.Errors[0].Message)
+		}
+		return fmt.Errorf("%d rows rejected, first at index %d: %s", len(result.InsertErrors), first.Index, msg)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bigquery
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestRowNaN(t *testing.T) {
+	m := &top.PodMetric{Version: "4.6.1", Pod: "etcd-0", AvgValue: .5, Q95Value: math.NaN(), MaxValue: math.Inf(1)}
+	b, err := json.Marshal(insertRow{InsertID: insertID(m), JSON: new(Client).row(m)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		JSON map[string]interface{} `json:"json"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.JSON["avg_value"] != .5 || got.JSON["q95_value"] != nil || got.JSON["max_value"] != nil {
+		t.Errorf("got %s, want a null q95_value and max_value", b)
+	}
+}
+
+func TestInsertID(t *testing.T) {
+	long := strings.Repeat("x", 253)
+	m := top.PodMetric{Version: "4.6.1", Cluster: long, QueryTime: "2020-10-01 12:00:00", Range: "10m",
+		Namespace: long, Pod: long, Metric: top.CPUMetric}
+	id := insertID(&m)
+	if len(id) > 128 {
+		t.Errorf("got an insertId of %d characters, BigQuery allows 128", len(id))
+	}
+	if insertID(&m) != id {
+		t.Error("the insertId of a row must be stable, for BigQuery to de-duplicate it")
+	}
+	for _, other := range []top.PodMetric{
+		func() top.PodMetric { o := m; o.Pod = "other"; return o }(),
+		func() top.PodMetric { o := m; o.Metric = top.MemoryMetric; return o }(),
+		func() top.PodMetric { o := m; o.Component = "a"; return o }(),
+	} {
+		if insertID(&other) == id {
+			t.Errorf("%s and %s share an insertId", &m, &other)
+		}
+	}
+}
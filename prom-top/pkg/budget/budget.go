@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package budget checks collected metrics against per-component resource budgets, allowing footprint contracts to
+// be enforced on every run.  Budgets are read from a YAML file of the form
+//
+//	budgets:
+//	- namespace: openshift-monitoring
+//	  app: prometheus-k8s
+//	  cpu: 500m
+//	  memory: 2Gi
+//	- namespace: openshift-monitoring
+//	  memory: 4Gi
+//
+// where app matches the label-app (owner name) column and may be omitted to cover every pod in the namespace.  cpu
+// and memory are Kubernetes quantities compared against each pod's 95th percentile usage; either may be omitted.
+// When several budgets limit a pod's metric, the one naming its app takes precedence.
+package budget
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Budget is the maximum q95 usage allowed for the pods of a namespace or app.
+type Budget struct {
+	Namespace string             `json:"namespace"`
+	App       string             `json:"app,omitempty"`
+	CPU       *resource.Quantity `json:"cpu,omitempty"`
+	Memory    *resource.Quantity `json:"memory,omitempty"`
+}
+
+// File is the parsed contents of a budget file.
+type File struct {
+	Budgets []Budget `json:"budgets"`
+}
+
+// Load reads and validates the budget file at path.
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading budget file: %v", err)
+	}
+	f := new(File)
+	if err := yaml.UnmarshalStrict(b, f); err != nil {
+		return nil, fmt.Errorf("parsing budget file %s: %v", path, err)
+	}
+	for i, bgt := range f.Budgets {
+		if bgt.Namespace == "" {
+			return nil, fmt.Errorf("budget file %s: budget %d has no namespace", path, i)
+		}
+		if bgt.CPU == nil && bgt.Memory == nil {
+			return nil, fmt.Errorf("budget file %s: budget %d (%s) sets neither cpu nor memory", path, i, bgt.name())
+		}
+	}
+	return f, nil
+}
+
+func (b Budget) name() string {
+	if b.App == "" {
+		return b.Namespace
+	}
+	return b.Namespace + "/" + b.App
+}
+
+// limit returns the budgeted maximum for metric, false if b doesn't budget it.
+func (b Budget) limit(metric string) (float64, bool) {
+	switch {
+	case metric == top.CPUMetric && b.CPU != nil:
+		return float64(b.CPU.MilliValue()) / 1000, true
+	case metric == top.MemoryMetric && b.Memory != nil:
+		return float64(b.Memory.Value()), true
+	}
+	return 0, false
+}
+
+// match returns the budget limiting m's metric, preferring one that names its app.
+func (f *File) match(m *top.PodMetric) (Budget, float64, bool) {
+	var (
+		found Budget
+		limit float64
+		ok    bool
+	)
+	for _, b := range f.Budgets {
+		if b.Namespace != m.Namespace || (b.App != "" && b.App != m.OwnerName) {
+			continue
+		}
+		l, budgeted := b.limit(m.Metric)
+		if !budgeted {
+			continue
+		}
+		if b.App != "" {
+			return b, l, true
+		}
+		if !ok {
+			found, limit, ok = b, l, true
+		}
+	}
+	return found, limit, ok
+}
+
+// Apply sets the Violation of every row of table whose q95 usage exceeds its budget and returns the number of
+// violations.
+func (f *File) Apply(table top.PodMetricTable) int {
+	violations := 0
+	for _, m := range table {
+		b, limit, ok := f.match(m)
+		if !ok || m.Q95Value <= limit {
+			continue
+		}
+		m.Violation = fmt.Sprintf("q95 %g exceeds %s budget %g", m.Q95Value, b.name(), limit)
+		violations++
+	}
+	return violations
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package budget
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// writeFile writes a budget file holding contents to a temporary directory and returns its path.
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "budgets.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApply(t *testing.T) {
+	f, err := Load(writeFile(t, `budgets:
+- namespace: openshift-monitoring
+  app: prometheus-k8s
+  cpu: 500m
+  memory: 2Gi
+- namespace: openshift-monitoring
+  memory: 1Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := top.PodMetricTable{
+		// the app's budget takes precedence over the namespace's
+		{Metric: top.MemoryMetric, Namespace: "openshift-monitoring", OwnerName: "prometheus-k8s", Q95Value: 1.5 * (1 << 30)},
+		{Metric: top.CPUMetric, Namespace: "openshift-monitoring", OwnerName: "prometheus-k8s", Q95Value: .6},
+		{Metric: top.CPUMetric, Namespace: "openshift-monitoring", OwnerName: "prometheus-k8s", Q95Value: .5},
+		{Metric: top.MemoryMetric, Namespace: "openshift-monitoring", OwnerName: "alertmanager", Q95Value: 1.5 * (1 << 30)},
+		// no cpu budget covers alertmanager
+		{Metric: top.CPUMetric, Namespace: "openshift-monitoring", OwnerName: "alertmanager", Q95Value: 10},
+		{Metric: top.MemoryMetric, Namespace: "openshift-etcd", OwnerName: "etcd", Q95Value: 1 << 40},
+	}
+	if n := f.Apply(table); n != 2 {
+		t.Errorf("got %d violations, want 2", n)
+	}
+	want := []string{
+		"",
+		"q95 0.6 exceeds openshift-monitoring/prometheus-k8s budget 0.5",
+		"",
+		"q95 1.610612736e+09 exceeds openshift-monitoring budget 1.073741824e+09",
+		"",
+		"",
+	}
+	for i, m := range table {
+		if m.Violation != want[i] {
+			t.Errorf("row %d: got violation %q, want %q", i, m.Violation, want[i])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]string{
+		"no namespace":  "budgets:\n- app: etcd\n  cpu: 1\n",
+		"no limit":      "budgets:\n- namespace: openshift-etcd\n",
+		"unknown field": "budgets:\n- namespace: openshift-etcd\n  cpus: 1\n",
+		"bad quantity":  "budgets:\n- namespace: openshift-etcd\n  memory: lots\n",
+	}
+	for name, contents := range tests {
+		if _, err := Load(writeFile(t, contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := Load(filepath.Join("testdata", "missing.yaml")); err == nil || !strings.Contains(err.Error(), "reading") {
+		t.Errorf("got error %v, want a read error", err)
+	}
+}
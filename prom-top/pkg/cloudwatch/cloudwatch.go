@@ -0,0 +1,222 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloudwatch publishes PodMetric aggregates as CloudWatch custom metrics through the PutMetricData query
+// API.  Each aggregation of each metric becomes its own CloudWatch metric, e.g. cpu_usage_ratio_q95, with Namespace
+// and Pod dimensions, Component, Version and Cluster when set, and one dimension per joined pod label and per custom
+// label of the run.
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// DefaultNamespace is the CloudWatch namespace metrics are published under when Config.Namespace is empty.
+const DefaultNamespace = "Caliper"
+
+// MaxDatumsPerRequest bounds the number of data points sent in one PutMetricData call.
+const MaxDatumsPerRequest = 20
+
+// Config selects the destination region and namespace.  Credentials are read from the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type Config struct {
+	// Region defaults to $AWS_REGION.
+	Region string
+	// Namespace defaults to DefaultNamespace.
+	Namespace string
+}
+
+func (c Config) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return os.Getenv("AWS_REGION")
+}
+
+// Validate reports missing settings.
+func (c Config) Validate() error {
+	var missing []string
+	if c.region() == "" {
+		missing = append(missing, "region")
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		missing = append(missing, "AWS_ACCESS_KEY_ID")
+	}
+	if os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		missing = append(missing, "AWS_SECRET_ACCESS_KEY")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Client publishes metrics to a single region and namespace.
+type Client struct {
+	cfg      Config
+	endpoint string
+	creds    credentials
+	http     *http.Client
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultNamespace
+	}
+	cfg.Region = cfg.region()
+	return &Client{
+		cfg:      cfg,
+		endpoint: fmt.Sprintf("https://monitoring.%s.amazonaws.com/", cfg.Region),
+		creds: credentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		http: http.DefaultClient,
+	}, nil
+}
+
+type datum struct {
+	name       string
+	dimensions [][2]string
+	value      float64
+	timestamp  time.Time
+	unit       string
+}
+
+// datums expands each PodMetric into one data point per aggregation.  Aggregations that are NaN or infinite are
+// skipped.
+func (c *Client) datums(metrics top.PodMetricTable) []datum {
+	var data []datum
+	for _, m := range metrics {
+		ts, err := time.ParseInLocation(dbhandler.TimestampFormat, m.QueryTime, time.Local)
+		if err != nil {
+			ts = time.Now()
+		}
+		dims := [][2]string{{"Namespace", m.Namespace}, {"Pod", m.Pod}}
+		if m.Component != "" {
+			// the components of a split pod are otherwise the same metric
+			dims = append(dims, [2]string{"Component", m.Component})
+		}
+		if m.Version != "" {
+			dims = append(dims, [2]string{"Version", m.Version})
+		}
+		if m.Cluster != "" {
+			dims = append(dims, [2]string{"Cluster", m.Cluster})
+		}
+		for _, name := range m.PodLabels.Names() {
+			dims = append(dims, [2]string{name, m.PodLabels[name]})
+		}
+		for _, name := range m.Labels.Names() {
+			dims = append(dims, [2]string{name, m.Labels[name]})
+		}
+		unit := "None"
+		if m.Metric == top.MemoryMetric {
+			unit = "Bytes"
+		}
+		for _, v := range []struct {
+			agg   top.Aggregation
+			value float64
+		}{
+			{top.Average, m.AvgValue},
+			{top.Maximum, m.MaxValue},
+			{top.Minimum, m.MinValue},
+			{top.Quantile95, m.Q95Value},
+			{top.Instant, m.InstValue},
+		} {
+			if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+				// not collected, e.g. the quantile of a series without samples, and rejected by PutMetricData
+				continue
+			}
+			data = append(data, datum{
+				name:       fmt.Sprintf("%s_%s", m.Metric, v.agg),
+				dimensions: dims,
+				value:      v.value,
+				timestamp:  ts,
+				unit:       unit,
+			})
+		}
+	}
+	return data
+}
+
+// Write publishes every aggregate of metrics, MaxDatumsPerRequest data points per request.
+func (c *Client) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	data := c.datums(metrics)
+	for start := 0; start < len(data); start += MaxDatumsPerRequest {
+		end := start + MaxDatumsPerRequest
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := c.put(ctx, data[start:end]); err != nil {
+			return fmt.Errorf("data points %d-%d failed, %d previously published: %v", start, end-1, start, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) put(ctx context.Context, data []datum) error {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {c.cfg.Namespace},
+	}
+	for i, d := range data {
+		prefix := fmt.Sprintf("MetricData.member.%d.", i+1)
+		form.Set(prefix+"MetricName", d.name)
+		form.Set(prefix+"Value", strconv.FormatFloat(d.value, 'g', -1, 64))
+		form.Set(prefix+"Timestamp", d.timestamp.UTC().Format(time.RFC3339))
+		form.Set(prefix+"Unit", d.unit)
+		for j, dim := range d.dimensions {
+			dp := fmt.Sprintf("%sDimensions.member.%d.", prefix, j+1)
+			form.Set(dp+"Name", dim[0])
+			form.Set(dp+"Value", dim[1])
+		}
+	}
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	c.creds.sign(req, []byte(body), c.cfg.Region, "monitoring", time.Now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("PutMetricData returned %s: %s", resp.Status, b)
+	}
+	return nil
+}
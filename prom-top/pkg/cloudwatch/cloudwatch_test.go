@@ -0,0 +1,107 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	for k, v := range map[string]string{"AWS_ACCESS_KEY_ID": "AKIDEXAMPLE", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := NewClient(Config{Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.endpoint = srv.URL
+	return c
+}
+
+func TestWriteNaN(t *testing.T) {
+	var got url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		var err error
+		if got, err = url.ParseQuery(string(b)); err != nil {
+			t.Errorf("decoding %s: %v", b, err)
+		}
+	})
+	metrics := top.PodMetricTable{
+		{Metric: top.CPUMetric, Namespace: "ns", Pod: "etcd-0", QueryTime: "2020-10-01 12:00:00", AvgValue: .5,
+			MaxValue: 1, MinValue: 0, Q95Value: math.NaN(), InstValue: math.Inf(1)},
+	}
+	if err := c.Write(context.Background(), metrics); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"cpu_usage_ratio_avg": "0.5", "cpu_usage_ratio_max": "1", "cpu_usage_ratio_min": "0"}
+	for i := 1; ; i++ {
+		name := got.Get(fmt.Sprintf("MetricData.member.%d.MetricName", i))
+		if name == "" {
+			if i-1 != len(want) {
+				t.Errorf("got %d data points, want %d", i-1, len(want))
+			}
+			break
+		}
+		value := got.Get(fmt.Sprintf("MetricData.member.%d.Value", i))
+		if want[name] != value {
+			t.Errorf("got %s=%s, want %q", name, value, want[name])
+		}
+	}
+}
+
+func TestDatumsDimensions(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	metrics := top.PodMetricTable{{Metric: top.MemoryMetric, Namespace: "ns", Pod: "app-1", Component: "istio-proxy",
+		Version: "4.6", Cluster: "east", PodLabels: dbhandler.Labels{"label_app": "web"},
+		Labels: dbhandler.Labels{"scenario": "soak"}}}
+	want := [][2]string{{"Namespace", "ns"}, {"Pod", "app-1"}, {"Component", "istio-proxy"}, {"Version", "4.6"},
+		{"Cluster", "east"}, {"label_app", "web"}, {"scenario", "soak"}}
+	data := c.datums(metrics)
+	if len(data) != 5 {
+		t.Fatalf("got %d data points, want 5", len(data))
+	}
+	for _, d := range data {
+		if !reflect.DeepEqual(d.dimensions, want) || d.unit != "Bytes" {
+			t.Errorf("%s: got dimensions %v in %s, want %v in Bytes", d.name, d.dimensions, d.unit, want)
+		}
+	}
+}
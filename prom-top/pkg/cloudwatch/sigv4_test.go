@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudwatch
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSign checks sign against the post-x-www-form-urlencoded vectors of the AWS Signature Version 4 test suite.
+func TestSign(t *testing.T) {
+	creds := credentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name, contentType, signature string
+	}{
+		{"post-x-www-form-urlencoded", "application/x-www-form-urlencoded",
+			"ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+		{"post-x-www-form-urlencoded-parameters", "application/x-www-form-urlencoded; charset=utf8",
+			"1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := "Param1=value1"
+			req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", test.contentType)
+			creds.sign(req, []byte(body), "us-east-1", "service", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, Signature=" + test.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("got Authorization\n%s\nwant\n%s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("got X-Amz-Date %q", got)
+			}
+		})
+	}
+}
+
+func TestSignSessionToken(t *testing.T) {
+	creds := credentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		sessionToken: "token"}
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.sign(req, nil, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("got X-Amz-Security-Token %q, want token", got)
+	}
+	got := req.Header.Get("Authorization")
+	if !strings.Contains(got, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("the session token is not signed: %s", got)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compare computes the change in resource usage between a baseline build and a fresh collection.
+//
+// Pod names change from run to run, so usage is compared per owning controller (label-app), falling back to the
+// workload for unowned pods, summed over its pods.  A baseline may hold several runs of the same build, in which case
+// its value is the mean of the per-run totals.
+package compare
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Key identifies a compared workload.
+type Key struct {
+	Metric    string
+	Namespace string
+	// Owner is qualified by the component of split pods, e.g. router-default/main, so that each is compared.
+	Owner string
+}
+
+// Delta is the change in usage of one workload.
+type Delta struct {
+	Key
+	// BaselineRuns is the number of baseline runs the workload appeared in.
+	BaselineRuns int
+	Baseline     float64
+	Current      float64
+	Delta        float64
+	// DeltaPct is the change relative to Baseline, NaN for workloads absent from either side.
+	DeltaPct float64
+}
+
+func keyOf(m *top.PodMetric) Key {
+	owner := m.OwnerName
+	if owner == "" {
+		owner = m.Workload
+	}
+	if owner == "" {
+		owner = top.Workload(m.Pod)
+	}
+	if m.Component != "" {
+		owner += "/" + m.Component
+	}
+	return Key{Metric: m.Metric, Namespace: m.Namespace, Owner: owner}
+}
+
+// runOf identifies the run a row belongs to.  Rows written before run ids were recorded share a query time.
+func runOf(m *top.PodMetric) string {
+	if m.RunID != "" {
+		return m.RunID
+	}
+	return m.QueryTime
+}
+
+// Compare returns the change in agg between baseline and current per workload, largest relative change first.
+func Compare(baseline, current top.PodMetricTable, agg top.Aggregation) []Delta {
+	// per-run totals of each workload
+	runTotals := make(map[Key]map[string]float64)
+	for _, m := range baseline {
+		k := keyOf(m)
+		if runTotals[k] == nil {
+			runTotals[k] = make(map[string]float64)
+		}
+		runTotals[k][runOf(m)] += m.Value(agg)
+	}
+	currentTotals := make(map[Key]float64)
+	for _, m := range current {
+		currentTotals[keyOf(m)] += m.Value(agg)
+	}
+
+	deltas := make(map[Key]*Delta)
+	for k, runs := range runTotals {
+		var sum float64
+		for _, v := range runs {
+			sum += v
+		}
+		deltas[k] = &Delta{Key: k, BaselineRuns: len(runs), Baseline: sum / float64(len(runs))}
+	}
+	for k, v := range currentTotals {
+		if deltas[k] == nil {
+			deltas[k] = &Delta{Key: k}
+		}
+		deltas[k].Current = v
+	}
+
+	result := make([]Delta, 0, len(deltas))
+	for k, d := range deltas {
+		d.Delta = d.Current - d.Baseline
+		d.DeltaPct = math.NaN()
+		_, inCurrent := currentTotals[k]
+		if d.BaselineRuns > 0 && inCurrent && d.Baseline != 0 {
+			d.DeltaPct = d.Delta / d.Baseline * 100
+		}
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := math.Abs(result[i].DeltaPct), math.Abs(result[j].DeltaPct)
+		// NaN sorts last
+		if math.IsNaN(a) != math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		if a != b {
+			return a > b
+		}
+		if result[i].Metric != result[j].Metric {
+			return result[i].Metric < result[j].Metric
+		}
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Owner < result[j].Owner
+	})
+	return result
+}
+
+// WriteText writes deltas to w as an aligned table.
+func WriteText(w io.Writer, deltas []Delta) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\tnamespace\tlabel-app\tbaseline runs\tbaseline\tcurrent\tdelta\tdelta%\t")
+	for _, d := range deltas {
+		pct := fmt.Sprintf("%+.1f%%", d.DeltaPct)
+		switch {
+		case d.BaselineRuns == 0:
+			pct = "new"
+		case math.IsNaN(d.DeltaPct) && d.Current == 0 && d.Baseline != 0:
+			pct = "gone"
+		case math.IsNaN(d.DeltaPct):
+			pct = "n/a"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n",
+			d.Metric, d.Namespace, d.Owner, d.BaselineRuns,
+			top.FormatValue(d.Baseline), top.FormatValue(d.Current), top.FormatDelta(d.Delta), pct)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compare
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func row(run, namespace, pod, owner, component string, max float64) *top.PodMetric {
+	return &top.PodMetric{Metric: "cpu", RunID: run, Namespace: namespace, Pod: pod, OwnerName: owner,
+		Component: component, MaxValue: max}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := top.PodMetricTable{
+		// two runs of etcd, its pods renamed between them, totalling 3 and 5
+		row("run-1", "openshift-etcd", "etcd-0", "etcd", "", 1),
+		row("run-1", "openshift-etcd", "etcd-1", "etcd", "", 2),
+		row("run-2", "openshift-etcd", "etcd-2", "etcd", "", 5),
+		// unowned, compared by workload
+		row("run-1", "app", "web-7d9f8b6c5-x7k2p", "", "", 2),
+		row("run-1", "app", "gone-0", "gone", "", 1),
+		row("run-1", "ingress", "router-0", "router", "main", 1),
+		row("run-1", "ingress", "router-0", "router", "sidecar", 1),
+	}
+	current := top.PodMetricTable{
+		row("", "openshift-etcd", "etcd-3", "etcd", "", 6),
+		row("", "app", "web-7d9f8b6c5-f4n2c", "", "", 1),
+		row("", "app", "new-0", "new", "", 1),
+		row("", "ingress", "router-1", "router", "main", 1),
+		row("", "ingress", "router-1", "router", "sidecar", 3),
+	}
+	want := []Delta{
+		{Key{"cpu", "ingress", "router/sidecar"}, 1, 1, 3, 2, 200},
+		// equal relative changes are ordered by namespace
+		{Key{"cpu", "app", "web"}, 1, 2, 1, -1, -50},
+		{Key{"cpu", "openshift-etcd", "etcd"}, 2, 4, 6, 2, 50},
+		{Key{"cpu", "ingress", "router/main"}, 1, 1, 1, 0, 0},
+		{Key{"cpu", "app", "gone"}, 1, 1, 0, -1, math.NaN()},
+		{Key{"cpu", "app", "new"}, 0, 0, 1, 1, math.NaN()},
+	}
+	got := Compare(baseline, current, top.Maximum)
+	if len(got) != len(want) {
+		t.Fatalf("got %d deltas, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		samePct := g.DeltaPct == w.DeltaPct || math.IsNaN(g.DeltaPct) && math.IsNaN(w.DeltaPct)
+		if g.Key != w.Key || g.BaselineRuns != w.BaselineRuns || g.Baseline != w.Baseline || g.Current != w.Current ||
+			g.Delta != w.Delta || !samePct {
+			t.Errorf("delta %d: got %+v, want %+v", i, g, w)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteText(buf, got); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, pct := range []string{"delta%", "+200.0%", "-50.0%", "+50.0%", "+0.0%", "gone", "new"} {
+		if fields := strings.Fields(lines[i]); fields[len(fields)-1] != pct {
+			t.Errorf("line %d: got %q, want it to end in %s", i, lines[i], pct)
+		}
+	}
+}
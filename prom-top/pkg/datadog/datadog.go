@@ -0,0 +1,204 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package datadog posts PodMetric aggregates to the Datadog metrics API.  Each aggregation of each metric is sent as
+// a gauge series named <prefix>.<metric>.<aggregation>, e.g. caliper.cpu_usage_ratio.q95, tagged with the pod,
+// namespace, owner, node, version, cluster, component of split pods, joined pod labels, and custom labels of the run.
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const (
+	// DefaultSite is the Datadog site used when neither Config.Site nor $DD_SITE is set.
+	DefaultSite = "datadoghq.com"
+	// DefaultPrefix namespaces the series names when Config.Prefix is empty.
+	DefaultPrefix = "caliper"
+	// MaxSeriesPerRequest bounds the size of a single submission, well under the API's payload limit.
+	MaxSeriesPerRequest = 500
+)
+
+// Config selects the Datadog site and account.
+type Config struct {
+	// APIKey defaults to $DD_API_KEY.
+	APIKey string
+	// Site defaults to $DD_SITE, then DefaultSite.
+	Site string
+	// Prefix defaults to DefaultPrefix.
+	Prefix string
+	// Tags are added to every series, e.g. "env:ci".
+	Tags []string
+}
+
+func (c Config) apiKey() string {
+	if c.APIKey != "" {
+		return c.APIKey
+	}
+	return os.Getenv("DD_API_KEY")
+}
+
+// Validate reports missing settings.
+func (c Config) Validate() error {
+	if c.apiKey() == "" {
+		return fmt.Errorf("missing API key, set DD_API_KEY")
+	}
+	return nil
+}
+
+// Client submits series to a single Datadog account.
+type Client struct {
+	cfg      Config
+	endpoint string
+	http     *http.Client
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.APIKey = cfg.apiKey()
+	site := cfg.Site
+	if site == "" {
+		site = os.Getenv("DD_SITE")
+	}
+	if site == "" {
+		site = DefaultSite
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	return &Client{
+		cfg:      cfg,
+		endpoint: fmt.Sprintf("https://api.%s/api/v1/series", site),
+		http:     http.DefaultClient,
+	}, nil
+}
+
+type series struct {
+	Metric string       `json:"metric"`
+	Type   string       `json:"type"`
+	Points [][2]float64 `json:"points"`
+	Tags   []string     `json:"tags"`
+}
+
+// series expands each PodMetric into one series per aggregation.  Aggregations that are NaN or infinite are skipped.
+func (c *Client) series(metrics top.PodMetricTable) []series {
+	var all []series
+	for _, m := range metrics {
+		ts, err := time.ParseInLocation(dbhandler.TimestampFormat, m.QueryTime, time.Local)
+		if err != nil {
+			ts = time.Now()
+		}
+		tags := append([]string{
+			"pod:" + m.Pod,
+			"namespace:" + m.Namespace,
+			"owner_name:" + m.OwnerName,
+			"node:" + m.Node,
+			"range:" + m.Range,
+		}, c.cfg.Tags...)
+		if m.Version != "" {
+			tags = append(tags, "version:"+m.Version)
+		}
+		if m.Cluster != "" {
+			tags = append(tags, "cluster:"+m.Cluster)
+		}
+		if m.Component != "" {
+			// the components of a split pod are otherwise the same series
+			tags = append(tags, "component:"+m.Component)
+		}
+		for _, name := range m.PodLabels.Names() {
+			tags = append(tags, name+":"+m.PodLabels[name])
+		}
+		for _, name := range m.Labels.Names() {
+			tags = append(tags, name+":"+m.Labels[name])
+		}
+		for _, v := range []struct {
+			agg   top.Aggregation
+			value float64
+		}{
+			{top.Average, m.AvgValue},
+			{top.Maximum, m.MaxValue},
+			{top.Minimum, m.MinValue},
+			{top.Quantile95, m.Q95Value},
+			{top.Instant, m.InstValue},
+		} {
+			if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+				// not collected, e.g. the quantile of a series without samples, and not representable in JSON
+				continue
+			}
+			all = append(all, series{
+				Metric: fmt.Sprintf("%s.%s.%s", c.cfg.Prefix, m.Metric, v.agg),
+				Type:   "gauge",
+				Points: [][2]float64{{float64(ts.Unix()), v.value}},
+				Tags:   tags,
+			})
+		}
+	}
+	return all
+}
+
+// Write submits every aggregate of metrics, MaxSeriesPerRequest series per request.
+func (c *Client) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	all := c.series(metrics)
+	for start := 0; start < len(all); start += MaxSeriesPerRequest {
+		end := start + MaxSeriesPerRequest
+		if end > len(all) {
+			end = len(all)
+		}
+		if err := c.submit(ctx, all[start:end]); err != nil {
+			return fmt.Errorf("series %d-%d failed, %d previously submitted: %v", start, end-1, start, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) submit(ctx context.Context, s []series) error {
+	b, err := json.Marshal(struct {
+		Series []series `json:"series"`
+	}{s})
+	if err != nil {
+		return fmt.Errorf("encoding series: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", c.cfg.APIKey)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("series API returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
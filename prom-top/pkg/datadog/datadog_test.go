@@ -0,0 +1,110 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestWriteNaN(t *testing.T) {
+	var got struct {
+		Series []series `json:"series"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("decoding %s: %v", b, err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.endpoint = srv.URL
+
+	metrics := top.PodMetricTable{
+		{Metric: top.CPUMetric, Pod: "etcd-0", QueryTime: "2020-10-01 12:00:00", AvgValue: .5, MaxValue: 1, MinValue: 0,
+			Q95Value: math.NaN(), InstValue: math.Inf(1)},
+	}
+	if err := c.Write(context.Background(), metrics); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range got.Series {
+		names = append(names, s.Metric)
+	}
+	want := []string{"caliper.cpu_usage_ratio.avg", "caliper.cpu_usage_ratio.max", "caliper.cpu_usage_ratio.min"}
+	if len(names) != len(want) {
+		t.Fatalf("got series %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("got series %v, want %v", names, want)
+		}
+	}
+}
+
+func TestSeriesComponent(t *testing.T) {
+	c, err := NewClient(Config{APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := top.PodMetricTable{
+		{Metric: top.CPUMetric, Namespace: "ns", Pod: "app-1", QueryTime: "2020-10-01 12:00:00", Component: "main"},
+		{Metric: top.CPUMetric, Namespace: "ns", Pod: "app-1", QueryTime: "2020-10-01 12:00:00", Component: "istio-proxy"},
+	}
+	seen := make(map[string]bool)
+	for _, s := range c.series(metrics) {
+		b, _ := json.Marshal(s)
+		if seen[string(b)] {
+			t.Errorf("duplicate series %s", b)
+		}
+		seen[string(b)] = true
+	}
+	if len(seen) != 10 {
+		t.Errorf("got %d distinct series, want 10", len(seen))
+	}
+}
+
+func TestSeriesPodLabels(t *testing.T) {
+	c, err := NewClient(Config{APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := top.PodMetricTable{{Metric: top.CPUMetric, Namespace: "ns", Pod: "app-1",
+		PodLabels: dbhandler.Labels{"label_app": "web"}, Labels: dbhandler.Labels{"scenario": "soak"}}}
+	for _, s := range c.series(metrics) {
+		tags := make(map[string]bool)
+		for _, tag := range s.Tags {
+			tags[tag] = true
+		}
+		if !tags["label_app:web"] || !tags["scenario:soak"] {
+			t.Errorf("%s: got tags %v, want label_app:web and scenario:soak", s.Metric, s.Tags)
+		}
+	}
+}
@@ -16,14 +16,21 @@ limitations under the License.
 package dbhandler
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
+	"github.com/Masterminds/squirrel"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/spf13/viper"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/secret"
 )
 
 type Row struct {
@@ -40,6 +47,39 @@ type Row struct {
 	MaxValue  float64 `db:"max_value"`
 	MinValue  float64 `db:"min_value"`
 	InstValue float64 `db:"inst_value"`
+	// Partial is set when the row comes from a collection that was interrupted before all queries completed.
+	Partial bool `db:"partial"`
+	// Cluster identifies the cluster the row was collected from, so rows of several clusters can share a table.
+	Cluster string `db:"cluster"`
+	// RunID references the RunsTable row of the invocation that produced the row.
+	RunID string `db:"run_id"`
+	// Violation describes the resource budget the row exceeds, empty if none.
+	Violation string `db:"violation"`
+	// Request is the pod's resource request for the metric, 0 if it has none.
+	Request float64 `db:"request"`
+	// Efficiency is AvgValue / Request, 0 if the pod has no request.
+	Efficiency float64 `db:"efficiency"`
+	// Burstiness is MaxValue / AvgValue and Q95Burstiness Q95Value / AvgValue, both 0 if AvgValue is.  Spiky
+	// workloads score high, steadily heavy ones close to 1.
+	Burstiness    float64 `db:"burstiness"`
+	Q95Burstiness float64 `db:"q95_burstiness"`
+	// Iteration numbers the collections of an --interval loop from 1, 0 outside of one.
+	Iteration int `db:"iteration"`
+	// Labels are the custom labels of the run, e.g. scenario=density-1000.
+	Labels Labels `db:"labels"`
+	// Workload is the pod name without the suffixes generated for each replica, which identifies the same logical
+	// workload across restarts, runs, and builds.
+	Workload string `db:"workload"`
+	// HPAMinReplicas and HPAMaxReplicas are the fewest and most replicas the HorizontalPodAutoscaler of the workload
+	// ran over the range, 0 for workloads without one.
+	HPAMinReplicas int `db:"hpa_min_replicas"`
+	HPAMaxReplicas int `db:"hpa_max_replicas"`
+	// Component is the part of the pod the row measures when its containers are split: "main", "init" for its init
+	// containers, or the name of a sidecar container.  Empty for rows of the whole pod.
+	Component string `db:"component"`
+	// PodLabels are the pod labels joined from kube_pod_labels with --pod-label, keyed by their kube-state-metrics
+	// name, e.g. label_app_kubernetes_io_name.
+	PodLabels Labels `db:"pod_labels"`
 }
 
 func (r *Row) String() string {
@@ -64,6 +104,7 @@ func (r *Row) String() string {
 func ColumnsHeaders() []string {
 	return []string{
 		"version",
+		"cluster",
 		"metric",
 		"node",
 		"pod",
@@ -76,32 +117,226 @@ func ColumnsHeaders() []string {
 		"inst_value",
 		"query_time",
 		"range",
+		"partial",
+		"run_id",
+		"violation",
+		"request",
+		"efficiency",
+		"burstiness",
+		"q95_burstiness",
+		"iteration",
+		"labels",
+		"workload",
+		"hpa_min_replicas",
+		"hpa_max_replicas",
+		"component",
+		"pod_labels",
+	}
+}
+
+// LongRow is a single aggregation of a Row, the unit of the long schema.
+type LongRow struct {
+	Version     string  `db:"version"`
+	Cluster     string  `db:"cluster"`
+	Metric      string  `db:"metric"`
+	Pod         string  `db:"pod"`
+	Range       string  `db:"range"`
+	Namespace   string  `db:"namespace"`
+	OwnerName   string  `db:"owner_name"`
+	Node        string  `db:"node"`
+	QueryTime   string  `db:"query_time"`
+	Aggregation string  `db:"aggregation"`
+	Value       float64 `db:"value"`
+	Partial     bool    `db:"partial"`
+	RunID       string  `db:"run_id"`
+	Violation   string  `db:"violation"`
+	Iteration   int     `db:"iteration"`
+	Labels      Labels  `db:"labels"`
+	Workload    string  `db:"workload"`
+	// HPAMinReplicas and HPAMaxReplicas are those of the Row.
+	HPAMinReplicas int    `db:"hpa_min_replicas"`
+	HPAMaxReplicas int    `db:"hpa_max_replicas"`
+	Component      string `db:"component"`
+	PodLabels      Labels `db:"pod_labels"`
+}
+
+// LongColumnsHeaders defines the columns of LongTable.
+func LongColumnsHeaders() []string {
+	return []string{
+		"version",
+		"cluster",
+		"metric",
+		"node",
+		"pod",
+		"namespace",
+		"owner_name",
+		"aggregation",
+		"value",
+		"query_time",
+		"range",
+		"partial",
+		"run_id",
+		"violation",
+		"iteration",
+		"labels",
+		"workload",
+		"hpa_min_replicas",
+		"hpa_max_replicas",
+		"component",
+		"pod_labels",
+	}
+}
+
+// Run describes a single invocation of prom-top, giving the context needed to normalize comparisons between runs.
+type Run struct {
+	RunID       string  `db:"run_id"`
+	Version     string  `db:"version"`
+	Cluster     string  `db:"cluster"`
+	ToolVersion string  `db:"tool_version"`
+	Range       string  `db:"range"`
+	StartTime   string  `db:"start_time"`
+	Duration    float64 `db:"query_duration_seconds"`
+	NodeCount   int     `db:"node_count"`
+	PodCount    int     `db:"pod_count"`
+	Partial     bool    `db:"partial"`
+	// Platform is the infrastructure provider, e.g. AWS or BareMetal, empty if unknown.
+	Platform string `db:"platform"`
+	// ClusterVersion is the version the cluster reports running, which Version, the build under test, may label
+	// differently.
+	ClusterVersion string `db:"cluster_version"`
+	// NodeRoles counts the nodes of each role, e.g. master and worker, and InstanceTypes those of each instance
+	// type.  A node with several roles counts toward each.
+	NodeRoles     map[string]int `db:"node_roles"`
+	InstanceTypes map[string]int `db:"instance_types"`
+}
+
+// RunsColumnsHeaders defines the columns of RunsTable.
+func RunsColumnsHeaders() []string {
+	return []string{
+		"run_id",
+		"version",
+		"cluster",
+		"tool_version",
+		"range",
+		"start_time",
+		"query_duration_seconds",
+		"node_count",
+		"pod_count",
+		"partial",
+		"platform",
+		"cluster_version",
+		"node_roles",
+		"instance_types",
 	}
 }
 
 const TimestampFormat = `2006-01-02 15:04:05`
 
-// Table is a hardcoded table name.  Will be replaced with dynamically set names.
+// Table is the name of the table of wide rows.  Like every table name, it is prefixed by CALIPER_TABLE_PREFIX, see
+// TableName.
 const Table = "caliper_metrics"
 
+// LongTable holds rows of the long schema, one per aggregation.
+const LongTable = "caliper_metrics_long"
+
+// RunsTable holds one row per invocation, referenced by the run_id column of the metrics tables.
+const RunsTable = "caliper_runs"
+
 const (
 	host     = "PGHOST"
 	port     = "PGPORT"
 	database = "PGDATABASE"
 	user     = "PGUSER"
 	password = "PGPASSWORD"
+	// passwordFile (optional) is a file holding the password, e.g. a mounted secret.  It takes precedence over
+	// password.
+	passwordFile = "PGPASSWORD_FILE"
+	// passwordVault (optional) is a Vault reference to the password, path#field.  See secret.VaultFromEnv.
+	passwordVault = "PGPASSWORD_VAULT"
+	// schema (optional) is the postgres schema the tables are created and looked up in, instead of the user's
+	// default search_path.
+	schema = "PGSCHEMA"
+	// tablePrefix (optional) is prepended to every table name, so that several teams can share a schema.
+	tablePrefix = "CALIPER_TABLE_PREFIX"
+	// partitionBy (optional) is the period by which db migrate partitions the metrics tables on query_time.  Only
+	// month is supported.
+	partitionBy = "CALIPER_PARTITION_BY"
+	// TLS settings, named as by libpq.  See TLSConfig.
+	sslMode     = "PGSSLMODE"
+	sslRootCert = "PGSSLROOTCERT"
+	sslCert     = "PGSSLCERT"
+	sslKey      = "PGSSLKEY"
 )
 
+// TLSConfig selects how connections are secured, with the semantics of libpq's sslmode, sslrootcert, sslcert, and
+// sslkey parameters.  Mode is one of disable, allow, prefer (the default), require, verify-ca, or verify-full.
+// Managed postgres services commonly mandate verify-full with their CA in RootCert.  Cert and Key authenticate the
+// client with a certificate.
+type TLSConfig struct {
+	Mode     string
+	RootCert string
+	Cert     string
+	Key      string
+}
+
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// tlsOverride holds the settings of SetTLS.
+var tlsOverride TLSConfig
+
+// SetTLS overrides the TLS settings read from the environment and .env file, PGSSLMODE, PGSSLROOTCERT, PGSSLCERT,
+// and PGSSLKEY, with the non-empty fields of c.
+func SetTLS(c TLSConfig) {
+	tlsOverride = c
+}
+
+// identifierRE matches the schema and table prefixes accepted, which need no quoting in SQL.
+var identifierRE = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
 type PostgresConfig struct {
-	host     string
-	port     int
-	database string
-	user     string
-	password string
+	host        string
+	port        int
+	database    string
+	user        string
+	password    string
+	schema      string
+	tablePrefix string
+	tls         TLSConfig
 }
 
 func (p PostgresConfig) String() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s", p.user, p.password, p.host, p.port, p.database)
+	dsn := url.URL{
+		Scheme: "postgres",
+		// escaped, secrets read from files and vault commonly hold reserved characters
+		User: url.UserPassword(p.user, p.password),
+		Host: fmt.Sprintf("%s:%d", p.host, p.port),
+		Path: "/" + p.database,
+	}
+	params := url.Values{}
+	if p.schema != "" {
+		// unknown parameters are sent to the server as run-time parameters
+		params.Set("search_path", p.schema)
+	}
+	for name, v := range map[string]string{
+		"sslmode":     p.tls.Mode,
+		"sslrootcert": p.tls.RootCert,
+		"sslcert":     p.tls.Cert,
+		"sslkey":      p.tls.Key,
+	} {
+		if v != "" {
+			params.Set(name, v)
+		}
+	}
+	dsn.RawQuery = params.Encode()
+	return dsn.String()
+}
+
+// TableName returns the name of the table named base, e.g. Table, prefixed by CALIPER_TABLE_PREFIX.  It is only
+// valid once the configuration has been read by ValidateConfig or NewPostgresClient.
+func TableName(base string) string {
+	return viper.GetString(tablePrefix) + base
 }
 
 func initConfig() PostgresConfig {
@@ -113,7 +348,16 @@ func initConfig() PostgresConfig {
 		port,
 		database,
 		user,
-		password)
+		password,
+		passwordFile,
+		passwordVault,
+		schema,
+		tablePrefix,
+		partitionBy,
+		sslMode,
+		sslRootCert,
+		sslCert,
+		sslKey)
 	if err != nil {
 		log.Fatalf("failed to bind env vars: %v", err)
 	}
@@ -124,16 +368,392 @@ func initConfig() PostgresConfig {
 		log.Printf("no postgress config found: %v", err)
 	}
 
-	return PostgresConfig{
+	cfg := PostgresConfig{
 		host:     viper.GetString(host),
 		port:     viper.GetInt(port),
 		database: viper.GetString(database),
 		user:     viper.GetString(user),
 		password: viper.GetString(password),
+
+		schema:      viper.GetString(schema),
+		tablePrefix: viper.GetString(tablePrefix),
+		tls: TLSConfig{
+			Mode:     viper.GetString(sslMode),
+			RootCert: viper.GetString(sslRootCert),
+			Cert:     viper.GetString(sslCert),
+			Key:      viper.GetString(sslKey),
+		},
+	}
+	if tlsOverride.Mode != "" {
+		cfg.tls.Mode = tlsOverride.Mode
+	}
+	if tlsOverride.RootCert != "" {
+		cfg.tls.RootCert = tlsOverride.RootCert
+	}
+	if tlsOverride.Cert != "" {
+		cfg.tls.Cert = tlsOverride.Cert
+	}
+	if tlsOverride.Key != "" {
+		cfg.tls.Key = tlsOverride.Key
 	}
+	return cfg
+}
+
+// ValidateConfig reports the postgres connection settings that are missing from the environment and .env file.
+func ValidateConfig() error {
+	cfg := initConfig()
+	var missing []string
+	if cfg.host == "" {
+		missing = append(missing, host)
+	}
+	if cfg.port == 0 {
+		missing = append(missing, port)
+	}
+	if cfg.database == "" {
+		missing = append(missing, database)
+	}
+	if cfg.user == "" {
+		missing = append(missing, user)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	if cfg.schema != "" && !identifierRE.MatchString(cfg.schema) {
+		return fmt.Errorf("invalid %s %q: use lower case letters, digits, and underscores", schema, cfg.schema)
+	}
+	if cfg.tablePrefix != "" && !identifierRE.MatchString(cfg.tablePrefix) {
+		return fmt.Errorf("invalid %s %q: use lower case letters, digits, and underscores", tablePrefix, cfg.tablePrefix)
+	}
+	if p := viper.GetString(partitionBy); p != "" && p != partitionMonth {
+		return fmt.Errorf("invalid %s %q: use month", partitionBy, p)
+	}
+	if cfg.tls.Mode != "" && !sslModes[cfg.tls.Mode] {
+		return fmt.Errorf("invalid sslmode %q: use one of disable, allow, prefer, require, verify-ca, verify-full", cfg.tls.Mode)
+	}
+	if (cfg.tls.Cert == "") != (cfg.tls.Key == "") {
+		return fmt.Errorf("a client certificate requires both sslcert and sslkey")
+	}
+	for _, f := range []string{cfg.tls.RootCert, cfg.tls.Cert, cfg.tls.Key} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("reading TLS settings: %v", err)
+		}
+	}
+	return nil
 }
 
 func NewPostgresClient() (*sqlx.DB, error) {
+	dsn, err := connString()
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.Connect("pgx", dsn)
+}
+
+// connString returns the connection URL of the configured database, with the password resolved.
+func connString() (string, error) {
 	cfg := initConfig()
-	return sqlx.Connect("pgx", cfg.String())
+	pw, err := secret.Resolve(cfg.password, viper.GetString(passwordFile), viper.GetString(passwordVault))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", password, err)
+	}
+	cfg.password = pw
+	return cfg.String(), nil
+}
+
+// DefaultBatchSize is the number of rows sent per INSERT statement by InsertRows when no batch size is given.
+// Postgres caps a statement at 65535 bind parameters, which bounds the usable size at ~5000 rows.
+const DefaultBatchSize = 500
+
+// values returns the values of r in ColumnsHeaders order.
+func (r Row) values() []interface{} {
+	return []interface{}{
+		r.Version,
+		r.Cluster,
+		r.Metric,
+		r.Node,
+		r.Pod,
+		r.Namespace,
+		r.OwnerName,
+		r.AvgValue,
+		r.Q95Value,
+		r.MaxValue,
+		r.MinValue,
+		r.InstValue,
+		r.QueryTime,
+		r.Range,
+		r.Partial,
+		r.RunID,
+		r.Violation,
+		r.Request,
+		r.Efficiency,
+		r.Burstiness,
+		r.Q95Burstiness,
+		r.Iteration,
+		r.Labels.JSON(),
+		r.Workload,
+		r.HPAMinReplicas,
+		r.HPAMaxReplicas,
+		r.Component,
+		r.PodLabels.JSON(),
+	}
+}
+
+// values returns the values of r in LongColumnsHeaders order.
+func (r LongRow) values() []interface{} {
+	return []interface{}{
+		r.Version,
+		r.Cluster,
+		r.Metric,
+		r.Node,
+		r.Pod,
+		r.Namespace,
+		r.OwnerName,
+		r.Aggregation,
+		r.Value,
+		r.QueryTime,
+		r.Range,
+		r.Partial,
+		r.RunID,
+		r.Violation,
+		r.Iteration,
+		r.Labels.JSON(),
+		r.Workload,
+		r.HPAMinReplicas,
+		r.HPAMaxReplicas,
+		r.Component,
+		r.PodLabels.JSON(),
+	}
+}
+
+// InsertRows writes rows to Table in INSERT statements of at most batchSize rows each.  All batches are executed in
+// a single transaction: on error the transaction is rolled back, nothing is written, and the returned error
+// identifies the failed batch.
+func InsertRows(db *sqlx.DB, rows []Row, batchSize int) (int64, error) {
+	return insertRows(db, TableName(Table), ColumnsHeaders(), len(rows), func(i int) []interface{} {
+		return rows[i].values()
+	}, batchSize)
+}
+
+// InsertLongRows writes rows to LongTable, batched and transactional as InsertRows.
+func InsertLongRows(db *sqlx.DB, rows []LongRow, batchSize int) (int64, error) {
+	return insertRows(db, TableName(LongTable), LongColumnsHeaders(), len(rows), func(i int) []interface{} {
+		return rows[i].values()
+	}, batchSize)
+}
+
+// InsertRun writes run to RunsTable.
+func InsertRun(db *sqlx.DB, run Run) error {
+	return insertRun(db, run)
+}
+
+// InsertRunRows writes run to RunsTable and its rows to Table, batched as InsertRows, in a single transaction: on
+// error neither the run nor any of its rows is written.
+func InsertRunRows(db *sqlx.DB, run Run, rows []Row, batchSize int) (int64, error) {
+	table, columns := TableName(Table), ColumnsHeaders()
+	values := func(i int) []interface{} { return rows[i].values() }
+	if err := ensurePartitions(db, table, columns, len(rows), values); err != nil {
+		return 0, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %v", err)
+	}
+	if err = insertRun(tx, run); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+	inserted, err := insertBatches(tx, table, columns, len(rows), values, batchSize)
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing run %s and %d rows: %v", run.RunID, inserted, err)
+	}
+	return inserted, nil
+}
+
+func insertRun(runner squirrel.BaseRunner, run Run) error {
+	nodeRoles, err := jsonColumn(run.NodeRoles)
+	if err != nil {
+		return fmt.Errorf("encoding node roles: %v", err)
+	}
+	instanceTypes, err := jsonColumn(run.InstanceTypes)
+	if err != nil {
+		return fmt.Errorf("encoding instance types: %v", err)
+	}
+	_, err = squirrel.
+		Insert(TableName(RunsTable)).
+		Columns(RunsColumnsHeaders()...).
+		Values(
+			run.RunID,
+			run.Version,
+			run.Cluster,
+			run.ToolVersion,
+			run.Range,
+			run.StartTime,
+			run.Duration,
+			run.NodeCount,
+			run.PodCount,
+			run.Partial,
+			run.Platform,
+			run.ClusterVersion,
+			nodeRoles,
+			instanceTypes,
+		).
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(runner).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("inserting run %s: %v", run.RunID, err)
+	}
+	return nil
+}
+
+// jsonColumn returns the value of a jsonb column holding counts, NULL if unknown.
+func jsonColumn(counts map[string]int) (interface{}, error) {
+	if counts == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(counts)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// RunExists reports whether RunsTable holds the run identified by runID.
+func RunExists(db *sqlx.DB, runID string) (bool, error) {
+	var exists bool
+	err := db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM `+TableName(RunsTable)+` WHERE run_id = $1)`, runID)
+	if err != nil {
+		return false, fmt.Errorf("looking up run %s: %v", runID, err)
+	}
+	return exists, nil
+}
+
+// SelectRows returns every row of Table written for the given cluster version.
+func SelectRows(db *sqlx.DB, version string) ([]Row, error) {
+	var rows []Row
+	err := db.Select(&rows, `SELECT version, cluster, metric, node, pod, namespace, COALESCE(owner_name, '') AS owner_name,
+    COALESCE(avg_value, 'NaN') AS avg_value, COALESCE(q95_value, 'NaN') AS q95_value,
+    COALESCE(max_value, 'NaN') AS max_value, COALESCE(min_value, 'NaN') AS min_value,
+    COALESCE(inst_value, 'NaN') AS inst_value, to_char(query_time, 'YYYY-MM-DD HH24:MI:SS') AS query_time,
+    range, partial, COALESCE(run_id, '') AS run_id, violation,
+    COALESCE(request, 0) AS request, COALESCE(efficiency, 0) AS efficiency,
+    COALESCE(burstiness, 0) AS burstiness, COALESCE(q95_burstiness, 0) AS q95_burstiness, iteration, labels, workload,
+    hpa_min_replicas, hpa_max_replicas, component, pod_labels
+FROM `+TableName(Table)+` WHERE version = $1`, version)
+	if err != nil {
+		return nil, fmt.Errorf("selecting %s rows: %v", version, err)
+	}
+	return rows, nil
+}
+
+// BuildSummary describes the rows of Table stored for one cluster version.
+type BuildSummary struct {
+	Version string `db:"version"`
+	// Runs counts distinct run ids.  Rows written before run ids were recorded count one run per query time.
+	Runs      int    `db:"runs"`
+	Rows      int    `db:"rows"`
+	FirstTime string `db:"first_time"`
+	LastTime  string `db:"last_time"`
+	// Metrics lists the distinct metrics, comma separated and sorted.
+	Metrics string `db:"metrics"`
+}
+
+// Summarize describes the contents of Table per cluster version, oldest first.
+func Summarize(db *sqlx.DB) ([]BuildSummary, error) {
+	var summaries []BuildSummary
+	err := db.Select(&summaries, `SELECT version,
+    COUNT(DISTINCT COALESCE(run_id, to_char(query_time, 'YYYY-MM-DD HH24:MI:SS'))) AS runs,
+    COUNT(*) AS rows,
+    COALESCE(to_char(MIN(query_time), 'YYYY-MM-DD HH24:MI:SS'), '') AS first_time,
+    COALESCE(to_char(MAX(query_time), 'YYYY-MM-DD HH24:MI:SS'), '') AS last_time,
+    string_agg(DISTINCT metric, ',' ORDER BY metric) AS metrics
+FROM `+TableName(Table)+` GROUP BY version ORDER BY MIN(query_time), version`)
+	if err != nil {
+		return nil, fmt.Errorf("summarizing %s: %v", TableName(Table), err)
+	}
+	return summaries, nil
+}
+
+// insertRows inserts nrows rows into table, values returning the i'th row's values in columns order.
+func insertRows(db *sqlx.DB, table string, columns []string, nrows int, values func(i int) []interface{}, batchSize int) (int64, error) {
+	if err := ensurePartitions(db, table, columns, nrows, values); err != nil {
+		return 0, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %v", err)
+	}
+	inserted, err := insertBatches(tx, table, columns, nrows, values, batchSize)
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing %d rows: %v", inserted, err)
+	}
+	return inserted, nil
+}
+
+// ensurePartitions creates the partitions of table the rows are written to, if it is partitioned.
+func ensurePartitions(db *sqlx.DB, table string, columns []string, nrows int, values func(i int) []interface{}) error {
+	if stmt := createPartitions(table, columns, nrows, values); stmt != "" {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("creating partitions of %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// insertBatches inserts nrows rows into table within tx, in INSERT statements of at most batchSize rows each.  The
+// caller rolls tx back on error.
+func insertBatches(tx *sqlx.Tx, table string, columns []string, nrows int, values func(i int) []interface{}, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	nbatches := (nrows + batchSize - 1) / batchSize
+	var inserted int64
+	for b := 0; b < nbatches; b++ {
+		start := b * batchSize
+		end := start + batchSize
+		if end > nrows {
+			end = nrows
+		}
+		ins := squirrel.
+			Insert(table).
+			Columns(columns...).
+			PlaceholderFormat(squirrel.Dollar).
+			RunWith(tx)
+		for i := start; i < end; i++ {
+			ins = ins.Values(values(i)...)
+		}
+		n, err := execInsert(ins)
+		if err != nil {
+			return 0, fmt.Errorf("batch %d/%d (rows %d-%d) failed, rolled back %d previously inserted rows: %v",
+				b+1, nbatches, start, end-1, inserted, err)
+		}
+		inserted += n
+		log.Printf("inserted batch %d/%d, %d/%d rows", b+1, nbatches, inserted, nrows)
+	}
+	return inserted, nil
+}
+
+func rollback(tx *sqlx.Tx) {
+	if err := tx.Rollback(); err != nil {
+		log.Printf("rollback failed: %v", err)
+	}
+}
+
+func execInsert(ins squirrel.InsertBuilder) (int64, error) {
+	resp, err := ins.Exec()
+	if err != nil {
+		return 0, err
+	}
+	return resp.RowsAffected()
 }
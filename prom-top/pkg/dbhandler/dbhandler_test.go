@@ -0,0 +1,157 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recorder is a database/sql driver logging the statements executed against it, so that the batching and
+// transactions of the inserts can be checked without a postgres.
+type recorder struct {
+	mu  sync.Mutex
+	log []string
+	// failOn fails the failOn'th INSERT executed, counting from 1.  0 fails none.
+	failOn  int
+	inserts int
+}
+
+func newRecorder(t *testing.T, failOn int) (*recorder, *sqlx.DB) {
+	r := &recorder{failOn: failOn}
+	db := sqlx.NewDb(sql.OpenDB(r), "postgres")
+	t.Cleanup(func() { db.Close() })
+	return r, db
+}
+
+func (r *recorder) record(entry string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = append(r.log, entry)
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return recorderConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+type recorderConn struct{ r *recorder }
+
+func (c recorderConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c recorderConn) Close() error { return nil }
+
+func (c recorderConn) Begin() (driver.Tx, error) {
+	c.r.record("BEGIN")
+	return recorderTx(c), nil
+}
+
+// ExecContext records INSERT statements as "INSERT table rows", counting the rows by their parenthesized values.
+func (c recorderConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	fields := strings.Fields(query)
+	if len(fields) < 3 || fields[0] != "INSERT" {
+		c.r.record(fields[0])
+		return driver.RowsAffected(0), nil
+	}
+	values := query[strings.Index(query, "VALUES"):]
+	rows := strings.Count(values, "(")
+	c.r.mu.Lock()
+	c.r.inserts++
+	fail := c.r.inserts == c.r.failOn
+	c.r.mu.Unlock()
+	if fail {
+		return nil, errors.New("connection reset")
+	}
+	c.r.record(fmt.Sprintf("INSERT %s %d", fields[2], rows))
+	return driver.RowsAffected(rows), nil
+}
+
+type recorderTx struct{ r *recorder }
+
+func (tx recorderTx) Commit() error {
+	tx.r.record("COMMIT")
+	return nil
+}
+
+func (tx recorderTx) Rollback() error {
+	tx.r.record("ROLLBACK")
+	return nil
+}
+
+func TestInsertRows(t *testing.T) {
+	rows := make([]Row, 5)
+	tests := []struct {
+		name      string
+		batchSize int
+		failOn    int
+		want      []string
+		wantErr   string
+	}{
+		{"one batch", 0, 0, []string{"BEGIN", "INSERT caliper_metrics 5", "COMMIT"}, ""},
+		{"batches", 2, 0, []string{
+			"BEGIN", "INSERT caliper_metrics 2", "INSERT caliper_metrics 2", "INSERT caliper_metrics 1", "COMMIT",
+		}, ""},
+		{"failed batch", 2, 2, []string{"BEGIN", "INSERT caliper_metrics 2", "ROLLBACK"},
+			"batch 2/3 (rows 2-3) failed, rolled back 2 previously inserted rows"},
+	}
+	for _, tt := range tests {
+		r, db := newRecorder(t, tt.failOn)
+		n, err := InsertRows(db, rows, tt.batchSize)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+		} else if err != nil || n != int64(len(rows)) {
+			t.Errorf("%s: inserted %d rows: %v", tt.name, n, err)
+		}
+		if !reflect.DeepEqual(r.log, tt.want) {
+			t.Errorf("%s: executed %q, want %q", tt.name, r.log, tt.want)
+		}
+	}
+}
+
+func TestInsertRunRows(t *testing.T) {
+	r, db := newRecorder(t, 0)
+	n, err := InsertRunRows(db, Run{RunID: "run-1"}, make([]Row, 3), 2)
+	if err != nil || n != 3 {
+		t.Fatalf("inserted %d rows: %v", n, err)
+	}
+	want := []string{
+		"BEGIN", "INSERT caliper_runs 1", "INSERT caliper_metrics 2", "INSERT caliper_metrics 1", "COMMIT",
+	}
+	if !reflect.DeepEqual(r.log, want) {
+		t.Errorf("executed %q, want %q", r.log, want)
+	}
+
+	// the run is rolled back with its rows
+	r, db = newRecorder(t, 3)
+	if _, err := InsertRunRows(db, Run{RunID: "run-1"}, make([]Row, 3), 2); err == nil {
+		t.Fatal("expected an error")
+	}
+	want = []string{"BEGIN", "INSERT caliper_runs 1", "INSERT caliper_metrics 2", "ROLLBACK"}
+	if !reflect.DeepEqual(r.log, want) {
+		t.Errorf("executed %q, want %q", r.log, want)
+	}
+}
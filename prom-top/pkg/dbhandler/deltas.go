@@ -0,0 +1,54 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UpdateDeltas compares the rows of run runID, collected from build version, with the most recent earlier run of
+// another build that measured the same workload, component, and metric, and sets their delta columns:
+// baseline_version names that build, q95_delta is the change of q95_value from the average q95 of the workload's pods
+// in that run, and q95_delta_pct is the change in percent of it.  Rows without an earlier build keep NULL deltas.
+// It returns the number of rows compared.
+func UpdateDeltas(db *sqlx.DB, runID, version string) (int64, error) {
+	table := TableName(Table)
+	res, err := db.Exec(`
+UPDATE `+table+` AS cur SET
+    baseline_version = prev.version,
+    q95_delta = cur.q95_value - prev.q95,
+    q95_delta_pct = CASE WHEN prev.q95 <> 0 THEN (cur.q95_value - prev.q95) / prev.q95 * 100 END
+FROM (
+    SELECT DISTINCT ON (namespace, workload, metric, component) namespace, workload, metric, component, version, q95
+    FROM (
+        SELECT namespace, workload, metric, component, version, run_id, max(query_time) AS query_time,
+            avg(q95_value) AS q95
+        FROM `+table+`
+        WHERE version <> $2 AND q95_value <> 'NaN'
+            AND query_time < (SELECT min(query_time) FROM `+table+` WHERE run_id = $1)
+        GROUP BY namespace, workload, metric, component, version, run_id
+    ) runs
+    ORDER BY namespace, workload, metric, component, query_time DESC
+) prev
+WHERE cur.run_id = $1 AND cur.namespace = prev.namespace AND cur.workload = prev.workload
+    AND cur.metric = prev.metric AND cur.component = prev.component`, runID, version)
+	if err != nil {
+		return 0, fmt.Errorf("updating the deltas of run %s: %v", runID, err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/arrow"
+	"github.com/redhat-et/caliper/prom-top/pkg/parquet"
+)
+
+// WriteParquet writes rows to w as a Parquet file with the columns of Table, named and typed as in the database.
+func WriteParquet(w io.Writer, rows []Row) error {
+	names := ColumnsHeaders()
+	columns := make([]parquet.Column, len(names))
+	for i, v := range (Row{}).values() {
+		columns[i].Name = names[i]
+		switch v.(type) {
+		case string:
+			columns[i].Type = parquet.String
+		case float64:
+			columns[i].Type = parquet.Double
+		case bool:
+			columns[i].Type = parquet.Boolean
+		case int:
+			columns[i].Type = parquet.Int64
+		default:
+			return fmt.Errorf("column %s: no parquet type for %T", names[i], v)
+		}
+	}
+	values := exportValues(rows)
+	return parquet.Write(w, columns, len(rows), func(row, col int) interface{} {
+		return values[row][col]
+	})
+}
+
+// ArrowColumns returns the columns of Table, named and typed as in the database, as written by WriteArrowBatch.
+func ArrowColumns() ([]arrow.Column, error) {
+	names := ColumnsHeaders()
+	columns := make([]arrow.Column, len(names))
+	for i, v := range (Row{}).values() {
+		columns[i].Name = names[i]
+		switch v.(type) {
+		case string:
+			columns[i].Type = arrow.String
+		case float64:
+			columns[i].Type = arrow.Double
+		case bool:
+			columns[i].Type = arrow.Boolean
+		case int:
+			columns[i].Type = arrow.Int64
+		default:
+			return nil, fmt.Errorf("column %s: no arrow type for %T", names[i], v)
+		}
+	}
+	return columns, nil
+}
+
+// WriteArrowBatch writes rows to w as an Arrow record batch of ArrowColumns.  The stream must start with their
+// schema, see arrow.WriteSchema.
+func WriteArrowBatch(w io.Writer, rows []Row) error {
+	columns, err := ArrowColumns()
+	if err != nil {
+		return err
+	}
+	values := exportValues(rows)
+	return arrow.WriteRecordBatch(w, columns, len(rows), func(row, col int) interface{} {
+		return values[row][col]
+	})
+}
+
+// exportValues returns the values of rows in ColumnsHeaders order, with integers widened to int64.
+func exportValues(rows []Row) [][]interface{} {
+	values := make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = rows[i].values()
+		for j, v := range values[i] {
+			if n, ok := v.(int); ok {
+				values[i][j] = int64(n)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Health describes the database prom-top writes to, as seen by the connecting user.
+type Health struct {
+	Server        string
+	User          string
+	Schema        string
+	SchemaVersion int
+	// Missing lists the privileges the user lacks to write results, e.g. "INSERT on caliper_metrics".
+	Missing []string
+}
+
+// Ping reports on the database behind db: who it is connected as, the schema version, and any privileges the user
+// lacks to migrate and write to the tables.  Run before a long collection, it surfaces setup problems that would
+// otherwise only fail the final INSERT.
+func Ping(db *sqlx.DB) (Health, error) {
+	var h Health
+	var schema *string
+	err := db.QueryRowx(`SELECT current_setting('server_version'), current_user, current_schema()`).Scan(&h.Server, &h.User, &schema)
+	if err != nil {
+		return h, fmt.Errorf("querying server: %v", err)
+	}
+	if schema != nil {
+		h.Schema = *schema
+	}
+	if h.SchemaVersion, err = SchemaVersion(db); err != nil {
+		return h, err
+	}
+	if h.SchemaVersion < LatestSchemaVersion() {
+		var canCreate bool
+		if h.Schema == "" {
+			// the schema itself does not exist yet and is created by Migrate
+			err = db.Get(&canCreate, `SELECT has_database_privilege(current_database(), 'CREATE')`)
+		} else {
+			err = db.Get(&canCreate, `SELECT has_schema_privilege(current_schema(), 'CREATE')`)
+		}
+		if err != nil {
+			return h, fmt.Errorf("checking privileges: %v", err)
+		}
+		if !canCreate {
+			if h.Schema == "" {
+				h.Missing = append(h.Missing, "CREATE on the database")
+			} else {
+				h.Missing = append(h.Missing, "CREATE on schema "+h.Schema)
+			}
+		}
+	}
+	for _, base := range []string{Table, LongTable, RunsTable, QueryStatsTable} {
+		table := TableName(base)
+		var exists bool
+		if err = db.Get(&exists, `SELECT to_regclass($1) IS NOT NULL`, table); err != nil {
+			return h, fmt.Errorf("checking privileges: %v", err)
+		}
+		if !exists {
+			// created by the pending migrations, and owned by whoever runs them
+			continue
+		}
+		for _, privilege := range []string{"SELECT", "INSERT"} {
+			var ok bool
+			if err = db.Get(&ok, `SELECT has_table_privilege($1, $2)`, table, privilege); err != nil {
+				return h, fmt.Errorf("checking privileges: %v", err)
+			}
+			if !ok {
+				h.Missing = append(h.Missing, privilege+" on "+table)
+			}
+		}
+	}
+	return h, nil
+}
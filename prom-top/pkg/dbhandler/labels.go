@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels are name=value pairs stored with each row so that results can be sliced by them, e.g. the custom labels
+// given to a run, scenario=density-1000, or the pod labels joined from kube_pod_labels.  They are stored as a jsonb
+// object.
+type Labels map[string]string
+
+// ParseLabels parses labels in the comma separated name=value form of Labels.String.
+func ParseLabels(s string) (Labels, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(Labels)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("label %q is not of the form name=value", pair)
+		}
+		labels[pair[:i]] = pair[i+1:]
+	}
+	return labels, nil
+}
+
+// Names returns the label names, sorted.
+func (l Labels) Names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the labels as comma separated name=value pairs, sorted by name.
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, name := range l.Names() {
+		pairs = append(pairs, name+"="+l[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// JSON returns the labels as the JSON object stored in the labels column, an empty object if there are none.
+func (l Labels) JSON() string {
+	if len(l) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(map[string]string(l))
+	return string(b)
+}
+
+// Scan implements sql.Scanner for the labels column.
+func (l *Labels) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("decoding labels: %v", err)
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*l = m
+	return nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+)
+
+// SchemaVersionTable records the migrations applied to the database, one row per migration.
+const SchemaVersionTable = "schema_version"
+
+// Migration is a forward-only change to the database schema.  Migrations are applied in order, each in its own
+// transaction, and must never be edited once released: change the schema by appending a new one.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// workloadSQL computes the workload of the pod column of a metrics table as top.Workload does, to backfill rows
+// written before the column existed.
+const workloadSQL = `regexp_replace(regexp_replace(pod, '(-[bcdfghjklmnpqrstvwxz2456789]{6,10})?-[bcdfghjklmnpqrstvwxz2456789]{5}$', ''), '-[0-9]+$', '')`
+
+// migrations returns the schema history.  The first creates the table as deployed before versioning was introduced,
+// as dumped in example/schema.sql, so that such databases are upgraded in place.  Table names are resolved with
+// TableName.
+func migrations() []Migration {
+	table, longTable, runsTable := TableName(Table), TableName(LongTable), TableName(RunsTable)
+	queryStatsTable := TableName(QueryStatsTable)
+	return []Migration{
+		{1, "create " + table, `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+    version text NOT NULL,
+    metric text NOT NULL,
+    node text NOT NULL,
+    pod text NOT NULL,
+    namespace text NOT NULL,
+    owner_name text,
+    avg_value numeric,
+    q95_value numeric,
+    max_value numeric,
+    min_value numeric,
+    inst_value numeric,
+    query_time timestamp without time zone,
+    range text NOT NULL
+)`},
+		{2, "add " + table + ".partial", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS partial boolean NOT NULL DEFAULT false`},
+		{3, "add " + table + ".cluster", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS cluster text NOT NULL DEFAULT ''`},
+		{4, "create " + longTable, `
+CREATE TABLE IF NOT EXISTS ` + longTable + ` (
+    version text NOT NULL,
+    cluster text NOT NULL DEFAULT '',
+    metric text NOT NULL,
+    node text NOT NULL,
+    pod text NOT NULL,
+    namespace text NOT NULL,
+    owner_name text,
+    aggregation text NOT NULL,
+    value numeric,
+    query_time timestamp without time zone,
+    range text NOT NULL,
+    partial boolean NOT NULL DEFAULT false
+)`},
+		{5, "create " + runsTable + ", add run_id to metrics tables", `
+CREATE TABLE IF NOT EXISTS ` + runsTable + ` (
+    run_id text PRIMARY KEY,
+    version text NOT NULL,
+    cluster text NOT NULL DEFAULT '',
+    tool_version text NOT NULL,
+    range text NOT NULL,
+    start_time timestamp without time zone NOT NULL,
+    query_duration_seconds double precision NOT NULL,
+    node_count integer NOT NULL,
+    pod_count integer NOT NULL,
+    partial boolean NOT NULL DEFAULT false
+);
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS run_id text REFERENCES ` + runsTable + ` (run_id);
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS run_id text REFERENCES ` + runsTable + ` (run_id)`},
+		{6, "add violation to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS violation text NOT NULL DEFAULT '';
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS violation text NOT NULL DEFAULT ''`},
+		{7, "add request and efficiency to " + table, `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS request numeric;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS efficiency numeric`},
+		{8, "add burstiness to " + table, `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS burstiness numeric;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS q95_burstiness numeric`},
+		{9, "add infrastructure to " + runsTable, `
+ALTER TABLE ` + runsTable + ` ADD COLUMN IF NOT EXISTS platform text NOT NULL DEFAULT '';
+ALTER TABLE ` + runsTable + ` ADD COLUMN IF NOT EXISTS cluster_version text NOT NULL DEFAULT '';
+ALTER TABLE ` + runsTable + ` ADD COLUMN IF NOT EXISTS node_roles jsonb;
+ALTER TABLE ` + runsTable + ` ADD COLUMN IF NOT EXISTS instance_types jsonb`},
+		{10, "add iteration to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS iteration integer NOT NULL DEFAULT 0;
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS iteration integer NOT NULL DEFAULT 0`},
+		{11, "create " + queryStatsTable, `
+CREATE TABLE IF NOT EXISTS ` + queryStatsTable + ` (
+    run_id text NOT NULL REFERENCES ` + runsTable + ` (run_id),
+    iteration integer NOT NULL DEFAULT 0,
+    metric text NOT NULL,
+    aggregation text NOT NULL,
+    query text NOT NULL,
+    duration_seconds double precision NOT NULL,
+    samples integer NOT NULL,
+    cached boolean NOT NULL DEFAULT false,
+    warnings text NOT NULL DEFAULT ''
+)`},
+		{12, "add labels to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}'`},
+		{13, "add workload to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS workload text NOT NULL DEFAULT '';
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS workload text NOT NULL DEFAULT '';
+UPDATE ` + table + ` SET workload = ` + workloadSQL + `;
+UPDATE ` + longTable + ` SET workload = ` + workloadSQL},
+		{14, "add hpa replicas to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS hpa_min_replicas integer NOT NULL DEFAULT 0;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS hpa_max_replicas integer NOT NULL DEFAULT 0;
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS hpa_min_replicas integer NOT NULL DEFAULT 0;
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS hpa_max_replicas integer NOT NULL DEFAULT 0`},
+		{15, "add prometheus stats to " + queryStatsTable, `
+ALTER TABLE ` + queryStatsTable + ` ADD COLUMN IF NOT EXISTS eval_seconds double precision NOT NULL DEFAULT 0;
+ALTER TABLE ` + queryStatsTable + ` ADD COLUMN IF NOT EXISTS samples_loaded bigint NOT NULL DEFAULT 0`},
+		{16, "add component to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS component text NOT NULL DEFAULT '';
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS component text NOT NULL DEFAULT ''`},
+		{17, "add deltas to " + table, `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS baseline_version text;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS q95_delta double precision;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS q95_delta_pct double precision`},
+		{18, "add pod labels to metrics tables", `
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS pod_labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE ` + longTable + ` ADD COLUMN IF NOT EXISTS pod_labels jsonb NOT NULL DEFAULT '{}'`},
+		{19, "add error to " + queryStatsTable, `
+ALTER TABLE ` + queryStatsTable + ` ADD COLUMN IF NOT EXISTS error text NOT NULL DEFAULT ''`},
+	}
+}
+
+// LatestSchemaVersion is the schema version this build of prom-top writes.
+func LatestSchemaVersion() int {
+	all := migrations()
+	return all[len(all)-1].Version
+}
+
+// SchemaVersion returns the version of the most recent migration applied to db, 0 if none have been.
+func SchemaVersion(db *sqlx.DB) (int, error) {
+	var exists bool
+	err := db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)`, TableName(SchemaVersionTable))
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %v", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int
+	if err = db.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM `+TableName(SchemaVersionTable)); err != nil {
+		return 0, fmt.Errorf("reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// CheckSchema verifies that db is at the schema version this build writes, so that a mismatch is reported with a
+// fix rather than as a column error from the first INSERT.
+func CheckSchema(db *sqlx.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	switch {
+	case current < latest:
+		return fmt.Errorf("database schema is at version %d, this prom-top requires version %d: run 'prom-top db migrate'", current, latest)
+	case current > latest:
+		return fmt.Errorf("database schema is at version %d, newer than the version %d this prom-top supports: upgrade prom-top", current, latest)
+	}
+	return nil
+}
+
+// Migrate applies every migration newer than the database's schema version and returns the number applied.  The
+// metrics tables are then partitioned if CALIPER_PARTITION_BY is set, see partition.
+func Migrate(db *sqlx.DB) (int, error) {
+	if s := viper.GetString(schema); s != "" {
+		// the tables are created in the first schema of the search_path, which must exist
+		if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS ` + s); err != nil {
+			return 0, fmt.Errorf("creating schema %s: %v", s, err)
+		}
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + TableName(SchemaVersionTable) + ` (
+    version integer PRIMARY KEY,
+    description text NOT NULL,
+    applied_at timestamp without time zone NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		return 0, fmt.Errorf("creating %s: %v", TableName(SchemaVersionTable), err)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, m := range migrations() {
+		if m.Version <= current {
+			continue
+		}
+		if err := apply(db, m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed, schema left at version %d: %v", m.Version, m.Description, current, err)
+		}
+		log.Printf("applied migration %d: %s", m.Version, m.Description)
+		current = m.Version
+		applied++
+	}
+	return applied, partition(db)
+}
+
+func apply(db *sqlx.DB, m Migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	if _, err = tx.Exec(m.SQL); err == nil {
+		_, err = tx.Exec(`INSERT INTO `+TableName(SchemaVersionTable)+` (version, description) VALUES ($1, $2)`, m.Version, m.Description)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("rollback failed: %v", rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbhandler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// benchSchema holds the tables written by the benchmarks.  It is dropped when they end.
+const benchSchema = "caliper_bench"
+
+// BenchmarkInsertRows compares the database/sql and native pgx write paths.  It writes to the postgres configured in
+// the environment, as NewPostgresClient does, and is skipped when none is, e.g.:
+//
+//	PGHOST=localhost PGPORT=5432 PGDATABASE=caliper PGUSER=caliper \
+//		go test -run - -bench InsertRows -benchmem ./prom-top/pkg/dbhandler
+func BenchmarkInsertRows(b *testing.B) {
+	if err := ValidateConfig(); err != nil {
+		b.Skipf("postgres is not configured: %v", err)
+	}
+	viper.Set(schema, benchSchema)
+	db, err := NewPostgresClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	defer func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + benchSchema + ` CASCADE`); err != nil {
+			b.Errorf("dropping schema %s: %v", benchSchema, err)
+		}
+	}()
+	if _, err := Migrate(db); err != nil {
+		b.Fatal(err)
+	}
+	nc, err := NewNativeClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer nc.Close()
+
+	now := time.Now().UTC().Format(TimestampFormat)
+	run := Run{RunID: "bench", Version: "bench", Cluster: "bench", Range: "10m", StartTime: now}
+	if err := InsertRun(db, run); err != nil {
+		b.Fatal(err)
+	}
+	drivers := []struct {
+		name   string
+		insert func(rows []Row) (int64, error)
+	}{
+		{"sql", func(rows []Row) (int64, error) { return InsertRows(db, rows, DefaultBatchSize) }},
+		{"pgx", func(rows []Row) (int64, error) { return nc.InsertRows(rows, DefaultBatchSize) }},
+	}
+	for _, nrows := range []int{1000, 10000} {
+		rows := benchRows(run, now, nrows)
+		for _, d := range drivers {
+			d := d
+			b.Run(fmt.Sprintf("%s/%d", d.name, nrows), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					if _, err := db.Exec(`TRUNCATE ` + TableName(Table)); err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+					if n, err := d.insert(rows); err != nil || n != int64(nrows) {
+						b.Fatalf("inserted %d of %d rows: %v", n, nrows, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// benchRows returns n rows of run, queried at now, of as many pods.
+func benchRows(run Run, now string, n int) []Row {
+	rows := make([]Row, n)
+	for i := range rows {
+		rows[i] = Row{
+			Version:   run.Version,
+			Cluster:   run.Cluster,
+			Metric:    "container_memory_bytes",
+			Node:      fmt.Sprintf("worker-%d", i%100),
+			Pod:       fmt.Sprintf("app-%d", i),
+			Namespace: fmt.Sprintf("ns-%d", i%50),
+			OwnerName: "app",
+			AvgValue:  float64(i),
+			Q95Value:  float64(i),
+			MaxValue:  float64(i),
+			MinValue:  float64(i),
+			InstValue: float64(i),
+			QueryTime: now,
+			Range:     run.Range,
+			RunID:     run.RunID,
+			Workload:  "app",
+		}
+	}
+	return rows
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+)
+
+// partitionMonth is the only supported value of CALIPER_PARTITION_BY.
+const partitionMonth = "month"
+
+// partitionedTables are the tables partitioned by CALIPER_PARTITION_BY, those growing with every run.
+var partitionedTables = []string{Table, LongTable}
+
+// partitionName returns the name of the partition of table holding the rows of the month starting at month, e.g.
+// caliper_metrics_y2020m11.
+func partitionName(table string, month time.Time) string {
+	return table + month.Format("_y2006m01")
+}
+
+// isPartitioned is the condition, in SQL, that table is partitioned.
+func isPartitioned(table string) string {
+	return `EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass('` + table + `'))`
+}
+
+// partitionTable returns the statements converting table into a table partitioned by month on query_time, with a
+// partition for every month of its rows and a default partition for rows without a query time.
+func partitionTable(table, runsTable string) string {
+	old := table + "_unpartitioned"
+	return `
+ALTER TABLE ` + table + ` RENAME TO ` + old + `;
+CREATE TABLE ` + table + ` (LIKE ` + old + ` INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING INDEXES) PARTITION BY RANGE (query_time);
+ALTER TABLE ` + table + ` ADD FOREIGN KEY (run_id) REFERENCES ` + runsTable + ` (run_id);
+CREATE TABLE ` + table + `_default PARTITION OF ` + table + ` DEFAULT;
+DO $$
+DECLARE
+    m timestamp;
+BEGIN
+    FOR m IN SELECT DISTINCT date_trunc('month', query_time) FROM ` + old + ` WHERE query_time IS NOT NULL LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF ` + table + ` FOR VALUES FROM (%L) TO (%L)',
+            '` + table + `' || to_char(m, '"_y"YYYY"m"MM'), m, m + interval '1 month');
+    END LOOP;
+END $$;
+INSERT INTO ` + table + ` SELECT * FROM ` + old + `;
+DROP TABLE ` + old
+}
+
+// partition converts the metrics tables of db that are not partitioned yet into tables partitioned by month, each
+// in its own transaction, if CALIPER_PARTITION_BY is set.  Partitioning requires postgres 11 or later.
+func partition(db *sqlx.DB) error {
+	if viper.GetString(partitionBy) == "" {
+		return nil
+	}
+	var serverVersion int
+	if err := db.Get(&serverVersion, `SELECT current_setting('server_version_num')::integer`); err != nil {
+		return fmt.Errorf("reading server version: %v", err)
+	}
+	if serverVersion < 110000 {
+		return fmt.Errorf("%s requires postgres 11 or later", partitionBy)
+	}
+	for _, base := range partitionedTables {
+		table := TableName(base)
+		var partitioned bool
+		if err := db.Get(&partitioned, `SELECT `+isPartitioned(table)); err != nil {
+			return fmt.Errorf("inspecting %s: %v", table, err)
+		}
+		if partitioned {
+			continue
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			return fmt.Errorf("beginning transaction: %v", err)
+		}
+		if _, err = tx.Exec(partitionTable(table, TableName(RunsTable))); err == nil {
+			err = tx.Commit()
+		} else if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("rollback failed: %v", rbErr)
+		}
+		if err != nil {
+			return fmt.Errorf("partitioning %s by month, left unpartitioned: %v", table, err)
+		}
+		log.Printf("partitioned %s by month of query_time", table)
+	}
+	return nil
+}
+
+// createPartitions returns a statement creating the monthly partitions of table missing for the nrows rows of
+// values, in columns order, if table is partitioned, and an empty string for tables without a query_time column.
+// Postgres does not create partitions as rows are inserted, so the statement is executed before every insert.
+func createPartitions(table string, columns []string, nrows int, values func(i int) []interface{}) string {
+	column := -1
+	for i, c := range columns {
+		if c == "query_time" {
+			column = i
+		}
+	}
+	if column < 0 {
+		return ""
+	}
+	months := make(map[time.Time]bool)
+	for i := 0; i < nrows; i++ {
+		queryTime, _ := values(i)[column].(string)
+		t, err := time.Parse(TimestampFormat, queryTime)
+		if err != nil {
+			// stored in the default partition
+			continue
+		}
+		months[time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)] = true
+	}
+	if len(months) == 0 {
+		return ""
+	}
+	sorted := make([]time.Time, 0, len(months))
+	for m := range months {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	var b strings.Builder
+	b.WriteString("DO $$\nBEGIN\n    IF " + isPartitioned(table) + " THEN\n")
+	for _, m := range sorted {
+		fmt.Fprintf(&b, "        CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s');\n",
+			partitionName(table, m), table, m.Format(TimestampFormat), m.AddDate(0, 1, 0).Format(TimestampFormat))
+	}
+	b.WriteString("    END IF;\nEND $$")
+	return b.String()
+}
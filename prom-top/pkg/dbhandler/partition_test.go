@@ -0,0 +1,49 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbhandler
+
+import "testing"
+
+func TestCreatePartitions(t *testing.T) {
+	columns := []string{"pod", "query_time"}
+	tests := []struct {
+		name       string
+		columns    []string
+		queryTimes []interface{}
+		want       string
+	}{
+		{"no query time column", []string{"pod"}, []interface{}{"2020-11-03 10:00:00"}, ""},
+		{"no rows", columns, nil, ""},
+		// rows without a valid query time are stored in the default partition
+		{"no valid query time", columns, []interface{}{"", nil, "2020-11-03"}, ""},
+		{"months", columns, []interface{}{"2021-01-01 00:00:00", "2020-12-31 23:59:59", "", "2020-12-01 00:00:00"}, `DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass('caliper_metrics')) THEN
+        CREATE TABLE IF NOT EXISTS caliper_metrics_y2020m12 PARTITION OF caliper_metrics FOR VALUES FROM ('2020-12-01 00:00:00') TO ('2021-01-01 00:00:00');
+        CREATE TABLE IF NOT EXISTS caliper_metrics_y2021m01 PARTITION OF caliper_metrics FOR VALUES FROM ('2021-01-01 00:00:00') TO ('2021-02-01 00:00:00');
+    END IF;
+END $$`},
+	}
+	for _, tt := range tests {
+		got := createPartitions("caliper_metrics", tt.columns, len(tt.queryTimes), func(i int) []interface{} {
+			return []interface{}{"pod", tt.queryTimes[i]}
+		})
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbhandler
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// QueryStatsTable holds one row per prometheus query executed by a run, referenced by run_id.
+const QueryStatsTable = "caliper_query_stats"
+
+// QueryStat records the execution of a single prometheus query.
+type QueryStat struct {
+	RunID       string `db:"run_id"`
+	Iteration   int    `db:"iteration"`
+	Metric      string `db:"metric"`
+	Aggregation string `db:"aggregation"`
+	Query       string `db:"query"`
+	// Duration is the wall time of the query.
+	Duration float64 `db:"duration_seconds"`
+	Samples  int     `db:"samples"`
+	Cached   bool    `db:"cached"`
+	// Warnings are those returned by prometheus with the result, newline separated.
+	Warnings string `db:"warnings"`
+	// EvalSeconds and SamplesLoaded are the evaluation time and samples loaded reported by prometheus, 0 unless
+	// requested with --prometheus-stats.
+	EvalSeconds   float64 `db:"eval_seconds"`
+	SamplesLoaded int64   `db:"samples_loaded"`
+	// Error is the reason the query failed, empty if it succeeded.
+	Error string `db:"error"`
+}
+
+// QueryStatsColumnsHeaders defines the columns of QueryStatsTable.
+func QueryStatsColumnsHeaders() []string {
+	return []string{
+		"run_id",
+		"iteration",
+		"metric",
+		"aggregation",
+		"query",
+		"duration_seconds",
+		"samples",
+		"cached",
+		"warnings",
+		"eval_seconds",
+		"samples_loaded",
+		"error",
+	}
+}
+
+// values returns the values of s in QueryStatsColumnsHeaders order.
+func (s QueryStat) values() []interface{} {
+	return []interface{}{
+		s.RunID,
+		s.Iteration,
+		s.Metric,
+		s.Aggregation,
+		s.Query,
+		s.Duration,
+		s.Samples,
+		s.Cached,
+		s.Warnings,
+		s.EvalSeconds,
+		s.SamplesLoaded,
+		s.Error,
+	}
+}
+
+// InsertQueryStats writes stats to QueryStatsTable, batched and transactional as InsertRows.
+func InsertQueryStats(db *sqlx.DB, stats []QueryStat, batchSize int) (int64, error) {
+	return insertRows(db, TableName(QueryStatsTable), QueryStatsColumnsHeaders(), len(stats), func(i int) []interface{} {
+		return stats[i].values()
+	}, batchSize)
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diff aligns the rows of two saved prom-top results and computes the change of each.
+//
+// Rows are aligned by namespace, pod, and metric, so each result must hold one row of each: results spanning several
+// clusters or iterations are rejected.  Pods recreated between the runs have new generated name suffixes,
+// so rows left unaligned are then matched by workload, the pod name stripped of its replicaset hash and random or
+// ordinal suffix, heaviest with heaviest.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// workload returns the workload of m, derived from its pod for results saved without one, qualified like pod.
+func workload(m *top.PodMetric) string {
+	w := m.Workload
+	if w == "" {
+		w = top.Workload(m.Pod)
+	}
+	return qualified(w, m)
+}
+
+// pod returns the pod of m, qualified by its component when its containers were split, e.g. etcd-0/istio-proxy.
+func pod(m *top.PodMetric) string {
+	return qualified(m.Pod, m)
+}
+
+func qualified(name string, m *top.PodMetric) string {
+	if m.Component == "" {
+		return name
+	}
+	return name + "/" + m.Component
+}
+
+// Row is the change of one pod's metric.  PodA or PodB is empty for pods present in only one of the results.  The
+// rows of split pods are those of their components, whose pods are qualified by the component.
+type Row struct {
+	Namespace string
+	Metric    string
+	PodA      string
+	PodB      string
+	A         float64
+	B         float64
+	Delta     float64
+	// DeltaPct is the change relative to A, NaN for pods absent from either result or with A of 0.
+	DeltaPct float64
+}
+
+// Summary totals the change of one metric.
+type Summary struct {
+	Metric  string
+	Matched int
+	OnlyA   int
+	OnlyB   int
+	TotalA  float64
+	TotalB  float64
+	// MedianDeltaPct is the median DeltaPct of the matched rows, NaN if there are none.
+	MedianDeltaPct float64
+}
+
+type key struct {
+	namespace, pod, metric string
+}
+
+// unique returns an error if table holds more than one row of a namespace, pod, and metric, which would be aligned
+// arbitrarily, naming the column that tells them apart.
+func unique(table top.PodMetricTable) error {
+	seen := make(map[key]*top.PodMetric, len(table))
+	for _, m := range table {
+		k := key{m.Namespace, pod(m), m.Metric}
+		prev, ok := seen[k]
+		if !ok {
+			seen[k] = m
+			continue
+		}
+		switch {
+		case prev.Cluster != m.Cluster:
+			return fmt.Errorf("%s %s/%s is in clusters %q and %q: diff the results of one cluster",
+				m.Metric, m.Namespace, pod(m), prev.Cluster, m.Cluster)
+		case prev.Iteration != m.Iteration:
+			return fmt.Errorf("%s %s/%s is in iterations %d and %d: diff the results of one iteration",
+				m.Metric, m.Namespace, pod(m), prev.Iteration, m.Iteration)
+		case prev.QueryTime != m.QueryTime:
+			return fmt.Errorf("%s %s/%s was queried at %s and %s: diff the results of one collection",
+				m.Metric, m.Namespace, pod(m), prev.QueryTime, m.QueryTime)
+		default:
+			return fmt.Errorf("%s %s/%s appears more than once", m.Metric, m.Namespace, pod(m))
+		}
+	}
+	return nil
+}
+
+// Diff aligns the rows of a and b and returns the change in agg of each, largest relative change first, along with
+// a summary per metric.  It returns an error if a or b holds more than one row of a namespace, pod, and metric.
+func Diff(a, b top.PodMetricTable, agg top.Aggregation) ([]Row, []Summary, error) {
+	if err := unique(a); err != nil {
+		return nil, nil, fmt.Errorf("a: %v", err)
+	}
+	if err := unique(b); err != nil {
+		return nil, nil, fmt.Errorf("b: %v", err)
+	}
+	var rows []Row
+	pair := func(pa, pb *top.PodMetric) {
+		r := Row{DeltaPct: math.NaN()}
+		if pa != nil {
+			r.Namespace, r.Metric, r.PodA, r.A = pa.Namespace, pa.Metric, pod(pa), pa.Value(agg)
+		}
+		if pb != nil {
+			r.Namespace, r.Metric, r.PodB, r.B = pb.Namespace, pb.Metric, pod(pb), pb.Value(agg)
+		}
+		r.Delta = r.B - r.A
+		if pa != nil && pb != nil && r.A != 0 {
+			r.DeltaPct = r.Delta / r.A * 100
+		}
+		rows = append(rows, r)
+	}
+
+	// exact matches first
+	exact := make(map[key]*top.PodMetric, len(b))
+	for _, m := range b {
+		exact[key{m.Namespace, pod(m), m.Metric}] = m
+	}
+	fuzzyA := make(map[key][]*top.PodMetric)
+	for _, m := range a {
+		k := key{m.Namespace, pod(m), m.Metric}
+		if mb, ok := exact[k]; ok {
+			pair(m, mb)
+			delete(exact, k)
+			continue
+		}
+		fk := key{m.Namespace, workload(m), m.Metric}
+		fuzzyA[fk] = append(fuzzyA[fk], m)
+	}
+	fuzzyB := make(map[key][]*top.PodMetric)
+	for _, m := range exact {
+		fk := key{m.Namespace, workload(m), m.Metric}
+		fuzzyB[fk] = append(fuzzyB[fk], m)
+	}
+
+	// then the remaining replicas of a workload, heaviest with heaviest
+	heaviestFirst := func(ms []*top.PodMetric) {
+		sort.Slice(ms, func(i, j int) bool {
+			if ms[i].Value(agg) != ms[j].Value(agg) {
+				return ms[i].Value(agg) > ms[j].Value(agg)
+			}
+			return pod(ms[i]) < pod(ms[j])
+		})
+	}
+	for fk, as := range fuzzyA {
+		bs := fuzzyB[fk]
+		delete(fuzzyB, fk)
+		heaviestFirst(as)
+		heaviestFirst(bs)
+		for i := 0; i < len(as) || i < len(bs); i++ {
+			var pa, pb *top.PodMetric
+			if i < len(as) {
+				pa = as[i]
+			}
+			if i < len(bs) {
+				pb = bs[i]
+			}
+			pair(pa, pb)
+		}
+	}
+	for _, bs := range fuzzyB {
+		for _, m := range bs {
+			pair(nil, m)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := math.Abs(rows[i].DeltaPct), math.Abs(rows[j].DeltaPct)
+		// NaN sorts last
+		if math.IsNaN(a) != math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		if a != b {
+			return a > b
+		}
+		if rows[i].Metric != rows[j].Metric {
+			return rows[i].Metric < rows[j].Metric
+		}
+		if rows[i].Namespace != rows[j].Namespace {
+			return rows[i].Namespace < rows[j].Namespace
+		}
+		return rows[i].PodA+rows[i].PodB < rows[j].PodA+rows[j].PodB
+	})
+	return rows, summarize(rows), nil
+}
+
+func summarize(rows []Row) []Summary {
+	summaries := make(map[string]*Summary)
+	pcts := make(map[string][]float64)
+	for _, r := range rows {
+		s := summaries[r.Metric]
+		if s == nil {
+			s = &Summary{Metric: r.Metric}
+			summaries[r.Metric] = s
+		}
+		s.TotalA += r.A
+		s.TotalB += r.B
+		switch {
+		case r.PodA == "":
+			s.OnlyB++
+		case r.PodB == "":
+			s.OnlyA++
+		default:
+			s.Matched++
+			if !math.IsNaN(r.DeltaPct) {
+				pcts[r.Metric] = append(pcts[r.Metric], r.DeltaPct)
+			}
+		}
+	}
+	result := make([]Summary, 0, len(summaries))
+	for metric, s := range summaries {
+		s.MedianDeltaPct = median(pcts[metric])
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Metric < result[j].Metric })
+	return result
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// WriteText writes rows and summaries to w as aligned tables.
+func WriteText(w io.Writer, rows []Row, summaries []Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\tnamespace\tpod a\tpod b\ta\tb\tdelta\tdelta%\t")
+	for _, r := range rows {
+		pct := fmt.Sprintf("%+.1f%%", r.DeltaPct)
+		switch {
+		case r.PodA == "":
+			pct = "new"
+		case r.PodB == "":
+			pct = "gone"
+		case math.IsNaN(r.DeltaPct):
+			pct = "n/a"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			r.Metric, r.Namespace, dash(r.PodA), dash(r.PodB), top.FormatValue(r.A), top.FormatValue(r.B), top.FormatDelta(r.Delta), pct)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "metric\tmatched\tonly a\tonly b\ttotal a\ttotal b\ttotal delta%\tmedian delta%\t")
+	for _, s := range summaries {
+		total := math.NaN()
+		if s.TotalA != 0 {
+			total = (s.TotalB - s.TotalA) / s.TotalA * 100
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%+.1f%%\t%+.1f%%\t\n",
+			s.Metric, s.Matched, s.OnlyA, s.OnlyB, top.FormatValue(s.TotalA), top.FormatValue(s.TotalB), total, s.MedianDeltaPct)
+	}
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestDiff(t *testing.T) {
+	row := func(pod string, avg float64) *top.PodMetric {
+		return &top.PodMetric{Namespace: "ns", Pod: pod, Metric: top.CPUMetric, AvgValue: avg}
+	}
+	a := top.PodMetricTable{row("etcd-0", 1), row("app-6d4cf56db6-x7k2p", 2)}
+	b := top.PodMetricTable{row("etcd-0", 1.5), row("app-6d4cf56db6-q9z8w", 1)}
+	rows, summaries, err := Diff(a, b, top.Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{
+		{Namespace: "ns", Metric: top.CPUMetric, PodA: "app-6d4cf56db6-x7k2p", PodB: "app-6d4cf56db6-q9z8w", A: 2, B: 1,
+			Delta: -1, DeltaPct: -50},
+		{Namespace: "ns", Metric: top.CPUMetric, PodA: "etcd-0", PodB: "etcd-0", A: 1, B: 1.5, Delta: .5, DeltaPct: 50},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(rows), len(want), rows)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d: got %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+	if len(summaries) != 1 || summaries[0].Matched != 2 || summaries[0].MedianDeltaPct != 0 {
+		t.Errorf("got summaries %+v", summaries)
+	}
+}
+
+func TestDiffAmbiguous(t *testing.T) {
+	row := func(cluster string, iteration int, queryTime string) *top.PodMetric {
+		return &top.PodMetric{Namespace: "ns", Pod: "etcd-0", Metric: top.CPUMetric, Cluster: cluster,
+			Iteration: iteration, QueryTime: queryTime}
+	}
+	tests := []struct {
+		name  string
+		table top.PodMetricTable
+		err   string
+	}{
+		{"clusters", top.PodMetricTable{row("east", 0, ""), row("west", 0, "")}, `clusters "east" and "west"`},
+		{"iterations", top.PodMetricTable{row("", 1, ""), row("", 2, "")}, "iterations 1 and 2"},
+		{"query times", top.PodMetricTable{row("", 0, "2020-10-01 12:00:00"), row("", 0, "2020-10-01 13:00:00")},
+			"queried at 2020-10-01 12:00:00 and 2020-10-01 13:00:00"},
+		{"duplicates", top.PodMetricTable{row("", 0, ""), row("", 0, "")}, "more than once"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			single := top.PodMetricTable{row("", 0, "")}
+			if _, _, err := Diff(test.table, single, top.Average); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("a: got %v, want an error containing %q", err, test.err)
+			}
+			if _, _, err := Diff(single, test.table, top.Average); err == nil || !strings.HasPrefix(err.Error(), "b: ") {
+				t.Errorf("b: got %v, want an error about b", err)
+			}
+		})
+	}
+}
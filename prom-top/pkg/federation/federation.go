@@ -0,0 +1,146 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package federation samples pod usage from prometheus' /federate endpoint, for clusters where the query API is
+// blocked but federation is allowed.  The aggregations the instant queries compute server-side are computed
+// client-side from the raw series.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const federatePath = "/federate"
+
+// The series federated, as selected by the instant queries of top.DefaultTemplates.
+const (
+	cpuSeries    = "container_cpu_usage_seconds_total"
+	memorySeries = "container_memory_usage_bytes"
+	ownerSeries  = "kube_pod_owner"
+)
+
+// selectors returns the match[] selectors of the series federated, each restricted by matchers.  kube_pod_owner is
+// only restricted by top.PodMatchers, the matchers on labels it has.
+func selectors(matchers []top.Matcher) []string {
+	return []string{
+		cpuSeries + `{pod!=""` + render(matchers) + `}`,
+		memorySeries + `{pod!=""` + render(matchers) + `}`,
+		ownerSeries + `{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"` + render(top.PodMatchers(matchers)) + `}`,
+	}
+}
+
+// render renders matchers, each preceded by a comma.
+func render(matchers []top.Matcher) string {
+	var s string
+	for _, m := range matchers {
+		s += "," + m.String()
+	}
+	return s
+}
+
+// Scrape federates the cpu and memory series of every pod matching matchers and returns one sample of each pod,
+// keyed by top.CPUMetric and top.MemoryMetric, as top.Collector.Observe expects.  The series of a pod's containers
+// are summed by namespace, pod, and node, and the owner_name of its controller is joined from kube_pod_owner.  The
+// cpu values are the cumulative counters, the collector derives their rate between samples.
+func Scrape(ctx context.Context, client promapi.Client, matchers []top.Matcher) (map[string]model.Vector, error) {
+	params := url.Values{"match[]": selectors(matchers)}
+	req, err := http.NewRequest(http.MethodGet, client.URL(federatePath, nil).String()+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", federatePath, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("reading %s: server returned %s: %s", federatePath, resp.Status, bytes.TrimSpace(body))
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", federatePath, err)
+	}
+	series := make(map[string]model.Vector, len(families))
+	for name, family := range families {
+		samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.Now()}, family)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %v", name, err)
+		}
+		series[name] = samples
+	}
+
+	owners := make(map[podKey]model.LabelValue)
+	for _, s := range series[ownerSeries] {
+		owners[keyOf(s.Metric)] = s.Metric["owner_name"]
+	}
+	return map[string]model.Vector{
+		top.CPUMetric:    sumByPod(series[cpuSeries], owners),
+		top.MemoryMetric: sumByPod(series[memorySeries], owners),
+	}, nil
+}
+
+// podKey identifies a pod: pod names, e.g. those of StatefulSets, repeat across namespaces.
+type podKey struct {
+	namespace, pod model.LabelValue
+}
+
+func keyOf(m model.Metric) podKey {
+	return podKey{m["namespace"], m["pod"]}
+}
+
+// sumByPod sums the container series of each pod.  The pod-level cgroup series, with an empty container label, and
+// those of the pause container would count the containers' usage twice and are skipped.  As with the instant
+// queries' join on kube_pod_owner, pods without a known controller are dropped.
+func sumByPod(vector model.Vector, owners map[podKey]model.LabelValue) model.Vector {
+	pods := make(map[string]*model.Sample)
+	var summed model.Vector
+	for _, s := range vector {
+		if c := s.Metric["container"]; c == "" || c == "POD" {
+			continue
+		}
+		owner, ok := owners[keyOf(s.Metric)]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s", s.Metric["namespace"], s.Metric["pod"], s.Metric["node"])
+		sum, ok := pods[key]
+		if !ok {
+			sum = &model.Sample{
+				Metric: model.Metric{
+					"namespace":  s.Metric["namespace"],
+					"pod":        s.Metric["pod"],
+					"node":       s.Metric["node"],
+					"owner_name": owner,
+				},
+				Timestamp: s.Timestamp,
+			}
+			pods[key] = sum
+			summed = append(summed, sum)
+		}
+		sum.Value += s.Value
+	}
+	return summed
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// federated is a /federate response: db/postgres-0 has an owner, app/postgres-0 has none and must be dropped.
+const federated = `# TYPE container_cpu_usage_seconds_total untyped
+container_cpu_usage_seconds_total{namespace="db",pod="postgres-0",node="worker-0",container="postgres"} 10 1601553600000
+container_cpu_usage_seconds_total{namespace="db",pod="postgres-0",node="worker-0",container="exporter"} 2 1601553600000
+container_cpu_usage_seconds_total{namespace="db",pod="postgres-0",node="worker-0",container="POD"} 1 1601553600000
+container_cpu_usage_seconds_total{namespace="db",pod="postgres-0",node="worker-0",container=""} 12 1601553600000
+container_cpu_usage_seconds_total{namespace="app",pod="postgres-0",node="worker-1",container="postgres"} 5 1601553600000
+# TYPE container_memory_usage_bytes untyped
+container_memory_usage_bytes{namespace="db",pod="postgres-0",node="worker-0",container="postgres"} 1024 1601553600000
+container_memory_usage_bytes{namespace="app",pod="postgres-0",node="worker-1",container="postgres"} 2048 1601553600000
+# TYPE kube_pod_owner untyped
+kube_pod_owner{namespace="db",pod="postgres-0",owner_kind="StatefulSet",owner_name="postgres"} 1 1601553600000
+`
+
+func TestScrape(t *testing.T) {
+	var matches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != federatePath {
+			http.NotFound(w, r)
+			return
+		}
+		matches = r.URL.Query()["match[]"]
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(federated))
+	}))
+	defer srv.Close()
+	client, err := promapi.NewClient(promapi.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matchers := []top.Matcher{{Name: "image", Type: top.MatchNotEqual, Value: ""}}
+	vectors, err := Scrape(context.Background(), client, matchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`container_cpu_usage_seconds_total{pod!="",image!=""}`,
+		`container_memory_usage_bytes{pod!="",image!=""}`,
+		`kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"}`,
+	}
+	sort.Strings(matches)
+	if len(matches) != len(want) {
+		t.Fatalf("got match[] %q, want %q", matches, want)
+	}
+	for i := range want {
+		if matches[i] != want[i] {
+			t.Errorf("got match[] %q, want %q", matches[i], want[i])
+		}
+	}
+
+	for metric, value := range map[string]model.SampleValue{top.CPUMetric: 12, top.MemoryMetric: 1024} {
+		v := vectors[metric]
+		if len(v) != 1 {
+			t.Fatalf("%s: got %v, want only db/postgres-0", metric, v)
+		}
+		wantMetric := model.Metric{"namespace": "db", "pod": "postgres-0", "node": "worker-0", "owner_name": "postgres"}
+		if !v[0].Metric.Equal(wantMetric) || v[0].Value != value {
+			t.Errorf("%s: got %v, want %v => %v", metric, v[0], wantMetric, value)
+		}
+	}
+}
+
+func TestScrapeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "federation disabled", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	client, err := promapi.NewClient(promapi.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Scrape(context.Background(), client, nil); err == nil {
+		t.Error("got no error for a 403 response")
+	}
+}
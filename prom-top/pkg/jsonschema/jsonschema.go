@@ -0,0 +1,234 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonschema generates JSON Schemas from Go types and validates JSON documents against them.  Only the
+// subset of the draft-07 vocabulary needed to describe encoding/json output of plain structs is supported: type,
+// properties, required, additionalProperties, and items.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Draft is the $schema of generated schemas.
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema as decoded by encoding/json.
+type Schema map[string]interface{}
+
+// Generate returns the schema of the JSON encoding of values of v's type.  Struct fields are named by their json
+// tags and required unless tagged omitempty.  Properties not declared by the type are rejected.  Pointers may be
+// null.
+func Generate(title string, v interface{}) Schema {
+	s := generate(reflect.TypeOf(v))
+	s["$schema"] = Draft
+	s["title"] = title
+	return s
+}
+
+func generate(t reflect.Type) Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := generate(t.Elem())
+		if typ, ok := s["type"]; ok {
+			s["type"] = []interface{}{typ, "null"}
+		}
+		return s
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": generate(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": generate(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		var required []interface{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name, omitempty := f.Name, false
+			if tag, ok := f.Tag.Lookup("json"); ok {
+				parts := strings.Split(tag, ",")
+				if parts[0] == "-" && len(parts) == 1 {
+					continue
+				}
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				for _, opt := range parts[1:] {
+					omitempty = omitempty || opt == "omitempty"
+				}
+			}
+			properties[name] = generate(f.Type)
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		s := Schema{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// e.g. interfaces, whose encoding is not known from the type
+	return Schema{}
+}
+
+// Validate decodes every JSON value of r, a single document or JSON Lines, and checks it against s.  It returns
+// the problems found, each prefixed by the index of its document, counted from 1, and the JSON pointer of the
+// offending value.  An error is returned only if r is not valid JSON.
+func (s Schema) Validate(r io.Reader) ([]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var problems []string
+	for n := 1; ; n++ {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return problems, nil
+		}
+		if err != nil {
+			return problems, fmt.Errorf("document %d: %v", n, err)
+		}
+		for _, p := range s.check(doc, "") {
+			problems = append(problems, fmt.Sprintf("document %d: %s", n, p))
+		}
+	}
+}
+
+// check returns the problems of v, found at pointer.
+func (s Schema) check(v interface{}, pointer string) []string {
+	at := pointer
+	if at == "" {
+		at = "/"
+	}
+	if want := s.types(); len(want) > 0 && !hasType(v, want) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", at, strings.Join(want, " or "), typeOf(v))}
+	}
+	var problems []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		properties := asSchema(s["properties"])
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := v[fmt.Sprint(name)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required property %q", at, name))
+				}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := pointer + "/" + escape(name)
+			if p, ok := properties[name]; ok {
+				problems = append(problems, asSchema(p).check(v[name], child)...)
+				continue
+			}
+			if allowed, ok := s["additionalProperties"].(bool); ok {
+				if !allowed {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property", child))
+				}
+			} else if additional, ok := s["additionalProperties"]; ok {
+				problems = append(problems, asSchema(additional).check(v[name], child)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				problems = append(problems, asSchema(items).check(item, fmt.Sprintf("%s/%d", pointer, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+func asSchema(v interface{}) Schema {
+	switch s := v.(type) {
+	case Schema:
+		return s
+	case map[string]interface{}:
+		return s
+	}
+	return Schema{}
+}
+
+// types returns the types s allows, given by its type keyword as a single type or a list of them.
+func (s Schema) types() []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, typ := range t {
+			types = append(types, fmt.Sprint(typ))
+		}
+		return types
+	}
+	return nil
+}
+
+func hasType(v interface{}, want []string) bool {
+	got := typeOf(v)
+	for _, w := range want {
+		if got == w || (w == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// escape encodes name as a JSON pointer reference token.
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
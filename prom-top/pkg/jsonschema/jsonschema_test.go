@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type pod struct {
+	Name     string            `json:"name"`
+	Node     *string           `json:"node"`
+	CPU      float64           `json:"cpu"`
+	Restarts int               `json:"restarts,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Ignored  string            `json:"-"`
+	Dash     string            `json:"-,"`
+	Untagged bool
+	internal int
+}
+
+type document struct {
+	Cluster string `json:"cluster"`
+	Pods    []pod  `json:"pods"`
+}
+
+func TestGenerate(t *testing.T) {
+	got := Generate("pod", pod{})
+	want := Schema{
+		"$schema": Draft,
+		"title":   "pod",
+		"type":    "object",
+		"properties": Schema{
+			"name":     Schema{"type": "string"},
+			"node":     Schema{"type": []interface{}{"string", "null"}},
+			"cpu":      Schema{"type": "number"},
+			"restarts": Schema{"type": "integer"},
+			"labels":   Schema{"type": "object", "additionalProperties": Schema{"type": "string"}},
+			"-":        Schema{"type": "string"},
+			"Untagged": Schema{"type": "boolean"},
+		},
+		"required":             []interface{}{"name", "node", "cpu", "-", "Untagged"},
+		"additionalProperties": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got schema\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// schemas are read back from their JSON encoding, e.g. document.schema.json
+	b, err := json.Marshal(Generate("document", document{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	for _, schema := range []Schema{Generate("document", document{}), s} {
+		problems, err := schema.Validate(strings.NewReader(`
+{"cluster": "prod", "pods": [{"name": "etcd-0", "node": null, "cpu": 1, "-": "", "Untagged": true}]}
+{"cluster": "prod", "pods": [{"name": "etcd-0", "node": "master-0", "cpu": "1", "-": "", "Untagged": true,
+	"labels": {"a/b": 1}, "extra": 1}]}
+{"pods": null}
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			`document 2: /pods/0/cpu: expected number, got string`,
+			`document 2: /pods/0/extra: unexpected property`,
+			`document 2: /pods/0/labels/a~1b: expected string, got integer`,
+			`document 3: /: missing required property "cluster"`,
+			`document 3: /pods: expected array, got null`,
+		}
+		if !reflect.DeepEqual(problems, want) {
+			t.Errorf("got problems\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	if _, err := Generate("document", document{}).Validate(strings.NewReader(`{"cluster": `)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metricsserver reads an instant snapshot of pod resource usage from the Kubernetes metrics.k8s.io API,
+// served by metrics-server, for clusters where prometheus is unavailable.
+package metricsserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Range is recorded in the range column of snapshot results in place of a query range, marking them as instant
+// values read from metrics-server rather than aggregations computed by prometheus.
+const Range = "metrics-server"
+
+const podMetricsPath = "/apis/metrics.k8s.io/v1beta1/pods"
+
+// podMetricsList is the subset of metrics.k8s.io/v1beta1 PodMetricsList read.
+type podMetricsList struct {
+	Items []struct {
+		Metadata   metav1.ObjectMeta `json:"metadata"`
+		Timestamp  metav1.Time       `json:"timestamp"`
+		Containers []struct {
+			Usage corev1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// ownerKinds are the controllers recorded as a pod's owner, as by the prometheus queries.
+var ownerKinds = map[string]bool{"ReplicaSet": true, "DaemonSet": true, "StatefulSet": true, "ReplicationController": true}
+
+// Snapshot returns the current cpu and memory usage of every pod, in the metrics and units of the prometheus
+// queries.  metrics-server keeps only the latest sample, so only InstValue is known: the other aggregations are NaN
+// and Range is set to Range.  Node, owner, and request are read from the pods' specs.
+func Snapshot(ctx context.Context, kc kubernetes.Interface) (top.PodMetricTable, error) {
+	raw, err := kc.Discovery().RESTClient().Get().AbsPath(podMetricsPath).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s, is metrics-server installed?: %v", podMetricsPath, err)
+	}
+	var usage podMetricsList
+	if err = json.Unmarshal(raw, &usage); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", podMetricsPath, err)
+	}
+	podList, err := kc.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %v", err)
+	}
+	pods := make(map[string]*corev1.Pod, len(podList.Items))
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		pods[p.Namespace+"/"+p.Name] = p
+	}
+
+	table := make(top.PodMetricTable, 0, 2*len(usage.Items))
+	for _, item := range usage.Items {
+		var cpu, memory float64
+		for _, c := range item.Containers {
+			cpu += float64(c.Usage.Cpu().MilliValue()) / 1000
+			memory += float64(c.Usage.Memory().Value())
+		}
+		var node, owner string
+		var cpuRequest, memoryRequest float64
+		if p, ok := pods[item.Metadata.Namespace+"/"+item.Metadata.Name]; ok {
+			node = p.Spec.NodeName
+			for _, ref := range p.OwnerReferences {
+				if ownerKinds[ref.Kind] {
+					owner = ref.Name
+				}
+			}
+			for _, c := range p.Spec.Containers {
+				cpuRequest += float64(c.Resources.Requests.Cpu().MilliValue()) / 1000
+				memoryRequest += float64(c.Resources.Requests.Memory().Value())
+			}
+		}
+		for _, v := range []struct {
+			metric         string
+			value, request float64
+		}{
+			{top.CPUMetric, cpu, cpuRequest},
+			{top.MemoryMetric, memory, memoryRequest},
+		} {
+			table = append(table, &top.PodMetric{
+				Metric:    v.metric,
+				Range:     Range,
+				Pod:       item.Metadata.Name,
+				Namespace: item.Metadata.Namespace,
+				Node:      node,
+				OwnerName: owner,
+				Workload:  top.Workload(item.Metadata.Name),
+				QueryTime: item.Timestamp.Format(dbhandler.TimestampFormat),
+				Q95Value:  math.NaN(),
+				AvgValue:  math.NaN(),
+				MaxValue:  math.NaN(),
+				MinValue:  math.NaN(),
+				InstValue: v.value,
+				Request:   v.request,
+			})
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package parquet writes flat tables as Apache Parquet files: a single row group of required, uncompressed, PLAIN
+// encoded columns, which every Parquet reader understands.  It implements just what exporting caliper results needs.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is the type of a column.
+type Type int32
+
+// Column types, valued as their Parquet physical types.
+const (
+	Boolean Type = 0
+	Int64   Type = 2
+	Double  Type = 5
+	// String is a BYTE_ARRAY annotated as UTF-8.
+	String Type = 6
+)
+
+// Column describes a column of the file.
+type Column struct {
+	Name string
+	Type Type
+}
+
+const magic = "PAR1"
+
+// Parquet enum values
+const (
+	required        = 0
+	utf8            = 0
+	plainEncoding   = 0
+	rleEncoding     = 3
+	uncompressed    = 0
+	dataPage        = 0
+	formatVersion   = 1
+	rootElementName = "schema"
+)
+
+// Write writes a Parquet file of nrows rows to w.  value returns the value of the row'th row in the col'th column,
+// a bool, int64, float64, or string as given by the column's Type.
+func Write(w io.Writer, columns []Column, nrows int, value func(row, col int) interface{}) error {
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, magic); err != nil {
+		return err
+	}
+	chunks := make([]chunk, len(columns))
+	var total int64
+	for c, col := range columns {
+		data, err := encodeColumn(col, nrows, func(row int) interface{} { return value(row, c) })
+		if err != nil {
+			return err
+		}
+		header := pageHeader(nrows, len(data))
+		chunks[c] = chunk{column: col, offset: cw.n, size: int64(len(header) + len(data))}
+		total += chunks[c].size
+		if _, err = cw.Write(header); err != nil {
+			return err
+		}
+		if _, err = cw.Write(data); err != nil {
+			return err
+		}
+	}
+	footer := fileMetaData(columns, chunks, nrows, total)
+	if _, err := cw.Write(footer); err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	if _, err := cw.Write(length); err != nil {
+		return err
+	}
+	_, err := io.WriteString(cw, magic)
+	return err
+}
+
+// chunk locates the data page of a column.
+type chunk struct {
+	column Column
+	offset int64
+	size   int64
+}
+
+// encodeColumn returns the PLAIN encoding of a column's values.  Required columns have no definition or repetition
+// levels, so this is the entire page.
+func encodeColumn(col Column, nrows int, value func(row int) interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var bits []byte
+	if col.Type == Boolean {
+		bits = make([]byte, (nrows+7)/8)
+	}
+	scratch := make([]byte, 8)
+	for row := 0; row < nrows; row++ {
+		v := value(row)
+		ok := false
+		switch col.Type {
+		case Boolean:
+			var b bool
+			if b, ok = v.(bool); ok && b {
+				bits[row/8] |= 1 << uint(row%8)
+			}
+		case Int64:
+			var i int64
+			if i, ok = v.(int64); ok {
+				binary.LittleEndian.PutUint64(scratch, uint64(i))
+				buf.Write(scratch)
+			}
+		case Double:
+			var f float64
+			if f, ok = v.(float64); ok {
+				binary.LittleEndian.PutUint64(scratch, math.Float64bits(f))
+				buf.Write(scratch)
+			}
+		case String:
+			var s string
+			if s, ok = v.(string); ok {
+				binary.LittleEndian.PutUint32(scratch, uint32(len(s)))
+				buf.Write(scratch[:4])
+				buf.WriteString(s)
+			}
+		default:
+			return nil, fmt.Errorf("column %s: unsupported type %d", col.Name, col.Type)
+		}
+		if !ok {
+			return nil, fmt.Errorf("column %s: row %d: unexpected value %T", col.Name, row, v)
+		}
+	}
+	if bits != nil {
+		return bits, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func pageHeader(nrows, size int) []byte {
+	e := newEncoder()
+	e.i32(1, dataPage)
+	e.i32(2, int32(size))
+	e.i32(3, int32(size))
+	e.beginStruct(5)
+	e.i32(1, int32(nrows))
+	e.i32(2, plainEncoding)
+	e.i32(3, rleEncoding)
+	e.i32(4, rleEncoding)
+	e.endStruct()
+	e.stop()
+	return e.bytes()
+}
+
+func fileMetaData(columns []Column, chunks []chunk, nrows int, total int64) []byte {
+	e := newEncoder()
+	e.i32(1, formatVersion)
+	e.list(2, typeStruct, len(columns)+1)
+	e.beginElement()
+	e.binary(4, rootElementName)
+	e.i32(5, int32(len(columns)))
+	e.endStruct()
+	for _, col := range columns {
+		e.beginElement()
+		e.i32(1, int32(col.Type))
+		e.i32(3, required)
+		e.binary(4, col.Name)
+		if col.Type == String {
+			e.i32(6, utf8)
+		}
+		e.endStruct()
+	}
+	e.i64(3, int64(nrows))
+	e.list(4, typeStruct, 1)
+	e.beginElement()
+	e.list(1, typeStruct, len(chunks))
+	for _, c := range chunks {
+		e.beginElement()
+		e.i64(2, c.offset)
+		e.beginStruct(3)
+		e.i32(1, int32(c.column.Type))
+		e.list(2, typeI32, 2)
+		e.varint(plainEncoding)
+		e.varint(rleEncoding)
+		e.list(3, typeBinary, 1)
+		e.rawBinary(c.column.Name)
+		e.i32(4, uncompressed)
+		e.i64(5, int64(nrows))
+		e.i64(6, c.size)
+		e.i64(7, c.size)
+		e.i64(9, c.offset)
+		e.endStruct()
+		e.endStruct()
+	}
+	e.i64(2, total)
+	e.i64(3, int64(nrows))
+	e.endStruct()
+	e.stop()
+	return e.bytes()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// columns and rows are a fixture of every column type, with more rows than a byte of booleans.
+var (
+	columns = []Column{
+		{Name: "pod", Type: String},
+		{Name: "iteration", Type: Int64},
+		{Name: "avg_value", Type: Double},
+		{Name: "partial", Type: Boolean},
+	}
+	rows = [][]interface{}{
+		{"etcd-0", int64(0), .5, false},
+		{"", int64(-1), 0., true},
+		{"dns-default-x7k2p", int64(math.MaxInt64), math.Inf(1), true},
+		{"ünïcödé", int64(math.MinInt64), -2.5e-300, false},
+		{"a", int64(4), 4., false},
+		{"b", int64(5), 5., false},
+		{"c", int64(6), 6., false},
+		{"d", int64(7), 7., true},
+		{"e", int64(8), 8., false},
+		{"f", int64(9), 9., true},
+	}
+)
+
+func write(t *testing.T, columns []Column, rows [][]interface{}) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := Write(buf, columns, len(rows), func(row, col int) interface{} { return rows[row][col] }); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	gotColumns, gotRows := read(t, write(t, columns, rows))
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Errorf("got columns %v, want %v", gotColumns, columns)
+	}
+	if !reflect.DeepEqual(gotRows, rows) {
+		t.Errorf("got rows %v, want %v", gotRows, rows)
+	}
+}
+
+func TestRoundTripWide(t *testing.T) {
+	// more columns than fit the short form of a thrift list header
+	var wide []Column
+	row := []interface{}{}
+	for i := 0; i < 20; i++ {
+		wide = append(wide, Column{Name: fmt.Sprintf("c%d", i), Type: Double})
+		row = append(row, float64(i))
+	}
+	gotColumns, gotRows := read(t, write(t, wide, [][]interface{}{row}))
+	if !reflect.DeepEqual(gotColumns, wide) || !reflect.DeepEqual(gotRows, [][]interface{}{row}) {
+		t.Errorf("got columns %v and rows %v", gotColumns, gotRows)
+	}
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	gotColumns, gotRows := read(t, write(t, columns, nil))
+	if !reflect.DeepEqual(gotColumns, columns) || len(gotRows) != 0 {
+		t.Errorf("got columns %v and rows %v", gotColumns, gotRows)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		col   Column
+		value interface{}
+	}{
+		{"mistyped", Column{Name: "iteration", Type: Int64}, 1},
+		{"unsupported type", Column{Name: "int96", Type: 3}, int64(1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Write(ioutil.Discard, []Column{tt.col}, 1, func(int, int) interface{} { return tt.value })
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestGolden compares the file written of the fixture to testdata/golden.parquet, so that any change of the encoding
+// is noticed.  Run the tests with -update to rewrite it after an intended change, and check that a reference reader
+// still reads the fixture from it, e.g. pyarrow:
+//
+//	python3 -c 'import pyarrow.parquet as pq; print(pq.read_table("testdata/golden.parquet").to_pydict())'
+func TestGolden(t *testing.T) {
+	got := write(t, columns, rows)
+	path := filepath.Join("testdata", "golden.parquet")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the file written differs from %s, run the tests with -update if the change is intended", path)
+	}
+}
+
+// read decodes a file written by Write following the Parquet format specification, independently of the encoder,
+// and returns its columns and rows.
+func read(t *testing.T, file []byte) ([]Column, [][]interface{}) {
+	t.Helper()
+	n := len(file)
+	if n < 12 || string(file[:4]) != "PAR1" || string(file[n-4:]) != "PAR1" {
+		t.Fatalf("missing the PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[n-8:]))
+	footer := &thriftReader{t: t, b: file[n-8-footerLen : n-8]}
+	meta := footer.readStruct()
+	if footer.pos != footerLen {
+		t.Fatalf("footer of %d bytes, decoded %d", footerLen, footer.pos)
+	}
+
+	// FileMetaData: 1 version, 2 schema, 3 num_rows, 4 row_groups
+	if meta[1] != int64(1) {
+		t.Errorf("got version %v", meta[1])
+	}
+	nrows := int(meta[3].(int64))
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	// SchemaElement: 1 type, 3 repetition_type, 4 name, 5 num_children, 6 converted_type
+	if root[4] != "schema" || root[5] != int64(len(schema)-1) {
+		t.Errorf("got root schema element %v", root)
+	}
+	var columns []Column
+	for _, e := range schema[1:] {
+		element := e.(map[int16]interface{})
+		if element[3] != int64(0) {
+			t.Errorf("column %v is not required", element[4])
+		}
+		col := Column{Name: element[4].(string), Type: Type(element[1].(int64))}
+		if utf8, ok := element[6]; (col.Type == String) != (ok && utf8 == int64(0)) {
+			t.Errorf("column %s: got converted type %v", col.Name, element[6])
+		}
+		columns = append(columns, col)
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("got %d row groups, want 1", len(groups))
+	}
+	// RowGroup: 1 columns, 2 total_byte_size, 3 num_rows
+	group := groups[0].(map[int16]interface{})
+	if group[3] != int64(nrows) {
+		t.Errorf("got %v rows in the row group, %d in the file", group[3], nrows)
+	}
+	chunks := group[1].([]interface{})
+	if len(chunks) != len(columns) {
+		t.Fatalf("got %d column chunks of %d columns", len(chunks), len(columns))
+	}
+	rows := make([][]interface{}, nrows)
+	for i := range rows {
+		rows[i] = make([]interface{}, len(columns))
+	}
+	var total int64
+	for c, chunk := range chunks {
+		// ColumnChunk: 2 file_offset, 3 meta_data
+		// ColumnMetaData: 1 type, 2 encodings, 3 path_in_schema, 4 codec, 5 num_values, 6 total_uncompressed_size,
+		// 7 total_compressed_size, 9 data_page_offset
+		md := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		col := columns[c]
+		if md[1] != int64(col.Type) || !reflect.DeepEqual(md[3], []interface{}{col.Name}) || md[4] != int64(0) ||
+			md[5] != int64(nrows) {
+			t.Errorf("column %s: got metadata %v", col.Name, md)
+		}
+		size := md[7].(int64)
+		total += size
+		offset := int(md[9].(int64))
+		page := &thriftReader{t: t, b: file[offset : offset+int(size)]}
+		// PageHeader: 1 type, 2 uncompressed_page_size, 3 compressed_page_size, 5 data_page_header
+		// DataPageHeader: 1 num_values, 2 encoding
+		header := page.readStruct()
+		dataHeader := header[5].(map[int16]interface{})
+		if header[1] != int64(0) || dataHeader[1] != int64(nrows) || dataHeader[2] != int64(0) {
+			t.Errorf("column %s: got page header %v", col.Name, header)
+		}
+		data := page.b[page.pos:]
+		if int64(len(data)) != header[3].(int64) {
+			t.Fatalf("column %s: page of %d bytes, header says %v", col.Name, len(data), header[3])
+		}
+		for row := 0; row < nrows; row++ {
+			switch col.Type {
+			case Boolean:
+				rows[row][c] = data[row/8]&(1<<uint(row%8)) != 0
+			case Int64:
+				rows[row][c] = int64(binary.LittleEndian.Uint64(data))
+				data = data[8:]
+			case Double:
+				rows[row][c] = math.Float64frombits(binary.LittleEndian.Uint64(data))
+				data = data[8:]
+			case String:
+				l := binary.LittleEndian.Uint32(data)
+				rows[row][c] = string(data[4 : 4+l])
+				data = data[4+l:]
+			}
+		}
+	}
+	if group[2] != total {
+		t.Errorf("got total byte size %v of the row group, its chunks total %d", group[2], total)
+	}
+	return columns, rows
+}
+
+// thriftReader decodes the Thrift compact protocol.  Structs are decoded as maps of field id to value, integers as
+// int64, and binaries as strings.
+type thriftReader struct {
+	t   *testing.T
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.t.Fatalf("thrift: unexpected end of input")
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("thrift: malformed varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return fields
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.readValue(b & 0x0f)
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:]))
+	case 8:
+		l := int(r.uvarint())
+		r.pos += l
+		return string(r.b[r.pos-l : r.pos])
+	case 9:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.readValue(header & 0x0f)
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	default:
+		r.t.Fatalf("thrift: unsupported type %d at %d", typ, r.pos)
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol field types
+const (
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// encoder serializes the Parquet metadata structures with the Thrift compact protocol.
+type encoder struct {
+	buf bytes.Buffer
+	// last holds the id of the last field written to each open struct, field ids being delta encoded.
+	last []int16
+}
+
+func newEncoder() *encoder {
+	return &encoder{last: []int16{0}}
+}
+
+func (e *encoder) bytes() []byte {
+	return e.buf.Bytes()
+}
+
+func (e *encoder) field(id int16, typ byte) {
+	top := len(e.last) - 1
+	if delta := id - e.last[top]; delta > 0 && delta <= 15 {
+		e.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		e.buf.WriteByte(typ)
+		e.varint(int64(id))
+	}
+	e.last[top] = id
+}
+
+// varint writes a zigzag encoded integer.
+func (e *encoder) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+func (e *encoder) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (e *encoder) i32(id int16, v int32) {
+	e.field(id, typeI32)
+	e.varint(int64(v))
+}
+
+func (e *encoder) i64(id int16, v int64) {
+	e.field(id, typeI64)
+	e.varint(v)
+}
+
+func (e *encoder) binary(id int16, s string) {
+	e.field(id, typeBinary)
+	e.rawBinary(s)
+}
+
+// rawBinary writes a string without a field header, e.g. as a list element.
+func (e *encoder) rawBinary(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+// list writes the header of a list of n elements of type elem, which must follow.
+func (e *encoder) list(id int16, elem byte, n int) {
+	e.field(id, typeList)
+	if n < 15 {
+		e.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	e.buf.WriteByte(0xf0 | elem)
+	e.uvarint(uint64(n))
+}
+
+// beginStruct opens a struct field, closed by endStruct.
+func (e *encoder) beginStruct(id int16) {
+	e.field(id, typeStruct)
+	e.beginElement()
+}
+
+// beginElement opens a struct list element, closed by endStruct.
+func (e *encoder) beginElement() {
+	e.last = append(e.last, 0)
+}
+
+func (e *encoder) endStruct() {
+	e.stop()
+	e.last = e.last[:len(e.last)-1]
+}
+
+// stop ends the current struct.
+func (e *encoder) stop() {
+	e.buf.WriteByte(0)
+}
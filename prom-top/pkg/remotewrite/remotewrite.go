@@ -0,0 +1,235 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package remotewrite sends PodMetric aggregates to a Prometheus remote_write endpoint, e.g. Mimir, Thanos Receive,
+// or Grafana Cloud.  Each aggregation of each metric becomes a sample of a series named
+// <prefix>_<metric>_<aggregation>, e.g. caliper_cpu_usage_ratio_q95, labeled with the pod, its component when its
+// containers were split, namespace, owner, node, range, version, cluster, pod labels joined with --pod-label, and
+// custom labels of the run, and stamped with the query time of the run.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+const (
+	// DefaultPrefix namespaces the series names when Config.Prefix is empty.
+	DefaultPrefix = "caliper"
+	// MaxSeriesPerRequest bounds the size of a single request, in line with the batches sent by Prometheus itself.
+	MaxSeriesPerRequest = 2000
+)
+
+// Config describes the endpoint and the series written to it.
+type Config struct {
+	URL string
+	// Token (optional) is sent as a bearer token in the Authorization header.
+	Token string
+	// Prefix defaults to DefaultPrefix.
+	Prefix string
+	// Labels are added to every series, e.g. "env=ci".  They must not be named as the labels of each row.
+	Labels []string
+}
+
+// reserved are the names of the labels each series already has.
+var reserved = map[string]bool{
+	"__name__": true, "pod": true, "namespace": true, "owner_name": true, "node": true, "range": true,
+	"version": true, "cluster": true, "component": true,
+}
+
+// Validate reports missing or malformed settings.
+func (c Config) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("missing URL")
+	}
+	seen := make(map[string]bool, len(c.Labels))
+	for _, l := range c.Labels {
+		name, _, err := splitLabel(l)
+		if err != nil {
+			return err
+		}
+		name = sanitize(name)
+		switch {
+		case reserved[name] || strings.HasPrefix(name, "__"):
+			return fmt.Errorf("label %s is reserved, choose another name", name)
+		case seen[name]:
+			return fmt.Errorf("label %s is given more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func splitLabel(l string) (name, value string, err error) {
+	i := strings.Index(l, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("label %q is not of the form name=value", l)
+	}
+	return l[:i], l[i+1:], nil
+}
+
+// Client writes series to a single endpoint.
+type Client struct {
+	cfg    Config
+	labels []label
+	http   *http.Client
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	c := &Client{cfg: cfg, http: &http.Client{Timeout: time.Minute}}
+	for _, l := range cfg.Labels {
+		name, value, _ := splitLabel(l)
+		c.labels = append(c.labels, label{sanitize(name), value})
+	}
+	return c, nil
+}
+
+// series expands each PodMetric into one single-sample series per aggregation.  It returns an error if a series
+// would have a label twice, e.g. a custom label of the run named as one of Config.Labels, which receivers reject.
+func (c *Client) series(metrics top.PodMetricTable) ([]timeSeries, error) {
+	var all []timeSeries
+	for _, m := range metrics {
+		ts, err := time.ParseInLocation(dbhandler.TimestampFormat, m.QueryTime, time.Local)
+		if err != nil {
+			ts = time.Now()
+		}
+		labels := append([]label{
+			{"pod", m.Pod},
+			{"namespace", m.Namespace},
+			{"owner_name", m.OwnerName},
+			{"node", m.Node},
+			{"range", m.Range},
+			{"version", m.Version},
+			// the components of a split pod are otherwise the same series
+			{"component", m.Component},
+		}, c.labels...)
+		if m.Cluster != "" {
+			labels = append(labels, label{"cluster", m.Cluster})
+		}
+		for _, name := range m.PodLabels.Names() {
+			labels = append(labels, label{sanitize(name), m.PodLabels[name]})
+		}
+		for _, name := range m.Labels.Names() {
+			labels = append(labels, label{sanitize(name), m.Labels[name]})
+		}
+		for _, v := range []struct {
+			agg   top.Aggregation
+			value float64
+		}{
+			{top.Average, m.AvgValue},
+			{top.Maximum, m.MaxValue},
+			{top.Minimum, m.MinValue},
+			{top.Quantile95, m.Q95Value},
+			{top.Instant, m.InstValue},
+		} {
+			s := timeSeries{
+				labels: append([]label{
+					{"__name__", sanitize(fmt.Sprintf("%s_%s_%s", c.cfg.Prefix, m.Metric, v.agg))},
+				}, labels...),
+				value:     v.value,
+				timestamp: ts.UnixNano() / int64(time.Millisecond),
+			}
+			// Receivers require the labels of a series sorted by name, and reject empty values.
+			sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+			s.labels = dropEmpty(s.labels)
+			for i := 1; i < len(s.labels); i++ {
+				if s.labels[i].name == s.labels[i-1].name {
+					return nil, fmt.Errorf("series of %s/%s has label %s twice", m.Namespace, m.Pod, s.labels[i].name)
+				}
+			}
+			all = append(all, s)
+		}
+	}
+	return all, nil
+}
+
+func dropEmpty(labels []label) []label {
+	kept := labels[:0]
+	for _, l := range labels {
+		if l.value != "" {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// sanitize replaces the characters that are not allowed in metric and label names with underscores.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Write sends every aggregate of metrics, MaxSeriesPerRequest series per request.
+func (c *Client) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	all, err := c.series(metrics)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(all); start += MaxSeriesPerRequest {
+		end := start + MaxSeriesPerRequest
+		if end > len(all) {
+			end = len(all)
+		}
+		if err := c.send(ctx, all[start:end]); err != nil {
+			return fmt.Errorf("series %d-%d failed, %d previously written: %v", start, end-1, start, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, s []timeSeries) error {
+	body := snappyEncode(marshalWriteRequest(s))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %s: %s", c.cfg.URL, resp.Status, b)
+	}
+	return nil
+}
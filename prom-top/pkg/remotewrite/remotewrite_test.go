@@ -0,0 +1,235 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// decodeWriteRequest is an independent decoder of the messages marshalWriteRequest encodes.
+func decodeWriteRequest(t *testing.T, b []byte) []timeSeries {
+	t.Helper()
+	var series []timeSeries
+	fields(t, b, func(num protowire.Number, typ protowire.Type, v []byte) {
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d", num)
+		}
+		var s timeSeries
+		fields(t, v, func(num protowire.Number, typ protowire.Type, v []byte) {
+			switch num {
+			case 1:
+				var l label
+				fields(t, v, func(num protowire.Number, typ protowire.Type, v []byte) {
+					switch num {
+					case 1:
+						l.name = string(v)
+					case 2:
+						l.value = string(v)
+					}
+				})
+				s.labels = append(s.labels, l)
+			case 2:
+				fields(t, v, func(num protowire.Number, typ protowire.Type, v []byte) {
+					switch num {
+					case 1:
+						bits, _ := protowire.ConsumeFixed64(v)
+						s.value = math.Float64frombits(bits)
+					case 2:
+						ts, _ := protowire.ConsumeVarint(v)
+						s.timestamp = int64(ts)
+					}
+				})
+			}
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+// fields calls f with each field of message b, the raw bytes of fixed64 and varint fields, the contents of the
+// length-delimited ones.
+func fields(t *testing.T, b []byte, f func(protowire.Number, protowire.Type, []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			v = b[:n]
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		f(num, typ, v)
+		b = b[n:]
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("got headers %v", r.Header)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{URL: srv.URL, Token: "tok", Labels: []string{"env=ci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := top.PodMetricTable{
+		{Metric: top.CPUMetric, Namespace: "ns", Pod: "app-1", Node: "worker-0", Range: "10m", Version: "4.6",
+			QueryTime: "2020-10-01 12:00:00", Component: "istio-proxy", PodLabels: dbhandler.Labels{"label_app": "web"},
+			Labels: dbhandler.Labels{"scenario": "soak"}, AvgValue: .5, MaxValue: 1, MinValue: .25, Q95Value: .75,
+			InstValue: math.NaN()},
+	}
+	if err := c.Write(context.Background(), metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(bodies))
+	}
+	decoded, err := snappy.Decode(nil, bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeWriteRequest(t, decoded)
+	want, err := c.series(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || len(got) != 5 {
+		t.Fatalf("got %d series, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !sameSeries(got[i], want[i]) {
+			t.Errorf("series %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	first := got[0]
+	wantLabels := []label{{"__name__", "caliper_cpu_usage_ratio_avg"}, {"component", "istio-proxy"}, {"env", "ci"},
+		{"label_app", "web"}, {"namespace", "ns"}, {"node", "worker-0"}, {"pod", "app-1"}, {"range", "10m"},
+		{"scenario", "soak"}, {"version", "4.6"}}
+	if len(first.labels) != len(wantLabels) {
+		t.Fatalf("got labels %v, want %v", first.labels, wantLabels)
+	}
+	for i := range wantLabels {
+		if first.labels[i] != wantLabels[i] {
+			t.Errorf("got labels %v, want %v", first.labels, wantLabels)
+			break
+		}
+	}
+	if first.value != .5 || first.timestamp != 1601553600000-localOffset(t) {
+		t.Errorf("got sample %v @ %d", first.value, first.timestamp)
+	}
+}
+
+func sameSeries(a, b timeSeries) bool {
+	if len(a.labels) != len(b.labels) || a.timestamp != b.timestamp {
+		return false
+	}
+	for i := range a.labels {
+		if a.labels[i] != b.labels[i] {
+			return false
+		}
+	}
+	return a.value == b.value || math.IsNaN(a.value) && math.IsNaN(b.value)
+}
+
+// localOffset is the offset of the local time zone, in which query times are written, in milliseconds.
+func localOffset(t *testing.T) int64 {
+	ts, err := time.ParseInLocation(dbhandler.TimestampFormat, "2020-10-01 12:00:00", time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, offset := ts.Zone()
+	return int64(offset) * 1000
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, maxLiteral, maxLiteral + 1, 3*maxLiteral + 7} {
+		src := bytes.Repeat([]byte("caliper"), n/7+1)[:n]
+		got, err := snappy.Decode(nil, snappyEncode(src))
+		if err != nil {
+			t.Errorf("%d bytes: %v", n, err)
+			continue
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: decoded %d different bytes", n, len(got))
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		labels []string
+		err    string
+	}{
+		{[]string{"env=ci", "team=perf"}, ""},
+		{[]string{"env"}, "not of the form"},
+		{[]string{"pod=x"}, "pod is reserved"},
+		{[]string{"__name__=x"}, "__name__ is reserved"},
+		{[]string{"__meta=x"}, "__meta is reserved"},
+		{[]string{"env=ci", "env=prod"}, "more than once"},
+	}
+	for _, test := range tests {
+		err := Config{URL: "http://receiver", Labels: test.labels}.Validate()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%v: %v", test.labels, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%v: got %v, want an error containing %q", test.labels, err, test.err)
+		}
+	}
+}
+
+func TestSeriesDuplicateLabel(t *testing.T) {
+	c, err := NewClient(Config{URL: "http://receiver", Labels: []string{"env=ci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := top.PodMetricTable{{Metric: top.CPUMetric, Namespace: "ns", Pod: "app-1",
+		Labels: dbhandler.Labels{"env": "prod"}}}
+	if _, err := c.series(metrics); err == nil || !strings.Contains(err.Error(), "label env twice") {
+		t.Errorf("got %v, want an error about the duplicate env label", err)
+	}
+}
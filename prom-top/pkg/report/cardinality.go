@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteCardinality writes the cardinality of prometheus' head block to w as aligned tables, one per contributor
+// list.
+func WriteCardinality(w io.Writer, status *top.TSDBStatus) error {
+	tw := newTabWriter(w)
+	if h := status.HeadStats; h != nil {
+		fmt.Fprintln(tw, "HEAD BLOCK")
+		fmt.Fprintln(tw, "series\tlabel pairs\tchunks\tfrom\tto\t")
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t\n", h.NumSeries, h.NumLabelPairs, h.ChunkCount,
+			formatMillis(h.MinTime), formatMillis(h.MaxTime))
+		fmt.Fprintln(tw)
+	}
+	sections := []struct {
+		title, name, value string
+		stats              []top.Stat
+	}{
+		{"SERIES BY METRIC", "metric", "series", status.SeriesCountByMetricName},
+		{"VALUES BY LABEL", "label", "values", status.LabelValueCountByLabelName},
+		{"MEMORY BY LABEL", "label", "bytes", status.MemoryInBytesByLabelName},
+		{"SERIES BY LABEL PAIR", "label pair", "series", status.SeriesCountByLabelValuePair},
+	}
+	for i, s := range sections {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintln(tw, s.title)
+		fmt.Fprintf(tw, "%s\t%s\t\n", s.name, s.value)
+		for _, stat := range s.stats {
+			fmt.Fprintf(tw, "%s\t%d\t\n", stat.Name, stat.Value)
+		}
+	}
+	return tw.Flush()
+}
+
+func formatMillis(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
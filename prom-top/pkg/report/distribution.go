@@ -0,0 +1,37 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteDistributions writes the distributions of agg across the pods of each app to w as an aligned table.
+func WriteDistributions(w io.Writer, agg top.Aggregation, dists []top.AppDistribution) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "DISTRIBUTION OF %s ACROSS PODS (%d apps)\n", strings.ToUpper(string(agg)), len(dists))
+	fmt.Fprintln(tw, "namespace\tlabel-app\tmetric\tpods\tmin\tp50\tp95\tmax\t")
+	for _, d := range dists {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n", d.Namespace, d.App, d.Metric, d.Pods,
+			top.FormatValue(d.Min), top.FormatValue(d.P50), top.FormatValue(d.P95), top.FormatValue(d.Max))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Idle returns the apps none of whose pods' q95 CPU usage reaches cpuFloor cores nor q95 memory usage reaches
+// memoryFloor bytes.  These are candidates for removal or downsizing.
+func Idle(t top.PodMetricTable, cpuFloor, memoryFloor float64) []Workload {
+	var idle []Workload
+	for _, w := range workloads(t) {
+		if w.CPU < cpuFloor && w.Memory < memoryFloor {
+			idle = append(idle, *w)
+		}
+	}
+	return idle
+}
+
+// WriteIdle writes the idle apps to w as an aligned table.
+func WriteIdle(w io.Writer, idle []Workload) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "IDLE APPS (%d)\n", len(idle))
+	fmt.Fprintln(tw, "namespace\tlabel-app\tpods\tq95 cpu\tq95 memory\t")
+	for _, wl := range idle {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t\n", wl.Namespace, wl.App, wl.Pods, top.FormatValue(wl.CPU), top.FormatValue(wl.Memory))
+	}
+	return tw.Flush()
+}
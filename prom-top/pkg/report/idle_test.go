@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestIdle(t *testing.T) {
+	table := top.PodMetricTable{
+		// idle, though split over two pods
+		{Metric: top.CPUMetric, Namespace: "app", Pod: "web-0", OwnerName: "web", Q95Value: .001},
+		{Metric: top.MemoryMetric, Namespace: "app", Pod: "web-0", OwnerName: "web", Q95Value: 1e6},
+		{Metric: top.CPUMetric, Namespace: "app", Pod: "web-1", OwnerName: "web", Q95Value: .002},
+		{Metric: top.MemoryMetric, Namespace: "app", Pod: "web-1", OwnerName: "web", Q95Value: 2e6},
+		// busy on memory only
+		{Metric: top.CPUMetric, Namespace: "app", Pod: "cache-0", OwnerName: "cache", Q95Value: .001},
+		{Metric: top.MemoryMetric, Namespace: "app", Pod: "cache-0", OwnerName: "cache", Q95Value: 1e9},
+		// one busy pod keeps the app from being idle
+		{Metric: top.CPUMetric, Namespace: "app", Pod: "worker-0", OwnerName: "worker", Q95Value: .001},
+		{Metric: top.CPUMetric, Namespace: "app", Pod: "worker-1", OwnerName: "worker", Q95Value: 2},
+		// unowned pods are their own app
+		{Metric: top.CPUMetric, Namespace: "debug", Pod: "shell", Q95Value: 0},
+	}
+	idle := Idle(table, .01, 1e8)
+	want := []Workload{
+		{Namespace: "app", App: "web", Pods: 2, CPU: .002, Memory: 2e6},
+		{Namespace: "debug", App: "shell", Pods: 1},
+	}
+	if !reflect.DeepEqual(idle, want) {
+		t.Fatalf("got idle apps %+v, want %+v", idle, want)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteIdle(buf, idle); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[0] != "IDLE APPS (2)" || !reflect.DeepEqual(strings.Fields(lines[2]),
+		[]string{"app", "web", "2", top.FormatValue(.002), top.FormatValue(2e6)}) {
+		t.Errorf("got report:\n%s", buf)
+	}
+}
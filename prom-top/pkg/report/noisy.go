@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// NoisyNeighbor is a pod consuming a disproportionate share of its node's usage.
+type NoisyNeighbor struct {
+	Node      string
+	Metric    string
+	Namespace string
+	Pod       string
+	// Usage is the pod's average usage over the range, NodeUsage the sum of the averages of every pod on the node.
+	Usage     float64
+	NodeUsage float64
+	Share     float64
+	// NodePods is the number of pods sharing the node.
+	NodePods int
+}
+
+// NoisyNeighbors returns the pods whose average usage of a metric exceeds share (0-1) of the combined usage of all
+// pods on their node, largest share first.  Nodes running a single pod are skipped, as are rows without a node.
+func NoisyNeighbors(t top.PodMetricTable, share float64) []NoisyNeighbor {
+	type key struct{ node, metric string }
+	byNode := make(map[key][]*top.PodMetric)
+	for _, p := range t {
+		if p.Node == "" {
+			continue
+		}
+		k := key{p.Node, p.Metric}
+		byNode[k] = append(byNode[k], p)
+	}
+	var noisy []NoisyNeighbor
+	for k, pods := range byNode {
+		if len(pods) < 2 {
+			continue
+		}
+		var total float64
+		for _, p := range pods {
+			total += p.AvgValue
+		}
+		if total <= 0 {
+			continue
+		}
+		for _, p := range pods {
+			if s := p.AvgValue / total; s > share {
+				noisy = append(noisy, NoisyNeighbor{
+					Node:      k.node,
+					Metric:    k.metric,
+					Namespace: p.Namespace,
+					Pod:       p.Pod,
+					Usage:     p.AvgValue,
+					NodeUsage: total,
+					Share:     s,
+					NodePods:  len(pods),
+				})
+			}
+		}
+	}
+	sort.Slice(noisy, func(i, j int) bool {
+		if noisy[i].Share != noisy[j].Share {
+			return noisy[i].Share > noisy[j].Share
+		}
+		return noisy[i].Node < noisy[j].Node
+	})
+	return noisy
+}
+
+// WriteNoisyNeighbors writes the noisy neighbors to w as an aligned table.
+func WriteNoisyNeighbors(w io.Writer, noisy []NoisyNeighbor) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "NOISY NEIGHBORS (%d)\n", len(noisy))
+	fmt.Fprintln(tw, "node\tmetric\tnamespace\tpod\tavg\tnode total\tshare\tnode pods\t")
+	for _, n := range noisy {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.1f%%\t%d\t\n",
+			n.Node, n.Metric, n.Namespace, n.Pod, top.FormatValue(n.Usage), top.FormatValue(n.NodeUsage), n.Share*100, n.NodePods)
+	}
+	return tw.Flush()
+}
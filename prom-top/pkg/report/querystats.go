@@ -0,0 +1,40 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteQueryStats writes the execution stats of the queries of a collection to w as an aligned table, in the order
+// given.  The evaluation time and samples loaded reported by prometheus are 0 unless they were requested.  Failed
+// queries, recorded when the collection continues on error, have an error.
+func WriteQueryStats(w io.Writer, stats []top.QueryStat) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "QUERY STATS")
+	fmt.Fprintln(tw, "seconds\teval-seconds\tseries\tsamples-loaded\tcached\tmetric\taggregation\twarnings\terror\tquery\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%.3f\t%.3f\t%d\t%d\t%t\t%s\t%s\t%s\t%s\t%s\t\n", s.Duration.Seconds(), s.Prometheus.EvalTime.Seconds(),
+			s.Samples, s.Prometheus.SamplesLoaded, s.Cached, s.Metric, s.Aggregation, strings.Join(s.Warnings, "; "), s.Error,
+			s.Expr)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteQuotas writes the consumption of each namespace's quotas and the usage of its busiest pod against the pod
+// limit of its LimitRanges to w as aligned tables, in percent of the constraint.
+func WriteQuotas(w io.Writer, quotas []top.NamespaceQuota, pods []top.PodLimit) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "QUOTA CONSUMPTION (%d quotas)\n", len(quotas))
+	fmt.Fprintln(tw, "namespace\tquota\thard\trequested\trequested%\tavg\tavg%\tq95\tq95%\t")
+	for _, q := range quotas {
+		requested, requestedPct := "-", "-"
+		if q.Requested > 0 {
+			requested, requestedPct = top.FormatValue(q.Requested), percent(q.Requested, q.Hard)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", q.Namespace, q.Quota, top.FormatValue(q.Hard),
+			requested, requestedPct, top.FormatValue(q.Average), percent(q.Average, q.Hard),
+			top.FormatValue(q.Q95), percent(q.Q95, q.Hard))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(tw, "POD LIMITS (%d limits)\n", len(pods))
+	fmt.Fprintln(tw, "namespace\tmetric\tpod max\tbusiest pod\tmax\tmax%\t")
+	for _, p := range pods {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", p.Namespace, p.Metric, top.FormatValue(p.Max), p.Pod,
+			top.FormatValue(p.Usage), percent(p.Usage, p.Max))
+	}
+	return tw.Flush()
+}
+
+// percent formats v as a percentage of limit.
+func percent(v, limit float64) string {
+	if limit <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*v/limit)
+}
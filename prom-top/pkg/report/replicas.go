@@ -0,0 +1,38 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteReplicaUsage writes the usage of each app, agg summed over its pods, and per replica to w as an aligned
+// table.
+func WriteReplicaUsage(w io.Writer, agg top.Aggregation, usage []top.AppReplicaUsage) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "%s PER REPLICA (%d apps)\n", strings.ToUpper(string(agg)), len(usage))
+	fmt.Fprintln(tw, "namespace\tlabel-app\tmetric\tpods\treplicas\ttotal\tper replica\t")
+	for _, u := range usage {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t\n", u.Namespace, u.App, u.Metric, u.Pods,
+			top.FormatValue(u.Replicas), top.FormatValue(u.Total), top.FormatValue(u.PerReplica))
+	}
+	return tw.Flush()
+}
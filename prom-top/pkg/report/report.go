@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package report derives human readable analyses from a collection's results, each answering a specific question
+// about the cluster's footprint.
+package report
+
+import (
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Workload is the combined usage of the pods of an app.  Pods without an owner are their own app.
+type Workload struct {
+	Namespace string
+	App       string
+	Pods      int
+	// CPU and Memory are the highest q95 usage of any of the app's pods.
+	CPU    float64
+	Memory float64
+}
+
+// workloads groups the rows of t by app.
+func workloads(t top.PodMetricTable) []*Workload {
+	type key struct{ namespace, app string }
+	apps := make(map[key]*Workload)
+	pods := make(map[key]map[string]bool)
+	for _, p := range t {
+		app := p.OwnerName
+		if app == "" {
+			app = p.Pod
+		}
+		k := key{p.Namespace, app}
+		w, ok := apps[k]
+		if !ok {
+			w = &Workload{Namespace: p.Namespace, App: app}
+			apps[k] = w
+			pods[k] = make(map[string]bool)
+		}
+		pods[k][p.Pod] = true
+		switch p.Metric {
+		case top.CPUMetric:
+			if p.Q95Value > w.CPU {
+				w.CPU = p.Q95Value
+			}
+		case top.MemoryMetric:
+			if p.Q95Value > w.Memory {
+				w.Memory = p.Q95Value
+			}
+		}
+	}
+	result := make([]*Workload, 0, len(apps))
+	for k, w := range apps {
+		w.Pods = len(pods[k])
+		result = append(result, w)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].App < result[j].App
+	})
+	return result
+}
+
+func newTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteSeries writes the series discovered by top.DiscoverSeries to w as an aligned table.
+func WriteSeries(w io.Writer, series []top.SeriesInfo) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "series\tfound\tused by\ttype\thelp\t")
+	for _, s := range series {
+		found := "no"
+		if s.Found {
+			found = "yes"
+		}
+		usedBy := strings.Join(s.Targets, ",")
+		if usedBy == "" {
+			usedBy = "-"
+		}
+		// help texts may span lines
+		help := strings.Join(strings.Fields(s.Help), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", s.Name, found, usedBy, s.Type, help)
+	}
+	return tw.Flush()
+}
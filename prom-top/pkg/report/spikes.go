@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Spike is a pod whose peak usage of a metric is far above its average, along with the events that may explain it.
+type Spike struct {
+	Metric    string
+	Namespace string
+	Pod       string
+	Avg       float64
+	Max       float64
+	// Burstiness is Max / Avg.
+	Burstiness float64
+	Events     []Event
+}
+
+// Event is a Kubernetes event concerning a spiking pod, e.g. an OOM kill or eviction.
+type Event struct {
+	Time    time.Time
+	Reason  string
+	Message string
+	// Count is the number of occurrences the event aggregates.
+	Count int32
+}
+
+// Spikes returns the rows whose burstiness is at least threshold, burstiest first.  Their events are left for the
+// caller to attach.
+func Spikes(t top.PodMetricTable, threshold float64) []Spike {
+	var spikes []Spike
+	for _, p := range t {
+		if p.Burstiness < threshold {
+			continue
+		}
+		spikes = append(spikes, Spike{
+			Metric:     p.Metric,
+			Namespace:  p.Namespace,
+			Pod:        p.Pod,
+			Avg:        p.AvgValue,
+			Max:        p.MaxValue,
+			Burstiness: p.Burstiness,
+		})
+	}
+	sort.Slice(spikes, func(i, j int) bool {
+		if spikes[i].Burstiness != spikes[j].Burstiness {
+			return spikes[i].Burstiness > spikes[j].Burstiness
+		}
+		return spikes[i].Namespace+"/"+spikes[i].Pod < spikes[j].Namespace+"/"+spikes[j].Pod
+	})
+	return spikes
+}
+
+// WriteSpikes writes the spikes to w as an aligned table, followed by the events of each spiking pod, oldest first.
+func WriteSpikes(w io.Writer, spikes []Spike) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "SPIKES (%d)\n", len(spikes))
+	fmt.Fprintln(tw, "metric\tnamespace\tpod\tavg\tmax\tburstiness\tevents\t")
+	for _, s := range spikes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t\n",
+			s.Metric, s.Namespace, s.Pod, top.FormatValue(s.Avg), top.FormatValue(s.Max), top.FormatValue(s.Burstiness), len(s.Events))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	// a pod spiking in several metrics shares its events, list them once
+	listed := make(map[string]bool)
+	for _, s := range spikes {
+		pod := s.Namespace + "/" + s.Pod
+		if len(s.Events) == 0 || listed[pod] {
+			continue
+		}
+		listed[pod] = true
+		if _, err := fmt.Fprintf(w, "\n%s events:\n", pod); err != nil {
+			return err
+		}
+		for _, e := range s.Events {
+			count := ""
+			if e.Count > 1 {
+				count = fmt.Sprintf(" (x%d)", e.Count)
+			}
+			if _, err := fmt.Fprintf(w, "  %s %s%s: %s\n", e.Time.Format(time.RFC3339), e.Reason, count, e.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
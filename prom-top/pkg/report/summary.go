@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Summary describes the size of a collection, so that a suspiciously small result set stands out.
+type Summary struct {
+	// Rows, Pods, Namespaces, and Nodes count the results and the distinct pods, namespaces, and nodes they cover.
+	Rows       int
+	Pods       int
+	Namespaces int
+	Nodes      int
+	// Queries is the number of queries executed, Cached those of them served from the cache.
+	Queries int
+	Cached  int
+	// Series is the number of series the queries returned, and SamplesLoaded the samples prometheus read to evaluate
+	// them, 0 unless reported with --prometheus-stats.
+	Series        int
+	SamplesLoaded int64
+	// Duration is the wall-clock time of the collection.
+	Duration time.Duration
+	Partial  bool
+}
+
+// NewSummary summarizes the results t of a collection that executed queries and took duration.
+func NewSummary(t top.PodMetricTable, queries []top.QueryStat, duration time.Duration) Summary {
+	s := Summary{Rows: len(t), Queries: len(queries), Duration: duration}
+	pods := make(map[string]bool)
+	namespaces := make(map[string]bool)
+	nodes := make(map[string]bool)
+	for _, p := range t {
+		if p.Pod != "" {
+			pods[p.Namespace+"/"+p.Pod] = true
+		}
+		if p.Namespace != "" {
+			namespaces[p.Namespace] = true
+		}
+		if p.Node != "" {
+			nodes[p.Node] = true
+		}
+		s.Partial = s.Partial || p.Partial
+	}
+	s.Pods, s.Namespaces, s.Nodes = len(pods), len(namespaces), len(nodes)
+	for _, q := range queries {
+		if q.Cached {
+			s.Cached++
+		}
+		s.Series += q.Samples
+		s.SamplesLoaded += q.Prometheus.SamplesLoaded
+	}
+	return s
+}
+
+// String returns the summary as a single line, e.g. to log.
+func (s Summary) String() string {
+	line := fmt.Sprintf("%d rows of %d pods in %d namespaces on %d nodes, from %d queries (%d cached) returning %d series",
+		s.Rows, s.Pods, s.Namespaces, s.Nodes, s.Queries, s.Cached, s.Series)
+	if s.SamplesLoaded > 0 {
+		line += fmt.Sprintf(", loading %d samples", s.SamplesLoaded)
+	}
+	line += fmt.Sprintf(", in %s", s.Duration.Round(time.Millisecond))
+	if s.Partial {
+		line += ", partial"
+	}
+	return line
+}
+
+// WriteSummary writes s to w as an aligned table.
+func WriteSummary(w io.Writer, s Summary) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "SUMMARY")
+	fmt.Fprintln(tw, "rows\tpods\tnamespaces\tnodes\tqueries\tcached\tseries\tsamples-loaded\tseconds\tpartial\t")
+	fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.3f\t%t\t\n", s.Rows, s.Pods, s.Namespaces, s.Nodes, s.Queries,
+		s.Cached, s.Series, s.SamplesLoaded, s.Duration.Seconds(), s.Partial)
+	return tw.Flush()
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// ANSI foreground colors.  They have the same length so that colored and plain rows stay aligned.
+const (
+	colorNone    = "\x1b[39m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorReset   = "\x1b[0m"
+)
+
+// TableOptions controls the rendering of WriteTable.
+type TableOptions struct {
+	// Color highlights rows with ANSI colors: red for rows exceeding their budget, yellow for bursty rows, and magenta
+	// and green for rows whose q95 grew or shrank by DeltaThreshold since Previous.
+	Color bool
+	// BurstyThreshold is the Burstiness from which a row is highlighted as bursty.  0 disables the highlight.
+	BurstyThreshold float64
+	// Previous (optional) are the results of the previous refresh of an --interval loop.  When set, a change column
+	// shows the change of each row's q95 since then.
+	Previous top.PodMetricTable
+	// DeltaThreshold is the relative change of q95 since Previous, e.g. 0.2 for 20%, from which a row is highlighted.
+	// 0 disables the highlight.
+	DeltaThreshold float64
+	// PodLabels (optional) are the joined pod labels shown, one column each after label-app.
+	PodLabels []string
+	// Cluster shows the cluster of each row in a first column, for the results of several clusters.
+	Cluster bool
+}
+
+// WriteTable writes the results to w as an aligned table, sorted by metric, namespace, and pod.  The pods of rows split
+// by component are qualified by it, e.g. etcd-0/istio-proxy.  The rows of nodes, which have no pod, are ranked by
+// descending q95.
+func WriteTable(w io.Writer, t top.PodMetricTable, opts TableOptions) error {
+	var previous map[string]*top.PodMetric
+	if opts.Previous != nil {
+		previous = make(map[string]*top.PodMetric, len(opts.Previous))
+		for _, p := range opts.Previous {
+			previous[rowKey(p)] = p
+		}
+	}
+
+	rows := append(top.PodMetricTable(nil), t...)
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		if a.Component != b.Component {
+			return a.Component < b.Component
+		}
+		return a.Q95Value > b.Q95Value
+	})
+
+	tw := newTabWriter(w)
+	line := func(color, format string, a ...interface{}) {
+		if opts.Color {
+			fmt.Fprint(tw, color)
+		}
+		fmt.Fprintf(tw, format, a...)
+		if opts.Color {
+			// after the last tab, the reset is not part of an aligned cell
+			fmt.Fprint(tw, colorReset)
+		}
+		fmt.Fprintln(tw)
+	}
+	header := ""
+	if opts.Cluster {
+		header = "cluster\t"
+	}
+	header += "metric\tnamespace\tpod\tnode\tlabel-app\t"
+	for _, l := range opts.PodLabels {
+		header += l + "\t"
+	}
+	header += "avg\tq95\tmax\tmin\trequest\tefficiency\tburstiness\t"
+	if previous != nil {
+		header += "change\t"
+	}
+	line(colorNone, header+"notes\t")
+	for _, p := range rows {
+		color := colorNone
+		notes := ""
+		change, moved := "", 0
+		if previous != nil {
+			change, moved = delta(previous[rowKey(p)], p, opts.DeltaThreshold)
+			change += "\t"
+		}
+		switch {
+		case p.Violation != "":
+			color, notes = colorRed, "over budget: "+p.Violation
+		case opts.BurstyThreshold > 0 && p.Burstiness >= opts.BurstyThreshold:
+			color, notes = colorYellow, "bursty"
+		case moved > 0:
+			color = colorMagenta
+		case moved < 0:
+			color = colorGreen
+		}
+		if p.Partial {
+			notes = join(notes, "partial")
+		}
+		pod := p.Pod
+		if p.Component != "" {
+			pod += "/" + p.Component
+		}
+		cluster := ""
+		if opts.Cluster {
+			cluster = p.Cluster + "\t"
+		}
+		labels := ""
+		for _, l := range opts.PodLabels {
+			labels += p.PodLabels[l] + "\t"
+		}
+		line(color, "%s%s\t%s\t%s\t%s\t%s\t%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s%s\t",
+			cluster, p.Metric, p.Namespace, pod, p.Node, p.OwnerName, labels,
+			top.FormatValue(p.AvgValue), top.FormatValue(p.Q95Value), top.FormatValue(p.MaxValue),
+			top.FormatValue(p.MinValue), top.FormatValue(p.Request), top.FormatValue(p.Efficiency),
+			top.FormatValue(p.Burstiness), change, notes)
+	}
+	return tw.Flush()
+}
+
+// rowKey identifies the row of a pod or its component, or of a node, across refreshes.
+func rowKey(p *top.PodMetric) string {
+	return p.Metric + "/" + p.Namespace + "/" + p.Pod + "/" + p.Component + "/" + p.Node
+}
+
+// delta describes the change of q95 from prev to p, "new" if p has no previous row.  moved is 1 or -1 if q95 grew or
+// shrank by at least threshold relative to prev, 0 otherwise.
+func delta(prev, p *top.PodMetric, threshold float64) (change string, moved int) {
+	if prev == nil {
+		return "new", 0
+	}
+	d := p.Q95Value - prev.Q95Value
+	switch {
+	case d > 0:
+		change = "↑ " + top.FormatDelta(d)
+	case d < 0:
+		change = "↓ " + top.FormatDelta(d)
+	default:
+		return "=", 0
+	}
+	if prev.Q95Value == 0 {
+		return change, 0
+	}
+	pct := d / prev.Q95Value
+	change += fmt.Sprintf(" (%+.1f%%)", 100*pct)
+	if threshold > 0 && math.Abs(pct) >= threshold {
+		moved = 1
+		if d < 0 {
+			moved = -1
+		}
+	}
+	return change, moved
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + ", " + b
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// WriteTotals writes the totals to w as an aligned table.
+func WriteTotals(w io.Writer, totals []top.Total) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "TOTALS")
+	fmt.Fprintln(tw, "metric\tnamespace\tpods\tavg\tq95\tmax\tinst\trequest\t")
+	for _, t := range totals {
+		ns := t.Namespace
+		if ns == "" {
+			ns = "(cluster)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", t.Metric, ns, t.Pods, top.FormatValue(t.Avg),
+			top.FormatValue(t.Q95), top.FormatValue(t.Max), top.FormatValue(t.Inst), top.FormatValue(t.Request))
+	}
+	return tw.Flush()
+}
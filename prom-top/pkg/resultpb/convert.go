@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resultpb is the protobuf encoding of prom-top results, written by --format proto.  result.pb.go is
+// generated from result.proto by protoc-gen-go, see the proto target of the Makefile.
+package resultpb
+
+import (
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// FromTable returns the message of t.
+func FromTable(t top.PodMetricTable) *PodMetricTable {
+	msg := &PodMetricTable{Rows: make([]*PodMetric, 0, len(t))}
+	for _, m := range t {
+		msg.Rows = append(msg.Rows, &PodMetric{
+			Version:       m.Version,
+			Cluster:       m.Cluster,
+			Metric:        m.Metric,
+			Node:          m.Node,
+			Pod:           m.Pod,
+			Namespace:     m.Namespace,
+			OwnerName:     m.OwnerName,
+			AvgValue:      m.AvgValue,
+			Q95Value:      m.Q95Value,
+			MaxValue:      m.MaxValue,
+			MinValue:      m.MinValue,
+			InstValue:     m.InstValue,
+			QueryTime:     m.QueryTime,
+			Range:         m.Range,
+			Partial:       m.Partial,
+			RunId:         m.RunID,
+			Violation:     m.Violation,
+			Request:       m.Request,
+			Efficiency:    m.Efficiency,
+			Burstiness:    m.Burstiness,
+			Q95Burstiness: m.Q95Burstiness,
+			Iteration:     int32(m.Iteration),
+			Labels:        m.Labels,
+			Workload:      m.Workload,
+
+			HpaMinReplicas: int32(m.HPAMinReplicas),
+			HpaMaxReplicas: int32(m.HPAMaxReplicas),
+			Component:      m.Component,
+			PodLabels:      m.PodLabels,
+		})
+	}
+	return msg
+}
+
+// Table returns the rows of t.
+func (t *PodMetricTable) Table() top.PodMetricTable {
+	table := make(top.PodMetricTable, 0, len(t.GetRows()))
+	for _, r := range t.GetRows() {
+		table = append(table, &top.PodMetric{
+			Version:       r.Version,
+			Cluster:       r.Cluster,
+			Metric:        r.Metric,
+			Node:          r.Node,
+			Pod:           r.Pod,
+			Namespace:     r.Namespace,
+			OwnerName:     r.OwnerName,
+			AvgValue:      r.AvgValue,
+			Q95Value:      r.Q95Value,
+			MaxValue:      r.MaxValue,
+			MinValue:      r.MinValue,
+			InstValue:     r.InstValue,
+			QueryTime:     r.QueryTime,
+			Range:         r.Range,
+			Partial:       r.Partial,
+			RunID:         r.RunId,
+			Violation:     r.Violation,
+			Request:       r.Request,
+			Efficiency:    r.Efficiency,
+			Burstiness:    r.Burstiness,
+			Q95Burstiness: r.Q95Burstiness,
+			Iteration:     int(r.Iteration),
+			Labels:        r.Labels,
+			Workload:      r.Workload,
+
+			HPAMinReplicas: int(r.HpaMinReplicas),
+			HPAMaxReplicas: int(r.HpaMaxReplicas),
+			Component:      r.Component,
+			PodLabels:      r.PodLabels,
+		})
+	}
+	return table
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resultpb
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// filled returns a row with every field set to a distinct value, so that a field missing from the conversions is
+// lost in the round trip.
+func filled(n int) *top.PodMetric {
+	m := new(top.PodMetric)
+	v := reflect.ValueOf(m).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(name)
+		case reflect.Float64:
+			f.SetFloat(float64(n*100+i) + .5)
+		case reflect.Int:
+			f.SetInt(int64(n*100 + i))
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Map:
+			f.Set(reflect.MakeMap(f.Type()))
+			f.SetMapIndex(reflect.ValueOf(name), reflect.ValueOf("value"))
+		default:
+			panic("unhandled field " + name)
+		}
+	}
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	table := top.PodMetricTable{filled(1), filled(2)}
+	b, err := proto.Marshal(FromTable(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(PodMetricTable)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+	got := msg.Table()
+	if !reflect.DeepEqual(got, table) {
+		for i := range table {
+			t.Errorf("row %d: got\n%+v\nwant\n%+v", i, got[i], table[i])
+		}
+	}
+
+	if got := new(PodMetricTable).Table(); len(got) != 0 {
+		t.Errorf("got %d rows from an empty message", len(got))
+	}
+}
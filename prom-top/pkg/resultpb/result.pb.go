@@ -0,0 +1,513 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.24.0
+// 	protoc        (unknown)
+// source: prom-top/pkg/resultpb/result.proto
+
+package resultpb
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// PodMetric is the usage of one metric by one pod, aggregated over range.
+type PodMetric struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version   string  `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Cluster   string  `protobuf:"bytes,2,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Metric    string  `protobuf:"bytes,3,opt,name=metric,proto3" json:"metric,omitempty"`
+	Node      string  `protobuf:"bytes,4,opt,name=node,proto3" json:"node,omitempty"`
+	Pod       string  `protobuf:"bytes,5,opt,name=pod,proto3" json:"pod,omitempty"`
+	Namespace string  `protobuf:"bytes,6,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	OwnerName string  `protobuf:"bytes,7,opt,name=owner_name,json=ownerName,proto3" json:"owner_name,omitempty"`
+	AvgValue  float64 `protobuf:"fixed64,8,opt,name=avg_value,json=avgValue,proto3" json:"avg_value,omitempty"`
+	Q95Value  float64 `protobuf:"fixed64,9,opt,name=q95_value,json=q95Value,proto3" json:"q95_value,omitempty"`
+	MaxValue  float64 `protobuf:"fixed64,10,opt,name=max_value,json=maxValue,proto3" json:"max_value,omitempty"`
+	MinValue  float64 `protobuf:"fixed64,11,opt,name=min_value,json=minValue,proto3" json:"min_value,omitempty"`
+	InstValue float64 `protobuf:"fixed64,12,opt,name=inst_value,json=instValue,proto3" json:"inst_value,omitempty"`
+	// query_time is the end of the range, formatted as "2006-01-02 15:04:05".
+	QueryTime string `protobuf:"bytes,13,opt,name=query_time,json=queryTime,proto3" json:"query_time,omitempty"`
+	Range     string `protobuf:"bytes,14,opt,name=range,proto3" json:"range,omitempty"`
+	// partial is set when the row comes from a collection interrupted before all queries completed.
+	Partial bool   `protobuf:"varint,15,opt,name=partial,proto3" json:"partial,omitempty"`
+	RunId   string `protobuf:"bytes,16,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	// violation describes the resource budget the row exceeds, empty if none.
+	Violation string `protobuf:"bytes,17,opt,name=violation,proto3" json:"violation,omitempty"`
+	// request is the pod's resource request for the metric, 0 if it has none.
+	Request float64 `protobuf:"fixed64,18,opt,name=request,proto3" json:"request,omitempty"`
+	// efficiency is avg_value / request, 0 if the pod has no request.
+	Efficiency    float64 `protobuf:"fixed64,19,opt,name=efficiency,proto3" json:"efficiency,omitempty"`
+	Burstiness    float64 `protobuf:"fixed64,20,opt,name=burstiness,proto3" json:"burstiness,omitempty"`
+	Q95Burstiness float64 `protobuf:"fixed64,21,opt,name=q95_burstiness,json=q95Burstiness,proto3" json:"q95_burstiness,omitempty"`
+	// iteration numbers the collections of an --interval loop from 1, 0 outside of one.
+	Iteration int32 `protobuf:"varint,22,opt,name=iteration,proto3" json:"iteration,omitempty"`
+	// labels are the custom labels given to the run with --label, e.g. scenario=density-1000.
+	Labels map[string]string `protobuf:"bytes,23,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// workload is the pod name without the suffixes generated for each replica, stable across restarts and builds.
+	Workload string `protobuf:"bytes,24,opt,name=workload,proto3" json:"workload,omitempty"`
+	// hpa_min_replicas and hpa_max_replicas are the fewest and most replicas the HorizontalPodAutoscaler of the workload
+	// ran over the range, 0 for workloads without one.
+	HpaMinReplicas int32 `protobuf:"varint,25,opt,name=hpa_min_replicas,json=hpaMinReplicas,proto3" json:"hpa_min_replicas,omitempty"`
+	HpaMaxReplicas int32 `protobuf:"varint,26,opt,name=hpa_max_replicas,json=hpaMaxReplicas,proto3" json:"hpa_max_replicas,omitempty"`
+	// component is the part of the pod measured when containers are split: main, init, or the name of a sidecar. Empty
+	// for rows of the whole pod.
+	Component string `protobuf:"bytes,27,opt,name=component,proto3" json:"component,omitempty"`
+	// pod_labels are the pod labels joined from kube_pod_labels with --pod-label, keyed by their kube-state-metrics name,
+	// e.g. label_app_kubernetes_io_name.
+	PodLabels map[string]string `protobuf:"bytes,28,rep,name=pod_labels,json=podLabels,proto3" json:"pod_labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *PodMetric) Reset() {
+	*x = PodMetric{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_prom_top_pkg_resultpb_result_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PodMetric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PodMetric) ProtoMessage() {}
+
+func (x *PodMetric) ProtoReflect() protoreflect.Message {
+	mi := &file_prom_top_pkg_resultpb_result_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PodMetric.ProtoReflect.Descriptor instead.
+func (*PodMetric) Descriptor() ([]byte, []int) {
+	return file_prom_top_pkg_resultpb_result_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PodMetric) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *PodMetric) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *PodMetric) GetMetric() string {
+	if x != nil {
+		return x.Metric
+	}
+	return ""
+}
+
+func (x *PodMetric) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *PodMetric) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *PodMetric) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *PodMetric) GetOwnerName() string {
+	if x != nil {
+		return x.OwnerName
+	}
+	return ""
+}
+
+func (x *PodMetric) GetAvgValue() float64 {
+	if x != nil {
+		return x.AvgValue
+	}
+	return 0
+}
+
+func (x *PodMetric) GetQ95Value() float64 {
+	if x != nil {
+		return x.Q95Value
+	}
+	return 0
+}
+
+func (x *PodMetric) GetMaxValue() float64 {
+	if x != nil {
+		return x.MaxValue
+	}
+	return 0
+}
+
+func (x *PodMetric) GetMinValue() float64 {
+	if x != nil {
+		return x.MinValue
+	}
+	return 0
+}
+
+func (x *PodMetric) GetInstValue() float64 {
+	if x != nil {
+		return x.InstValue
+	}
+	return 0
+}
+
+func (x *PodMetric) GetQueryTime() string {
+	if x != nil {
+		return x.QueryTime
+	}
+	return ""
+}
+
+func (x *PodMetric) GetRange() string {
+	if x != nil {
+		return x.Range
+	}
+	return ""
+}
+
+func (x *PodMetric) GetPartial() bool {
+	if x != nil {
+		return x.Partial
+	}
+	return false
+}
+
+func (x *PodMetric) GetRunId() string {
+	if x != nil {
+		return x.RunId
+	}
+	return ""
+}
+
+func (x *PodMetric) GetViolation() string {
+	if x != nil {
+		return x.Violation
+	}
+	return ""
+}
+
+func (x *PodMetric) GetRequest() float64 {
+	if x != nil {
+		return x.Request
+	}
+	return 0
+}
+
+func (x *PodMetric) GetEfficiency() float64 {
+	if x != nil {
+		return x.Efficiency
+	}
+	return 0
+}
+
+func (x *PodMetric) GetBurstiness() float64 {
+	if x != nil {
+		return x.Burstiness
+	}
+	return 0
+}
+
+func (x *PodMetric) GetQ95Burstiness() float64 {
+	if x != nil {
+		return x.Q95Burstiness
+	}
+	return 0
+}
+
+func (x *PodMetric) GetIteration() int32 {
+	if x != nil {
+		return x.Iteration
+	}
+	return 0
+}
+
+func (x *PodMetric) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *PodMetric) GetWorkload() string {
+	if x != nil {
+		return x.Workload
+	}
+	return ""
+}
+
+func (x *PodMetric) GetHpaMinReplicas() int32 {
+	if x != nil {
+		return x.HpaMinReplicas
+	}
+	return 0
+}
+
+func (x *PodMetric) GetHpaMaxReplicas() int32 {
+	if x != nil {
+		return x.HpaMaxReplicas
+	}
+	return 0
+}
+
+func (x *PodMetric) GetComponent() string {
+	if x != nil {
+		return x.Component
+	}
+	return ""
+}
+
+func (x *PodMetric) GetPodLabels() map[string]string {
+	if x != nil {
+		return x.PodLabels
+	}
+	return nil
+}
+
+// PodMetricTable is the result of one or more collections.
+type PodMetricTable struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Rows []*PodMetric `protobuf:"bytes,1,rep,name=rows,proto3" json:"rows,omitempty"`
+}
+
+func (x *PodMetricTable) Reset() {
+	*x = PodMetricTable{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_prom_top_pkg_resultpb_result_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PodMetricTable) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PodMetricTable) ProtoMessage() {}
+
+func (x *PodMetricTable) ProtoReflect() protoreflect.Message {
+	mi := &file_prom_top_pkg_resultpb_result_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PodMetricTable.ProtoReflect.Descriptor instead.
+func (*PodMetricTable) Descriptor() ([]byte, []int) {
+	return file_prom_top_pkg_resultpb_result_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PodMetricTable) GetRows() []*PodMetric {
+	if x != nil {
+		return x.Rows
+	}
+	return nil
+}
+
+var File_prom_top_pkg_resultpb_result_proto protoreflect.FileDescriptor
+
+var file_prom_top_pkg_resultpb_result_proto_rawDesc = []byte{
+	0x0a, 0x22, 0x70, 0x72, 0x6f, 0x6d, 0x2d, 0x74, 0x6f, 0x70, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x72,
+	0x65, 0x73, 0x75, 0x6c, 0x74, 0x70, 0x62, 0x2f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x63, 0x61, 0x6c, 0x69, 0x70, 0x65, 0x72, 0x2e, 0x76, 0x31,
+	0x22, 0xf7, 0x07, 0x0a, 0x09, 0x50, 0x6f, 0x64, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x6c, 0x75, 0x73,
+	0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x6c, 0x75, 0x73, 0x74,
+	0x65, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f,
+	0x64, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x70, 0x6f, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x70, 0x6f, 0x64,
+	0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x1d,
+	0x0a, 0x0a, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1b, 0x0a,
+	0x09, 0x61, 0x76, 0x67, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x08, 0x61, 0x76, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x71, 0x39,
+	0x35, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x71,
+	0x39, 0x35, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x6d, 0x61, 0x78, 0x5f, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x6d, 0x61, 0x78, 0x56,
+	0x61, 0x6c, 0x75, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x6d, 0x69, 0x6e, 0x5f, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x6d, 0x69, 0x6e, 0x56, 0x61, 0x6c, 0x75,
+	0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x6e, 0x73, 0x74, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x0c, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x69, 0x6e, 0x73, 0x74, 0x56, 0x61, 0x6c, 0x75, 0x65,
+	0x12, 0x1d, 0x0a, 0x0a, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x0d,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x71, 0x75, 0x65, 0x72, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x12,
+	0x14, 0x0a, 0x05, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x72, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c,
+	0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x70, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x12,
+	0x15, 0x0a, 0x06, 0x72, 0x75, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x10, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x72, 0x75, 0x6e, 0x49, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x76, 0x69, 0x6f, 0x6c, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x76, 0x69, 0x6f, 0x6c, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18,
+	0x12, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e,
+	0x0a, 0x0a, 0x65, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x13, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x0a, 0x65, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x1e,
+	0x0a, 0x0a, 0x62, 0x75, 0x72, 0x73, 0x74, 0x69, 0x6e, 0x65, 0x73, 0x73, 0x18, 0x14, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x0a, 0x62, 0x75, 0x72, 0x73, 0x74, 0x69, 0x6e, 0x65, 0x73, 0x73, 0x12, 0x25,
+	0x0a, 0x0e, 0x71, 0x39, 0x35, 0x5f, 0x62, 0x75, 0x72, 0x73, 0x74, 0x69, 0x6e, 0x65, 0x73, 0x73,
+	0x18, 0x15, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x71, 0x39, 0x35, 0x42, 0x75, 0x72, 0x73, 0x74,
+	0x69, 0x6e, 0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x74, 0x65, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x18, 0x16, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x69, 0x74, 0x65, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x12, 0x39, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x17, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x63, 0x61, 0x6c, 0x69, 0x70, 0x65, 0x72, 0x2e, 0x76, 0x31,
+	0x2e, 0x50, 0x6f, 0x64, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x2e, 0x4c, 0x61, 0x62, 0x65, 0x6c,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x12, 0x1a,
+	0x0a, 0x08, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x28, 0x0a, 0x10, 0x68, 0x70,
+	0x61, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x73, 0x18, 0x19,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0e, 0x68, 0x70, 0x61, 0x4d, 0x69, 0x6e, 0x52, 0x65, 0x70, 0x6c,
+	0x69, 0x63, 0x61, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x68, 0x70, 0x61, 0x5f, 0x6d, 0x61, 0x78, 0x5f,
+	0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x73, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0e,
+	0x68, 0x70, 0x61, 0x4d, 0x61, 0x78, 0x52, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x73, 0x12, 0x1c,
+	0x0a, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x6f, 0x6e, 0x65, 0x6e, 0x74, 0x18, 0x1b, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x6f, 0x6e, 0x65, 0x6e, 0x74, 0x12, 0x43, 0x0a, 0x0a,
+	0x70, 0x6f, 0x64, 0x5f, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x1c, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x24, 0x2e, 0x63, 0x61, 0x6c, 0x69, 0x70, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x6f,
+	0x64, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x2e, 0x50, 0x6f, 0x64, 0x4c, 0x61, 0x62, 0x65, 0x6c,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x09, 0x70, 0x6f, 0x64, 0x4c, 0x61, 0x62, 0x65, 0x6c,
+	0x73, 0x1a, 0x39, 0x0a, 0x0b, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x3c, 0x0a, 0x0e,
+	0x50, 0x6f, 0x64, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3b, 0x0a, 0x0e, 0x50, 0x6f,
+	0x64, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x54, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x29, 0x0a, 0x04,
+	0x72, 0x6f, 0x77, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x63, 0x61, 0x6c,
+	0x69, 0x70, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x6f, 0x64, 0x4d, 0x65, 0x74, 0x72, 0x69,
+	0x63, 0x52, 0x04, 0x72, 0x6f, 0x77, 0x73, 0x42, 0x34, 0x5a, 0x32, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x72, 0x65, 0x64, 0x68, 0x61, 0x74, 0x2d, 0x65, 0x74, 0x2f,
+	0x63, 0x61, 0x6c, 0x69, 0x70, 0x65, 0x72, 0x2f, 0x70, 0x72, 0x6f, 0x6d, 0x2d, 0x74, 0x6f, 0x70,
+	0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_prom_top_pkg_resultpb_result_proto_rawDescOnce sync.Once
+	file_prom_top_pkg_resultpb_result_proto_rawDescData = file_prom_top_pkg_resultpb_result_proto_rawDesc
+)
+
+func file_prom_top_pkg_resultpb_result_proto_rawDescGZIP() []byte {
+	file_prom_top_pkg_resultpb_result_proto_rawDescOnce.Do(func() {
+		file_prom_top_pkg_resultpb_result_proto_rawDescData = protoimpl.X.CompressGZIP(file_prom_top_pkg_resultpb_result_proto_rawDescData)
+	})
+	return file_prom_top_pkg_resultpb_result_proto_rawDescData
+}
+
+var file_prom_top_pkg_resultpb_result_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_prom_top_pkg_resultpb_result_proto_goTypes = []interface{}{
+	(*PodMetric)(nil),      // 0: caliper.v1.PodMetric
+	(*PodMetricTable)(nil), // 1: caliper.v1.PodMetricTable
+	nil,                    // 2: caliper.v1.PodMetric.LabelsEntry
+	nil,                    // 3: caliper.v1.PodMetric.PodLabelsEntry
+}
+var file_prom_top_pkg_resultpb_result_proto_depIdxs = []int32{
+	2, // 0: caliper.v1.PodMetric.labels:type_name -> caliper.v1.PodMetric.LabelsEntry
+	3, // 1: caliper.v1.PodMetric.pod_labels:type_name -> caliper.v1.PodMetric.PodLabelsEntry
+	0, // 2: caliper.v1.PodMetricTable.rows:type_name -> caliper.v1.PodMetric
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_prom_top_pkg_resultpb_result_proto_init() }
+func file_prom_top_pkg_resultpb_result_proto_init() {
+	if File_prom_top_pkg_resultpb_result_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_prom_top_pkg_resultpb_result_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PodMetric); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_prom_top_pkg_resultpb_result_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PodMetricTable); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_prom_top_pkg_resultpb_result_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_prom_top_pkg_resultpb_result_proto_goTypes,
+		DependencyIndexes: file_prom_top_pkg_resultpb_result_proto_depIdxs,
+		MessageInfos:      file_prom_top_pkg_resultpb_result_proto_msgTypes,
+	}.Build()
+	File_prom_top_pkg_resultpb_result_proto = out.File
+	file_prom_top_pkg_resultpb_result_proto_rawDesc = nil
+	file_prom_top_pkg_resultpb_result_proto_goTypes = nil
+	file_prom_top_pkg_resultpb_result_proto_depIdxs = nil
+}
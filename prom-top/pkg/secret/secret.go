@@ -0,0 +1,188 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secret reads credentials from mounted secret files or HashiCorp Vault, so that deployments need not pass
+// them in environment variables.
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountToken is the token Vault's kubernetes auth method logs in with when running in a pod.
+const serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// FromFile returns the content of the file at path, without surrounding whitespace.
+func FromFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Vault reads secrets from a Vault server.  It authenticates with Token if set, else by logging in with the pod's
+// service account token to the kubernetes auth method mounted at AuthPath, as Role.
+type Vault struct {
+	// Addr is the server's address, e.g. https://vault.example.com:8200.
+	Addr string
+	// Token (optional) is a Vault token.
+	Token string
+	// Role (optional) is the kubernetes auth role logged in as when Token is empty.
+	Role string
+	// AuthPath is the mount path of the kubernetes auth method.  Defaults to kubernetes.
+	AuthPath string
+
+	http *http.Client
+}
+
+// VaultFromEnv returns the Vault configured by VAULT_ADDR, VAULT_TOKEN or VAULT_TOKEN_FILE, VAULT_ROLE, and
+// VAULT_AUTH_PATH, the variables read by the vault CLI where they overlap.
+func VaultFromEnv() (*Vault, error) {
+	v := &Vault{
+		Addr:     os.Getenv("VAULT_ADDR"),
+		Token:    os.Getenv("VAULT_TOKEN"),
+		Role:     os.Getenv("VAULT_ROLE"),
+		AuthPath: os.Getenv("VAULT_AUTH_PATH"),
+	}
+	if v.Token == "" && os.Getenv("VAULT_TOKEN_FILE") != "" {
+		token, err := FromFile(os.Getenv("VAULT_TOKEN_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		v.Token = token
+	}
+	if v.Addr == "" {
+		return nil, fmt.Errorf("reading secrets from vault requires VAULT_ADDR")
+	}
+	if v.Token == "" && v.Role == "" {
+		return nil, fmt.Errorf("reading secrets from vault requires VAULT_TOKEN, VAULT_TOKEN_FILE, or VAULT_ROLE")
+	}
+	return v, nil
+}
+
+// Read returns a field of the secret at a Vault path, written path#field, e.g. secret/data/caliper#password.  Both
+// versions of the key/value secrets engine are supported.
+func (v *Vault) Read(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 1 || i == len(ref)-1 {
+		return "", fmt.Errorf("invalid vault reference %q, expected path#field", ref)
+	}
+	path, field := strings.Trim(ref[:i], "/"), ref[i+1:]
+	if v.Token == "" {
+		if err := v.login(); err != nil {
+			return "", err
+		}
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(http.MethodGet, "/v1/"+path, nil, &resp); err != nil {
+		return "", fmt.Errorf("reading %s from vault: %v", path, err)
+	}
+	data := resp.Data
+	// version 2 of the key/value engine nests the secret under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return value, nil
+}
+
+// login exchanges the pod's service account token for a Vault token.
+func (v *Vault) login() error {
+	jwt, err := FromFile(serviceAccountToken)
+	if err != nil {
+		return fmt.Errorf("logging in to vault as %s: %v", v.Role, err)
+	}
+	authPath := strings.Trim(v.AuthPath, "/")
+	if authPath == "" {
+		authPath = "kubernetes"
+	}
+	body, err := json.Marshal(map[string]string{"role": v.Role, "jwt": jwt})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(http.MethodPost, "/v1/auth/"+authPath+"/login", body, &resp); err != nil {
+		return fmt.Errorf("logging in to vault as %s: %v", v.Role, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("logging in to vault as %s: no token returned", v.Role)
+	}
+	v.Token = resp.Auth.ClientToken
+	return nil
+}
+
+func (v *Vault) do(method, path string, body []byte, out interface{}) error {
+	if v.http == nil {
+		v.http = &http.Client{Timeout: 30 * time.Second}
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(v.Addr, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if v.Token != "" {
+		req.Header.Set("X-Vault-Token", v.Token)
+	}
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, out)
+}
+
+// Resolve returns the secret held in the file at file if set, else at the Vault reference vaultRef if set, else
+// value.
+func Resolve(value, file, vaultRef string) (string, error) {
+	switch {
+	case file != "":
+		return FromFile(file)
+	case vaultRef != "":
+		v, err := VaultFromEnv()
+		if err != nil {
+			return "", err
+		}
+		return v.Read(vaultRef)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// vaultServer serves the secrets of a key/value engine of both versions, mounted at kv1 and kv2, to the token
+// "s.token".
+func vaultServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv1/caliper":
+			_, _ = w.Write([]byte(`{"data":{"password":"v1-secret","port":5432}}`))
+		case "/v1/kv2/data/caliper":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"v2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv1/nested":
+			// a version 1 secret that happens to have a data field is not unwrapped
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"inner"},"password":"outer"}}`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultRead(t *testing.T) {
+	srv := vaultServer(t)
+	tests := []struct {
+		ref     string
+		token   string
+		want    string
+		wantErr string
+	}{
+		{ref: "kv1/caliper#password", want: "v1-secret"},
+		{ref: "/kv2/data/caliper/#password", want: "v2-secret"},
+		{ref: "kv1/nested#password", want: "outer"},
+		{ref: "kv1/caliper#port", wantErr: `no string field "port"`},
+		{ref: "kv2/data/caliper#user", wantErr: `no string field "user"`},
+		{ref: "kv1/missing#password", wantErr: "404 Not Found"},
+		{ref: "kv1/caliper#password", token: "s.other", wantErr: "permission denied"},
+		{ref: "kv1/caliper", wantErr: "expected path#field"},
+		{ref: "kv1/caliper#", wantErr: "expected path#field"},
+		{ref: "#password", wantErr: "expected path#field"},
+	}
+	for _, tt := range tests {
+		token := tt.token
+		if token == "" {
+			token = "s.token"
+		}
+		v := &Vault{Addr: srv.URL + "/", Token: token}
+		got, err := v.Read(tt.ref)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %q, error %v, want error %q", tt.ref, got, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.ref, err)
+		case got != tt.want:
+			t.Errorf("%s: got %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// setenv sets the environment variables of vars for the duration of the test, unsetting those set to "".
+func setenv(t *testing.T, vars map[string]string) {
+	for k, v := range vars {
+		old, ok := os.LookupEnv(k)
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("  file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s.token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := vaultServer(t)
+	setenv(t, map[string]string{
+		"VAULT_ADDR": srv.URL, "VAULT_TOKEN": "", "VAULT_TOKEN_FILE": tokenFile, "VAULT_ROLE": "", "VAULT_AUTH_PATH": "",
+	})
+
+	tests := []struct {
+		value, file, vaultRef string
+		want                  string
+	}{
+		{"flag", "", "", "flag"},
+		{"flag", file, "kv1/caliper#password", "file-secret"},
+		{"flag", "", "kv2/data/caliper#password", "v2-secret"},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(tt.value, tt.file, tt.vaultRef)
+		if err != nil {
+			t.Errorf("%+v: %v", tt, err)
+		} else if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt, got, tt.want)
+		}
+	}
+	if _, err := Resolve("", filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("expected an error reading a missing file")
+	}
+
+	setenv(t, map[string]string{"VAULT_TOKEN_FILE": ""})
+	if _, err := Resolve("", "", "kv1/caliper#password"); err == nil || !strings.Contains(err.Error(), "VAULT_ROLE") {
+		t.Errorf("got error %v, want the missing credentials reported", err)
+	}
+	setenv(t, map[string]string{"VAULT_ADDR": "", "VAULT_TOKEN": "s.token"})
+	if _, err := Resolve("", "", "kv1/caliper#password"); err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Errorf("got error %v, want the missing address reported", err)
+	}
+}
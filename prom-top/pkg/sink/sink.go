@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sink defines the destination of a collection's results and a registry of named sinks.
+//
+// Sinks register themselves by name, typically from an init function, in the manner of database/sql drivers.  An
+// out-of-tree sink is added by importing its package for side effects into a build of the CLI:
+//
+//	import _ "example.com/caliper-kafka-sink"
+//
+// after which it is selectable with --format.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Sink writes the results of a collection to its destination.
+type Sink interface {
+	Write(ctx context.Context, metrics top.PodMetricTable) error
+}
+
+// Func adapts an ordinary function to the Sink interface.
+type Func func(ctx context.Context, metrics top.PodMetricTable) error
+
+func (f Func) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	return f(ctx, metrics)
+}
+
+// Driver constructs a named Sink.
+type Driver struct {
+	// Validate (optional) checks the sink's configuration before any collection is made, so that mistakes are
+	// reported up front.  Errors should say how to fix the configuration.
+	Validate func() error
+	// Open returns a Sink ready to be written to.
+	Open func() (Sink, error)
+}
+
+var (
+	mu      sync.RWMutex
+	drivers = make(map[string]Driver)
+)
+
+// Register makes a sink available under name.  It panics if name is registered twice or Open is nil.
+func Register(name string, d Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+	if d.Open == nil {
+		panic(fmt.Sprintf("sink: Register %q with nil Open", name))
+	}
+	if _, dup := drivers[name]; dup {
+		panic(fmt.Sprintf("sink: Register called twice for %q", name))
+	}
+	drivers[name] = d
+}
+
+// Names returns the registered sink names, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for n := range drivers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (Driver, error) {
+	mu.RLock()
+	d, ok := drivers[name]
+	mu.RUnlock()
+	if !ok {
+		return Driver{}, fmt.Errorf("unknown sink %q: use one of %s", name, strings.Join(Names(), ", "))
+	}
+	return d, nil
+}
+
+// Validate checks the configuration of the sink registered under name.
+func Validate(name string) error {
+	d, err := lookup(name)
+	if err != nil {
+		return err
+	}
+	if d.Validate == nil {
+		return nil
+	}
+	return d.Validate()
+}
+
+// Open returns the sink registered under name.
+func Open(name string) (Sink, error) {
+	d, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.Open()
+}
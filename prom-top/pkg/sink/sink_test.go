@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/sink"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestRegistry(t *testing.T) {
+	var written top.PodMetricTable
+	sink.Register("test-memory", sink.Driver{
+		Open: func() (sink.Sink, error) {
+			return sink.Func(func(_ context.Context, metrics top.PodMetricTable) error {
+				written = append(written, metrics...)
+				return nil
+			}), nil
+		},
+	})
+	sink.Register("test-misconfigured", sink.Driver{
+		Validate: func() error { return errors.New("no destination. Set --test-destination") },
+		Open:     func() (sink.Sink, error) { return nil, errors.New("not validated") },
+	})
+
+	// other tests register sinks too
+	if names := strings.Join(sink.Names(), ","); !strings.Contains(names, "test-memory,test-misconfigured") {
+		t.Errorf("got sinks %s", names)
+	}
+	if err := sink.Validate("test-memory"); err != nil {
+		t.Errorf("a sink without Validate is always valid, got %v", err)
+	}
+	if err := sink.Validate("test-misconfigured"); err == nil || !strings.Contains(err.Error(), "--test-destination") {
+		t.Errorf("got error %v, want the error of Validate", err)
+	}
+	if _, err := sink.Open("test-misconfigured"); err == nil {
+		t.Error("expected the error of Open")
+	}
+
+	s, err := sink.Open("test-memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := top.PodMetricTable{{Metric: "cpu", Pod: "etcd-0"}}
+	if err := s.Write(context.Background(), table); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, table) {
+		t.Errorf("wrote %v, want %v", written, table)
+	}
+
+	for _, err := range []error{sink.Validate("kafka"), openErr("kafka")} {
+		if err == nil || !strings.Contains(err.Error(), "use one of ") ||
+			!strings.Contains(err.Error(), "test-memory, test-misconfigured") {
+			t.Errorf("got error %v, want the registered sinks listed", err)
+		}
+	}
+}
+
+func openErr(name string) error {
+	_, err := sink.Open(name)
+	return err
+}
+
+func TestRegisterPanics(t *testing.T) {
+	open := func() (sink.Sink, error) { return nil, nil }
+	sink.Register("test-twice", sink.Driver{Open: open})
+	tests := map[string]func(){
+		"registered twice": func() { sink.Register("test-twice", sink.Driver{Open: open}) },
+		"nil Open":         func() { sink.Register("test-nil-open", sink.Driver{}) },
+	}
+	for name, register := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected Register to panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
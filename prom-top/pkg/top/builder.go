@@ -0,0 +1,354 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Aggregation identifies the statistic a query computes for each pod.
+type Aggregation string
+
+const (
+	Average    Aggregation = "avg"
+	Maximum    Aggregation = "max"
+	Minimum    Aggregation = "min"
+	Quantile95 Aggregation = "q95"
+	Instant    Aggregation = "inst"
+	// Request is the pod's resource request rather than a statistic of its usage.
+	Request Aggregation = "request"
+)
+
+// Aggregations lists every supported Aggregation in output order.
+var Aggregations = []Aggregation{Average, Maximum, Minimum, Quantile95, Instant, Request}
+
+// Metric names as reported in PodMetric.Metric.
+const (
+	CPUMetric    = "cpu_usage_ratio"
+	MemoryMetric = "container_memory_bytes"
+)
+
+// ownerJoin attaches the owning controller's name to each pod series.  kube_pod_owner only has the pod's labels, so it
+// is restricted by OwnerMatchers rather than Matchers.
+const ownerJoin = ` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"{{.OwnerMatchers}}})`
+
+// partials are shared sub-templates available to every query template.
+//
+// cpuRate is the per-second CPU usage over Range.  When a downsampling Step is set, it is instead computed as the
+// average of Step-wide rates sampled every Step across Range, bounding the cost of very long ranges.
+const partials = `{{define "cpuRate"}}` +
+	`{{if .Step}}avg_over_time({{end}}` +
+	`rate(container_cpu_usage_seconds_total{pod!=''{{.Matchers}}}[{{.Window}}])` +
+	`{{if .Step}}[{{.Range}}:{{.Step}}]){{end}}` +
+	`{{end}}`
+
+// Query Templates
+// Each target metric maps to one template per Aggregation.  Templates are executed with the query Range, the
+// range selector Window, the downsampling Step (empty unless downsampling), and Matchers, a (possibly empty) list of
+// additional label matchers, each preceded by a comma.  OwnerMatchers are those of them that apply to kube_pod_owner,
+// and ContainerMatchers those that apply to the container series of kube-state-metrics, e.g. the requests.
+var defaultTemplates = map[string]map[Aggregation]string{
+	CPUMetric: {
+		Average:    `avg({{template "cpuRate" .}}) by (pod, namespace, node)` + ownerJoin,
+		Maximum:    `max({{template "cpuRate" .}}) by (pod, namespace, node)` + ownerJoin,
+		Minimum:    `min({{template "cpuRate" .}}) by (pod, namespace, node)` + ownerJoin,
+		Quantile95: `quantile(.95, {{template "cpuRate" .}}) by (pod, namespace, node)` + ownerJoin,
+		Instant:    `sum(container_cpu_usage_seconds_total{container!='',container!='POD',pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Request:    `sum(kube_pod_container_resource_requests{resource="cpu"{{.ContainerMatchers}}}) by (pod, namespace, node)` + ownerJoin,
+	},
+	MemoryMetric: {
+		Average:    `avg(container_memory_usage_bytes{pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Maximum:    `max(container_memory_usage_bytes{pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Minimum:    `min(container_memory_usage_bytes{pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Quantile95: `quantile(.95, container_memory_usage_bytes{pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Instant:    `sum(container_memory_usage_bytes{container!='',container!='POD',pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+		Request:    `sum(kube_pod_container_resource_requests{resource="memory"{{.ContainerMatchers}}}) by (pod, namespace, node)` + ownerJoin,
+	},
+}
+
+// DefaultTemplates returns a copy of the builtin CPU and memory query templates, e.g. to extend with
+// HistogramTemplates before calling NewQueryBuilder.
+func DefaultTemplates() map[string]map[Aggregation]string {
+	templates := make(map[string]map[Aggregation]string, len(defaultTemplates))
+	for metric, aggs := range defaultTemplates {
+		templates[metric] = make(map[Aggregation]string, len(aggs))
+		for agg, text := range aggs {
+			templates[metric][agg] = text
+		}
+	}
+	return templates
+}
+
+// HistogramTemplates returns the query templates of a Prometheus histogram, named by the common prefix of its
+// _bucket, _sum, and _count series, e.g. storage_operation_duration_seconds.  Quantile95 and Maximum are estimated
+// over Range from the bucket rates with histogram_quantile, so they are only as precise as the bucket boundaries.
+// Average is the mean observation over Range.  Histograms have no minimum or instant value.
+func HistogramTemplates(metric string) (map[Aggregation]string, error) {
+	if err := ValidateMetricName(metric); err != nil {
+		return nil, err
+	}
+	buckets := `sum by (le, pod, namespace, node) (rate(` + metric + `_bucket{pod!=''{{.Matchers}}}[{{.Range}}]))`
+	observed := func(series string) string {
+		return `sum by (pod, namespace, node) (rate(` + metric + series + `{pod!=''{{.Matchers}}}[{{.Range}}]))`
+	}
+	return map[Aggregation]string{
+		Average:    `(` + observed("_sum") + ` / ` + observed("_count") + `)` + ownerJoin,
+		Maximum:    `histogram_quantile(1, ` + buckets + `)` + ownerJoin,
+		Quantile95: `histogram_quantile(.95, ` + buckets + `)` + ownerJoin,
+	}, nil
+}
+
+// GaugeTemplates returns the query templates of a gauge, e.g. container_memory_working_set_bytes, whose values are
+// aggregated like those of container_memory_usage_bytes for MemoryMetric.
+func GaugeTemplates(metric string) (map[Aggregation]string, error) {
+	if err := ValidateMetricName(metric); err != nil {
+		return nil, err
+	}
+	selector := metric + `{pod!=''{{.Matchers}}}`
+	return map[Aggregation]string{
+		Average:    `avg(` + selector + `) by (pod, namespace, node)` + ownerJoin,
+		Maximum:    `max(` + selector + `) by (pod, namespace, node)` + ownerJoin,
+		Minimum:    `min(` + selector + `) by (pod, namespace, node)` + ownerJoin,
+		Quantile95: `quantile(.95, ` + selector + `) by (pod, namespace, node)` + ownerJoin,
+		Instant:    `sum(` + selector + `) by (pod, namespace, node)` + ownerJoin,
+	}, nil
+}
+
+// CounterTemplates returns the query templates of a counter, e.g. container_network_receive_bytes_total, whose
+// per-second rate is aggregated like that of container_cpu_usage_seconds_total for CPUMetric.
+func CounterTemplates(metric string) (map[Aggregation]string, error) {
+	if err := ValidateMetricName(metric); err != nil {
+		return nil, err
+	}
+	rate := `{{if .Step}}avg_over_time({{end}}` +
+		`rate(` + metric + `{pod!=''{{.Matchers}}}[{{.Window}}])` +
+		`{{if .Step}}[{{.Range}}:{{.Step}}]){{end}}`
+	return map[Aggregation]string{
+		Average:    `avg(` + rate + `) by (pod, namespace, node)` + ownerJoin,
+		Maximum:    `max(` + rate + `) by (pod, namespace, node)` + ownerJoin,
+		Minimum:    `min(` + rate + `) by (pod, namespace, node)` + ownerJoin,
+		Quantile95: `quantile(.95, ` + rate + `) by (pod, namespace, node)` + ownerJoin,
+		Instant:    `sum(` + metric + `{pod!=''{{.Matchers}}}) by (pod, namespace, node)` + ownerJoin,
+	}, nil
+}
+
+// MetricTemplates returns the query templates of metric by its prometheus type: counter, histogram, or gauge, which
+// is also assumed of metrics of any other or unknown type, e.g. recording rules.
+func MetricTemplates(metric, metricType string) (map[Aggregation]string, error) {
+	switch metricType {
+	case string(promv1.MetricTypeCounter):
+		return CounterTemplates(metric)
+	case string(promv1.MetricTypeHistogram):
+		return HistogramTemplates(metric)
+	default:
+		return GaugeTemplates(metric)
+	}
+}
+
+// Params are the values substituted into query templates.
+type Params struct {
+	// Range is the lookback window of range vector selectors.
+	Range string
+	// Namespaces (optional) restricts the query to series from the listed namespaces.
+	Namespaces []string
+	// Matchers (optional) are additional label matchers applied to every series selector.
+	Matchers []Matcher
+	// Step (optional) downsamples range aggregations into a subquery evaluated at this resolution.
+	Step string
+	// PodLabels (optional) are kube_pod_labels labels, e.g. label_app_kubernetes_io_name, joined onto every series.
+	// See PodLabelName.
+	PodLabels []string
+}
+
+// window is the range selector duration, the downsampling step if set and the full range otherwise.
+func (p Params) window() string {
+	if p.Step != "" {
+		return p.Step
+	}
+	return p.Range
+}
+
+// validate rejects parameters that would produce malformed PromQL.
+func (p Params) validate() error {
+	if err := ValidateDuration(p.Range); err != nil {
+		return fmt.Errorf("range: %v", err)
+	}
+	if p.Step != "" {
+		if err := ValidateDuration(p.Step); err != nil {
+			return fmt.Errorf("step: %v", err)
+		}
+	}
+	for _, m := range p.Matchers {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, l := range p.PodLabels {
+		if err := ValidateLabelName(l); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(l, podLabelPrefix) {
+			return fmt.Errorf("pod label %q: kube_pod_labels names its labels %s<name>", l, podLabelPrefix)
+		}
+	}
+	return nil
+}
+
+// matchers renders the additional label matchers implied by p, each preceded by a comma.
+func (p Params) matchers() string {
+	return p.render(p.Matchers)
+}
+
+// ownerMatchers renders the matchers of p that apply to the series of kube-state-metrics describing pods, such as
+// kube_pod_owner and kube_pod_labels, each preceded by a comma.  See PodMatchers.
+func (p Params) ownerMatchers() string {
+	return p.render(PodMatchers(p.Matchers))
+}
+
+// containerMatchers renders the matchers of p that apply to the series of kube-state-metrics describing containers,
+// such as kube_pod_container_resource_requests, each preceded by a comma.  See ContainerMatchers.
+func (p Params) containerMatchers() string {
+	return p.render(ContainerMatchers(p.Matchers))
+}
+
+// render renders matchers, preceded by the matcher of p.Namespaces, each preceded by a comma.
+func (p Params) render(matchers []Matcher) string {
+	all := matchers
+	if len(p.Namespaces) > 0 {
+		all = append([]Matcher{anyOf("namespace", p.Namespaces)}, all...)
+	}
+	buf := new(strings.Builder)
+	for _, m := range all {
+		buf.WriteString(",")
+		buf.WriteString(m.String())
+	}
+	return buf.String()
+}
+
+// defaultBuilder is parsed once at package init and used when Config.QueryBuilder is nil.
+var defaultBuilder = mustNewQueryBuilder(defaultTemplates)
+
+// DefaultQueryBuilder returns the builder used when Config.QueryBuilder is nil.
+func DefaultQueryBuilder() *QueryBuilder {
+	return defaultBuilder
+}
+
+// Query is a single PromQL expression along with the metric and aggregation it produces.
+type Query struct {
+	Metric      string
+	Aggregation Aggregation
+	Expr        string
+}
+
+// QueryBuilder generates PromQL expressions from a set of pre-parsed templates.
+type QueryBuilder struct {
+	templates map[string]map[Aggregation]*template.Template
+}
+
+// NewQueryBuilder parses templates, a mapping of metric name to per-aggregation query template.
+func NewQueryBuilder(templates map[string]map[Aggregation]string) (*QueryBuilder, error) {
+	b := &QueryBuilder{templates: make(map[string]map[Aggregation]*template.Template, len(templates))}
+	for metric, aggs := range templates {
+		b.templates[metric] = make(map[Aggregation]*template.Template, len(aggs))
+		for agg, text := range aggs {
+			t, err := template.New(fmt.Sprintf("%s/%s", metric, agg)).Parse(partials)
+			if err == nil {
+				t, err = t.Parse(text)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s %s template: %v", agg, metric, err)
+			}
+			b.templates[metric][agg] = t
+		}
+	}
+	return b, nil
+}
+
+func mustNewQueryBuilder(templates map[string]map[Aggregation]string) *QueryBuilder {
+	b, err := NewQueryBuilder(templates)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Build returns the query expression computing agg of metric over queryRange.
+func (b *QueryBuilder) Build(metric string, agg Aggregation, queryRange string) (string, error) {
+	return b.BuildWithParams(metric, agg, Params{Range: queryRange})
+}
+
+// BuildWithParams returns the query expression computing agg of metric, parameterized by p.
+func (b *QueryBuilder) BuildWithParams(metric string, agg Aggregation, p Params) (string, error) {
+	t, ok := b.templates[metric][agg]
+	if !ok {
+		return "", fmt.Errorf("no %s query defined for metric %q", agg, metric)
+	}
+	if err := p.validate(); err != nil {
+		return "", fmt.Errorf("composing %s %s query: %v", agg, metric, err)
+	}
+	buf := new(bytes.Buffer)
+	err := t.Execute(buf, struct {
+		Range, Window, Step, Matchers, OwnerMatchers, ContainerMatchers string
+	}{p.Range, p.window(), p.Step, p.matchers(), p.ownerMatchers(), p.containerMatchers()})
+	if err != nil {
+		return "", fmt.Errorf("composing %s %s query: %v", agg, metric, err)
+	}
+	if len(p.PodLabels) > 0 {
+		return podLabelJoin(buf.String(), p.PodLabels, p.ownerMatchers()), nil
+	}
+	return buf.String(), nil
+}
+
+// Metrics returns the names of the metrics known to the builder, sorted.
+func (b *QueryBuilder) Metrics() []string {
+	names := make([]string, 0, len(b.templates))
+	for m := range b.templates {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has reports whether the builder defines a query computing agg of metric.
+func (b *QueryBuilder) Has(metric string, agg Aggregation) bool {
+	_, ok := b.templates[metric][agg]
+	return ok
+}
+
+// Queries builds every metric/aggregation combination known to the builder.
+func (b *QueryBuilder) Queries(p Params) ([]Query, error) {
+	var queries []Query
+	for _, metric := range b.Metrics() {
+		for _, agg := range Aggregations {
+			if !b.Has(metric, agg) {
+				continue
+			}
+			expr, err := b.BuildWithParams(metric, agg, p)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, Query{Metric: metric, Aggregation: agg, Expr: expr})
+		}
+	}
+	return queries, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	namespace, err := NewMatcher("namespace", MatchEqual, "openshift-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err := NewMatcher("container", MatchNotEqual, "etcd-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := NewMatcher("image", MatchNotEqual, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		metric string
+		agg    Aggregation
+		params Params
+		want   string
+	}{
+		{
+			name:   "range",
+			metric: CPUMetric,
+			agg:    Average,
+			params: Params{Range: "10m"},
+			want: `avg(rate(container_cpu_usage_seconds_total{pod!=''}[10m])) by (pod, namespace, node)` +
+				` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`,
+		},
+		{
+			name:   "downsampled",
+			metric: CPUMetric,
+			agg:    Maximum,
+			params: Params{Range: "7d", Step: "5m"},
+			want: `max(avg_over_time(rate(container_cpu_usage_seconds_total{pod!=''}[5m])[7d:5m])) by (pod, namespace, node)` +
+				` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`,
+		},
+		{
+			name:   "instant sums the containers of each pod",
+			metric: MemoryMetric,
+			agg:    Instant,
+			params: Params{Range: "10m"},
+			want: `sum(container_memory_usage_bytes{container!='',container!='POD',pod!=''}) by (pod, namespace, node)` +
+				` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`,
+		},
+		{
+			name:   "matchers, only those of the pod applied to kube_pod_owner",
+			metric: MemoryMetric,
+			agg:    Request,
+			params: Params{Range: "10m", Namespaces: []string{"a", "b.c"}, Matchers: []Matcher{namespace, container}},
+			want: `sum(kube_pod_container_resource_requests{resource="memory",namespace=~"a|b\\.c",namespace="openshift-etcd",container!="etcd-metrics"}) by (pod, namespace, node)` +
+				` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController",namespace=~"a|b\\.c",namespace="openshift-etcd"})`,
+		},
+		{
+			name:   "requests only matched on the labels of kube-state-metrics",
+			metric: CPUMetric,
+			agg:    Request,
+			params: Params{Range: "10m", Matchers: []Matcher{container, image}},
+			want: `sum(kube_pod_container_resource_requests{resource="cpu",container!="etcd-metrics"}) by (pod, namespace, node)` +
+				` * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DefaultQueryBuilder().BuildWithParams(tt.metric, tt.agg, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPodLabels(t *testing.T) {
+	got, err := DefaultQueryBuilder().BuildWithParams(CPUMetric, Average, Params{
+		Range:     "10m",
+		Matchers:  []Matcher{{Name: "container", Type: MatchEqual, Value: "etcd"}},
+		PodLabels: []string{"label_app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "kube_pod_labels") || !strings.Contains(got, "label_app") {
+		t.Errorf("%s does not join kube_pod_labels", got)
+	}
+	if strings.Count(got, `container="etcd"`) != 1 {
+		t.Errorf("%s applies the container matcher to the series of kube-state-metrics", got)
+	}
+}
+
+func TestSplitTemplatesMatchers(t *testing.T) {
+	templates, err := SplitTemplates([]string{"istio-proxy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewQueryBuilder(templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := Params{Range: "10m", Matchers: []Matcher{{Name: "image", Type: MatchNotEqual, Value: ""}}}
+	for _, agg := range []Aggregation{Instant, Request} {
+		got, err := b.BuildWithParams(CPUMetric, agg, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(got, `kube_pod_init_container_info{container!='',image`) ||
+			strings.Contains(got, `kube_pod_container_resource_requests{resource="cpu",image`) {
+			t.Errorf("%s: %s applies the image matcher to the series of kube-state-metrics", agg, got)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		agg    Aggregation
+		params Params
+	}{
+		{name: "unknown metric", metric: "nope", agg: Average, params: Params{Range: "10m"}},
+		{name: "undefined aggregation", metric: CPUMetric, agg: "median", params: Params{Range: "10m"}},
+		{name: "range", metric: CPUMetric, agg: Average, params: Params{Range: "10 minutes"}},
+		{name: "step", metric: CPUMetric, agg: Average, params: Params{Range: "7d", Step: "5"}},
+		{name: "matcher", metric: CPUMetric, agg: Average, params: Params{Range: "10m",
+			Matchers: []Matcher{{Name: "pod", Type: MatchRegexp, Value: "("}}}},
+		{name: "pod label", metric: CPUMetric, agg: Average, params: Params{Range: "10m", PodLabels: []string{"app"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := DefaultQueryBuilder().BuildWithParams(tt.metric, tt.agg, tt.params); err == nil {
+				t.Errorf("expected an error, got %s", got)
+			}
+		})
+	}
+}
+
+func TestNewQueryBuilder(t *testing.T) {
+	if _, err := NewQueryBuilder(map[string]map[Aggregation]string{"m": {Average: "avg(m{{.Matchers}"}}); err == nil {
+		t.Error("expected an error parsing a malformed template")
+	}
+
+	gauge, err := GaugeTemplates("container_memory_working_set_bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	histogram, err := HistogramTemplates("storage_operation_duration_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewQueryBuilder(map[string]map[Aggregation]string{
+		"container_memory_working_set_bytes": gauge,
+		"storage_operation_duration_seconds": histogram,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, err := b.Queries(Params{Range: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, q := range queries {
+		got = append(got, q.Metric+"/"+string(q.Aggregation))
+	}
+	want := []string{
+		"container_memory_working_set_bytes/avg", "container_memory_working_set_bytes/max",
+		"container_memory_working_set_bytes/min", "container_memory_working_set_bytes/q95",
+		"container_memory_working_set_bytes/inst",
+		"storage_operation_duration_seconds/avg", "storage_operation_duration_seconds/max",
+		"storage_operation_duration_seconds/q95",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got queries %v, want %v", got, want)
+	}
+	if b.Has("storage_operation_duration_seconds", Instant) {
+		t.Error("histograms have no instant value")
+	}
+}
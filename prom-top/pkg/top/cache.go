@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Cache stores query results so that repeated invocations within a short window don't re-execute identical,
+// potentially expensive, queries against Prometheus.  Entries are keyed on the query string and range, and on
+// Config.CacheScope.
+type Cache interface {
+	// Get returns the cached vector for key.  ok is false if the key is missing or the entry has expired.
+	Get(key string) (v model.Vector, ok bool)
+	// Set stores v under key.
+	Set(key string, v model.Vector) error
+}
+
+func cacheKey(scope, query, queryRange string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + queryRange + "\x00" + query))
+	return hex.EncodeToString(sum[:])
+}
+
+type cacheEntry struct {
+	Expires time.Time    `json:"expires"`
+	Vector  model.Vector `json:"vector"`
+}
+
+// MemoryCache is an in-process Cache.  Entries expire after TTL.
+type MemoryCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewMemoryCache returns an empty MemoryCache whose entries live for ttl.
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		TTL:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (model.Vector, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.Expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.Vector, true
+}
+
+func (c *MemoryCache) Set(key string, v model.Vector) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{
+		Expires: time.Now().Add(c.TTL),
+		Vector:  v,
+	}
+	return nil
+}
+
+// FileCache is a Cache persisted as one JSON file per entry under Dir, allowing results to be shared between
+// separate invocations of the CLI.  Entries expire after TTL.
+type FileCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// NewFileCache returns a FileCache rooted at dir, creating the directory if necessary.
+func NewFileCache(dir string, ttl time.Duration) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating cache dir %q: %v", dir, err)
+	}
+	return &FileCache{Dir: dir, TTL: ttl}, nil
+}
+
+func (c *FileCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *FileCache) Get(key string) (model.Vector, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, false
+	}
+	if time.Now().After(e.Expires) {
+		_ = os.Remove(c.path(key))
+		return nil, false
+	}
+	return e.Vector, true
+}
+
+func (c *FileCache) Set(key string, v model.Vector) error {
+	b, err := json.Marshal(cacheEntry{
+		Expires: time.Now().Add(c.TTL),
+		Vector:  v,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding cache entry: %v", err)
+	}
+	// write to a temp file and rename so concurrent readers never observe a partial entry
+	tmp, err := ioutil.TempFile(c.Dir, key)
+	if err != nil {
+		return fmt.Errorf("writing cache entry: %v", err)
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache entry: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache entry: %v", err)
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
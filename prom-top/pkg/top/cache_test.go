@@ -0,0 +1,116 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCacheKey(t *testing.T) {
+	key := cacheKey("a", "up", "10m")
+	for _, other := range []string{cacheKey("b", "up", "10m"), cacheKey("a", "down", "10m"), cacheKey("a", "up", "1h")} {
+		if other == key {
+			t.Errorf("keys of different scopes, queries, or ranges collide: %s", key)
+		}
+	}
+	if cacheKey("a", "up", "10m") != key {
+		t.Error("the key of a query is not stable")
+	}
+}
+
+func TestCaches(t *testing.T) {
+	v := model.Vector{{Metric: model.Metric{"pod": "etcd-0"}, Value: 1.5, Timestamp: 1601553600000}}
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newFileCache := func(ttl time.Duration) Cache {
+		c, err := NewFileCache(filepath.Join(dir, ttl.String()), ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	caches := []struct {
+		name string
+		new  func(ttl time.Duration) Cache
+	}{
+		{"memory", func(ttl time.Duration) Cache { return NewMemoryCache(ttl) }},
+		{"file", newFileCache},
+	}
+	for _, c := range caches {
+		t.Run(c.name, func(t *testing.T) {
+			live := c.new(time.Hour)
+			if _, ok := live.Get("k"); ok {
+				t.Error("got an entry from an empty cache")
+			}
+			if err := live.Set("k", v); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := live.Get("k")
+			if !ok || len(got) != 1 || !got[0].Equal(v[0]) {
+				t.Errorf("got %v, %v, want %v", got, ok, v)
+			}
+
+			expired := c.new(-time.Second)
+			if err := expired.Set("k", v); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := expired.Get("k"); ok {
+				t.Error("got an expired entry")
+			}
+		})
+	}
+}
+
+func TestFileCacheShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, err := NewFileCache(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Set("k", model.Vector{{Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	// a later invocation reads the entries of an earlier one
+	b, err := NewFileCache(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := b.Get("k"); !ok || len(got) != 1 || got[0].Value != 2 {
+		t.Errorf("got %v, %v from a second cache of %s", got, ok, dir)
+	}
+
+	// a corrupt entry is a miss, not an error
+	if err := ioutil.WriteFile(a.path("corrupt"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Get("corrupt"); ok {
+		t.Error("got an entry from a corrupt file")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	promapi "github.com/prometheus/client_golang/api"
+)
+
+const tsdbStatusPath = "/api/v1/status/tsdb"
+
+// HeadStats describes the series held in prometheus' in-memory head block.
+type HeadStats struct {
+	NumSeries     uint64 `json:"numSeries"`
+	NumLabelPairs int    `json:"numLabelPairs"`
+	ChunkCount    int64  `json:"chunkCount"`
+	MinTime       int64  `json:"minTime"`
+	MaxTime       int64  `json:"maxTime"`
+}
+
+// Stat is a name, e.g. a metric or label, and its count.
+type Stat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// TSDBStatus is the cardinality of prometheus' head block, as reported by its TSDB status API.  Each list is
+// ordered from the largest contributor down.
+type TSDBStatus struct {
+	HeadStats                   *HeadStats `json:"headStats"`
+	SeriesCountByMetricName     []Stat     `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []Stat     `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []Stat     `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []Stat     `json:"seriesCountByLabelValuePair"`
+}
+
+// Cardinality reads the series counts per metric and the top label cardinality contributors from prometheus' TSDB
+// status API.  limit, when non-zero, is the number of entries of each list, prometheus defaults to 10.  Versions
+// older than 2.15 and most query frontends, e.g. thanos-querier, do not serve the API.
+func Cardinality(ctx context.Context, client promapi.Client, limit int) (*TSDBStatus, error) {
+	u := client.URL(tsdbStatusPath, nil)
+	if limit > 0 {
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", tsdbStatusPath, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading %s: %s, is it served by this prometheus?", tsdbStatusPath, resp.Status)
+	}
+	var status struct {
+		Status string     `json:"status"`
+		Error  string     `json:"error"`
+		Data   TSDBStatus `json:"data"`
+	}
+	if err = json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", tsdbStatusPath, err)
+	}
+	if status.Status != "success" {
+		return nil, fmt.Errorf("reading %s: %s", tsdbStatusPath, status.Error)
+	}
+	return &status.Data, nil
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+)
+
+// counterMetrics are sampled as cumulative counters.  The Collector observes their per-second rate between
+// consecutive samples rather than the raw counter value.
+var counterMetrics = map[string]bool{
+	CPUMetric: true,
+}
+
+// Collector characterizes pods by sampling the instant queries repeatedly, e.g. every minute for an hour, and
+// aggregating the observations client-side.  Unlike Top, whose aggregations are bounded by what a single query over
+// Range can express, a Collector's resolution is set by how often Collect is called.
+//
+// Collect may be called concurrently with Table.
+type Collector struct {
+	cfg Config
+
+	mu      sync.Mutex
+	series  map[string]*accumulator
+	first   time.Time
+	last    time.Time
+	samples int
+}
+
+// NewCollector returns a Collector for cfg.  Range and the over-time settings of cfg are ignored.  PrometheusClient
+// may be nil if the samples are taken elsewhere and passed to Observe.
+func NewCollector(cfg Config) (*Collector, error) {
+	if cfg.QueryBuilder == nil {
+		cfg.QueryBuilder = defaultBuilder
+	}
+	if len(cfg.Range) == 0 {
+		cfg.Range = defaultRange
+	}
+	return &Collector{cfg: cfg, series: make(map[string]*accumulator)}, nil
+}
+
+// Collect executes the instant query of every metric once and records the observations.
+func (c *Collector) Collect(ctx context.Context) error {
+	if c.cfg.PrometheusClient == nil {
+		return fmt.Errorf("collector requires a PrometheusClient")
+	}
+	now := time.Now()
+	vectors := make(map[string]model.Vector)
+	cfg := c.cfg
+	cfg.Context = ctx
+	for _, metric := range cfg.QueryBuilder.Metrics() {
+		if !cfg.QueryBuilder.Has(metric, Instant) {
+			// e.g. histograms, which can't be sampled
+			continue
+		}
+		expr, err := cfg.QueryBuilder.BuildWithParams(metric, Instant, Params{
+			Range:      cfg.Range,
+			Namespaces: cfg.Namespaces,
+			Matchers:   cfg.Matchers,
+			PodLabels:  cfg.PodLabels,
+		})
+		if err != nil {
+			return err
+		}
+		// bypass the cache, every sample must be fresh
+		start := time.Now()
+		v, warnings, promStats, err := executeWithRetries(cfg, expr, now)
+		if err != nil {
+			return fmt.Errorf("query %q failed: %w", expr, err)
+		}
+		vector, ok := v.(model.Vector)
+		if !ok {
+			return fmt.Errorf("expected vector")
+		}
+		cfg.Stats.record(QueryStat{Metric: metric, Aggregation: Instant, Expr: expr, Duration: time.Since(start),
+			Samples: len(vector), Warnings: warnings, Prometheus: promStats})
+		if err := checkWarnings(cfg, expr, warnings); err != nil {
+			return err
+		}
+		vectors[metric] = vector
+	}
+	c.Observe(vectors, now)
+	return nil
+}
+
+// Observe records a sample taken at ts, the instant value of every pod's metrics keyed by metric name, e.g. as
+// computed from a source other than the instant queries.  Series must carry the labels of the instant queries'
+// results: namespace, pod, node, and owner_name.  The values of counter metrics, e.g. CPUMetric, are cumulative.
+func (c *Collector) Observe(vectors map[string]model.Vector, now time.Time) {
+	for metric, vector := range vectors {
+		c.record(metric, vector, now)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == 0 {
+		c.first = now
+	}
+	c.last = now
+	c.samples++
+}
+
+// record observes the samples of metric at ts.  The series of a pod, or of one of its components, are summed: each
+// accumulator observes a single value per sample.
+func (c *Collector) record(metric string, vector model.Vector, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sums := make(map[*accumulator]float64, len(vector))
+	for _, sample := range vector {
+		key := fmt.Sprintf("%s-%s-%s-%s", sample.Metric["namespace"], sample.Metric["pod"], metric, sample.Metric["component"])
+		a, ok := c.series[key]
+		if !ok {
+			a = &accumulator{
+				metric:  metric,
+				counter: counterMetrics[metric],
+				min:     math.Inf(1),
+				max:     math.Inf(-1),
+				q95:     newP2Quantile(.95),
+			}
+			c.series[key] = a
+		}
+		a.labels = sample.Metric
+		sums[a] += float64(sample.Value)
+	}
+	for a, v := range sums {
+		a.observe(v, ts)
+	}
+}
+
+// Samples returns the number of completed calls to Collect and Observe.
+func (c *Collector) Samples() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.samples
+}
+
+// Table returns the aggregated observations.  Range is set to the span between the first and last sample, InstValue
+// to the most recent observation, and the derived columns are computed as by Top.  Counter metrics need at least two
+// samples to produce a row.
+func (c *Collector) Table() PodMetricTable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	span := model.Duration(c.last.Sub(c.first)).String()
+	table := make(PodMetricTable, 0, len(c.series))
+	for _, a := range c.series {
+		if a.q95.count() == 0 {
+			continue
+		}
+		table = append(table, &PodMetric{
+			Version:   c.cfg.Build,
+			Metric:    a.metric,
+			Pod:       string(a.labels["pod"]),
+			Namespace: string(a.labels["namespace"]),
+			Node:      string(a.labels["node"]),
+			OwnerName: string(a.labels["owner_name"]),
+			Component: string(a.labels["component"]),
+			PodLabels: podLabels(a.labels),
+			Range:     span,
+			QueryTime: c.last.Format(dbhandler.TimestampFormat),
+			AvgValue:  a.sum / float64(a.q95.count()),
+			MaxValue:  a.max,
+			MinValue:  a.min,
+			Q95Value:  a.q95.value(),
+			InstValue: a.last,
+		})
+	}
+	return table.derive()
+}
+
+// accumulator tracks the running statistics of a single pod's metric, in constant space however many samples are
+// taken.
+type accumulator struct {
+	metric  string
+	counter bool
+	labels  model.Metric
+
+	// previous counter value and its timestamp, used to derive rates
+	prev     float64
+	prevTime time.Time
+
+	sum, last float64
+	min, max  float64
+	// q95 estimates the 95th percentile and counts the observations
+	q95 *p2Quantile
+}
+
+func (a *accumulator) observe(v float64, ts time.Time) {
+	if a.counter {
+		prev, prevTime := a.prev, a.prevTime
+		a.prev, a.prevTime = v, ts
+		if prevTime.IsZero() || v < prev {
+			// first sample, or the counter was reset by a container restart
+			return
+		}
+		v = (v - prev) / ts.Sub(prevTime).Seconds()
+	}
+	a.q95.observe(v)
+	a.last = v
+	a.sum += v
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+}
+
+// quantile returns the q-quantile of values using linear interpolation between closest ranks, as Prometheus'
+// quantile_over_time does.
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/toptest"
+)
+
+func TestCollectorCollect(t *testing.T) {
+	pods := []toptest.Pod{
+		{Namespace: "openshift-etcd", Name: "etcd-0", Node: "master-0", OwnerName: "etcd",
+			Values: toptest.Usage(.5, 2e9, 1, 4e9, 3600)},
+		{Namespace: "openshift-dns", Name: "dns-default-x7k2p", Node: "worker-0", OwnerName: "dns-default",
+			Values: toptest.Usage(.01, 5e7, .1, 1e8, 36)},
+	}
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := top.NewCollector(top.Config{PrometheusClient: querier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Collect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Samples() != 3 {
+		t.Errorf("got %d samples, want 3", c.Samples())
+	}
+
+	rows := make(map[string]*top.PodMetric)
+	for _, p := range c.Table() {
+		rows[p.Pod+"/"+p.Metric] = p
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want a cpu and a memory row per pod: %v", len(rows), rows)
+	}
+	for _, pod := range pods {
+		// the counters of the fixture are constant, their rate between samples is 0
+		for metric, want := range map[string]float64{top.CPUMetric: 0, top.MemoryMetric: pod.Values[top.MemoryMetric][top.Instant]} {
+			p, ok := rows[pod.Name+"/"+metric]
+			if !ok {
+				t.Errorf("no %s row of %s", metric, pod.Name)
+				continue
+			}
+			if p.AvgValue != want || p.MaxValue != want || p.MinValue != want || p.Q95Value != want || p.InstValue != want {
+				t.Errorf("%s of %s: got %s, want every aggregation %v", metric, pod.Name, p, want)
+			}
+			if p.Namespace != pod.Namespace || p.Node != pod.Node || p.OwnerName != pod.OwnerName {
+				t.Errorf("%s of %s: got labels %s", metric, pod.Name, p)
+			}
+		}
+	}
+}
+
+func TestCollectorObserve(t *testing.T) {
+	c, err := top.NewCollector(top.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	labels := model.Metric{"namespace": "ns", "pod": "app-1", "node": "worker-0"}
+	container := func(name string, v float64) *model.Sample {
+		m := labels.Clone()
+		m["container"] = model.LabelValue(name)
+		return &model.Sample{Metric: m, Value: model.SampleValue(v)}
+	}
+	// cumulative cpu seconds of two containers, sampled every minute, and reset by a restart before the last sample
+	counters := [][2]float64{{0, 0}, {30, 30}, {60, 90}, {120, 120}, {10, 20}}
+	for i, v := range counters {
+		c.Observe(map[string]model.Vector{
+			top.CPUMetric:    {container("a", v[0]), container("b", v[1])},
+			top.MemoryMetric: {container("a", float64(i)), container("b", 10)},
+		}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	rows := make(map[string]*top.PodMetric)
+	for _, p := range c.Table() {
+		rows[p.Metric] = p
+	}
+	// the summed counters rise by 60, 90, then 90 seconds a minute
+	cpu := rows[top.CPUMetric]
+	if cpu == nil || cpu.MinValue != 1 || cpu.MaxValue != 1.5 || cpu.AvgValue != 4./3 || cpu.InstValue != 1.5 {
+		t.Errorf("got cpu %s, want rates of 1, 1.5 and 1.5", cpu)
+	}
+	memory := rows[top.MemoryMetric]
+	if memory == nil || memory.MinValue != 10 || memory.MaxValue != 14 || memory.AvgValue != 12 || memory.InstValue != 14 {
+		t.Errorf("got memory %s, want the sums 10 through 14", memory)
+	}
+	if memory != nil && memory.Range != "4m" {
+		t.Errorf("got range %s, want the 4m between the first and last samples", memory.Range)
+	}
+}
+
+func TestCollectorQ95(t *testing.T) {
+	c, err := top.NewCollector(top.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	labels := model.Metric{"namespace": "ns", "pod": "app-1"}
+	// far more samples than the q95 sketch keeps exactly
+	const n = 10000
+	for i := 0; i < n; i++ {
+		c.Observe(map[string]model.Vector{
+			top.MemoryMetric: {{Metric: labels, Value: model.SampleValue(i % 100)}},
+		}, start.Add(time.Duration(i)*time.Second))
+	}
+	table := c.Table()
+	if len(table) != 1 {
+		t.Fatalf("got %d rows, want 1", len(table))
+	}
+	if got := table[0].Q95Value; math.Abs(got-94.05) > 1 {
+		t.Errorf("got q95 %v, want about 94.05", got)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Components of a pod, reported in PodMetric.Component when its containers are split.  Sidecars are reported by
+// their container name.
+const (
+	// ComponentMain is every container of the pod that is neither an init container nor a sidecar.
+	ComponentMain = "main"
+	// ComponentInit is the init containers of the pod, combined.
+	ComponentInit = "init"
+)
+
+// DefaultSidecars are the containers injected into the pods of OpenShift workloads by the service mesh and OAuth
+// proxy, split from the main container by default.
+var DefaultSidecars = []string{"istio-proxy", "oauth-proxy"}
+
+// containerName matches the names kubernetes accepts for containers, DNS-1123 labels.
+var containerName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// SplitTemplates returns the CPU and memory query templates of the pod view split by component: the init containers
+// of each pod, each of the sidecars, and its remaining, main containers each get a row, with the Component set.  The
+// rows of a pod add up to its usage, less that of the pause container, which isn't attributed to any component.
+// Requests include those of init containers.
+func SplitTemplates(sidecars []string) (map[string]map[Aggregation]string, error) {
+	for _, s := range sidecars {
+		if !containerName.MatchString(s) {
+			return nil, fmt.Errorf("invalid sidecar %q: must be a container name", s)
+		}
+		if s == ComponentMain || s == ComponentInit {
+			return nil, fmt.Errorf("invalid sidecar %q: reserved for the %s component", s, s)
+		}
+	}
+	// aggregate applies the aggregation opened by fn, e.g. "avg(", to the series of expr per component
+	aggregate := func(fn, expr string) string {
+		return fn + components(expr, sidecars) + `) by (pod, namespace, node, component)` + ownerJoin
+	}
+	requests := func(resource string) string {
+		return `(kube_pod_container_resource_requests{resource="` + resource + `"{{.ContainerMatchers}}}` +
+			` or kube_pod_init_container_resource_requests{resource="` + resource + `"{{.ContainerMatchers}}})`
+	}
+	cpu := `container_cpu_usage_seconds_total{container!='',container!='POD',pod!=''{{.Matchers}}}`
+	cpuRate := `{{if .Step}}avg_over_time({{end}}` +
+		`rate(` + cpu + `[{{.Window}}])` +
+		`{{if .Step}}[{{.Range}}:{{.Step}}]){{end}}`
+	memory := `container_memory_usage_bytes{container!='',container!='POD',pod!=''{{.Matchers}}}`
+	return map[string]map[Aggregation]string{
+		CPUMetric: {
+			Average:    aggregate(`avg(`, cpuRate),
+			Maximum:    aggregate(`max(`, cpuRate),
+			Minimum:    aggregate(`min(`, cpuRate),
+			Quantile95: aggregate(`quantile(.95, `, cpuRate),
+			Instant:    aggregate(`sum(`, cpu),
+			Request:    aggregate(`sum(`, requests("cpu")),
+		},
+		MemoryMetric: {
+			Average:    aggregate(`avg(`, memory),
+			Maximum:    aggregate(`max(`, memory),
+			Minimum:    aggregate(`min(`, memory),
+			Quantile95: aggregate(`quantile(.95, `, memory),
+			Instant:    aggregate(`sum(`, memory),
+			Request:    aggregate(`sum(`, requests("memory")),
+		},
+	}, nil
+}
+
+// components returns the series of expr, each labeled with the component of the pod its container belongs to.  Init
+// containers are identified by kube-state-metrics' kube_pod_init_container_info.
+func components(expr string, sidecars []string) string {
+	init := `label_replace(` + expr + ` and on(namespace, pod, container) kube_pod_init_container_info{container!=''{{.ContainerMatchers}}}, ` +
+		`"component", "` + ComponentInit + `", "", "")`
+	other := `label_replace(` + expr + `, "component", "` + ComponentMain + `", "", "")`
+	if len(sidecars) > 0 {
+		other = `label_replace(` + other + `, "component", "$1", "container", "(` + strings.Join(sidecars, "|") + `)")`
+	}
+	return init + ` or ignoring(component) ` + other
+}
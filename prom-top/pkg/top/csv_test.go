@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Jonathan Cope jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// csvTable is a table exercising every CSV column.
+var csvTable = PodMetricTable{
+	{Metric: "cpu", Range: "1h", Pod: "etcd-0", Namespace: "openshift-etcd", Node: "master-0", OwnerName: "etcd",
+		Q95Value: .4, MaxValue: .5, MinValue: .1, AvgValue: .3, InstValue: .2, Request: 1, Efficiency: .3,
+		Burstiness: 1.6, Q95Burstiness: 1.3, Cluster: "prod", RunID: "run-1", QueryTime: "2020-10-01T12:00:00Z",
+		Iteration: 2, Workload: "etcd", HPAMinReplicas: 1, HPAMaxReplicas: 3, Component: "etcd"},
+	{Metric: "memory", Range: "1h", Pod: "dns-default-x7k2p", Namespace: "openshift-dns", Node: "worker-0",
+		OwnerName: "dns-default", MaxValue: 5e7, Partial: true, Violation: "max", Workload: "dns-default"},
+}
+
+func TestWriteCSV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := csvTable[:1].WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := csvTable[1:].AppendCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 3 {
+		t.Errorf("got %d lines, want a header and 2 rows:\n%s", got, buf)
+	}
+	if !bytes.Equal(buf.Bytes(), csvTable.MarshalCSV()) {
+		t.Errorf("appended table differs from the table marshaled at once:\n%s", buf)
+	}
+	table, err := ReadCSV(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(table, csvTable) {
+		t.Errorf("round trip changed the table:\ngot  %v\nwant %v", table, csvTable)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"long schema":    "metric,aggregation,pod,namespace\n",
+		"missing column": "metric,pod\ncpu,etcd-0\n",
+		"bad value":      "metric,pod,namespace,max\ncpu,etcd-0,openshift-etcd,lots\n",
+	}
+	for name, in := range tests {
+		if _, err := ReadCSV(strings.NewReader(in)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMarshalCSVQuoting(t *testing.T) {
+	p := &PodMetric{Metric: "cpu", Pod: "a,b", Namespace: `say "hi"`, OwnerName: "multi\nline", Workload: "a,b",
+		Labels: map[string]string{"scenario": "density", "team": "x"}}
+	if got, want := len(p.csvRecord()), len(csvHeader); got != want {
+		t.Fatalf("got %d fields, want one per each of the %d header columns", got, want)
+	}
+	line := string(p.MarshalCSV())
+	for _, quoted := range []string{`,"a,b",`, `,"say ""hi""",`, "\"multi\nline\"", `,"scenario=density,team=x",`} {
+		if !strings.Contains(line, quoted) {
+			t.Errorf("%q lacks %q", line, quoted)
+		}
+	}
+	table, err := ReadCSV(bytes.NewReader(PodMetricTable{p}.MarshalCSV()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 1 || !reflect.DeepEqual(table[0], p) {
+		t.Errorf("round trip changed the row:\ngot  %v\nwant %v", table, p)
+	}
+}
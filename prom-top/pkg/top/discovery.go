@@ -0,0 +1,172 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// selectorRE matches the metric name of the series selectors of a query, e.g. container_memory_usage_bytes{...}.
+var selectorRE = regexp.MustCompile(`([a-zA-Z_:][a-zA-Z0-9_:]*)\{`)
+
+// Series returns the prometheus metrics read by the queries of the builder, each mapped to the target metrics, e.g.
+// cpu_usage_ratio, whose queries read it.
+func (b *QueryBuilder) Series() (map[string][]string, error) {
+	series := make(map[string][]string)
+	for _, metric := range b.Metrics() {
+		seen := make(map[string]bool)
+		for _, agg := range Aggregations {
+			if !b.Has(metric, agg) {
+				continue
+			}
+			expr, err := b.Build(metric, agg, defaultRange)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range selectorRE.FindAllStringSubmatch(expr, -1) {
+				if !seen[m[1]] {
+					seen[m[1]] = true
+					series[m[1]] = append(series[m[1]], metric)
+				}
+			}
+		}
+	}
+	return series, nil
+}
+
+// MetadataAPI is the part of the prometheus API read by DiscoverSeries.
+type MetadataAPI interface {
+	LabelValues(ctx context.Context, label string, startTime time.Time, endTime time.Time) (model.LabelValues, promv1.Warnings, error)
+	Metadata(ctx context.Context, metric string, limit string) (map[string][]promv1.Metadata, error)
+}
+
+// SeriesInfo describes a prometheus metric and whether the cluster has it.
+type SeriesInfo struct {
+	Name string
+	// Targets are the target metrics whose queries read the series, empty for series only matched by a pattern.
+	Targets []string
+	// Found is set if prometheus holds samples of the series in the last hour.
+	Found bool
+	// Type and Help are the metadata reported by the targets exposing the series, empty if none do, e.g. for
+	// recording rules.
+	Type string
+	Help string
+}
+
+// DiscoverSeries reports which of the series read by the queries of b exist on the cluster, with their type and
+// help text.  pattern (optional) restricts the report to the series it matches, and adds every other series of the
+// cluster it matches, e.g. to find the new name of a renamed metric.  The list is sorted by name.
+func DiscoverSeries(ctx context.Context, api MetadataAPI, b *QueryBuilder, pattern *regexp.Regexp) ([]SeriesInfo, error) {
+	targets, err := b.Series()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	names, _, err := api.LabelValues(ctx, model.MetricNameLabel, now.Add(-time.Hour), now)
+	if err != nil {
+		return nil, fmt.Errorf("listing metric names: %v", err)
+	}
+	metadata, err := api.Metadata(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("reading metric metadata: %v", err)
+	}
+
+	found := make(map[string]bool, len(names))
+	for _, n := range names {
+		found[string(n)] = true
+	}
+	listed := make(map[string]bool)
+	for name := range targets {
+		if pattern == nil || pattern.MatchString(name) {
+			listed[name] = true
+		}
+	}
+	if pattern != nil {
+		for name := range found {
+			if pattern.MatchString(name) {
+				listed[name] = true
+			}
+		}
+	}
+
+	infos := make([]SeriesInfo, 0, len(listed))
+	for name := range listed {
+		info := SeriesInfo{Name: name, Targets: targets[name], Found: found[name]}
+		if md := metadata[name]; len(md) > 0 {
+			info.Type, info.Help = string(md[0].Type), md[0].Help
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// histogramSeries matches the names of the series of a histogram or summary, capturing the name of the metric.
+var histogramSeries = regexp.MustCompile(`^(.+)_(bucket|sum|count)$`)
+
+// ExpandMetrics returns the metrics of the cluster whose names pattern matches in full, as PromQL regular expressions
+// do, each mapped to its type for MetricTemplates.  The series of a histogram are matched by their own names and by
+// the histogram's, and collected as one metric.  Summaries, which cannot be aggregated across pods, are skipped.
+func ExpandMetrics(ctx context.Context, api MetadataAPI, pattern string) (map[string]string, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	now := time.Now()
+	names, _, err := api.LabelValues(ctx, model.MetricNameLabel, now.Add(-time.Hour), now)
+	if err != nil {
+		return nil, fmt.Errorf("listing metric names: %v", err)
+	}
+	metadata, err := api.Metadata(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("reading metric metadata: %v", err)
+	}
+	typeOf := func(name string) string {
+		if md := metadata[name]; len(md) > 0 {
+			return string(md[0].Type)
+		}
+		return ""
+	}
+
+	metrics := make(map[string]string)
+	for _, n := range names {
+		name, metricType := string(n), typeOf(string(n))
+		if m := histogramSeries.FindStringSubmatch(name); m != nil {
+			switch typeOf(m[1]) {
+			case string(promv1.MetricTypeHistogram):
+				if re.MatchString(m[1]) || re.MatchString(name) {
+					metrics[m[1]] = string(promv1.MetricTypeHistogram)
+				}
+				continue
+			case string(promv1.MetricTypeSummary):
+				continue
+			}
+		}
+		if metricType == string(promv1.MetricTypeSummary) || !re.MatchString(name) {
+			continue
+		}
+		metrics[name] = metricType
+	}
+	return metrics, nil
+}
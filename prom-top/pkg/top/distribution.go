@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"math"
+	"sort"
+)
+
+// AppDistribution describes how an aggregation of a metric is spread across the pods of an app, e.g. the p95 of
+// the per-pod average memory of every router pod.  For horizontally scaled components this is the statistic to
+// size a replica by, where a single pod's usage over time may be unrepresentative.
+type AppDistribution struct {
+	Metric    string
+	Namespace string
+	App       string
+	Pods      int
+	Min       float64
+	P50       float64
+	P95       float64
+	Max       float64
+}
+
+// Distributions returns the distribution of agg across the pods of every app with at least two pods, sorted by
+// namespace, app, and metric.  Quantiles are interpolated between closest ranks, as by Prometheus' quantile.  Rows
+// without a value, NaN, are skipped.  Pods without an owner are treated as their own app.
+func (pm PodMetricTable) Distributions(agg Aggregation) []AppDistribution {
+	type key struct{ metric, namespace, app string }
+	values := make(map[key][]float64)
+	for _, p := range pm {
+		v := p.Value(agg)
+		if math.IsNaN(v) {
+			continue
+		}
+		app := p.OwnerName
+		if app == "" {
+			app = p.Pod
+		}
+		k := key{p.Metric, p.Namespace, app}
+		values[k] = append(values[k], v)
+	}
+	var result []AppDistribution
+	for k, v := range values {
+		if len(v) < 2 {
+			continue
+		}
+		sort.Float64s(v)
+		result = append(result, AppDistribution{
+			Metric:    k.metric,
+			Namespace: k.namespace,
+			App:       k.app,
+			Pods:      len(v),
+			Min:       v[0],
+			P50:       quantile(v, .5),
+			P95:       quantile(v, .95),
+			Max:       v[len(v)-1],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.App != b.App {
+			return a.App < b.App
+		}
+		return a.Metric < b.Metric
+	})
+	return result
+}
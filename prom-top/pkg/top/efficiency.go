@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "sort"
+
+// derive computes the columns derived from the collected aggregations and labels: the Workload of every row, the
+// Efficiency of every row with a resource request, and the burstiness of every row with a non-zero average.
+func (pm PodMetricTable) derive() PodMetricTable {
+	for _, p := range pm {
+		p.Workload = Workload(p.Pod)
+		if p.Request > 0 {
+			p.Efficiency = p.AvgValue / p.Request
+		}
+		if p.AvgValue > 0 {
+			p.Burstiness = p.MaxValue / p.AvgValue
+			p.Q95Burstiness = p.Q95Value / p.AvgValue
+		}
+	}
+	return pm
+}
+
+// AppEfficiency is the efficiency of an app, its pods' summed average usage over their summed requests.
+type AppEfficiency struct {
+	Metric     string
+	Namespace  string
+	App        string
+	Usage      float64
+	Request    float64
+	Efficiency float64
+}
+
+// Efficiencies summarizes the efficiency of every app with a resource request, least efficient, i.e. most
+// over-requested, first.  Pods without an owner are treated as their own app.
+func (pm PodMetricTable) Efficiencies() []AppEfficiency {
+	type key struct{ metric, namespace, app string }
+	apps := make(map[key]*AppEfficiency)
+	for _, p := range pm {
+		if p.Request <= 0 {
+			continue
+		}
+		app := p.OwnerName
+		if app == "" {
+			app = p.Pod
+		}
+		k := key{p.Metric, p.Namespace, app}
+		if apps[k] == nil {
+			apps[k] = &AppEfficiency{Metric: p.Metric, Namespace: p.Namespace, App: app}
+		}
+		apps[k].Usage += p.AvgValue
+		apps[k].Request += p.Request
+	}
+	result := make([]AppEfficiency, 0, len(apps))
+	for _, a := range apps {
+		a.Efficiency = a.Usage / a.Request
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Efficiency != result[j].Efficiency {
+			return result[i].Efficiency < result[j].Efficiency
+		}
+		return result[i].Namespace+"/"+result[i].App < result[j].Namespace+"/"+result[j].App
+	})
+	return result
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "sort"
+
+// AtLeast returns the rows whose Q95Value reaches the floor of their metric, keyed by metric name.  Rows of metrics
+// without a floor are kept.  The rows are shared with pm, not copied.
+func (pm PodMetricTable) AtLeast(floors map[string]float64) PodMetricTable {
+	kept := make(PodMetricTable, 0, len(pm))
+	for _, p := range pm {
+		if floor, ok := floors[p.Metric]; ok && p.Q95Value < floor {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// TopPerNamespace returns the k rows of each namespace and metric with the highest Q95Value, sorted by namespace,
+// metric, and descending Q95Value.  The rows are shared with pm, not copied.
+func (pm PodMetricTable) TopPerNamespace(k int) PodMetricTable {
+	sorted := append(PodMetricTable(nil), pm...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Q95Value > b.Q95Value
+	})
+	kept := make(PodMetricTable, 0, len(sorted))
+	n := 0
+	for i, p := range sorted {
+		if i > 0 && (p.Namespace != sorted[i-1].Namespace || p.Metric != sorted[i-1].Metric) {
+			n = 0
+		}
+		if n < k {
+			kept = append(kept, p)
+		}
+		n++
+	}
+	return kept
+}
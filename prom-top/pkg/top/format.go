@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NumberFormat overrides how values are rendered in text output, i.e. CSV, logs, and report tables.
+type NumberFormat struct {
+	// Notation is 'f' for fixed or 'e' for scientific notation, 0 to keep each output's default notation.
+	Notation byte
+	// Precision is the number of digits after the decimal point, negative to keep each output's default precision.
+	// When only Notation is set, values are rendered with the fewest digits that represent them exactly.
+	Precision int
+}
+
+// Validate returns an error if f.Notation is unknown.
+func (f NumberFormat) Validate() error {
+	switch f.Notation {
+	case 0, 'f', 'e':
+		return nil
+	default:
+		return fmt.Errorf("unknown notation %q", f.Notation)
+	}
+}
+
+var numberFormat = NumberFormat{Precision: -1}
+
+// SetNumberFormat overrides the rendering of values by every text output.  It must be called before any output is
+// written.
+func SetNumberFormat(f NumberFormat) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	numberFormat = f
+	return nil
+}
+
+// FormatFloat renders v with the strconv.FormatFloat format and precision of the output, unless overridden by
+// SetNumberFormat.
+func FormatFloat(v float64, format byte, precision int) string {
+	if numberFormat.Notation != 0 {
+		format, precision = numberFormat.Notation, -1
+	}
+	if numberFormat.Precision >= 0 {
+		precision = numberFormat.Precision
+	}
+	return strconv.FormatFloat(v, format, precision, 64)
+}
+
+// FormatValue renders v for an aligned table, with 4 significant digits unless overridden by SetNumberFormat.
+func FormatValue(v float64) string {
+	return FormatFloat(v, 'g', 4)
+}
+
+// FormatDelta renders v like FormatValue, always signed.
+func FormatDelta(v float64) string {
+	s := FormatValue(v)
+	if s[0] != '-' && s[0] != '+' && s != "NaN" {
+		s = "+" + s
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"time"
+)
+
+// hpaReplicasQuery returns the query of the fewest (fn min_over_time) or most (max_over_time) replicas of every
+// HorizontalPodAutoscaler over queryRange, labeled with the name of its scale target where kube-state-metrics reports
+// it.  kube-state-metrics releases before 2.0 name the series kube_hpa_* and the autoscaler label hpa.
+func hpaReplicasQuery(fn, queryRange string) string {
+	replicas := fn + `(kube_horizontalpodautoscaler_status_current_replicas[` + queryRange + `])`
+	legacy := `label_replace(` + fn + `(kube_hpa_status_current_replicas[` + queryRange + `]), "horizontalpodautoscaler", "$1", "hpa", "(.*)")`
+	return `(` + replicas + ` * on(namespace, horizontalpodautoscaler) group_left(scaletargetref_name) kube_horizontalpodautoscaler_info)` +
+		` or on(namespace, horizontalpodautoscaler) ` + replicas +
+		` or on(namespace, horizontalpodautoscaler) ` + legacy
+}
+
+// ReplicaRange is the fewest and most replicas an autoscaler ran.
+type ReplicaRange struct {
+	Min, Max int
+}
+
+// HPAReplicas returns the fewest and most replicas of every HorizontalPodAutoscaler over cfg.Range, keyed by
+// namespace/workload of its scale target.  Autoscalers whose target kube-state-metrics does not report are keyed by
+// their own name, which usually is that of their target.
+func HPAReplicas(cfg Config) (map[string]ReplicaRange, error) {
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
+	if cfg.Range == "" {
+		cfg.Range = defaultRange
+	}
+	if err := ValidateDuration(cfg.Range); err != nil {
+		return nil, err
+	}
+	now := cfg.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	replicas := make(map[string]ReplicaRange)
+	for _, fn := range []string{"min_over_time", "max_over_time"} {
+		vector, _, err := query(cfg, Query{Metric: "hpa_replicas", Expr: hpaReplicasQuery(fn, cfg.Range)}, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range vector {
+			target := sample.Metric["scaletargetref_name"]
+			if target == "" {
+				target = sample.Metric["horizontalpodautoscaler"]
+			}
+			key := string(sample.Metric["namespace"]) + "/" + string(target)
+			r := replicas[key]
+			if fn == "min_over_time" {
+				r.Min = int(sample.Value)
+			} else {
+				r.Max = int(sample.Value)
+			}
+			replicas[key] = r
+		}
+	}
+	return replicas, nil
+}
+
+// AttachHPAReplicas sets the HPAMinReplicas and HPAMaxReplicas of the rows of the autoscaled workloads of replicas,
+// see HPAReplicas, so that changes of usage caused by autoscaling can be told from changes of the usage per pod.
+func (pm PodMetricTable) AttachHPAReplicas(replicas map[string]ReplicaRange) {
+	for _, p := range pm {
+		if r, ok := replicas[p.Namespace+"/"+p.Workload]; ok {
+			p.HPAMinReplicas, p.HPAMaxReplicas = r.Min, r.Max
+		}
+	}
+}
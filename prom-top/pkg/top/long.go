@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+)
+
+// LongPodMetric is a single aggregation of a PodMetric.  Where a PodMetric holds every aggregation in its own
+// column, the long schema holds one row per aggregation, named in the Aggregation column.  This is the shape
+// expected by Grafana SQL panels and most BI tools.
+type LongPodMetric dbhandler.LongRow
+
+type LongPodMetricTable []*LongPodMetric
+
+// longCSVHeader names the CSV columns of the long schema, in the order written by csvRecord.
+var longCSVHeader = []string{
+	"metric", "range", "pod", "namespace", "node", "label-app", "aggregation", "value", "partial", "cluster", "violation", "run-id",
+	"query-time", "iteration", "labels", "workload", "hpa-min-replicas", "hpa-max-replicas", "component",
+	"pod-labels",
+}
+
+func (p LongPodMetric) csvRecord() []string {
+	return []string{
+		p.Metric, p.Range, p.Pod, p.Namespace, p.Node, p.OwnerName, p.Aggregation,
+		floatToString(p.Value), strconv.FormatBool(p.Partial), p.Cluster, p.Violation, p.RunID,
+		p.QueryTime, strconv.Itoa(p.Iteration), p.Labels.String(), p.Workload,
+		strconv.Itoa(p.HPAMinReplicas), strconv.Itoa(p.HPAMaxReplicas), p.Component,
+		p.PodLabels.String(),
+	}
+}
+
+// Long returns the table in the long schema, one row per pod, metric, and aggregation.
+func (pm PodMetricTable) Long() LongPodMetricTable {
+	long := make(LongPodMetricTable, 0, len(pm)*len(Aggregations))
+	for _, p := range pm {
+		for _, agg := range Aggregations {
+			long = append(long, &LongPodMetric{
+				Version:     p.Version,
+				Cluster:     p.Cluster,
+				Metric:      p.Metric,
+				Pod:         p.Pod,
+				Range:       p.Range,
+				Namespace:   p.Namespace,
+				OwnerName:   p.OwnerName,
+				Node:        p.Node,
+				QueryTime:   p.QueryTime,
+				Aggregation: string(agg),
+				Value:       p.Value(agg),
+				Partial:     p.Partial,
+				Violation:   p.Violation,
+				RunID:       p.RunID,
+				Iteration:   p.Iteration,
+				Labels:      p.Labels,
+				Workload:    p.Workload,
+
+				HPAMinReplicas: p.HPAMinReplicas,
+				HPAMaxReplicas: p.HPAMaxReplicas,
+				Component:      p.Component,
+				PodLabels:      p.PodLabels,
+			})
+		}
+	}
+	return long
+}
+
+// WriteCSV streams the table as RFC 4180 CSV to w.
+func (lt LongPodMetricTable) WriteCSV(w io.Writer) error {
+	if err := writeCSVHeader(w, longCSVHeader); err != nil {
+		return err
+	}
+	return lt.AppendCSV(w)
+}
+
+// AppendCSV writes the rows of the table as CSV to w without a header, extending a file written by WriteCSV.
+func (lt LongPodMetricTable) AppendCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	for _, line := range lt {
+		if err := cw.Write(line.csvRecord()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
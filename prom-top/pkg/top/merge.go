@@ -0,0 +1,71 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "strings"
+
+// Reaggregate combines the rows of the same cluster, namespace, pod, component, and metric, e.g. from several runs,
+// into one.  Rows are expected in chronological order.  The combined row holds the mean of the averages, the largest
+// maximum, q95, and request, and the smallest minimum.  Taking the largest q95 is conservative: the q95 of the
+// combined range can't be derived from the q95 of its parts.  The RunID of the combined row joins those of its parts
+// with "+", the rest of its fields, including the instant value, are taken from the last part.
+func (pm PodMetricTable) Reaggregate() PodMetricTable {
+	type key struct {
+		cluster, namespace, pod, component, metric string
+	}
+	groups := make(map[key]PodMetricTable)
+	var order []key
+	for _, p := range pm {
+		k := key{p.Cluster, p.Namespace, p.Pod, p.Component, p.Metric}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], p)
+	}
+
+	combined := make(PodMetricTable, 0, len(groups))
+	for _, k := range order {
+		parts := groups[k]
+		c := *parts[len(parts)-1]
+		var sum float64
+		var runs []string
+		for _, p := range parts {
+			sum += p.AvgValue
+			if p.MaxValue > c.MaxValue {
+				c.MaxValue = p.MaxValue
+			}
+			if p.Q95Value > c.Q95Value {
+				c.Q95Value = p.Q95Value
+			}
+			if p.Request > c.Request {
+				c.Request = p.Request
+			}
+			if p.MinValue < c.MinValue {
+				c.MinValue = p.MinValue
+			}
+			c.Partial = c.Partial || p.Partial
+			if p.RunID != "" {
+				runs = append(runs, p.RunID)
+			}
+		}
+		c.AvgValue = sum / float64(len(parts))
+		c.RunID = strings.Join(runs, "+")
+		c.Efficiency, c.Burstiness, c.Q95Burstiness = 0, 0, 0
+		combined = append(combined, &c)
+	}
+	return combined.derive()
+}
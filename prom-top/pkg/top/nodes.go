@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "sort"
+
+// Metric names of the node-exporter utilization of each node, as reported in PodMetric.Metric.  The usage of the
+// pods of each node is reported as CPUMetric and MemoryMetric.
+const (
+	NodeCPUMetric    = "node_cpu_utilization_ratio"
+	NodeMemoryMetric = "node_memory_utilization_ratio"
+)
+
+// nodeRateWindow is the range selector of the rates sampled over Range by the node queries.
+const nodeRateWindow = "5m"
+
+// byNode copies the instance label of node-exporter series, the name of the node on OpenShift and kube-prometheus, to
+// the node label.
+func byNode(expr string) string {
+	return `label_replace(` + expr + `, "node", "$1", "instance", "(.*)")`
+}
+
+// nodeExprs maps each node metric to an instant expression of the value of every node.
+var nodeExprs = map[string]string{
+	CPUMetric:        `sum by (node) (rate(container_cpu_usage_seconds_total{pod!='',container!=''{{.Matchers}}}[` + nodeRateWindow + `]))`,
+	MemoryMetric:     `sum by (node) (container_memory_usage_bytes{pod!='',container!=''{{.Matchers}}})`,
+	NodeCPUMetric:    `1 - avg by (node) (` + byNode(`rate(node_cpu_seconds_total{mode="idle"}[`+nodeRateWindow+`])`) + `)`,
+	NodeMemoryMetric: `1 - sum by (node) (` + byNode(`node_memory_MemAvailable_bytes{}`) + `) / sum by (node) (` + byNode(`node_memory_MemTotal_bytes{}`) + `)`,
+}
+
+// nodeRequests maps the pod usage metrics of the node queries to the expression of the requests of every node's pods.
+var nodeRequests = map[string]string{
+	CPUMetric:    `sum by (node) (kube_pod_container_resource_requests{resource="cpu"{{.ContainerMatchers}}})`,
+	MemoryMetric: `sum by (node) (kube_pod_container_resource_requests{resource="memory"{{.ContainerMatchers}}})`,
+}
+
+// NodeTemplates returns the query templates of the node view: the usage of the pods of each node, summed, and the
+// utilization of each node reported by node-exporter.  Unlike the pod queries, the statistics are computed over
+// Range, from the values sampled every Step, or at prometheus' evaluation interval without one.  Matchers only apply
+// to the pod usage, node-exporter describes the whole node.
+func NodeTemplates() map[string]map[Aggregation]string {
+	templates := make(map[string]map[Aggregation]string, len(nodeExprs))
+	for metric, expr := range nodeExprs {
+		overRange := `(` + expr + `)[{{.Range}}:{{.Step}}]`
+		templates[metric] = map[Aggregation]string{
+			Average:    `avg_over_time(` + overRange + `)`,
+			Maximum:    `max_over_time(` + overRange + `)`,
+			Minimum:    `min_over_time(` + overRange + `)`,
+			Quantile95: `quantile_over_time(.95, ` + overRange + `)`,
+			Instant:    expr,
+		}
+		if request, ok := nodeRequests[metric]; ok {
+			templates[metric][Request] = request
+		}
+	}
+	return templates
+}
+
+// nodeBuilder is parsed once at package init.
+var nodeBuilder = mustNewQueryBuilder(NodeTemplates())
+
+// NodeQueryBuilder returns the builder of the node view, whose rows have a Node and no Pod.
+func NodeQueryBuilder() *QueryBuilder {
+	return nodeBuilder
+}
+
+// Busiest returns the rows sorted by metric and descending Q95Value, ranking the nodes of a node view from the
+// busiest.  The rows are shared with pm, not copied.
+func (pm PodMetricTable) Busiest() PodMetricTable {
+	sorted := append(PodMetricTable(nil), pm...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Q95Value > b.Q95Value
+	})
+	return sorted
+}
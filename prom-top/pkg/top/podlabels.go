@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+)
+
+// podLabelPrefix prefixes the pod labels kube-state-metrics exports on kube_pod_labels.
+const podLabelPrefix = "label_"
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// PodLabelName returns the name kube-state-metrics gives Kubernetes pod label name on kube_pod_labels, e.g.
+// label_app_kubernetes_io_name for app.kubernetes.io/name.  Names already in that form are returned unchanged.
+func PodLabelName(name string) string {
+	if strings.HasPrefix(name, podLabelPrefix) {
+		return name
+	}
+	return podLabelPrefix + invalidLabelChars.ReplaceAllString(name, "_")
+}
+
+// podLabelJoin wraps expr, a query by pod and namespace, to copy the labels of the pods' kube_pod_labels series onto
+// its results in a single group_right.  matchers are those of the other series selectors.  Pods without a
+// kube_pod_labels series are dropped, as by the owner join.
+func podLabelJoin(expr string, labels []string, matchers string) string {
+	names := strings.Join(labels, ", ")
+	return fmt.Sprintf("max by (namespace, pod, %s) (kube_pod_labels{pod!=''%s}) * on(namespace, pod) group_right(%s) (%s)",
+		names, matchers, names, expr)
+}
+
+// podLabels returns the pod labels joined onto a series, keyed by their kube_pod_labels name, nil if there are none.
+func podLabels(m model.Metric) dbhandler.Labels {
+	var labels dbhandler.Labels
+	for name, value := range m {
+		if !strings.HasPrefix(string(name), podLabelPrefix) {
+			continue
+		}
+		if labels == nil {
+			labels = make(dbhandler.Labels)
+		}
+		labels[string(name)] = string(value)
+	}
+	return labels
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+const readyPath = "/-/ready"
+
+// DefaultStaleAfter is the age beyond which Preflight reports the last scrape of a target as stale, several times
+// the usual 30s scrape interval.
+const DefaultStaleAfter = 2 * time.Minute
+
+// dataSource is a group of scrape targets exposing the series the queries read.
+type dataSource struct {
+	name  string
+	match func(t promv1.ActiveTarget) bool
+}
+
+var dataSources = []dataSource{
+	{"kube-state-metrics", func(t promv1.ActiveTarget) bool {
+		return t.Labels["job"] == "kube-state-metrics" || strings.Contains(t.ScrapeURL, "kube-state-metrics")
+	}},
+	{"cAdvisor", func(t promv1.ActiveTarget) bool {
+		return strings.HasSuffix(t.ScrapeURL, "/metrics/cadvisor")
+	}},
+}
+
+// Preflight checks that prometheus is ready and that the targets of kube-state-metrics, which exposes the pod
+// owners and requests, and cAdvisor, which exposes the container usage, are scraped: that each has targets, all of
+// them up, and none last scraped longer than staleAfter ago.  It returns a description of every problem found, which
+// degrade the results without necessarily failing the queries.  The error reports that the checks could not be run.
+func Preflight(ctx context.Context, client promapi.Client, staleAfter time.Duration) ([]string, error) {
+	if staleAfter <= 0 {
+		staleAfter = DefaultStaleAfter
+	}
+	var problems []string
+	req, err := http.NewRequest(http.MethodGet, client.URL(readyPath, nil).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", readyPath, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		problems = append(problems, fmt.Sprintf("prometheus is not ready: %s", resp.Status))
+	}
+
+	targets, err := promv1.NewAPI(client).Targets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing targets: %v", err)
+	}
+	now := time.Now()
+	for _, src := range dataSources {
+		var matched, down, stale int
+		var lastError string
+		for _, t := range targets.Active {
+			if !src.match(t) {
+				continue
+			}
+			matched++
+			if t.Health != promv1.HealthGood {
+				down++
+				if t.LastError != "" {
+					lastError = t.LastError
+				}
+			} else if now.Sub(t.LastScrape) > staleAfter {
+				stale++
+			}
+		}
+		switch {
+		case matched == 0:
+			problems = append(problems, fmt.Sprintf("no %s targets are scraped, its series will be missing", src.name))
+			continue
+		case down > 0 && lastError != "":
+			problems = append(problems, fmt.Sprintf("%d/%d %s targets are down, e.g. %s", down, matched, src.name, lastError))
+		case down > 0:
+			problems = append(problems, fmt.Sprintf("%d/%d %s targets are down", down, matched, src.name))
+		}
+		if stale > 0 {
+			problems = append(problems, fmt.Sprintf("%d/%d %s targets were last scraped over %s ago", stale, matched, src.name, staleAfter))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Every user supplied value is validated or escaped here before it is substituted into a query template, so that
+// malformed input is rejected up front and can't alter the structure of the generated PromQL.
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// ValidateMetricName returns an error if name is not a legal Prometheus metric name.
+func ValidateMetricName(name string) error {
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	return nil
+}
+
+// ValidateLabelName returns an error if name is not a legal Prometheus label name.
+func ValidateLabelName(name string) error {
+	if !labelNameRE.MatchString(name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	return nil
+}
+
+// ValidateDuration returns an error if d is not a Prometheus duration, e.g. 10m.
+func ValidateDuration(d string) error {
+	if _, err := model.ParseDuration(d); err != nil {
+		return fmt.Errorf("invalid duration %q: %v", d, err)
+	}
+	return nil
+}
+
+// QuoteLabelValue returns v as a double-quoted PromQL string literal, escaping quotes, backslashes and control
+// characters.
+func QuoteLabelValue(v string) string {
+	// PromQL string literals follow Go's escaping rules
+	return strconv.Quote(v)
+}
+
+// MatchType is a label matching operator.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher is a label matcher appended to the series selectors of every query.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// PodMatchers returns the matchers of matchers on the namespace and pod labels, the only labels the series of
+// kube-state-metrics describing pods, such as kube_pod_owner, share with the cAdvisor series of their containers.
+// Matching them on e.g. container or image would leave nothing to join.
+func PodMatchers(matchers []Matcher) []Matcher {
+	var pod []Matcher
+	for _, m := range matchers {
+		if m.Name == "namespace" || m.Name == "pod" {
+			pod = append(pod, m)
+		}
+	}
+	return pod
+}
+
+// ContainerMatchers returns the matchers of matchers on the namespace, pod, and container labels, the only labels the
+// series of kube-state-metrics describing containers, such as kube_pod_container_resource_requests, share with the
+// cAdvisor series.  Matching them on e.g. image or id would leave no requests.
+func ContainerMatchers(matchers []Matcher) []Matcher {
+	var container []Matcher
+	for _, m := range matchers {
+		if m.Name == "namespace" || m.Name == "pod" || m.Name == "container" {
+			container = append(container, m)
+		}
+	}
+	return container
+}
+
+// NewMatcher returns a validated Matcher.  Regular expression values must compile.
+func NewMatcher(name string, t MatchType, value string) (Matcher, error) {
+	m := Matcher{Name: name, Type: t, Value: value}
+	return m, m.Validate()
+}
+
+// ParseMatcher parses a matcher of the form name=value, name!=value, name=~regex or name!~regex.  The value is taken
+// literally; it must not be quoted.
+func ParseMatcher(s string) (Matcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i < 0 {
+		return Matcher{}, fmt.Errorf("invalid matcher %q: expected name=value, name!=value, name=~regex or name!~regex", s)
+	}
+	name, rest := s[:i], s[i:]
+	for _, t := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+		if strings.HasPrefix(rest, string(t)) {
+			return NewMatcher(name, t, strings.TrimPrefix(rest, string(t)))
+		}
+	}
+	return Matcher{}, fmt.Errorf("invalid matcher %q: expected name=value, name!=value, name=~regex or name!~regex", s)
+}
+
+// Validate returns an error if the matcher's name, type, or value is not legal.
+func (m Matcher) Validate() error {
+	if err := ValidateLabelName(m.Name); err != nil {
+		return err
+	}
+	switch m.Type {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+			return fmt.Errorf("invalid regex for label %q: %v", m.Name, err)
+		}
+	default:
+		return fmt.Errorf("invalid match type %q for label %q", m.Type, m.Name)
+	}
+	return nil
+}
+
+// String renders the matcher as PromQL, with the value safely quoted.
+func (m Matcher) String() string {
+	return m.Name + string(m.Type) + QuoteLabelValue(m.Value)
+}
+
+// anyOf returns a regex matcher for label name equal to any of values.
+func anyOf(name string, values []string) Matcher {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = regexp.QuoteMeta(v)
+	}
+	return Matcher{Name: name, Type: MatchRegexp, Value: strings.Join(quoted, "|")}
+}
+
+// NoneOf returns a regex matcher for label name equal to none of values.
+func NoneOf(name string, values []string) Matcher {
+	m := anyOf(name, values)
+	m.Type = MatchNotRegexp
+	return m
+}
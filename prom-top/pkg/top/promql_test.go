@@ -0,0 +1,118 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMatcher(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Matcher
+		wantErr bool
+	}{
+		{in: "namespace=openshift-etcd", want: Matcher{"namespace", MatchEqual, "openshift-etcd"}},
+		{in: "namespace!=default", want: Matcher{"namespace", MatchNotEqual, "default"}},
+		{in: "pod=~etcd-.*", want: Matcher{"pod", MatchRegexp, "etcd-.*"}},
+		{in: "pod!~etcd-.*", want: Matcher{"pod", MatchNotRegexp, "etcd-.*"}},
+		{in: "image=a=b", want: Matcher{"image", MatchEqual, "a=b"}},
+		{in: `pod="etcd-0"`, want: Matcher{"pod", MatchEqual, `"etcd-0"`}},
+		{in: "namespace", wantErr: true},
+		{in: "=etcd", wantErr: true},
+		{in: "name-space=etcd", wantErr: true},
+		{in: "pod=~etcd-(", wantErr: true},
+		{in: "pod!etcd", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMatcher(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherString(t *testing.T) {
+	tests := []struct {
+		m    Matcher
+		want string
+	}{
+		{Matcher{"pod", MatchEqual, "etcd-0"}, `pod="etcd-0"`},
+		{Matcher{"pod", MatchEqual, `"} or vector(1) #`}, `pod="\"} or vector(1) #"`},
+		{Matcher{"pod", MatchRegexp, `etcd-\d+`}, `pod=~"etcd-\\d+"`},
+		{Matcher{"pod", MatchNotEqual, "a\nb"}, `pod!="a\nb"`},
+		{anyOf("namespace", []string{"a.b", "c"}), `namespace=~"a\\.b|c"`},
+		{NoneOf("namespace", []string{"kube-system"}), `namespace!~"kube-system"`},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"metric name", ValidateMetricName("container_cpu_usage_seconds_total"), true},
+		{"recording rule name", ValidateMetricName("node_namespace_pod:cpu:sum"), true},
+		{"metric name with a dash", ValidateMetricName("container-cpu"), false},
+		{"metric name with a brace", ValidateMetricName(`up{job="x"}`), false},
+		{"label name", ValidateLabelName("label_app_kubernetes_io_name"), true},
+		{"label name with a colon", ValidateLabelName("a:b"), false},
+		{"label name starting with a digit", ValidateLabelName("1app"), false},
+		{"duration", ValidateDuration("90m"), true},
+		{"duration without a unit", ValidateDuration("90"), false},
+		{"fractional duration", ValidateDuration("1.5h"), false},
+		{"match type", Matcher{"pod", "==", "x"}.Validate(), false},
+	}
+	for _, tt := range tests {
+		if got := tt.err == nil; got != tt.valid {
+			t.Errorf("%s: got error %v", tt.name, tt.err)
+		}
+	}
+}
+
+func TestPodMatchers(t *testing.T) {
+	matchers := []Matcher{
+		{"namespace", MatchEqual, "app"},
+		{"container", MatchEqual, "web"},
+		{"pod", MatchRegexp, "web-.*"},
+		{"image", MatchRegexp, ".*nginx.*"},
+	}
+	if got, want := PodMatchers(matchers), []Matcher{matchers[0], matchers[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pod matchers %v, want %v", got, want)
+	}
+	if got, want := ContainerMatchers(matchers), matchers[:3]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got container matchers %v, want %v", got, want)
+	}
+	if got := PodMatchers(matchers[3:]); got != nil {
+		t.Errorf("got pod matchers %v, want none", got)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const queryPath = "/api/v1/query"
+
+// PrometheusStats are the execution stats prometheus reports for a query evaluated with stats=all.
+type PrometheusStats struct {
+	// EvalTime is the time prometheus spent evaluating the query, excluding its wait in the queue.
+	EvalTime time.Duration
+	// QueueTime is the time the query waited for one of prometheus' --query.max-concurrency slots.
+	QueueTime time.Duration
+	// SamplesLoaded is the number of samples read to evaluate the query.  Prometheus reports it since 2.35.
+	SamplesLoaded int64
+	// PeakSamples is the largest number of samples held in memory at once during the evaluation.
+	PeakSamples int64
+}
+
+// add accumulates the stats of another query into s.  Peak samples are not additive, the larger is kept.
+func (s *PrometheusStats) add(o PrometheusStats) {
+	s.EvalTime += o.EvalTime
+	s.QueueTime += o.QueueTime
+	s.SamplesLoaded += o.SamplesLoaded
+	if o.PeakSamples > s.PeakSamples {
+		s.PeakSamples = o.PeakSamples
+	}
+}
+
+// StatsQuerier is a Querier that also reports the execution stats of each query.  Queries executed through a
+// StatsQuerier record them in QueryStat.Prometheus.
+type StatsQuerier interface {
+	Querier
+	QueryWithStats(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, PrometheusStats, error)
+}
+
+// statsQuerier queries prometheus' instant query API with stats=all, and any other params.
+type statsQuerier struct {
+	client promapi.Client
+	params url.Values
+}
+
+// NewStatsQuerier returns a StatsQuerier executing queries against client.  Its results are those of v1.API.
+// Prometheus older than 2.35 reports no samples, and query frontends may not report stats at all, in which case they
+// are left zero.
+func NewStatsQuerier(client promapi.Client) StatsQuerier {
+	return &statsQuerier{client: client, params: url.Values{"stats": {"all"}}}
+}
+
+func (q *statsQuerier) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	v, warnings, _, err := q.QueryWithStats(ctx, query, ts)
+	return v, warnings, err
+}
+
+func (q *statsQuerier) QueryWithStats(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, PrometheusStats, error) {
+	var stats PrometheusStats
+	form := url.Values{}
+	for name, values := range q.params {
+		form[name] = values
+	}
+	form.Set("query", query)
+	if !ts.IsZero() {
+		form.Set("time", strconv.FormatFloat(float64(ts.UnixNano())/1e9, 'f', -1, 64))
+	}
+	req, err := http.NewRequest(http.MethodPost, q.client.URL(queryPath, nil).String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, body, err := q.client.Do(ctx, req)
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	var r struct {
+		Status    string   `json:"status"`
+		ErrorType string   `json:"errorType"`
+		Error     string   `json:"error"`
+		Warnings  []string `json:"warnings"`
+		Data      struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+			Stats      struct {
+				Timings struct {
+					EvalTotalTime float64 `json:"evalTotalTime"`
+					ExecQueueTime float64 `json:"execQueueTime"`
+				} `json:"timings"`
+				Samples struct {
+					TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+					PeakSamples           int64 `json:"peakSamples"`
+				} `json:"samples"`
+			} `json:"stats"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &r); err != nil {
+		if resp.StatusCode/100 != 2 {
+			return nil, nil, stats, &v1.Error{Type: statusErrorType(resp.StatusCode), Msg: "server returned " + resp.Status}
+		}
+		return nil, nil, stats, fmt.Errorf("decoding %s: %v", queryPath, err)
+	}
+	if r.Status != "success" {
+		return nil, r.Warnings, stats, &v1.Error{Type: v1.ErrorType(r.ErrorType), Msg: r.Error}
+	}
+	stats = PrometheusStats{
+		EvalTime:      seconds(r.Data.Stats.Timings.EvalTotalTime),
+		QueueTime:     seconds(r.Data.Stats.Timings.ExecQueueTime),
+		SamplesLoaded: r.Data.Stats.Samples.TotalQueryableSamples,
+		PeakSamples:   r.Data.Stats.Samples.PeakSamples,
+	}
+	v, err := decodeValue(r.Data.ResultType, r.Data.Result)
+	if err != nil {
+		return nil, r.Warnings, stats, fmt.Errorf("decoding %s: %v", queryPath, err)
+	}
+	return v, r.Warnings, stats, nil
+}
+
+// statusErrorType classifies the error of a response of status code without a prometheus error in its body, as v1.API
+// does, e.g. a 502 of a router in front of prometheus.
+func statusErrorType(code int) v1.ErrorType {
+	switch code / 100 {
+	case 4:
+		return v1.ErrClient
+	case 5:
+		return v1.ErrServer
+	}
+	return v1.ErrBadResponse
+}
+
+// seconds converts the fractional seconds of prometheus' timings to a Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// decodeValue decodes the result of a query of resultType.
+func decodeValue(resultType string, result json.RawMessage) (model.Value, error) {
+	switch resultType {
+	case "vector":
+		var v model.Vector
+		err := json.Unmarshal(result, &v)
+		return v, err
+	case "matrix":
+		var m model.Matrix
+		err := json.Unmarshal(result, &m)
+		return m, err
+	case "scalar":
+		s := new(model.Scalar)
+		err := json.Unmarshal(result, s)
+		return s, err
+	case "string":
+		s := new(model.String)
+		err := json.Unmarshal(result, s)
+		return s, err
+	}
+	return nil, fmt.Errorf("unexpected result type %q", resultType)
+}
+
+// execute runs query against q at ts, with the execution stats prometheus reports if q is a StatsQuerier.
+func execute(ctx context.Context, q Querier, query string, ts time.Time) (model.Value, v1.Warnings, PrometheusStats, error) {
+	if sq, ok := q.(StatsQuerier); ok {
+		return sq.QueryWithStats(ctx, query, ts)
+	}
+	v, warnings, err := q.Query(ctx, query, ts)
+	return v, warnings, PrometheusStats{}, err
+}
@@ -39,19 +39,29 @@ package top
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"hash"
 	"hash/fnv"
+	"io"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
 )
 
+// Querier is the subset of the prometheus v1.API used to collect metrics.
+type Querier interface {
+	Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error)
+}
+
 type Config struct {
 	Context context.Context
 	// QueryType must specify the query to be executed, defaults to Instant
@@ -70,165 +80,614 @@ type Config struct {
 	//   y = years
 	// The time format concatenates the int and unit: ##unit, e.g. 10 minutes == 10m
 	Range string `json:"range,omitempty"`
-	// PrometheusClient must be an initialized prometheus client
-	PrometheusClient v1.API `json:"prometheusClient"`
+	// PrometheusClient must be an initialized prometheus client.  Any Querier will do, a v1.API satisfies it.
+	PrometheusClient Querier `json:"prometheusClient"`
+	// Cache (optional) is consulted before each query is executed and populated with its result.  Leave nil to
+	// always query Prometheus.
+	Cache Cache `json:"-"`
+	// CacheScope (optional) identifies the prometheus queried, e.g. by its cluster, in the keys of Cache, so that a
+	// Cache shared by the collections of several prometheus instances keeps their results apart.
+	CacheScope string `json:"cacheScope,omitempty"`
+	// MaxConcurrency (optional) bounds the number of queries executed against Prometheus at once.  Defaults to 4.
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+	// QueryBuilder (optional) generates the queries to execute.  Defaults to the builtin CPU and memory queries.
+	QueryBuilder *QueryBuilder `json:"-"`
+	// Matchers (optional) are label matchers added to every series selector, e.g. to filter namespaces.
+	Matchers []Matcher `json:"matchers,omitempty"`
+	// PodLabels (optional) are the kube_pod_labels labels joined onto every result, e.g. label_app_kubernetes_io_name,
+	// and recorded in PodMetric.PodLabels.
+	PodLabels []string `json:"podLabels,omitempty"`
+	// Namespaces (optional) restricts collection to the listed namespaces.  Required when ShardSize is set.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ShardSize (optional) splits every query into one query per group of ShardSize namespaces, merging the results
+	// client-side.  This keeps individual queries under Prometheus' timeout and max-samples limits on very large
+	// clusters.  0 disables sharding.
+	ShardSize int `json:"shardSize,omitempty"`
+	// DownsampleThreshold (optional) is the Range beyond which range aggregations are rewritten as subqueries
+	// evaluated every DownsampleStep, keeping the cost of long ranges bounded.  Defaults to 24h, negative disables.
+	DownsampleThreshold time.Duration `json:"downsampleThreshold,omitempty"`
+	// DownsampleStep (optional) is the subquery resolution used when downsampling.  Defaults to 5m.
+	DownsampleStep string `json:"downsampleStep,omitempty"`
+	// Stats (optional) records the wall time, series count, and warnings of every query executed, and the execution
+	// stats prometheus reports for them if PrometheusClient is a StatsQuerier.
+	Stats *RunStats `json:"-"`
+	// Time (optional) is the end of Range, the evaluation time of the queries.  Defaults to the time Top is called.
+	Time time.Time `json:"time,omitempty"`
+	// Strict (optional) fails the collection on the first query prometheus returns warnings for, e.g. of dropped
+	// samples or a failing replica, rather than accepting its possibly partial result.
+	Strict bool `json:"strict,omitempty"`
+	// ContinueOnError (optional) completes the collection when queries fail, e.g. by timing out, rather than failing
+	// it on the first.  See QueryErrors.
+	ContinueOnError bool `json:"continueOnError,omitempty"`
+	// Retries (optional) is the number of times a query failing transiently, with a network error, a timeout, or a 5xx
+	// response such as the 502 of a route, is retried before it fails.  0 disables retries.
+	Retries int `json:"retries,omitempty"`
+	// RetryBackoff (optional) is the delay before the first retry of a query, doubled for each subsequent one.  Defaults
+	// to 1s.
+	RetryBackoff time.Duration `json:"retryBackoff,omitempty"`
+	// RetryJitter (optional) randomizes each retry delay by up to this fraction of it, e.g. 0.2 for 20%, so that the
+	// queries failing together do not retry in lockstep.
+	RetryJitter float64 `json:"retryJitter,omitempty"`
+	// Timeout (optional) bounds every attempt of each query, retries included.  A query exceeding it fails with an
+	// error wrapping ErrQueryTimeout, naming the query.  0 leaves queries unbounded.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Deadline (optional) bounds the whole collection.  When it passes, Top returns the rows collated so far, marked
+	// Partial, as if Context were canceled.
+	Deadline time.Time `json:"deadline,omitempty"`
+	// PartialOnWarnings (optional) marks the rows of the metrics whose queries prometheus returned warnings for
+	// Partial, e.g. the partial responses of a Thanos Query whose stores failed.  Ignored with Strict.
+	PartialOnWarnings bool `json:"partialOnWarnings,omitempty"`
+	// Build (optional) identifies the build under test, e.g. an OpenShift release, and is stored in the Version of
+	// every result.  Sinks record it, so that the results of several builds can share a table and be compared.
+	Build string `json:"build,omitempty"`
 }
 
-const (
-	cpuMetric    = "container_cpu_usage_seconds_total"
-	memoryMetric = "container_memory_usage_bytes"
-	//"container_network_receive_bytes_total",
-	//"container_network_transmit_bytes_total",
-)
-
-// Query Templates
-// These templates are combined with targetMetrics to generate the query string respective of the query type
-// defined at start time.  Given ONE OF
-//     T_INSTANT
-//     T_QUANTILE
-//     T_AVERAGE
-const (
-	avgCpu  = `avg(rate(container_cpu_usage_seconds_total{pod!=''}[{{.Range}}])) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	maxCpu  = `max(rate(container_cpu_usage_seconds_total{pod!=''}[{{.Range}}])) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	minCpu  = `min(rate(container_cpu_usage_seconds_total{pod!=''}[{{.Range}}])) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	q95Cpu  = `quantile(.95, rate(container_cpu_usage_seconds_total{pod!=''}[{{.Range}}])) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	instCpu = `sum(container_cpu_usage_seconds_total{pod!=''}) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-
-	avgMem  = `avg(container_memory_usage_bytes{pod!=''}) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	maxMem  = `max(container_memory_usage_bytes{pod!=''}) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	minMem  = `min(container_memory_usage_bytes{pod!=''}) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	q95Mem  = `quantile(.95, container_memory_usage_bytes{pod!=''}) by (pod, namespace, node) * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-	instMem = `container_memory_usage_bytes{pod!=''} * on(pod) group_left(owner_name) sum by (owner_name, pod) (kube_pod_owner{owner_kind=~"ReplicaSet|DaemonSet|StatefulSet|ReplicationController"})`
-)
+type PodMetric dbhandler.Row
 
-var metrics = []string{avgCpu, maxCpu, minCpu, q95Cpu, instCpu, avgMem, maxMem, minMem, q95Mem, instMem}
+// csvHeader names the CSV columns, in the order written by csvRecord.
+var csvHeader = []string{
+	"metric", "range", "pod", "namespace", "node", "label-app", "quantile-95", "max", "min", "avg", "inst", "request", "efficiency", "burstiness", "q95-burstiness", "partial", "cluster", "violation", "run-id", "query-time", "iteration", "labels", "workload", "hpa-min-replicas", "hpa-max-replicas", "component", "pod-labels",
+}
 
-type PodMetric dbhandler.Row
+// csvRecord returns the CSV fields of p, one per csvHeader column.
+func (p PodMetric) csvRecord() []string {
+	return []string{
+		p.Metric, p.Range, p.Pod, p.Namespace, p.Node, p.OwnerName,
+		floatToString(p.Q95Value), floatToString(p.MaxValue), floatToString(p.MinValue),
+		floatToString(p.AvgValue), floatToString(p.InstValue), floatToString(p.Request), floatToString(p.Efficiency),
+		floatToString(p.Burstiness), floatToString(p.Q95Burstiness), strconv.FormatBool(p.Partial), p.Cluster, p.Violation,
+		p.RunID, p.QueryTime, strconv.Itoa(p.Iteration), p.Labels.String(), p.Workload,
+		strconv.Itoa(p.HPAMinReplicas), strconv.Itoa(p.HPAMaxReplicas), p.Component, p.PodLabels.String(),
+	}
+}
 
+// MarshalCSV returns p as a single RFC 4180 CSV line.
 func (p PodMetric) MarshalCSV() []byte {
-	return []byte(fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,\n",
-		p.Metric, p.Range, p.Pod, p.Namespace, p.OwnerName,
-		floatToString(p.Q95Value), floatToString(p.MaxValue), floatToString(p.MinValue),
-		floatToString(p.AvgValue), floatToString(p.InstValue)))
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	_ = w.Write(p.csvRecord())
+	w.Flush()
+	return buf.Bytes()
+}
+
+// setValue stores v in the field corresponding to agg.
+func (p *PodMetric) setValue(agg Aggregation, v float64) {
+	switch agg {
+	case Quantile95:
+		p.Q95Value = v
+	case Average:
+		p.AvgValue = v
+	case Maximum:
+		p.MaxValue = v
+	case Minimum:
+		p.MinValue = v
+	case Instant:
+		p.InstValue = v
+	case Request:
+		p.Request = v
+	}
+}
+
+// Value returns the field corresponding to agg.
+func (p PodMetric) Value(agg Aggregation) float64 {
+	switch agg {
+	case Quantile95:
+		return p.Q95Value
+	case Average:
+		return p.AvgValue
+	case Maximum:
+		return p.MaxValue
+	case Minimum:
+		return p.MinValue
+	case Request:
+		return p.Request
+	default:
+		return p.InstValue
+	}
 }
 
 func floatToString(f float64) string {
-	return strconv.FormatFloat(f, 'e', -1, 64)
+	return FormatFloat(f, 'e', -1)
 }
 
 func (p PodMetric) String() string {
-	return fmt.Sprintf("metric => %q {Pod=%s, Namespace=%s, Node=%s, Owner_Name=%s}: {Avg: %f, Q95: %f, Max: %f, Min: %f}",
-		p.Metric, p.Pod, p.Namespace, p.Node, p.OwnerName, p.AvgValue, p.Q95Value, p.MaxValue, p.MinValue,
+	f := func(v float64) string { return FormatFloat(v, 'f', 6) }
+	s := fmt.Sprintf("metric => %q {Pod=%s, Namespace=%s, Node=%s, Owner_Name=%s}: {Avg: %s, Q95: %s, Max: %s, Min: %s, Request: %s, Efficiency: %s}",
+		p.Metric, p.Pod, p.Namespace, p.Node, p.OwnerName, f(p.AvgValue), f(p.Q95Value), f(p.MaxValue), f(p.MinValue), f(p.Request), f(p.Efficiency),
 	)
+	if p.Partial {
+		s += " (partial)"
+	}
+	if p.Violation != "" {
+		s += " VIOLATION: " + p.Violation
+	}
+	return s
 }
 
 type PodMetricTable []*PodMetric
 
+// markPartial flags every row as coming from an interrupted collection.
+func (pm PodMetricTable) markPartial() PodMetricTable {
+	for _, p := range pm {
+		p.Partial = true
+	}
+	return pm
+}
+
 func (pm PodMetricTable) MarshalCSV() []byte {
 	buf := new(bytes.Buffer)
-	buf.Write([]byte("metric, range, pod, namespace, label-app, quantile-95, max, min, avg, inst\n"))
+	_ = pm.WriteCSV(buf)
+	return buf.Bytes()
+}
+
+// WriteCSV streams the table as RFC 4180 CSV to w, one row at a time, so that large tables need not be held in
+// memory twice.  Fields containing commas, quotes, or newlines are quoted.
+func (pm PodMetricTable) WriteCSV(w io.Writer) error {
+	if err := writeCSVHeader(w, csvHeader); err != nil {
+		return err
+	}
+	return pm.AppendCSV(w)
+}
+
+// AppendCSV writes the rows of the table as CSV to w without a header, extending a file written by WriteCSV.
+func (pm PodMetricTable) AppendCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
 	for _, line := range pm {
-		buf.Write(line.MarshalCSV())
+		if err := cw.Write(line.csvRecord()); err != nil {
+			return err
+		}
 	}
-	return buf.Bytes()
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVHeader(w io.Writer, header []string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
 }
 
-// targetMetrics specify the metric to be queried.  These values are processed by generateQueryList()
-// to generated the query string
-//var targetMetrics = []string{cpuMetric, memoryMetric}
+// csvFields parse each column of csvHeader into a PodMetric.
+var csvFields = map[string]func(p *PodMetric, v string) error{
+	"metric":     func(p *PodMetric, v string) error { p.Metric = v; return nil },
+	"range":      func(p *PodMetric, v string) error { p.Range = v; return nil },
+	"pod":        func(p *PodMetric, v string) error { p.Pod = v; return nil },
+	"namespace":  func(p *PodMetric, v string) error { p.Namespace = v; return nil },
+	"node":       func(p *PodMetric, v string) error { p.Node = v; return nil },
+	"label-app":  func(p *PodMetric, v string) error { p.OwnerName = v; return nil },
+	"cluster":    func(p *PodMetric, v string) error { p.Cluster = v; return nil },
+	"violation":  func(p *PodMetric, v string) error { p.Violation = v; return nil },
+	"run-id":     func(p *PodMetric, v string) error { p.RunID = v; return nil },
+	"query-time": func(p *PodMetric, v string) error { p.QueryTime = v; return nil },
+	"workload":   func(p *PodMetric, v string) error { p.Workload = v; return nil },
+	"component":  func(p *PodMetric, v string) error { p.Component = v; return nil },
+	"iteration": func(p *PodMetric, v string) (err error) {
+		p.Iteration, err = strconv.Atoi(v)
+		return err
+	},
+	"hpa-min-replicas": func(p *PodMetric, v string) (err error) {
+		p.HPAMinReplicas, err = strconv.Atoi(v)
+		return err
+	},
+	"hpa-max-replicas": func(p *PodMetric, v string) (err error) {
+		p.HPAMaxReplicas, err = strconv.Atoi(v)
+		return err
+	},
+	"partial": func(p *PodMetric, v string) (err error) {
+		p.Partial, err = strconv.ParseBool(v)
+		return err
+	},
+	"labels": func(p *PodMetric, v string) (err error) {
+		p.Labels, err = dbhandler.ParseLabels(v)
+		return err
+	},
+	"pod-labels": func(p *PodMetric, v string) (err error) {
+		p.PodLabels, err = dbhandler.ParseLabels(v)
+		return err
+	},
+	"quantile-95":    floatField(func(p *PodMetric) *float64 { return &p.Q95Value }),
+	"max":            floatField(func(p *PodMetric) *float64 { return &p.MaxValue }),
+	"min":            floatField(func(p *PodMetric) *float64 { return &p.MinValue }),
+	"avg":            floatField(func(p *PodMetric) *float64 { return &p.AvgValue }),
+	"inst":           floatField(func(p *PodMetric) *float64 { return &p.InstValue }),
+	"request":        floatField(func(p *PodMetric) *float64 { return &p.Request }),
+	"efficiency":     floatField(func(p *PodMetric) *float64 { return &p.Efficiency }),
+	"burstiness":     floatField(func(p *PodMetric) *float64 { return &p.Burstiness }),
+	"q95-burstiness": floatField(func(p *PodMetric) *float64 { return &p.Q95Burstiness }),
+}
 
-func top(cfg Config) (PodMetricTable, error) {
-	now := time.Now() // get current time to maintain static end of range in queries
+func floatField(field func(p *PodMetric) *float64) func(p *PodMetric, v string) error {
+	return func(p *PodMetric, v string) (err error) {
+		*field(p), err = strconv.ParseFloat(v, 64)
+		return err
+	}
+}
 
-	//podMetricHashTable is used to collate metric values by pod.  Each query must be executed independently, resulting
-	// in up to 4 values per pod.  Pods are hashed to the table to enable simple lookup and updating
-	podMetricHashTable := make(map[uint32]*PodMetric)
-	hash := fnv.New32a()
-	for _, m := range metrics {
-		tmp := template.New("")
-		template.Must(tmp.Parse(m))
-		//// constructs the query
-		queryBuf := new(bytes.Buffer)
-		err := tmp.Execute(queryBuf, struct {
-			Metric, Range string
-		}{m, cfg.Range})
-		if err != nil {
-			return nil, fmt.Errorf("composing base query template: %v", err)
+// ReadCSV parses a table written by WriteCSV.  Columns are matched by name, so files written by older versions of
+// prom-top, which lack some columns, can be read as well.  Unknown columns are ignored.
+func ReadCSV(r io.Reader) (PodMetricTable, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty csv, expected a header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool, len(header))
+	for _, name := range header {
+		columns[name] = true
+	}
+	if columns["aggregation"] {
+		return nil, fmt.Errorf("csv is in the long schema, only the wide schema can be read")
+	}
+	for _, required := range []string{"metric", "pod", "namespace"} {
+		if !columns[required] {
+			return nil, fmt.Errorf("csv header lacks the %s column", required)
 		}
+	}
 
-		// execute the query
-		queryValue, _, err := cfg.PrometheusClient.Query(cfg.Context, queryBuf.String(), now)
+	var table PodMetricTable
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return table, nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("query %q failed: %v", queryBuf.String(), err)
+			return nil, err
 		}
-		vector, ok := queryValue.(model.Vector)
-		if !ok {
-			return nil, fmt.Errorf("expected vector")
+		p := new(PodMetric)
+		for i, v := range record {
+			parse, ok := csvFields[header[i]]
+			if !ok {
+				continue
+			}
+			if err := parse(p, v); err != nil {
+				return nil, fmt.Errorf("record %d, column %s: %v", n, header[i], err)
+			}
+		}
+		if p.Workload == "" {
+			// written before the column existed
+			p.Workload = Workload(p.Pod)
 		}
+		table = append(table, p)
+	}
+}
 
-		for _, sample := range vector {
-			ns, _ := sample.Metric["namespace"]
-			pod, _ := sample.Metric["pod"]
-			node, _ := sample.Metric["node"]
+func top(cfg Config) (PodMetricTable, error) {
+	now := cfg.Time // a static end of range for every query
+	if now.IsZero() {
+		now = time.Now()
+	}
 
-			// The hash is derived from the namespace, pod name, and node
-			var metric string
-			if strings.Contains(m, "mem") {
-				metric = "container_memory_bytes"
-			} else if strings.Contains(m, "cpu") {
-				metric = "cpu_usage_ratio"
-			}
-			_, err := hash.Write([]byte(fmt.Sprintf("%s-%s-%s", string(ns), string(pod), metric)))
-			id := hash.Sum32()
-			hash.Reset()
+	step, err := downsampleStep(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var queries []Query
+	for _, shard := range shards(cfg.Namespaces, cfg.ShardSize) {
+		q, err := cfg.QueryBuilder.Queries(Params{Range: cfg.Range, Namespaces: shard, Matchers: cfg.Matchers, Step: step,
+			PodLabels: cfg.PodLabels})
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q...)
+	}
 
+	c := newCollator(cfg.Range, cfg.Build, now)
+	failed := new(queryFailures)
+	parent := cfg.Context
+	g, ctx := errgroup.WithContext(parent)
+	cfg.Context = ctx
+	// sem bounds the number of queries in flight against prometheus
+	sem := make(chan struct{}, cfg.MaxConcurrency)
+	for _, q := range queries {
+		q := q
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			start := time.Now()
+			vector, warnings, err := query(cfg, q, now)
+			if err != nil && cfg.ContinueOnError && parent.Err() == nil && !errors.Is(err, ErrWarnings) {
+				failed.add(cfg, q, time.Since(start), err)
+				return nil
+			}
 			if err != nil {
-				return nil, err
+				return err
 			}
-			_, ok := podMetricHashTable[id]
-			if !ok {
-				podMetricHashTable[id] = new(PodMetric)
+			if len(warnings) > 0 && cfg.PartialOnWarnings {
+				failed.warn(q)
 			}
+			return c.collate(q, vector)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if parent.Err() != nil {
+			// the caller interrupted the collection, hand back what was collated so far
+			return c.table().markPartial().derive(), fmt.Errorf("collection interrupted: %w", parent.Err())
+		}
+		return nil, err
+	}
+	if len(failed.errs) > 0 {
+		return failed.markPartial(c.table()).derive(), failed.errs
+	}
+	return failed.markPartial(c.table()).derive(), nil
+}
 
-			ownerName, _ := sample.Metric["owner_name"]
-			podMetricHashTable[id].Namespace = string(ns)
-			podMetricHashTable[id].Pod = string(pod)
-			podMetricHashTable[id].Node = string(node)
-			podMetricHashTable[id].Metric = metric
-			podMetricHashTable[id].OwnerName = string(ownerName)
-			podMetricHashTable[id].Range = cfg.Range
-			podMetricHashTable[id].QueryTime = now.Format(dbhandler.TimestampFormat)
-
-			switch {
-			case strings.Contains(m, "quantile"):
-				podMetricHashTable[id].Q95Value = float64(sample.Value)
-			case strings.Contains(m, "avg"):
-				podMetricHashTable[id].AvgValue = float64(sample.Value)
-			case strings.Contains(m, "max"):
-				podMetricHashTable[id].MaxValue = float64(sample.Value)
-			case strings.Contains(m, "min"):
-				podMetricHashTable[id].MinValue = float64(sample.Value)
-			case len(m) > 0:
-				// instant metrics will not have the above aggregation queries, making it difficult to detect.
-				// assume it's an instant query if the string is non-0 len and doesn't contain an aggregation query
-				podMetricHashTable[id].InstValue = float64(sample.Value)
-			}
-			//}
+// QueryError is a query that failed in a collection that continued on error.
+type QueryError struct {
+	Metric      string
+	Aggregation Aggregation
+	Expr        string
+	Err         error
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Aggregation, e.Metric, e.Err)
+}
+
+func (e QueryError) Unwrap() error {
+	return e.Err
+}
+
+// QueryErrors is the error Top returns, along with the rows it collated, when queries of a collection with
+// Config.ContinueOnError fail.  The rows of the metrics whose queries failed lack their aggregations and are marked
+// Partial.
+type QueryErrors []QueryError
+
+func (e QueryErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d queries failed, the first: %v", len(e), e[0])
+}
+
+// queryFailures collects the QueryErrors of a collection, and the metrics of the queries returning warnings with
+// Config.PartialOnWarnings, concurrently.
+type queryFailures struct {
+	mu     sync.Mutex
+	errs   QueryErrors
+	warned []string
+}
+
+// add records the failure of q after d, also in cfg.Stats.
+func (f *queryFailures) add(cfg Config, q Query, d time.Duration, err error) {
+	cfg.Stats.record(QueryStat{Metric: q.Metric, Aggregation: q.Aggregation, Expr: q.Expr, Duration: d, Error: err.Error()})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, QueryError{Metric: q.Metric, Aggregation: q.Aggregation, Expr: q.Expr, Err: err})
+}
+
+// warn records that q returned warnings.
+func (f *queryFailures) warn(q Query) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.warned = append(f.warned, q.Metric)
+}
+
+// markPartial flags the rows of the metrics of the failed and warned queries.
+func (f *queryFailures) markPartial(t PodMetricTable) PodMetricTable {
+	if len(f.errs) == 0 && len(f.warned) == 0 {
+		return t
+	}
+	metrics := make(map[string]bool, len(f.errs)+len(f.warned))
+	for _, e := range f.errs {
+		metrics[e.Metric] = true
+	}
+	for _, m := range f.warned {
+		metrics[m] = true
+	}
+	for _, p := range t {
+		if metrics[p.Metric] {
+			p.Partial = true
 		}
 	}
-	podMetrics := make(PodMetricTable, 0, len(podMetricHashTable))
-	for _, pm := range podMetricHashTable {
+	return t
+}
+
+// downsampleStep returns the subquery step to apply to range aggregations, or an empty string if cfg.Range is within
+// the downsampling threshold.
+func downsampleStep(cfg Config) (string, error) {
+	if cfg.DownsampleThreshold < 0 {
+		return "", nil
+	}
+	r, err := model.ParseDuration(cfg.Range)
+	if err != nil {
+		return "", fmt.Errorf("invalid range %q: %v", cfg.Range, err)
+	}
+	if time.Duration(r) <= cfg.DownsampleThreshold {
+		return "", nil
+	}
+	return cfg.DownsampleStep, nil
+}
+
+// shards splits namespaces into groups of at most size.  A single, possibly empty, shard is returned when size is
+// not positive.
+func shards(namespaces []string, size int) [][]string {
+	if size <= 0 || len(namespaces) <= size {
+		return [][]string{namespaces}
+	}
+	var s [][]string
+	for len(namespaces) > size {
+		s = append(s, namespaces[:size])
+		namespaces = namespaces[size:]
+	}
+	return append(s, namespaces)
+}
+
+// collator merges the samples of independently executed queries into one PodMetric per pod and metric.  It is
+// safe for concurrent use.
+type collator struct {
+	queryRange string
+	build      string
+	now        time.Time
+
+	mu sync.Mutex
+	//podMetricHashTable is used to collate metric values by pod.  Each query must be executed independently, resulting
+	// in up to 4 values per pod.  Pods are hashed to the table to enable simple lookup and updating
+	podMetricHashTable map[uint32]*PodMetric
+	hash               hash.Hash32
+}
+
+func newCollator(queryRange, build string, now time.Time) *collator {
+	return &collator{
+		queryRange:         queryRange,
+		build:              build,
+		now:                now,
+		podMetricHashTable: make(map[uint32]*PodMetric),
+		hash:               fnv.New32a(),
+	}
+}
+
+// collate records the samples of vector, the result of executing q.
+func (c *collator) collate(q Query, vector model.Vector) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, sample := range vector {
+		ns, _ := sample.Metric["namespace"]
+		pod, _ := sample.Metric["pod"]
+		node, _ := sample.Metric["node"]
+		component, _ := sample.Metric["component"]
+
+		// The hash is derived from the namespace, pod name, and metric, the node of the rows of node queries, which
+		// have no pod, and the component of split pods
+		key := fmt.Sprintf("%s-%s-%s", string(ns), string(pod), q.Metric)
+		if pod == "" {
+			key += "-" + string(node)
+		}
+		if component != "" {
+			key += "-" + string(component)
+		}
+		_, err := c.hash.Write([]byte(key))
+		id := c.hash.Sum32()
+		c.hash.Reset()
+
+		if err != nil {
+			return err
+		}
+		_, ok := c.podMetricHashTable[id]
+		if !ok {
+			c.podMetricHashTable[id] = new(PodMetric)
+		}
+
+		ownerName, _ := sample.Metric["owner_name"]
+		c.podMetricHashTable[id].Namespace = string(ns)
+		c.podMetricHashTable[id].Pod = string(pod)
+		// not every query necessarily preserves the node label, don't let one that drops it erase it
+		if node != "" {
+			c.podMetricHashTable[id].Node = string(node)
+		}
+		c.podMetricHashTable[id].Metric = q.Metric
+		c.podMetricHashTable[id].OwnerName = string(ownerName)
+		c.podMetricHashTable[id].Component = string(component)
+		if labels := podLabels(sample.Metric); labels != nil {
+			c.podMetricHashTable[id].PodLabels = labels
+		}
+		c.podMetricHashTable[id].Range = c.queryRange
+		c.podMetricHashTable[id].Version = c.build
+		c.podMetricHashTable[id].QueryTime = c.now.Format(dbhandler.TimestampFormat)
+
+		c.podMetricHashTable[id].setValue(q.Aggregation, float64(sample.Value))
+	}
+	return nil
+}
+
+// table returns the collated results.
+func (c *collator) table() PodMetricTable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	podMetrics := make(PodMetricTable, 0, len(c.podMetricHashTable))
+	for _, pm := range c.podMetricHashTable {
 		podMetrics = append(podMetrics, pm)
 	}
+	return podMetrics
+}
+
+// query executes q at time ts, serving the result from cfg.Cache when a live entry exists.  The execution is
+// recorded to cfg.Stats.
+func query(cfg Config, q Query, ts time.Time) (model.Vector, v1.Warnings, error) {
+	start := time.Now()
+	stat := QueryStat{Metric: q.Metric, Aggregation: q.Aggregation, Expr: q.Expr}
+	key := cacheKey(cfg.CacheScope, q.Expr, cfg.Range)
+	if cfg.Cache != nil {
+		if v, ok := cfg.Cache.Get(key); ok {
+			stat.Duration, stat.Samples, stat.Cached = time.Since(start), len(v), true
+			cfg.Stats.record(stat)
+			return v, nil, nil
+		}
+	}
+	queryValue, warnings, promStats, err := executeWithRetries(cfg, q.Expr, ts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query %q failed: %w", q.Expr, err)
+	}
+	vector, ok := queryValue.(model.Vector)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected vector")
+	}
+	stat.Duration, stat.Samples, stat.Warnings, stat.Prometheus = time.Since(start), len(vector), warnings, promStats
+	cfg.Stats.record(stat)
+	if err := checkWarnings(cfg, q.Expr, warnings); err != nil {
+		return nil, nil, err
+	}
+	if cfg.Cache != nil {
+		// a failed cache write only costs a repeated query later, don't fail the run for it
+		_ = cfg.Cache.Set(key, vector)
+	}
+	return vector, warnings, nil
+}
 
-	return podMetrics, nil
+// ErrWarnings is wrapped by the error of a Strict collection failed by query warnings.
+var ErrWarnings = errors.New("prometheus returned warnings")
+
+// checkWarnings returns an error wrapping ErrWarnings if cfg is Strict and warnings is not empty.
+func checkWarnings(cfg Config, q string, warnings v1.Warnings) error {
+	if !cfg.Strict || len(warnings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("query %q: %w: %s", q, ErrWarnings, strings.Join(warnings, "; "))
 }
 
-const defaultRange = "10m"
+const (
+	defaultRange               = "10m"
+	defaultMaxConcurrency      = 4
+	defaultDownsampleThreshold = 24 * time.Hour
+	defaultDownsampleStep      = "5m"
+)
 
 // Top executes the specified query against targetMetrics and returns a slice of Prometheus InstantVertices.  An
 // instantVertex is a point-in-time data structure containing the metric values for all reporting components.  Thus,
 // Top is not intended for continuous monitoring.
+//
+// If cfg.Context is canceled or cfg.Deadline passes mid-collection, Top returns the rows collated so far, each marked
+// Partial, along with an error wrapping the context's error.  If queries fail with cfg.ContinueOnError set, Top
+// returns the rows collated from the others along with QueryErrors.
 func Top(cfg Config) (PodMetricTable, error) {
 	if cfg.Context == nil {
 		cfg.Context = context.Background()
@@ -236,5 +695,25 @@ func Top(cfg Config) (PodMetricTable, error) {
 	if len(cfg.Range) == 0 {
 		cfg.Range = defaultRange
 	}
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = defaultMaxConcurrency
+	}
+	if cfg.QueryBuilder == nil {
+		cfg.QueryBuilder = defaultBuilder
+	}
+	if cfg.DownsampleThreshold == 0 {
+		cfg.DownsampleThreshold = defaultDownsampleThreshold
+	}
+	if len(cfg.DownsampleStep) == 0 {
+		cfg.DownsampleStep = defaultDownsampleStep
+	}
+	if cfg.ShardSize > 0 && len(cfg.Namespaces) == 0 {
+		return nil, fmt.Errorf("sharding requires the list of namespaces to shard")
+	}
+	if !cfg.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		cfg.Context, cancel = context.WithDeadline(cfg.Context, cfg.Deadline)
+		defer cancel()
+	}
 	return top(cfg)
 }
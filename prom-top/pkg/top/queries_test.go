@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/dbhandler"
+)
+
+func TestCollate(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := newCollator("10m", "4.6.1", now)
+	sample := func(labels model.Metric, v float64) *model.Sample {
+		return &model.Sample{Metric: labels, Value: model.SampleValue(v)}
+	}
+	etcd := model.Metric{"namespace": "openshift-etcd", "pod": "etcd-0", "node": "master-0", "owner_name": "etcd"}
+	// the request query of a pod without a node label must not erase the node collated from the others
+	etcdRequest := model.Metric{"namespace": "openshift-etcd", "pod": "etcd-0", "owner_name": "etcd"}
+	node := model.Metric{"node": "worker-0"}
+	split := func(component string) model.Metric {
+		return model.Metric{"namespace": "ns", "pod": "app-1", "node": "worker-0", "component": model.LabelValue(component)}
+	}
+
+	collations := []struct {
+		q      Query
+		vector model.Vector
+	}{
+		{Query{Metric: CPUMetric, Aggregation: Average}, model.Vector{sample(etcd, .5), sample(node, 3)}},
+		{Query{Metric: CPUMetric, Aggregation: Maximum}, model.Vector{sample(etcd, 2), sample(node, 4)}},
+		{Query{Metric: CPUMetric, Aggregation: Request}, model.Vector{sample(etcdRequest, 1)}},
+		{Query{Metric: MemoryMetric, Aggregation: Average}, model.Vector{sample(etcd, 1024)}},
+		{Query{Metric: MemoryMetric, Aggregation: Maximum}, model.Vector{sample(split("a"), 1), sample(split("b"), 2)}},
+	}
+	for _, collation := range collations {
+		if err := c.collate(collation.q, collation.vector); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table := c.table().derive()
+	sort.Slice(table, func(i, j int) bool {
+		return table[i].Metric+table[i].Pod+table[i].Component < table[j].Metric+table[j].Pod+table[j].Component
+	})
+	want := PodMetricTable{
+		{Metric: MemoryMetric, Pod: "app-1", Namespace: "ns", Node: "worker-0", Component: "a", MaxValue: 1, Workload: "app"},
+		{Metric: MemoryMetric, Pod: "app-1", Namespace: "ns", Node: "worker-0", Component: "b", MaxValue: 2, Workload: "app"},
+		{Metric: MemoryMetric, Pod: "etcd-0", Namespace: "openshift-etcd", Node: "master-0", OwnerName: "etcd",
+			AvgValue: 1024, Workload: "etcd"},
+		{Metric: CPUMetric, Node: "worker-0", AvgValue: 3, MaxValue: 4, Burstiness: 4. / 3},
+		{Metric: CPUMetric, Pod: "etcd-0", Namespace: "openshift-etcd", Node: "master-0", OwnerName: "etcd",
+			AvgValue: .5, MaxValue: 2, Request: 1, Efficiency: .5, Burstiness: 4, Workload: "etcd"},
+	}
+	if len(table) != len(want) {
+		t.Fatalf("got %d rows, want %d:\n%v", len(table), len(want), table)
+	}
+	for i, w := range want {
+		w.Range, w.Version, w.QueryTime = "10m", "4.6.1", now.Format(dbhandler.TimestampFormat)
+		if got := table[i]; !reflect.DeepEqual(got, w) {
+			t.Errorf("row %d:\ngot  %+v\nwant %+v", i, *got, *w)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	namespaces := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		size int
+		want [][]string
+	}{
+		{0, [][]string{namespaces}},
+		{5, [][]string{namespaces}},
+		{2, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{1, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}},
+	}
+	for _, tt := range tests {
+		if got := shards(namespaces, tt.size); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("size %d: got %v, want %v", tt.size, got, tt.want)
+		}
+	}
+	if got := shards(nil, 2); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("got %v, want a single empty shard", got)
+	}
+}
+
+func TestDownsampleStep(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Range: "24h", DownsampleThreshold: 24 * time.Hour, DownsampleStep: "5m"}, ""},
+		{Config{Range: "7d", DownsampleThreshold: 24 * time.Hour, DownsampleStep: "5m"}, "5m"},
+		{Config{Range: "2h", DownsampleThreshold: time.Hour, DownsampleStep: "1m"}, "1m"},
+		{Config{Range: "7d", DownsampleThreshold: -1, DownsampleStep: "5m"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := downsampleStep(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("range %s, threshold %s: got step %q, want %q", tt.cfg.Range, tt.cfg.DownsampleThreshold, got, tt.want)
+		}
+	}
+	if _, err := downsampleStep(Config{Range: "a week"}); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
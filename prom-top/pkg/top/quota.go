@@ -0,0 +1,161 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// quotaQuery selects the hard cpu and memory quotas of every ResourceQuota, and limitRangeQuery the maximum cpu and
+// memory of a pod allowed by every LimitRange.
+const (
+	quotaQuery      = `kube_resourcequota{type="hard", resource=~"(requests\\.|limits\\.)?(cpu|memory)"}`
+	limitRangeQuery = `kube_limitrange{type="Pod", constraint="max", resource=~"cpu|memory"}`
+)
+
+// quotaMetrics maps the resources of quotas and limit ranges to the metric of their usage.
+var quotaMetrics = map[string]string{"cpu": CPUMetric, "memory": MemoryMetric}
+
+// Limits are the ResourceQuota and LimitRange constraints of the namespaces, as reported by kube-state-metrics.
+type Limits struct {
+	// Quotas are the hard quotas keyed by namespace/resource, e.g. openshift-etcd/requests.cpu.  Quotas of cpu and
+	// memory are those of their requests.  The lowest applies when several ResourceQuotas constrain a resource.
+	Quotas map[string]float64
+	// PodMax are the maximum usage of a pod keyed by namespace/resource, e.g. openshift-etcd/memory, the lowest
+	// when several LimitRanges constrain a resource.
+	PodMax map[string]float64
+}
+
+// ReadLimits returns the quotas and pod limits of the namespaces, e.g. kube_resourcequota.
+func ReadLimits(cfg Config) (Limits, error) {
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
+	limits := Limits{Quotas: make(map[string]float64), PodMax: make(map[string]float64)}
+	for _, q := range []struct {
+		expr   string
+		limits map[string]float64
+	}{{quotaQuery, limits.Quotas}, {limitRangeQuery, limits.PodMax}} {
+		vector, _, err := query(cfg, Query{Metric: "limits", Expr: q.expr}, time.Now())
+		if err != nil {
+			return Limits{}, err
+		}
+		for _, sample := range vector {
+			resource := string(sample.Metric["resource"])
+			if q.expr == quotaQuery && !strings.Contains(resource, ".") {
+				resource = "requests." + resource
+			}
+			key := string(sample.Metric["namespace"]) + "/" + resource
+			if v, ok := q.limits[key]; !ok || float64(sample.Value) < v {
+				q.limits[key] = float64(sample.Value)
+			}
+		}
+	}
+	return limits, nil
+}
+
+// NamespaceQuota is the consumption of a namespace's hard quota of a resource by its collected pods.
+type NamespaceQuota struct {
+	Namespace string
+	// Quota is the constrained resource, e.g. requests.cpu or limits.memory.
+	Quota  string
+	Metric string
+	Hard   float64
+	// Requested, Average, and Q95 are the sums of the requests, average, and q95 usage of the pods.  Requested is 0
+	// for quotas of limits.
+	Requested float64
+	Average   float64
+	Q95       float64
+}
+
+// PodLimit is the usage of the busiest pod of a namespace against the maximum a LimitRange allows a pod.
+type PodLimit struct {
+	Namespace string
+	Metric    string
+	Max       float64
+	// Pod is the pod with the highest max usage, Usage that usage.
+	Pod   string
+	Usage float64
+}
+
+// QuotaUsage returns the consumption of the quotas and pod limits of every namespace with collected pods, sorted by
+// namespace and resource.
+func (pm PodMetricTable) QuotaUsage(limits Limits) ([]NamespaceQuota, []PodLimit) {
+	type key struct{ namespace, metric string }
+	sums := make(map[key]*NamespaceQuota)
+	busiest := make(map[key]*PodMetric)
+	for _, p := range pm {
+		k := key{p.Namespace, p.Metric}
+		if sums[k] == nil {
+			sums[k] = &NamespaceQuota{}
+		}
+		sums[k].Requested += p.Request
+		sums[k].Average += p.AvgValue
+		sums[k].Q95 += p.Q95Value
+		if b := busiest[k]; b == nil || p.MaxValue > b.MaxValue {
+			busiest[k] = p
+		}
+	}
+
+	var quotas []NamespaceQuota
+	for k, hard := range limits.Quotas {
+		namespace, resource := splitKey(k)
+		kind := resource[strings.Index(resource, ".")+1:]
+		s, ok := sums[key{namespace, quotaMetrics[kind]}]
+		if !ok {
+			continue
+		}
+		q := NamespaceQuota{Namespace: namespace, Quota: resource, Metric: quotaMetrics[kind], Hard: hard,
+			Average: s.Average, Q95: s.Q95}
+		if strings.HasPrefix(resource, "requests.") {
+			q.Requested = s.Requested
+		}
+		quotas = append(quotas, q)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Namespace != quotas[j].Namespace {
+			return quotas[i].Namespace < quotas[j].Namespace
+		}
+		return quotas[i].Quota < quotas[j].Quota
+	})
+
+	var pods []PodLimit
+	for k, max := range limits.PodMax {
+		namespace, resource := splitKey(k)
+		b, ok := busiest[key{namespace, quotaMetrics[resource]}]
+		if !ok {
+			continue
+		}
+		pods = append(pods, PodLimit{Namespace: namespace, Metric: b.Metric, Max: max, Pod: b.Pod, Usage: b.MaxValue})
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Metric < pods[j].Metric
+	})
+	return quotas, pods
+}
+
+// splitKey splits a namespace/resource key of Limits.
+func splitKey(k string) (namespace, resource string) {
+	i := strings.Index(k, "/")
+	return k[:i], k[i+1:]
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package record captures the Prometheus responses of a collection with a Recorder, and replays them with a fake
+// top.Querier, so that a collection can be repeated without a cluster, e.g. by prom-top --replay:
+//
+//	rec := record.NewRecorder(promv1.NewAPI(conn))
+//	top.Top(top.Config{PrometheusClient: rec})
+//	rec.Save("responses.json")
+//
+// and later:
+//
+//	q, _ := record.LoadQuerier("responses.json")
+//	table, err := top.Top(top.Config{PrometheusClient: q})
+//
+// The fixtures of tests are built with toptest.
+package record
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// Response is a recorded result of a single query.
+type Response struct {
+	Query    string       `json:"query"`
+	Vector   model.Vector `json:"vector"`
+	Warnings []string     `json:"warnings,omitempty"`
+	// Error, if set, is returned instead of Vector.
+	Error string `json:"error,omitempty"`
+}
+
+// Querier is a top.Querier that answers queries from recorded Responses.  Queries without a recorded response fail,
+// so that recordings which drift from the query templates are noticed.
+type Querier struct {
+	mu        sync.Mutex
+	responses map[string]Response
+	calls     map[string]int
+}
+
+var _ top.Querier = &Querier{}
+
+// NewQuerier returns a Querier serving responses.
+func NewQuerier(responses ...Response) *Querier {
+	q := &Querier{
+		responses: make(map[string]Response, len(responses)),
+		calls:     make(map[string]int),
+	}
+	for _, r := range responses {
+		q.Add(r)
+	}
+	return q
+}
+
+// LoadQuerier returns a Querier serving the responses in the JSON file at path, as written by Recorder.Save.
+func LoadQuerier(path string) (*Querier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %v", err)
+	}
+	var responses []Response
+	if err := json.Unmarshal(b, &responses); err != nil {
+		return nil, fmt.Errorf("decoding recording %q: %v", path, err)
+	}
+	return NewQuerier(responses...), nil
+}
+
+// Add records r, replacing any earlier response to the same query.
+func (q *Querier) Add(r Response) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.responses[r.Query] = r
+}
+
+func (q *Querier) Query(ctx context.Context, query string, _ time.Time) (model.Value, v1.Warnings, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.calls[query]++
+	r, ok := q.responses[query]
+	if !ok {
+		return nil, nil, fmt.Errorf("record: no recorded response for query %q", query)
+	}
+	if r.Error != "" {
+		return nil, r.Warnings, fmt.Errorf("%s", r.Error)
+	}
+	return r.Vector, r.Warnings, nil
+}
+
+// Calls returns the number of times query was executed.
+func (q *Querier) Calls(query string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.calls[query]
+}
+
+// Recorder is a top.Querier that passes queries through to a live Querier and records each response.
+type Recorder struct {
+	Querier top.Querier
+
+	mu        sync.Mutex
+	responses map[string]Response
+}
+
+var _ top.StatsQuerier = &Recorder{}
+
+// NewRecorder returns a Recorder wrapping q.
+func NewRecorder(q top.Querier) *Recorder {
+	return &Recorder{Querier: q, responses: make(map[string]Response)}
+}
+
+func (r *Recorder) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	v, warnings, err := r.Querier.Query(ctx, query, ts)
+	r.record(query, v, warnings, err)
+	return v, warnings, err
+}
+
+// QueryWithStats passes the execution stats of query through if the wrapped Querier is a top.StatsQuerier.  They are
+// not recorded.
+func (r *Recorder) QueryWithStats(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, top.PrometheusStats, error) {
+	sq, ok := r.Querier.(top.StatsQuerier)
+	if !ok {
+		v, warnings, err := r.Query(ctx, query, ts)
+		return v, warnings, top.PrometheusStats{}, err
+	}
+	v, warnings, stats, err := sq.QueryWithStats(ctx, query, ts)
+	r.record(query, v, warnings, err)
+	return v, warnings, stats, err
+}
+
+// record saves the response to query.
+func (r *Recorder) record(query string, v model.Value, warnings v1.Warnings, err error) {
+	resp := Response{Query: query, Warnings: warnings}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if vector, ok := v.(model.Vector); ok {
+		resp.Vector = vector
+	}
+	r.mu.Lock()
+	r.responses[query] = resp
+	r.mu.Unlock()
+}
+
+// Responses returns the recorded responses, sorted by query.
+func (r *Recorder) Responses() []Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	responses := make([]Response, 0, len(r.responses))
+	for _, resp := range r.responses {
+		responses = append(responses, resp)
+	}
+	sort.Slice(responses, func(i, j int) bool { return responses[i].Query < responses[j].Query })
+	return responses
+}
+
+// Save writes the recorded responses to path as JSON readable by LoadQuerier.
+func (r *Recorder) Save(path string) error {
+	b, err := json.MarshalIndent(r.Responses(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding recording: %v", err)
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package record_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/record"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/toptest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	pods := []toptest.Pod{
+		{Namespace: "openshift-etcd", Name: "etcd-0", Node: "master-0", OwnerName: "etcd",
+			Values: toptest.Usage(.5, 2e9, 1, 4e9, 3600)},
+	}
+	live, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a query the live querier has no answer to is recorded as an error
+	live.Add(record.Response{Query: "up", Error: "server error"})
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	rec := record.NewRecorder(live)
+	want, err := top.Top(top.Config{PrometheusClient: rec, Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 2 {
+		t.Fatalf("got %d rows, want a cpu and a memory row: %v", len(want), want)
+	}
+	if _, _, err := rec.Query(context.Background(), "up", now); err == nil {
+		t.Fatal("expected the error of the live querier")
+	}
+
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "responses.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := record.LoadQuerier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := top.Top(top.Config{PrometheusClient: replay, Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows(got), rows(want)) {
+		t.Errorf("replayed %v, recorded %v", got, want)
+	}
+	if _, _, err := replay.Query(context.Background(), "up", now); err == nil || err.Error() != "server error" {
+		t.Errorf("got error %v, want the recorded server error", err)
+	}
+	if _, _, err := replay.Query(context.Background(), "down", now); err == nil {
+		t.Error("expected an error replaying a query that was not recorded")
+	}
+	if n := replay.Calls("up"); n != 1 {
+		t.Errorf("got %d calls of up, want 1", n)
+	}
+}
+
+// rows returns the rows of table by pod and metric, as Top returns them in no particular order.
+func rows(table top.PodMetricTable) map[string]top.PodMetric {
+	m := make(map[string]top.PodMetric, len(table))
+	for _, p := range table {
+		m[p.Pod+"/"+p.Metric] = *p
+	}
+	return m
+}
+
+func TestQuerierCanceled(t *testing.T) {
+	q := record.NewQuerier(record.Response{Query: "up", Vector: model.Vector{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := q.Query(ctx, "up", time.Now()); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
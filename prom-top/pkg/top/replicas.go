@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// replicasQuery returns the desired replica count of every controller a pod's owner_name can name, in an owner
+// label: the ReplicaSets of Deployments, which take the count of their Deployment, StatefulSets, and DaemonSets.
+const replicasQuery = `label_replace(max by (namespace, replicaset) (` +
+	`kube_replicaset_owner{owner_kind="Deployment"} * on(namespace, owner_name) group_left() ` +
+	`label_replace(kube_deployment_status_replicas, "owner_name", "$1", "deployment", "(.*)")` +
+	`), "owner", "$1", "replicaset", "(.*)")` +
+	` or label_replace(max by (namespace, statefulset) (kube_statefulset_status_replicas), "owner", "$1", "statefulset", "(.*)")` +
+	` or label_replace(max by (namespace, daemonset) (kube_daemonset_status_desired_number_scheduled), "owner", "$1", "daemonset", "(.*)")`
+
+// Replicas returns the current replica count of the controllers owning pods, keyed by namespace/owner_name, as
+// reported by kube-state-metrics, e.g. kube_deployment_status_replicas.
+func Replicas(cfg Config) (map[string]float64, error) {
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
+	vector, _, err := query(cfg, Query{Metric: "replicas", Expr: replicasQuery}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	replicas := make(map[string]float64, len(vector))
+	for _, sample := range vector {
+		replicas[string(sample.Metric["namespace"])+"/"+string(sample.Metric["owner"])] = float64(sample.Value)
+	}
+	return replicas, nil
+}
+
+// AppReplicaUsage is the usage of an app normalized by its replica count, so that builds changing the number of
+// replicas can be compared per instance.
+type AppReplicaUsage struct {
+	Metric    string
+	Namespace string
+	App       string
+	// Pods is the number of pods collected, Replicas the controller's desired count.
+	Pods     int
+	Replicas float64
+	// Total is the sum of agg over the app's pods, PerReplica Total / Replicas.
+	Total      float64
+	PerReplica float64
+}
+
+// PerReplica returns the sum of agg over the pods of every app with a known replica count, see Replicas, and that
+// sum per replica, sorted by namespace, app, and metric.
+func (pm PodMetricTable) PerReplica(agg Aggregation, replicas map[string]float64) []AppReplicaUsage {
+	type key struct{ metric, namespace, app string }
+	apps := make(map[key]*AppReplicaUsage)
+	for _, p := range pm {
+		n, ok := replicas[p.Namespace+"/"+p.OwnerName]
+		v := p.Value(agg)
+		if p.OwnerName == "" || !ok || n <= 0 || math.IsNaN(v) {
+			continue
+		}
+		k := key{p.Metric, p.Namespace, p.OwnerName}
+		if apps[k] == nil {
+			apps[k] = &AppReplicaUsage{Metric: p.Metric, Namespace: p.Namespace, App: p.OwnerName, Replicas: n}
+		}
+		apps[k].Pods++
+		apps[k].Total += v
+	}
+	result := make([]AppReplicaUsage, 0, len(apps))
+	for _, a := range apps {
+		a.PerReplica = a.Total / a.Replicas
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.App != b.App {
+			return a.App < b.App
+		}
+		return a.Metric < b.Metric
+	})
+	return result
+}
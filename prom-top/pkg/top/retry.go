@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// defaultRetryBackoff is the delay before the first retry of a query when Config.RetryBackoff is not set.
+const defaultRetryBackoff = time.Second
+
+// executeWithRetries executes query like execute, retrying it up to cfg.Retries times while it fails transiently.  The
+// delay before each retry starts at cfg.RetryBackoff and doubles, randomized by cfg.RetryJitter.
+func executeWithRetries(cfg Config, query string, ts time.Time) (model.Value, v1.Warnings, PrometheusStats, error) {
+	backoff := cfg.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		v, warnings, stats, err := executeAttempt(cfg, query, ts)
+		if err == nil || attempt >= cfg.Retries || !transient(cfg.Context, err) {
+			if err != nil && attempt > 0 {
+				err = fmt.Errorf("%w, after %d attempts", err, attempt+1)
+			}
+			return v, warnings, stats, err
+		}
+		select {
+		case <-cfg.Context.Done():
+			return nil, nil, PrometheusStats{}, fmt.Errorf("%v, retry aborted: %v", err, cfg.Context.Err())
+		case <-time.After(jitter(backoff, cfg.RetryJitter)):
+		}
+		backoff *= 2
+	}
+}
+
+// ErrQueryTimeout is wrapped by the error of a query exceeding Config.Timeout.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// executeAttempt executes query once, within cfg.Timeout if set.
+func executeAttempt(cfg Config, query string, ts time.Time) (model.Value, v1.Warnings, PrometheusStats, error) {
+	if cfg.Timeout <= 0 {
+		return execute(cfg.Context, cfg.PrometheusClient, query, ts)
+	}
+	ctx, cancel := context.WithTimeout(cfg.Context, cfg.Timeout)
+	defer cancel()
+	v, warnings, stats, err := execute(ctx, cfg.PrometheusClient, query, ts)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && cfg.Context.Err() == nil {
+		err = fmt.Errorf("%w after %s", ErrQueryTimeout, cfg.Timeout)
+	}
+	return v, warnings, stats, err
+}
+
+// transient reports whether err, returned by a query executed with ctx, is worth retrying: a network error, a query
+// exceeding Config.Timeout, or the timeout or 5xx response of prometheus or a proxy in front of it, e.g. a router's
+// 502.  Nothing is retried once ctx is done.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrQueryTimeout) {
+		return true
+	}
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Type == v1.ErrServer || apiErr.Type == v1.ErrTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// jitter returns d randomized by up to fraction of it either way.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	return d + time.Duration(fraction*(2*rand.Float64()-1)*float64(d))
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "sort"
+
+// exactQuantileObservations is the number of observations a p2Quantile keeps to compute the exact quantile before it
+// starts estimating it.
+const exactQuantileObservations = 256
+
+// p2Quantile computes a quantile of a stream of observations in constant space.  The quantile of the first
+// exactQuantileObservations is exact.  Beyond them, it is estimated with the P² algorithm of Jain and Chlamtac, which
+// keeps five markers whose heights approximate the minimum, the quantile, the maximum, and the midpoints between them.
+type p2Quantile struct {
+	p float64
+	n int
+	// exact holds the observations until the markers take over
+	exact []float64
+	// heights of the markers and their actual and desired positions, 0-based
+	q       [5]float64
+	pos     [5]int
+	desired [5]float64
+}
+
+// newP2Quantile returns an estimator of the p-quantile, e.g. .95.
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{p: p}
+}
+
+// observe adds v to the stream.
+func (e *p2Quantile) observe(v float64) {
+	if e.n < exactQuantileObservations {
+		e.exact = append(e.exact, v)
+		e.n++
+		return
+	}
+	if e.exact != nil {
+		e.placeMarkers()
+	}
+	e.n++
+
+	// the cell of v, extending the extremes if v lies beyond them
+	var k int
+	switch {
+	case v < e.q[0]:
+		e.q[0] = v
+	case v >= e.q[4]:
+		e.q[4] = v
+		k = 3
+	default:
+		for k = 0; v >= e.q[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < len(e.pos); i++ {
+		e.pos[i]++
+	}
+	increments := [5]float64{0, e.p / 2, e.p, (1 + e.p) / 2, 1}
+	for i := range e.desired {
+		e.desired[i] += increments[i]
+	}
+
+	// move the middle markers that are off their desired positions by a whole step
+	for i := 1; i <= 3; i++ {
+		d := e.desired[i] - float64(e.pos[i])
+		if (d >= 1 && e.pos[i+1]-e.pos[i] > 1) || (d <= -1 && e.pos[i-1]-e.pos[i] < -1) {
+			step := 1
+			if d < 0 {
+				step = -1
+			}
+			h := e.parabolic(i, float64(step))
+			if e.q[i-1] >= h || h >= e.q[i+1] {
+				h = e.linear(i, step)
+			}
+			e.q[i] = h
+			e.pos[i] += step
+		}
+	}
+}
+
+// placeMarkers hands over from the exact observations to the markers, placed at their desired positions among them.
+func (e *p2Quantile) placeMarkers() {
+	sort.Float64s(e.exact)
+	last := float64(len(e.exact) - 1)
+	e.desired = [5]float64{0, last * e.p / 2, last * e.p, last * (1 + e.p) / 2, last}
+	for i, d := range e.desired {
+		e.pos[i] = int(d + .5)
+		e.q[i] = e.exact[e.pos[i]]
+	}
+	e.exact = nil
+}
+
+// parabolic returns the height of marker i moved by d, interpolated through its neighbours.
+func (e *p2Quantile) parabolic(i int, d float64) float64 {
+	n0, n1, n2 := float64(e.pos[i-1]), float64(e.pos[i]), float64(e.pos[i+1])
+	return e.q[i] + d/(n2-n0)*((n1-n0+d)*(e.q[i+1]-e.q[i])/(n2-n1)+(n2-n1-d)*(e.q[i]-e.q[i-1])/(n1-n0))
+}
+
+// linear returns the height of marker i moved by d, interpolated towards the neighbour in its direction.
+func (e *p2Quantile) linear(i, d int) float64 {
+	return e.q[i] + float64(d)*(e.q[i+d]-e.q[i])/float64(e.pos[i+d]-e.pos[i])
+}
+
+// value returns the quantile, NaN if nothing was observed.
+func (e *p2Quantile) value() float64 {
+	if e.exact != nil || e.n == 0 {
+		return quantile(e.exact, e.p)
+	}
+	return e.q[2]
+}
+
+// count returns the number of observations.
+func (e *p2Quantile) count() int {
+	return e.n
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestP2Quantile(t *testing.T) {
+	if v := newP2Quantile(.95).value(); !math.IsNaN(v) {
+		t.Errorf("got %v with no observations, want NaN", v)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		n    int
+		draw func() float64
+		want float64
+		// tolerance of the estimate, 0 for an exact quantile
+		tolerance float64
+	}{
+		{"exact", exactQuantileObservations, func() float64 { return float64(rng.Intn(1000)) }, 0, 0},
+		{"uniform", 100000, func() float64 { return rng.Float64() }, .95, .01},
+		{"exponential", 100000, rng.ExpFloat64, -math.Log(.05), .05},
+	}
+	for _, tt := range tests {
+		e := newP2Quantile(.95)
+		observed := make([]float64, 0, exactQuantileObservations)
+		for i := 0; i < tt.n; i++ {
+			v := tt.draw()
+			e.observe(v)
+			if i < exactQuantileObservations {
+				observed = append(observed, v)
+			}
+		}
+		if e.count() != tt.n {
+			t.Errorf("%s: counted %d observations, want %d", tt.name, e.count(), tt.n)
+		}
+		want := tt.want
+		if tt.tolerance == 0 {
+			want = quantile(observed, .95)
+		}
+		if got := e.value(); math.Abs(got-want) > tt.tolerance*want {
+			t.Errorf("%s: got q95 %v, want %v within %v%%", tt.name, got, want, tt.tolerance*100)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueryStat describes the execution of a single query.
+type QueryStat struct {
+	Metric      string
+	Aggregation Aggregation
+	Expr        string
+	// Duration is the wall time of the query.
+	Duration time.Duration
+	// Samples is the number of series returned.
+	Samples int
+	// Cached is set when the result was served by Config.Cache rather than prometheus.
+	Cached bool
+	// Warnings are those returned by prometheus with the result, e.g. of a failing replica.
+	Warnings []string
+	// Prometheus are the execution stats reported by prometheus, zero unless the query was executed by a StatsQuerier.
+	Prometheus PrometheusStats
+	// Error is the reason the query failed, empty if it succeeded.  Only a collection that continues on error records
+	// failed queries.
+	Error string
+}
+
+// RunStats accumulates the QueryStat of every query executed by a collection, so that the queries dominating its
+// time can be identified.  It is safe for concurrent use, and recording to a nil RunStats is a no-op.
+type RunStats struct {
+	mu      sync.Mutex
+	queries []QueryStat
+}
+
+func (s *RunStats) record(stat QueryStat) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, stat)
+}
+
+// Queries returns the recorded stats, slowest first.
+func (s *RunStats) Queries() []QueryStat {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := append([]QueryStat(nil), s.queries...)
+	sort.SliceStable(queries, func(i, j int) bool {
+		return queries[i].Duration > queries[j].Duration
+	})
+	return queries
+}
+
+// Warnings returns the warnings of every recorded query, in the order recorded.
+func (s *RunStats) Warnings() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var warnings []string
+	for _, q := range s.queries {
+		warnings = append(warnings, q.Warnings...)
+	}
+	return warnings
+}
+
+// Prometheus returns the execution stats reported by prometheus, summed over every recorded query.
+func (s *RunStats) Prometheus() PrometheusStats {
+	var total PrometheusStats
+	if s == nil {
+		return total
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range s.queries {
+		total.add(q.Prometheus)
+	}
+	return total
+}
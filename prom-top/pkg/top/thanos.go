@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"net/url"
+	"strconv"
+
+	promapi "github.com/prometheus/client_golang/api"
+)
+
+// ThanosOptions are the query parameters specific to Thanos Query, the querier in front of the stores of long
+// retention or multi-cluster setups.
+type ThanosOptions struct {
+	// Dedup merges the series of replicas of the same prometheus, identified by ReplicaLabels, into one.
+	Dedup bool
+	// ReplicaLabels (optional) are the external labels distinguishing the replicas, e.g. prometheus_replica.  Defaults
+	// to the querier's --query.replica-label.
+	ReplicaLabels []string
+	// PartialResponse lets the querier answer when some of its stores fail, with a warning for each, rather than
+	// failing the query.  Set Config.PartialOnWarnings to mark the rows of such answers Partial.
+	PartialResponse bool
+	// Stats requests the execution stats of each query with stats=all, as by NewStatsQuerier.
+	Stats bool
+}
+
+// params returns the query parameters of o.
+func (o ThanosOptions) params() url.Values {
+	params := url.Values{
+		"dedup":            {strconv.FormatBool(o.Dedup)},
+		"partial_response": {strconv.FormatBool(o.PartialResponse)},
+	}
+	for _, l := range o.ReplicaLabels {
+		params.Add("replicaLabels[]", l)
+	}
+	if o.Stats {
+		params.Set("stats", "all")
+	}
+	return params
+}
+
+// NewThanosQuerier returns a StatsQuerier executing queries against client, a Thanos Query endpoint, with the
+// parameters of opts.  Its stats are zero unless opts.Stats is set and the querier reports them.
+func NewThanosQuerier(client promapi.Client, opts ThanosOptions) StatsQuerier {
+	return &statsQuerier{client: client, params: opts.params()}
+}
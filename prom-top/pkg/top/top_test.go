@@ -0,0 +1,279 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/record"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/toptest"
+)
+
+// pods are the fixture cluster of the tests of Top.
+var pods = []toptest.Pod{
+	{Namespace: "openshift-etcd", Name: "etcd-0", Node: "master-0", OwnerName: "etcd",
+		Values: toptest.Usage(.5, 2e9, 1, 4e9, 3600)},
+	{Namespace: "openshift-dns", Name: "dns-default-x7k2p", Node: "worker-0", OwnerName: "dns-default",
+		Values: toptest.Usage(.01, 5e7, .1, 1e8, 36)},
+	{Namespace: "openshift-monitoring", Name: "prometheus-k8s-0", Node: "worker-1", OwnerName: "prometheus-k8s",
+		Values: toptest.Usage(1, 8e9, 0, 0, 7200)},
+}
+
+var now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// byPod returns the rows of table by pod and metric, as Top returns them in no particular order.
+func byPod(t *testing.T, table top.PodMetricTable) map[string]*top.PodMetric {
+	t.Helper()
+	rows := make(map[string]*top.PodMetric, len(table))
+	for _, p := range table {
+		key := p.Pod + "/" + p.Metric
+		if _, ok := rows[key]; ok {
+			t.Errorf("duplicate row %s", p)
+		}
+		rows[key] = p
+	}
+	return rows
+}
+
+func TestTop(t *testing.T) {
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "1h"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := top.Top(top.Config{PrometheusClient: querier, Range: "1h", Time: now, Build: "4.6.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := byPod(t, table)
+	if len(rows) != 2*len(pods) {
+		t.Fatalf("got %d rows, want a cpu and a memory row per pod: %v", len(rows), table)
+	}
+	for _, pod := range pods {
+		for _, metric := range []string{top.CPUMetric, top.MemoryMetric} {
+			p, ok := rows[pod.Name+"/"+metric]
+			if !ok {
+				t.Errorf("no %s row of %s", metric, pod.Name)
+				continue
+			}
+			for agg, want := range pod.Values[metric] {
+				if got := p.Value(agg); got != want {
+					t.Errorf("%s %s of %s: got %v, want %v", agg, metric, pod.Name, got, want)
+				}
+			}
+			if p.Namespace != pod.Namespace || p.Node != pod.Node || p.OwnerName != pod.OwnerName {
+				t.Errorf("%s of %s: got labels %s", metric, pod.Name, p)
+			}
+			if p.Range != "1h" || p.Version != "4.6.1" || p.Partial {
+				t.Errorf("%s of %s: got range %q, build %q, partial %v", metric, pod.Name, p.Range, p.Version, p.Partial)
+			}
+			// the usage of the fixture is constant
+			if p.Burstiness != 1 && p.AvgValue > 0 {
+				t.Errorf("%s of %s: got burstiness %v, want 1", metric, pod.Name, p.Burstiness)
+			}
+		}
+	}
+	if got := rows["etcd-0/"+top.CPUMetric].Efficiency; got != .5 {
+		t.Errorf("got cpu efficiency %v of etcd-0, want .5", got)
+	}
+	if got := rows["prometheus-k8s-0/"+top.MemoryMetric].Efficiency; got != 0 {
+		t.Errorf("got memory efficiency %v of prometheus-k8s-0 without a request, want 0", got)
+	}
+}
+
+func TestTopShards(t *testing.T) {
+	var responses []record.Response
+	namespaces := []string{"openshift-dns", "openshift-etcd", "openshift-monitoring"}
+	for _, shard := range [][]string{namespaces[:2], namespaces[2:]} {
+		r, err := toptest.Responses(top.DefaultQueryBuilder(), top.Params{Range: "10m", Namespaces: shard}, pods...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, r...)
+	}
+	// every pod answers the queries of both shards, Top must merge them into one row per pod and metric
+	table, err := top.Top(top.Config{PrometheusClient: record.NewQuerier(responses...), Namespaces: namespaces,
+		ShardSize: 2, Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := byPod(t, table); len(rows) != 2*len(pods) {
+		t.Errorf("got %d rows, want a cpu and a memory row per pod: %v", len(rows), table)
+	}
+
+	if _, err := top.Top(top.Config{PrometheusClient: record.NewQuerier(), ShardSize: 2}); err == nil {
+		t.Error("expected an error sharding without namespaces")
+	}
+}
+
+func TestTopCache(t *testing.T) {
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := top.NewMemoryCache(time.Hour)
+	for i := 0; i < 2; i++ {
+		table, err := top.Top(top.Config{PrometheusClient: querier, Cache: cache, CacheScope: "a", Time: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(table) != 2*len(pods) {
+			t.Errorf("got %d rows, want %d", len(table), 2*len(pods))
+		}
+	}
+	queries, err := top.DefaultQueryBuilder().Queries(top.Params{Range: "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range queries {
+		if n := querier.Calls(q.Expr); n != 1 {
+			t.Errorf("%s %s executed %d times, want once", q.Aggregation, q.Metric, n)
+		}
+	}
+
+	// the results of another prometheus are not served from the cache
+	other := record.NewQuerier()
+	if _, err := top.Top(top.Config{PrometheusClient: other, Cache: cache, CacheScope: "b", Time: now}); err == nil {
+		t.Error("expected the queries of scope b to miss the cache")
+	}
+}
+
+func TestTopContinueOnError(t *testing.T) {
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing, err := top.DefaultQueryBuilder().Build(top.MemoryMetric, top.Quantile95, "10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	querier.Add(record.Response{Query: failing, Error: "query timed out in expression evaluation"})
+
+	if _, err := top.Top(top.Config{PrometheusClient: querier, Time: now}); err == nil {
+		t.Fatal("expected the failing query to fail the collection")
+	}
+
+	table, err := top.Top(top.Config{PrometheusClient: querier, Time: now, ContinueOnError: true})
+	var queryErrors top.QueryErrors
+	if !errors.As(err, &queryErrors) || len(queryErrors) != 1 {
+		t.Fatalf("got error %v, want the QueryErrors of the failing query", err)
+	}
+	if e := queryErrors[0]; e.Metric != top.MemoryMetric || e.Aggregation != top.Quantile95 || e.Expr != failing {
+		t.Errorf("got %+v, want the failure of the q95 memory query", e)
+	}
+	rows := byPod(t, table)
+	if len(rows) != 2*len(pods) {
+		t.Fatalf("got %d rows, want a cpu and a memory row per pod: %v", len(rows), table)
+	}
+	for _, p := range rows {
+		if p.Partial != (p.Metric == top.MemoryMetric) {
+			t.Errorf("%s: got partial %v, only the memory rows lack an aggregation", p, p.Partial)
+		}
+		if p.Metric == top.MemoryMetric && p.Q95Value != 0 {
+			t.Errorf("%s: got a q95 of the failed query", p)
+		}
+	}
+}
+
+func TestTopCanceled(t *testing.T) {
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	table, err := top.Top(top.Config{Context: ctx, PrometheusClient: querier, Time: now})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want one wrapping %v", err, context.Canceled)
+	}
+	for _, p := range table {
+		if !p.Partial {
+			t.Errorf("%s: the rows of an interrupted collection must be partial", p)
+		}
+	}
+}
+
+// concurrencyQuerier records the most queries in flight at once.
+type concurrencyQuerier struct {
+	top.Querier
+
+	mu                sync.Mutex
+	inFlight, maxSeen int
+}
+
+func (q *concurrencyQuerier) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	q.mu.Lock()
+	q.inFlight++
+	if q.inFlight > q.maxSeen {
+		q.maxSeen = q.inFlight
+	}
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.inFlight--
+		q.mu.Unlock()
+	}()
+	// long enough for the other workers to start theirs
+	time.Sleep(10 * time.Millisecond)
+	return q.Querier.Query(ctx, query, ts)
+}
+
+func TestTopMaxConcurrency(t *testing.T) {
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "10m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int{1, 3} {
+		q := &concurrencyQuerier{Querier: querier}
+		table, err := top.Top(top.Config{PrometheusClient: q, Time: now, MaxConcurrency: max})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(table) != 2*len(pods) {
+			t.Errorf("got %d rows, want %d", len(table), 2*len(pods))
+		}
+		if q.maxSeen != max {
+			t.Errorf("MaxConcurrency %d: got %d queries in flight at once", max, q.maxSeen)
+		}
+	}
+}
+
+func TestTopDownsampled(t *testing.T) {
+	// the fixture only answers the subqueries of a downsampled week
+	querier, err := toptest.Querier(top.DefaultQueryBuilder(), top.Params{Range: "7d", Step: "5m"}, pods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := top.Top(top.Config{PrometheusClient: querier, Range: "7d", Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 2*len(pods) {
+		t.Errorf("got %d rows, want %d", len(table), 2*len(pods))
+	}
+	full := top.Config{PrometheusClient: querier, Range: "7d", Time: now, DownsampleThreshold: -1}
+	if _, err := top.Top(full); err == nil {
+		t.Error("expected the queries of a week that is not downsampled to miss the fixture")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package toptest provides fixtures for the tests of top and its consumers: a fake cluster of pods whose queries are
+// answered by a record.Querier, as Prometheus would answer the queries of a QueryBuilder.
+package toptest
+
+import (
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+	"github.com/redhat-et/caliper/prom-top/pkg/top/record"
+)
+
+// Pod is a pod of a fixture cluster.
+type Pod struct {
+	Namespace string
+	Name      string
+	Node      string
+	OwnerName string
+	// Values are the results of the pod's queries, by metric and aggregation.  Queries without a value return no
+	// sample of the pod.
+	Values map[string]map[top.Aggregation]float64
+}
+
+// Usage returns the Values of a pod using cpu cores and memory bytes constantly, with the given requests.  Every
+// aggregation of a constant usage is the usage itself, except the Instant cpu value, which is the cumulative counter
+// of cpu seconds after cpuSeconds.
+func Usage(cpu, memory, cpuRequest, memoryRequest, cpuSeconds float64) map[string]map[top.Aggregation]float64 {
+	return map[string]map[top.Aggregation]float64{
+		top.CPUMetric: {
+			top.Average: cpu, top.Maximum: cpu, top.Minimum: cpu, top.Quantile95: cpu,
+			top.Instant: cpu * cpuSeconds, top.Request: cpuRequest,
+		},
+		top.MemoryMetric: {
+			top.Average: memory, top.Maximum: memory, top.Minimum: memory, top.Quantile95: memory,
+			top.Instant: memory, top.Request: memoryRequest,
+		},
+	}
+}
+
+// Sample returns the sample of the query of metric and agg for p, or false if p has no value for it.
+func (p Pod) Sample(metric string, agg top.Aggregation) (*model.Sample, bool) {
+	v, ok := p.Values[metric][agg]
+	if !ok {
+		return nil, false
+	}
+	return &model.Sample{
+		Metric: model.Metric{
+			"namespace":  model.LabelValue(p.Namespace),
+			"pod":        model.LabelValue(p.Name),
+			"node":       model.LabelValue(p.Node),
+			"owner_name": model.LabelValue(p.OwnerName),
+		},
+		Value: model.SampleValue(v),
+	}, true
+}
+
+// Responses returns the responses of pods to every query builder generates for params.
+func Responses(builder *top.QueryBuilder, params top.Params, pods ...Pod) ([]record.Response, error) {
+	queries, err := builder.Queries(params)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]record.Response, 0, len(queries))
+	for _, q := range queries {
+		r := record.Response{Query: q.Expr, Vector: model.Vector{}}
+		for _, p := range pods {
+			if s, ok := p.Sample(q.Metric, q.Aggregation); ok {
+				r.Vector = append(r.Vector, s)
+			}
+		}
+		responses = append(responses, r)
+	}
+	return responses, nil
+}
+
+// Querier returns a record.Querier answering the queries builder generates for params with the values of pods.
+func Querier(builder *top.QueryBuilder, params top.Params, pods ...Pod) (*record.Querier, error) {
+	responses, err := Responses(builder, params, pods...)
+	if err != nil {
+		return nil, err
+	}
+	return record.NewQuerier(responses...), nil
+}
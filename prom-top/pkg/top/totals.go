@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"math"
+	"sort"
+)
+
+// Total is the combined usage of the collected pods of a namespace, or of the cluster, for a metric: the sums of
+// their per-pod values.  Pods rarely peak together, so the sums of the q95 and max overstate the peak of the total.
+type Total struct {
+	Metric string `json:"metric"`
+	// Namespace is empty for the cluster-wide total.
+	Namespace string  `json:"namespace,omitempty"`
+	Pods      int     `json:"pods"`
+	Avg       float64 `json:"avg"`
+	Q95       float64 `json:"q95"`
+	Max       float64 `json:"max"`
+	Inst      float64 `json:"inst"`
+	Request   float64 `json:"request"`
+}
+
+// Totals returns the cluster-wide total of each metric, followed by the totals of its namespaces, sorted by metric
+// and namespace.  Values that are NaN, i.e. were not collected, count as 0.
+func (pm PodMetricTable) Totals() []Total {
+	type key struct{ metric, namespace string }
+	totals := make(map[key]*Total)
+	add := func(k key, p *PodMetric) {
+		t, ok := totals[k]
+		if !ok {
+			t = &Total{Metric: k.metric, Namespace: k.namespace}
+			totals[k] = t
+		}
+		t.Pods++
+		t.Avg += orZero(p.AvgValue)
+		t.Q95 += orZero(p.Q95Value)
+		t.Max += orZero(p.MaxValue)
+		t.Inst += orZero(p.InstValue)
+		t.Request += orZero(p.Request)
+	}
+	for _, p := range pm {
+		add(key{p.Metric, ""}, p)
+		add(key{p.Metric, p.Namespace}, p)
+	}
+	result := make([]Total, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Metric != result[j].Metric {
+			return result[i].Metric < result[j].Metric
+		}
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result
+}
+
+func orZero(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
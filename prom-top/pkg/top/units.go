@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CPUUnits and MemoryUnits map the output units of each metric to the number of units in the unit Prometheus
+// reports, cores and bytes respectively.
+var (
+	CPUUnits = map[string]float64{
+		"cores":      1,
+		"millicores": 1000,
+	}
+	MemoryUnits = map[string]float64{
+		"bytes": 1,
+		"KiB":   1.0 / (1 << 10),
+		"MiB":   1.0 / (1 << 20),
+		"GiB":   1.0 / (1 << 30),
+	}
+)
+
+// Units selects the unit CPU and memory values are expressed in, named as in CPUUnits and MemoryUnits.  The zero
+// value selects cores and bytes.
+type Units struct {
+	CPU    string
+	Memory string
+}
+
+// Validate returns an error if u names an unknown unit.
+func (u Units) Validate() error {
+	if _, ok := CPUUnits[u.CPU]; u.CPU != "" && !ok {
+		return fmt.Errorf("unknown cpu unit %q, use one of %s", u.CPU, unitNames(CPUUnits))
+	}
+	if _, ok := MemoryUnits[u.Memory]; u.Memory != "" && !ok {
+		return fmt.Errorf("unknown memory unit %q, use one of %s", u.Memory, unitNames(MemoryUnits))
+	}
+	return nil
+}
+
+func unitNames(units map[string]float64) string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Convert returns a copy of the table with the values of CPU and memory rows expressed in u.  Ratios, such as
+// Efficiency, are unitless and left as is.  Convert panics if u is invalid.
+func (pm PodMetricTable) Convert(u Units) PodMetricTable {
+	return pm.scale(u, false)
+}
+
+// FromUnits is the inverse of Convert: it returns a copy of the table, whose CPU and memory values are expressed in
+// u, with the values in cores and bytes.  FromUnits panics if u is invalid.
+func (pm PodMetricTable) FromUnits(u Units) PodMetricTable {
+	return pm.scale(u, true)
+}
+
+// scale multiplies the values of CPU and memory rows by the factors of u, or divides them if inverse is set.
+func (pm PodMetricTable) scale(u Units, inverse bool) PodMetricTable {
+	factors := make(map[string]float64, 2)
+	if u.CPU != "" {
+		factors[CPUMetric] = CPUUnits[u.CPU]
+	}
+	if u.Memory != "" {
+		factors[MemoryMetric] = MemoryUnits[u.Memory]
+	}
+	for metric, f := range factors {
+		if f == 0 {
+			panic(fmt.Sprintf("invalid unit for %s", metric))
+		}
+		if inverse {
+			factors[metric] = 1 / f
+		}
+	}
+
+	converted := make(PodMetricTable, 0, len(pm))
+	for _, p := range pm {
+		c := *p
+		if f, ok := factors[p.Metric]; ok {
+			c.AvgValue *= f
+			c.MaxValue *= f
+			c.MinValue *= f
+			c.Q95Value *= f
+			c.InstValue *= f
+			c.Request *= f
+		}
+		converted = append(converted, &c)
+	}
+	return converted
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package top
+
+import "regexp"
+
+var (
+	// generatedSuffix matches the suffixes kubernetes appends to the names of pods owned by a replicaset, daemonset, or
+	// job: an optional pod template hash and a random 5 character string, both drawn from an alphabet without vowels.
+	generatedSuffix = regexp.MustCompile(`(-[bcdfghjklmnpqrstvwxz2456789]{6,10})?-[bcdfghjklmnpqrstvwxz2456789]{5}$`)
+	// ordinalSuffix matches the ordinal of a statefulset's pods, and the scheduled time of a cronjob's jobs.
+	ordinalSuffix = regexp.MustCompile(`-[0-9]+$`)
+)
+
+// Workload returns the name of the logical workload of pod: its name without the suffixes generated for each
+// replica, e.g. etcd-quorum-guard for etcd-quorum-guard-6d4cf56db6-x7k2p, or prometheus-k8s for prometheus-k8s-1.
+// The name is stable across restarts and rollouts, and so matches the pods of a workload between runs and builds.
+// The pods of a workload, e.g. the replicas of a deployment, share it.
+func Workload(pod string) string {
+	return ordinalSuffix.ReplaceAllString(generatedSuffix.ReplaceAllString(pod, ""), "")
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook POSTs the full result of a collection to an arbitrary HTTP endpoint as a single JSON document,
+// covering backends that have no dedicated sink.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/jsonschema"
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+// DefaultRetries is the number of times prom-top retries a failed delivery unless --webhook-retries says otherwise.
+const DefaultRetries = 3
+
+// Config describes the endpoint and how to deliver to it.
+type Config struct {
+	URL string
+	// Token (optional) is sent as a bearer token in the Authorization header.
+	Token string
+	// Retries is the number of additional attempts made after a connection error or a 429 or 5xx response, e.g.
+	// DefaultRetries.  0 disables retries.
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each subsequent one.  Defaults to 1s.
+	Backoff time.Duration
+	// Units (optional) are reported in the document's run metadata.  They describe the results, which must already
+	// be converted to them.
+	Units top.Units
+	// Totals adds the cluster-wide and per-namespace totals of the results to the document.
+	Totals bool
+	// ToolVersion (optional) identifies the prom-top build in the document's run metadata.
+	ToolVersion string
+}
+
+// Validate reports missing settings.
+func (c Config) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("missing URL")
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("negative retries %d", c.Retries)
+	}
+	return nil
+}
+
+// Document is the JSON body delivered to the endpoint.
+type Document struct {
+	Run     Run      `json:"run"`
+	Results []Result `json:"results"`
+	// Totals (optional) are the sums of the results per metric, see top.PodMetricTable.Totals.
+	Totals []top.Total `json:"totals,omitempty"`
+}
+
+// Run is the metadata shared by every result of a collection.
+type Run struct {
+	Version   string `json:"version,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	QueryTime string `json:"query_time,omitempty"`
+	Range     string `json:"range,omitempty"`
+	// Partial is set if any result comes from an interrupted collection.
+	Partial bool `json:"partial"`
+	Count   int  `json:"count"`
+	// CPUUnit and MemoryUnit are the units of the values of CPU and memory results.
+	CPUUnit    string `json:"cpu_unit"`
+	MemoryUnit string `json:"memory_unit"`
+	// Iteration numbers the collections of an --interval loop from 1, 0 outside of one.
+	Iteration int `json:"iteration,omitempty"`
+	// Labels are the custom labels given to the run with --label, e.g. scenario: density-1000.
+	Labels map[string]string `json:"labels,omitempty"`
+	// RunID identifies the run, as the run_id of its rows in the database.
+	RunID string `json:"run_id,omitempty"`
+	// ToolVersion is the version of prom-top that collected the results.
+	ToolVersion string `json:"tool_version,omitempty"`
+}
+
+// Result is a single PodMetric.  Field names match the database columns.  Values that are NaN or infinite, which
+// JSON cannot represent, e.g. the quantiles of series without samples, are null.
+type Result struct {
+	Metric        string   `json:"metric"`
+	Node          string   `json:"node"`
+	Pod           string   `json:"pod"`
+	Namespace     string   `json:"namespace"`
+	OwnerName     string   `json:"owner_name"`
+	Workload      string   `json:"workload"`
+	AvgValue      *float64 `json:"avg_value"`
+	Q95Value      *float64 `json:"q95_value"`
+	MaxValue      *float64 `json:"max_value"`
+	MinValue      *float64 `json:"min_value"`
+	InstValue     *float64 `json:"inst_value"`
+	Request       *float64 `json:"request"`
+	Efficiency    *float64 `json:"efficiency"`
+	Burstiness    *float64 `json:"burstiness"`
+	Q95Burstiness *float64 `json:"q95_burstiness"`
+	Partial       bool     `json:"partial"`
+	Violation     string   `json:"violation,omitempty"`
+	// HPAMinReplicas and HPAMaxReplicas are the fewest and most replicas the autoscaler of the workload ran, 0 for
+	// workloads without one.
+	HPAMinReplicas int `json:"hpa_min_replicas"`
+	HPAMaxReplicas int `json:"hpa_max_replicas"`
+	// Component is the part of the pod measured when containers are split, e.g. main, init, or istio-proxy, empty
+	// for the whole pod.
+	Component string `json:"component,omitempty"`
+	// PodLabels are the pod labels joined with --pod-label, keyed by their kube_pod_labels name.
+	PodLabels map[string]string `json:"pod_labels,omitempty"`
+}
+
+// NewDocument assembles the document describing metrics.
+func NewDocument(metrics top.PodMetricTable) Document {
+	doc := Document{
+		Run:     Run{Count: len(metrics)},
+		Results: make([]Result, 0, len(metrics)),
+	}
+	for _, m := range metrics {
+		if doc.Run.QueryTime == "" {
+			doc.Run.QueryTime = m.QueryTime
+			doc.Run.RunID = m.RunID
+			doc.Run.Version = m.Version
+			doc.Run.Cluster = m.Cluster
+			doc.Run.Range = m.Range
+			doc.Run.Iteration = m.Iteration
+			doc.Run.Labels = m.Labels
+		}
+		doc.Run.Partial = doc.Run.Partial || m.Partial
+		doc.Results = append(doc.Results, Result{
+			Metric:        m.Metric,
+			Node:          m.Node,
+			Pod:           m.Pod,
+			Namespace:     m.Namespace,
+			OwnerName:     m.OwnerName,
+			Workload:      m.Workload,
+			AvgValue:      value(m.AvgValue),
+			Q95Value:      value(m.Q95Value),
+			MaxValue:      value(m.MaxValue),
+			MinValue:      value(m.MinValue),
+			InstValue:     value(m.InstValue),
+			Request:       value(m.Request),
+			Efficiency:    value(m.Efficiency),
+			Burstiness:    value(m.Burstiness),
+			Q95Burstiness: value(m.Q95Burstiness),
+			Partial:       m.Partial,
+			Violation:     m.Violation,
+
+			HPAMinReplicas: m.HPAMinReplicas,
+			HPAMaxReplicas: m.HPAMaxReplicas,
+			Component:      m.Component,
+			PodLabels:      m.PodLabels,
+		})
+	}
+	return doc
+}
+
+// value returns a pointer to v, or nil if v is NaN or infinite.
+func value(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// Document assembles the document describing metrics, with the run metadata and totals selected by c.
+func (c Config) Document(metrics top.PodMetricTable) Document {
+	doc := NewDocument(metrics)
+	doc.Run.ToolVersion = c.ToolVersion
+	doc.Run.CPUUnit, doc.Run.MemoryUnit = "cores", "bytes"
+	if c.Units.CPU != "" {
+		doc.Run.CPUUnit = c.Units.CPU
+	}
+	if c.Units.Memory != "" {
+		doc.Run.MemoryUnit = c.Units.Memory
+	}
+	if c.Totals {
+		doc.Totals = metrics.Totals()
+	}
+	return doc
+}
+
+// Schema returns the JSON Schema of Document.
+func Schema() jsonschema.Schema {
+	return jsonschema.Generate("prom-top result document", Document{})
+}
+
+// Client delivers documents to a single endpoint.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	return &Client{cfg: cfg, http: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// Write POSTs the document describing metrics, retrying transient failures.
+func (c *Client) Write(ctx context.Context, metrics top.PodMetricTable) error {
+	doc := c.cfg.Document(metrics)
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("encoding document: %v", err)
+	}
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.cfg.Retries {
+			return fmt.Errorf("delivery failed after %d attempts: %v", attempt+1, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("delivery aborted after %d attempts: %v", attempt+1, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post delivers body once.  retry reports whether a failure is transient.
+func (c *Client) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("%s returned %s: %s", c.cfg.URL, resp.Status, b)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Red Hat, Inc. jcope@redhat.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redhat-et/caliper/prom-top/pkg/top"
+)
+
+func TestDocumentNaN(t *testing.T) {
+	metrics := top.PodMetricTable{
+		{Version: "4.6.1", Metric: top.CPUMetric, Pod: "etcd-0", AvgValue: .5, Q95Value: math.NaN(),
+			MaxValue: math.Inf(1)},
+	}
+	b, err := json.Marshal(Config{}.Document(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if r := doc.Results[0]; r["avg_value"] != .5 || r["q95_value"] != nil || r["max_value"] != nil {
+		t.Errorf("got %v, want a null q95_value and max_value", r)
+	}
+	problems, err := Schema().Validate(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("the document does not match its schema: %s", p)
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	metrics := top.PodMetricTable{{Metric: top.CPUMetric, Pod: "etcd-0"}}
+
+	for _, tt := range []struct {
+		retries int
+		calls   int
+		fail    bool
+	}{
+		{retries: 0, calls: 1, fail: true},
+		{retries: 1, calls: 2, fail: true},
+		{retries: DefaultRetries, calls: 3},
+	} {
+		calls = 0
+		c, err := NewClient(Config{URL: srv.URL, Retries: tt.retries, Backoff: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Write(context.Background(), metrics)
+		if (err != nil) != tt.fail || calls != tt.calls {
+			t.Errorf("%d retries: got %d deliveries and error %v, want %d deliveries, failing %v", tt.retries, calls,
+				err, tt.calls, tt.fail)
+		}
+	}
+
+	if _, err := NewClient(Config{URL: srv.URL, Retries: -1}); err == nil {
+		t.Error("expected an error with negative retries")
+	}
+}
+
+func TestDocumentRun(t *testing.T) {
+	metrics := top.PodMetricTable{
+		{Version: "4.6.1", Metric: top.CPUMetric, Pod: "etcd-0", RunID: "5b6f1d1e", QueryTime: "2020-10-01 12:00:00"},
+	}
+	doc := Config{ToolVersion: "v0.4.0"}.Document(metrics)
+	if doc.Run.RunID != "5b6f1d1e" || doc.Run.ToolVersion != "v0.4.0" || doc.Run.Version != "4.6.1" {
+		t.Errorf("got run %+v", doc.Run)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Config{URL: "http://receiver", Retries: -1}).Validate(); err == nil {
+		t.Error("got no error for negative retries")
+	}
+	if err := (Config{Retries: 1}).Validate(); err == nil {
+		t.Error("got no error for a missing URL")
+	}
+}
@@ -32,7 +32,7 @@ def parse_args_install_config(args=argparse.Namespace()):
 
 def prom_top_command(kubeconfig='', version=''):
     cmd = []
-    args = [f'--postgres', '--ocp-version', f'{str(version)}', '--range', f'{str(s.TEST_RANGE_SECONDS)}s']
+    args = [f'--postgres', '--build', f'{str(version)}', '--range', f'{str(s.TEST_RANGE_SECONDS)}s']
     if s.PROM_TOP_SOURCE == 1:
         cmd = [f'docker', 'run', '--network', 'build_postgres', '--rm', '-v', f'{kubeconfig}:/root/.kube/config',
                '--env-file', f'{s.DOTENV}', '-e', 'PGHOST=postgres', 'quay.io/jcope/prom-top:latest']